@@ -17,6 +17,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/go-logr/zapr"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -47,6 +48,8 @@ import (
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/selection"
 	"github.com/aws/karpenter/pkg/controllers/termination"
+	"github.com/aws/karpenter/pkg/dashboard"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/options"
 )
@@ -76,23 +79,40 @@ func main() {
 	// Set up controller runtime controller
 	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: clientSet})
 	cloudProvider = cloudprovidermetrics.Decorate(cloudProvider)
+	if opts.InstanceTypeOrdering != "" {
+		if _, err := cloudprovider.NewComparatorChainFor(cloudProvider, strings.Split(opts.InstanceTypeOrdering, ",")...); err != nil {
+			logging.FromContext(ctx).Fatalf("Invalid instance-type-ordering, %s", err)
+		}
+	}
 	manager := controllers.NewManagerOrDie(ctx, config, controllerruntime.Options{
 		Logger:                 zapr.NewLogger(logging.FromContext(ctx).Desugar()),
-		LeaderElection:         true,
+		LeaderElection:         opts.LeaderElection,
 		LeaderElectionID:       "karpenter-leader-election",
+		LeaseDuration:          &opts.LeaderElectionLeaseDuration,
+		RenewDeadline:          &opts.LeaderElectionRenewDeadline,
+		RetryPeriod:            &opts.LeaderElectionRetryPeriod,
 		Scheme:                 scheme,
 		MetricsBindAddress:     fmt.Sprintf(":%d", opts.MetricsPort),
 		HealthProbeBindAddress: fmt.Sprintf(":%d", opts.HealthProbePort),
+		// Release leadership when shutting down, e.g. during a rolling update,
+		// so that another replica takes over without waiting for the lease to expire
+		LeaderElectionReleaseOnCancel: true,
 	})
 
-	provisioningController := provisioning.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider)
+	recorder := events.NewRecorder(manager.GetEventRecorderFor("karpenter"))
+	provisioningController := provisioning.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider, recorder)
 
+	if opts.DashboardPort != 0 {
+		if err := manager.Add(dashboard.NewDashboard(opts.DashboardAddress, opts.DashboardPort, manager.GetClient())); err != nil {
+			panic(fmt.Sprintf("Unable to add dashboard, %s", err))
+		}
+	}
 	if err := manager.RegisterControllers(ctx,
 		provisioningController,
-		selection.NewController(manager.GetClient(), provisioningController),
+		selection.NewController(manager.GetClient(), provisioningController, recorder),
 		persistentvolumeclaim.NewController(manager.GetClient()),
-		termination.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider),
-		node.NewController(manager.GetClient()),
+		termination.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider, recorder),
+		node.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider, recorder),
 		metricspod.NewController(manager.GetClient()),
 		metricsnode.NewController(manager.GetClient()),
 		counter.NewController(manager.GetClient()),
@@ -0,0 +1,92 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-karpenter-explain is a kubectl plugin that explains why a pod is or
+// isn't provisioned by each provisioner, e.g.
+//
+//	kubectl karpenter explain --cluster-name my-cluster --namespace default my-pod
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/signals"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/registry"
+	"github.com/aws/karpenter/pkg/explain"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+)
+
+var (
+	scheme = runtime.NewScheme()
+	// The plugin's flags are declared before opts, which parses all flags
+	namespace = flag.String("namespace", "default", "The namespace of the pod")
+	verbose   = flag.Bool("verbose", false, "Indicates whether the reason that each incompatible instance type can't run the pod should be printed")
+	opts      = options.MustParse()
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apis.AddToScheme(scheme))
+}
+
+func main() {
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: kubectl karpenter explain [--namespace <namespace>] [--verbose] <pod>\n")
+		os.Exit(2)
+	}
+	config := controllerruntime.GetConfigOrDie()
+	config.UserAgent = "karpenter-explain"
+	kubeClient, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		exit(fmt.Errorf("creating kubernetes client, %w", err))
+	}
+	// Logs of the cloud provider and scheduler are discarded, since their
+	// results are reported instead
+	ctx := logging.WithLogger(signals.NewContext(), zap.NewNop().Sugar())
+	ctx = injection.WithConfig(ctx, config)
+	ctx = injection.WithOptions(ctx, opts)
+
+	pod := &v1.Pod{}
+	if err := kubeClient.Get(ctx, types.NamespacedName{Namespace: *namespace, Name: flag.Arg(0)}, pod); err != nil {
+		exit(fmt.Errorf("getting pod, %w", err))
+	}
+	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: kubernetes.NewForConfigOrDie(config)})
+	report, err := explain.NewExplainer(ctx, kubeClient, cloudProvider).Explain(ctx, pod)
+	if err != nil {
+		exit(err)
+	}
+	report.Print(os.Stdout, *verbose)
+}
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	os.Exit(1)
+}
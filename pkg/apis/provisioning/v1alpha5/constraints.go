@@ -37,6 +37,12 @@ type Constraints struct {
 	// KubeletConfiguration are options passed to the kubelet when provisioning nodes
 	//+optional
 	KubeletConfiguration *KubeletConfiguration `json:"kubeletConfiguration,omitempty"`
+	// InstanceSelectionStrategy determines how the instance type options of
+	// each node are chosen, and how the cloud provider chooses between them.
+	// Defaults to CapacityOptimized.
+	// +kubebuilder:validation:Enum=LowestPrice;CapacityOptimized;Diversified
+	// +optional
+	InstanceSelectionStrategy *InstanceSelectionStrategy `json:"instanceSelectionStrategy,omitempty"`
 	// Provider contains fields specific to your cloudprovider.
 	// +kubebuilder:pruning:PreserveUnknownFields
 	Provider *Provider `json:"provider,omitempty"`
@@ -45,6 +51,32 @@ type Constraints struct {
 // +kubebuilder:object:generate=false
 type Provider = runtime.RawExtension
 
+// InstanceSelectionStrategy trades off the cost of nodes against the risk of
+// their capacity being unavailable or interrupted
+type InstanceSelectionStrategy string
+
+const (
+	// InstanceSelectionStrategyLowestPrice prefers the cheapest instance types
+	// that fit the pods, and launches the cheapest of them
+	InstanceSelectionStrategyLowestPrice InstanceSelectionStrategy = "LowestPrice"
+	// InstanceSelectionStrategyCapacityOptimized prefers the smallest instance
+	// types that fit the pods, and launches from the deepest capacity pools,
+	// in order of preference
+	InstanceSelectionStrategyCapacityOptimized InstanceSelectionStrategy = "CapacityOptimized"
+	// InstanceSelectionStrategyDiversified prefers instance types of as many
+	// families as possible, and spreads nodes across capacity pools, so that
+	// an interruption affects fewer nodes
+	InstanceSelectionStrategyDiversified InstanceSelectionStrategy = "Diversified"
+)
+
+// InstanceSelectionStrategyOrDefault returns the strategy, defaulting to CapacityOptimized
+func InstanceSelectionStrategyOrDefault(strategy *InstanceSelectionStrategy) InstanceSelectionStrategy {
+	if strategy == nil {
+		return InstanceSelectionStrategyCapacityOptimized
+	}
+	return *strategy
+}
+
 // ValidatePod returns an error if the pod's requirements are not met by the constraints
 func (c *Constraints) ValidatePod(pod *v1.Pod) error {
 	// Tolerate Taints
@@ -63,12 +95,29 @@ func (c *Constraints) ValidatePod(pod *v1.Pod) error {
 	return nil
 }
 
+// ValidatePod returns an error if the provisioner doesn't provision the pod,
+// because its priority is too low or its requirements are not met by the
+// constraints
+func (s *ProvisionerSpec) ValidatePod(pod *v1.Pod) error {
+	if s.MinPodPriority != nil {
+		priority := int32(0)
+		if pod.Spec.Priority != nil {
+			priority = *pod.Spec.Priority
+		}
+		if priority < *s.MinPodPriority {
+			return fmt.Errorf("priority %d is less than the minimum pod priority %d", priority, *s.MinPodPriority)
+		}
+	}
+	return s.Constraints.ValidatePod(pod)
+}
+
 func (c *Constraints) Tighten(pod *v1.Pod) *Constraints {
 	return &Constraints{
-		Labels:               c.Labels,
-		Requirements:         c.Requirements.Add(NewPodRequirements(pod).Requirements...).WellKnown(),
-		Taints:               c.Taints,
-		Provider:             c.Provider,
-		KubeletConfiguration: c.KubeletConfiguration,
+		Labels:                    c.Labels,
+		Requirements:              c.Requirements.Add(NewPodRequirements(pod).Requirements...).WellKnown(),
+		Taints:                    c.Taints,
+		Provider:                  c.Provider,
+		KubeletConfiguration:      c.KubeletConfiguration,
+		InstanceSelectionStrategy: c.InstanceSelectionStrategy,
 	}
 }
@@ -14,6 +14,16 @@ limitations under the License.
 
 package v1alpha5
 
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // KubeletConfiguration defines args to be used when configuring kubelet on provisioned nodes.
 // They are a subset of the upstream types, recognizing not all options may be supported.
 // Wherever possible, the types and names should reflect the upstream kubelet types.
@@ -22,4 +32,87 @@ type KubeletConfiguration struct {
 	// Note that not all providers may use all addresses.
 	//+optional
 	ClusterDNS []string `json:"clusterDNS,omitempty"`
+	// SystemReserved contains resources reserved for OS system daemons and kernel memory.
+	//+optional
+	SystemReserved v1.ResourceList `json:"systemReserved,omitempty"`
+	// KubeReserved contains resources reserved for Kubernetes system components.
+	//+optional
+	KubeReserved v1.ResourceList `json:"kubeReserved,omitempty"`
+	// EvictionHard is the map of signal names to quantities that define hard eviction thresholds.
+	// For example: {"memory.available": "300Mi"}. Percentages of capacity are also supported.
+	//+optional
+	EvictionHard map[string]string `json:"evictionHard,omitempty"`
+	// EvictionSoft is the map of signal names to quantities that define soft eviction thresholds.
+	// Each soft threshold requires a matching evictionSoftGracePeriod.
+	//+optional
+	EvictionSoft map[string]string `json:"evictionSoft,omitempty"`
+	// EvictionSoftGracePeriod is the map of signal names to grace periods for each soft eviction signal.
+	//+optional
+	EvictionSoftGracePeriod map[string]metav1.Duration `json:"evictionSoftGracePeriod,omitempty"`
+	// MaxPods is an override for the maximum number of pods that can run on a node.
+	//+kubebuilder:validation:Minimum:=0
+	//+optional
+	MaxPods *int32 `json:"maxPods,omitempty"`
+	// PodsPerCore is the maximum number of pods per core. The number of pods on a
+	// node cannot exceed the smaller of maxPods and podsPerCore multiplied by the
+	// number of cores. A value of 0 disables the limit.
+	//+kubebuilder:validation:Minimum:=0
+	//+optional
+	PodsPerCore *int32 `json:"podsPerCore,omitempty"`
+	// ImageGCHighThresholdPercent is the percent of disk usage after which image
+	// garbage collection is always run. The percent is calculated by dividing this
+	// field value by 100, so this field must be between 0 and 100, inclusive.
+	//+kubebuilder:validation:Minimum:=0
+	//+kubebuilder:validation:Maximum:=100
+	//+optional
+	ImageGCHighThresholdPercent *int32 `json:"imageGCHighThresholdPercent,omitempty"`
+	// ImageGCLowThresholdPercent is the percent of disk usage before which image
+	// garbage collection is never run. Lowest disk usage to garbage collect to.
+	// It must be less than imageGCHighThresholdPercent.
+	//+kubebuilder:validation:Minimum:=0
+	//+kubebuilder:validation:Maximum:=100
+	//+optional
+	ImageGCLowThresholdPercent *int32 `json:"imageGCLowThresholdPercent,omitempty"`
+}
+
+// HashInclude excludes the fields other than ClusterDNS from the hashes that
+// name launch templates while they're unset, so that launch templates that
+// don't use them keep their names.
+func (k KubeletConfiguration) HashInclude(field string, v interface{}) (bool, error) {
+	if field == "ClusterDNS" {
+		return true, nil
+	}
+	return !reflect.ValueOf(v).IsZero(), nil
+}
+
+// EvictionThreshold returns the amount of the resource held back by the hard
+// eviction threshold for the signal, resolving percentages against capacity.
+func (k *KubeletConfiguration) EvictionThreshold(signal string, capacity resource.Quantity) (resource.Quantity, bool) {
+	if k == nil {
+		return resource.Quantity{}, false
+	}
+	threshold, ok := k.EvictionHard[signal]
+	if !ok {
+		return resource.Quantity{}, false
+	}
+	if percentage, ok := ParsePercentage(threshold); ok {
+		return *resource.NewQuantity(int64(float64(capacity.Value())*percentage/100), capacity.Format), true
+	}
+	quantity, err := resource.ParseQuantity(threshold)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+	return quantity, true
+}
+
+// ParsePercentage parses values of the form "10%", returning false if the value isn't a percentage.
+func ParsePercentage(value string) (float64, bool) {
+	if !strings.HasSuffix(value, "%") {
+		return 0, false
+	}
+	percentage, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, false
+	}
+	return percentage, true
 }
@@ -24,6 +24,28 @@ import (
 type Limits struct {
 	// Resources contains all the allocatable resources that Karpenter supports for limiting.
 	Resources v1.ResourceList `json:"resources,omitempty"`
+	// Scoped limits bound the resources of the subset of nodes with a label
+	// value, e.g. the nodes in each zone or the spot nodes.
+	// +optional
+	Scoped []ScopedLimit `json:"scoped,omitempty"`
+}
+
+// ScopedLimit bounds the resources of the nodes with a value of a label
+type ScopedLimit struct {
+	// Key is the node label that the limit is scoped by, e.g.
+	// topology.kubernetes.io/zone or karpenter.sh/capacity-type.
+	Key string `json:"key"`
+	// Values of the label that the limit applies to. If empty, the limit
+	// applies to each value of the label separately.
+	// +optional
+	Values []string `json:"values,omitempty"`
+	// Resources bound the resources of the nodes with each value.
+	// +optional
+	Resources v1.ResourceList `json:"resources,omitempty"`
+	// Percentages bound the resources of the nodes with each value, as a
+	// percentage of the resources of all of the provisioner's nodes.
+	// +optional
+	Percentages map[v1.ResourceName]int32 `json:"percentages,omitempty"`
 }
 
 func (l *Limits) ExceededBy(resources v1.ResourceList) error {
@@ -39,3 +61,70 @@ func (l *Limits) ExceededBy(resources v1.ResourceList) error {
 	}
 	return nil
 }
+
+// ScopedExceededBy returns an error if the resources of the nodes with any of
+// the scoped limits' label values exceed the limit
+func (l *Limits) ScopedExceededBy(scoped []ScopedResources, total v1.ResourceList) error {
+	if l == nil {
+		return nil
+	}
+	for i := range l.Scoped {
+		for _, usage := range scoped {
+			if usage.Key != l.Scoped[i].Key || !l.Scoped[i].Applies(usage.Value) {
+				continue
+			}
+			if err := l.Scoped[i].ExceededBy(usage.Value, usage.Resources, total); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Applies returns true if the limit bounds the nodes with the label value
+func (s *ScopedLimit) Applies(value string) bool {
+	if len(s.Values) == 0 {
+		return true
+	}
+	for _, v := range s.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ExceededBy returns an error if the resources of the nodes with the label
+// value are greater than the limit. Percentages are relative to the total
+// resources of the provisioner's nodes.
+func (s *ScopedLimit) ExceededBy(value string, usage v1.ResourceList, total v1.ResourceList) error {
+	if err := s.ResourcesExceededBy(value, usage); err != nil {
+		return err
+	}
+	return s.PercentagesExceededBy(value, usage, total)
+}
+
+// ResourcesExceededBy returns an error if the resources of the nodes with the
+// label value are greater than the limit's resources
+func (s *ScopedLimit) ResourcesExceededBy(value string, usage v1.ResourceList) error {
+	for resourceName, quantity := range usage {
+		if limit, ok := s.Resources[resourceName]; ok && quantity.Cmp(limit) > 0 {
+			return fmt.Errorf("%s resource usage of %v for %s=%s exceeds limit of %v", resourceName, quantity.AsDec(), s.Key, value, limit.AsDec())
+		}
+	}
+	return nil
+}
+
+// PercentagesExceededBy returns an error if the resources of the nodes with the
+// label value are greater than the limit's percentages of the total resources
+func (s *ScopedLimit) PercentagesExceededBy(value string, usage v1.ResourceList, total v1.ResourceList) error {
+	for resourceName, quantity := range usage {
+		if percentage, ok := s.Percentages[resourceName]; ok {
+			// Compared as floats, since milli values overflow for large quantities of memory
+			if all := total[resourceName]; quantity.AsApproximateFloat64()*100 > float64(percentage)*all.AsApproximateFloat64() {
+				return fmt.Errorf("%s resource usage of %v for %s=%s exceeds %d%% of %v", resourceName, quantity.AsDec(), s.Key, value, percentage, all.AsDec())
+			}
+		}
+	}
+	return nil
+}
@@ -41,14 +41,121 @@ type ProvisionerSpec struct {
 	// Termination due to expiration is disabled if this field is not set.
 	// +optional
 	TTLSecondsUntilExpired *int64 `json:"ttlSecondsUntilExpired,omitempty"`
+	// DeprovisioningMode determines the action taken on nodes that are
+	// selected for deprovisioning, e.g. because they are expired or empty.
+	// Delete terminates the node, cordoning and draining it first. Cordon only
+	// cordons the node and annotates it with the reason it was selected,
+	// leaving draining and termination to the cluster operator. Overrides the
+	// controller's --deprovisioning-mode.
+	// +kubebuilder:validation:Enum=Delete;Cordon
+	// +optional
+	DeprovisioningMode *DeprovisioningMode `json:"deprovisioningMode,omitempty"`
+	// DeletionPolicy determines what happens to the provisioner's nodes when
+	// the provisioner is deleted. Delete cordons, drains, and terminates the
+	// nodes, respecting pod disruption budgets, and waits for them to be gone
+	// before the provisioner is removed. Orphan removes the provisioner label
+	// from the nodes and leaves them running.
+	// +kubebuilder:validation:Enum=Delete;Orphan
+	// +optional
+	DeletionPolicy *DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// TerminationGracePeriod is the maximum duration that the provisioner's
+	// nodes are drained for, measured from when the node is deleted. Evictions
+	// respect pod disruption budgets until then, after which the pods that
+	// remain on the node are force deleted. Nodes are drained until all of
+	// their pods are evicted if it's not set.
+	// +optional
+	TerminationGracePeriod *metav1.Duration `json:"terminationGracePeriod,omitempty"`
+	// UpdateStrategy determines how expired nodes are updated. Expired nodes
+	// are replaced if it's not set.
+	// +optional
+	UpdateStrategy *UpdateStrategy `json:"updateStrategy,omitempty"`
 	// Limits define a set of bounds for provisioning capacity.
 	Limits *Limits `json:"limits,omitempty"`
+	// MinPodPriority is the lowest priority of the pods that the provisioner
+	// launches nodes for. Pods with a lower priority are left to the
+	// kube-scheduler, which may preempt even lower priority pods for them.
+	// All pods are provisioned for if it's not set.
+	// +optional
+	MinPodPriority *int32 `json:"minPodPriority,omitempty"`
+	// MaxNodesPerMinute limits the rate at which the provisioner launches
+	// nodes, in bursts of up to a minute's worth of nodes. Nodes that exceed
+	// the rate are queued and launched once the rate allows. Applies in
+	// addition to the controller's --max-nodes-per-minute.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxNodesPerMinute *int32 `json:"maxNodesPerMinute,omitempty"`
+	// BatchIdleDuration is the amount of time the provisioner waits for
+	// additional pods after the most recently received pod before launching
+	// capacity. Overrides the controller's --batch-idle-duration.
+	// +optional
+	BatchIdleDuration *metav1.Duration `json:"batchIdleDuration,omitempty"`
+	// BatchMaxDuration is the maximum amount of time the provisioner waits for
+	// pods before launching capacity, measured from the first pod in a batch.
+	// Overrides the controller's --batch-max-duration.
+	// +optional
+	BatchMaxDuration *metav1.Duration `json:"batchMaxDuration,omitempty"`
+}
+
+// DeprovisioningMode is the action taken on nodes selected for deprovisioning
+type DeprovisioningMode string
+
+const (
+	// DeprovisioningModeDelete terminates nodes selected for deprovisioning
+	DeprovisioningModeDelete DeprovisioningMode = "Delete"
+	// DeprovisioningModeCordon cordons and annotates nodes selected for
+	// deprovisioning without draining or terminating them
+	DeprovisioningModeCordon DeprovisioningMode = "Cordon"
+)
+
+// DeletionPolicy is the action taken on a provisioner's nodes when the
+// provisioner is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete terminates the provisioner's nodes before the
+	// provisioner is removed
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan leaves the provisioner's nodes running, no longer
+	// managed by the provisioner
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+// UpdateStrategy determines how the provisioner's nodes are updated when they
+// expire
+type UpdateStrategy struct {
+	// Type is Replace or InPlace. Replace deprovisions expired nodes, so that
+	// pods are rescheduled to new nodes. InPlace cordons and drains expired
+	// nodes, then patches their operating system and reboots them, if the
+	// cloud provider supports it for the node. Nodes that can't be updated in
+	// place are replaced. Nodes updated in place expire again
+	// ttlSecondsUntilExpired after the update.
+	// +kubebuilder:validation:Enum=Replace;InPlace
+	Type UpdateStrategyType `json:"type"`
+	// MaxUnavailable is the number of the provisioner's nodes that may be
+	// updating in place at the same time. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxUnavailable *int32 `json:"maxUnavailable,omitempty"`
 }
 
+// UpdateStrategyType is the way the provisioner's nodes are updated
+type UpdateStrategyType string
+
+const (
+	// UpdateStrategyReplace replaces expired nodes with new nodes
+	UpdateStrategyReplace UpdateStrategyType = "Replace"
+	// UpdateStrategyInPlace patches the operating system of expired nodes
+	// without replacing them
+	UpdateStrategyInPlace UpdateStrategyType = "InPlace"
+)
+
 // Provisioner is the Schema for the Provisioners API
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:path=provisioners,scope=Cluster
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Nodes",type="integer",JSONPath=".status.nodeCount"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type Provisioner struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
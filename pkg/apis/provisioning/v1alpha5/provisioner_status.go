@@ -33,11 +33,28 @@ type ProvisionerStatus struct {
 
 	// Resources is the list of resources that have been provisioned.
 	Resources v1.ResourceList `json:"resources,omitempty"`
+
+	// ScopedResources are the resources that have been provisioned for each
+	// value of the labels that the provisioner's scoped limits are scoped by.
+	// +optional
+	ScopedResources []ScopedResources `json:"scopedResources,omitempty"`
+
+	// NodeCount is the number of nodes that have been provisioned.
+	// +optional
+	NodeCount int32 `json:"nodeCount,omitempty"`
+}
+
+// ScopedResources are the resources of the nodes with a value of a label
+type ScopedResources struct {
+	Key       string          `json:"key"`
+	Value     string          `json:"value"`
+	Resources v1.ResourceList `json:"resources,omitempty"`
 }
 
 func (p *Provisioner) StatusConditions() apis.ConditionManager {
 	return apis.NewLivingConditionSet(
 		Active,
+		WithinLimits,
 	).Manage(p)
 }
 
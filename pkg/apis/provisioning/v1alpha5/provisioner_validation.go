@@ -21,6 +21,7 @@ import (
 
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/apis"
@@ -28,8 +29,14 @@ import (
 )
 
 var (
-	SupportedNodeSelectorOps sets.String = sets.NewString(string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn), string(v1.NodeSelectorOpExists), string(v1.NodeSelectorOpDoesNotExist))
-	SupportedProvisionerOps  sets.String = sets.NewString(string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn), string(v1.NodeSelectorOpExists))
+	SupportedReservedResources   sets.String = sets.NewString(string(v1.ResourceCPU), string(v1.ResourceMemory), string(v1.ResourceEphemeralStorage), "pid")
+	SupportedEvictionSignals     sets.String = sets.NewString("memory.available", "nodefs.available", "nodefs.inodesFree", "imagefs.available", "imagefs.inodesFree", "pid.available")
+	SupportedNodeSelectorOps     sets.String = sets.NewString(string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn), string(v1.NodeSelectorOpExists), string(v1.NodeSelectorOpDoesNotExist))
+	SupportedProvisionerOps      sets.String = sets.NewString(string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn), string(v1.NodeSelectorOpExists))
+	SupportedDeprovisioningModes sets.String = sets.NewString(string(DeprovisioningModeDelete), string(DeprovisioningModeCordon))
+	SupportedDeletionPolicies    sets.String = sets.NewString(string(DeletionPolicyDelete), string(DeletionPolicyOrphan))
+	SupportedUpdateStrategies    sets.String = sets.NewString(string(UpdateStrategyReplace), string(UpdateStrategyInPlace))
+	SupportedSelectionStrategies sets.String = sets.NewString(string(InstanceSelectionStrategyLowestPrice), string(InstanceSelectionStrategyCapacityOptimized), string(InstanceSelectionStrategyDiversified))
 )
 
 func (p *Provisioner) Validate(ctx context.Context) (errs *apis.FieldError) {
@@ -43,6 +50,13 @@ func (s *ProvisionerSpec) validate(ctx context.Context) (errs *apis.FieldError)
 	return errs.Also(
 		s.validateTTLSecondsUntilExpired(),
 		s.validateTTLSecondsAfterEmpty(),
+		s.validateBatchDurations(),
+		s.validateMaxNodesPerMinute(),
+		s.validateDeprovisioningMode(),
+		s.validateDeletionPolicy(),
+		s.validateTerminationGracePeriod(),
+		s.validateUpdateStrategy(),
+		s.validateLimits(),
 		s.Validate(ctx),
 	)
 }
@@ -61,16 +75,105 @@ func (s *ProvisionerSpec) validateTTLSecondsAfterEmpty() (errs *apis.FieldError)
 	return errs
 }
 
+func (s *ProvisionerSpec) validateTerminationGracePeriod() (errs *apis.FieldError) {
+	if s.TerminationGracePeriod != nil && s.TerminationGracePeriod.Duration < 0 {
+		return errs.Also(apis.ErrInvalidValue("cannot be negative", "terminationGracePeriod"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateBatchDurations() (errs *apis.FieldError) {
+	if s.BatchIdleDuration != nil && s.BatchIdleDuration.Duration <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "batchIdleDuration"))
+	}
+	if s.BatchMaxDuration != nil && s.BatchMaxDuration.Duration <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "batchMaxDuration"))
+	}
+	if s.BatchIdleDuration != nil && s.BatchMaxDuration != nil && s.BatchIdleDuration.Duration > s.BatchMaxDuration.Duration {
+		errs = errs.Also(apis.ErrGeneric("batchIdleDuration must not exceed batchMaxDuration", "batchIdleDuration", "batchMaxDuration"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateMaxNodesPerMinute() (errs *apis.FieldError) {
+	if s.MaxNodesPerMinute != nil && *s.MaxNodesPerMinute <= 0 {
+		return errs.Also(apis.ErrInvalidValue("must be positive", "maxNodesPerMinute"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateDeprovisioningMode() (errs *apis.FieldError) {
+	if s.DeprovisioningMode != nil && !SupportedDeprovisioningModes.Has(string(*s.DeprovisioningMode)) {
+		return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, must be one of %s", *s.DeprovisioningMode, SupportedDeprovisioningModes.List()), "deprovisioningMode"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateDeletionPolicy() (errs *apis.FieldError) {
+	if s.DeletionPolicy != nil && !SupportedDeletionPolicies.Has(string(*s.DeletionPolicy)) {
+		return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, must be one of %s", *s.DeletionPolicy, SupportedDeletionPolicies.List()), "deletionPolicy"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateUpdateStrategy() (errs *apis.FieldError) {
+	if s.UpdateStrategy == nil {
+		return errs
+	}
+	if !SupportedUpdateStrategies.Has(string(s.UpdateStrategy.Type)) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, must be one of %s", s.UpdateStrategy.Type, SupportedUpdateStrategies.List()), "updateStrategy.type"))
+	}
+	if s.UpdateStrategy.MaxUnavailable != nil && *s.UpdateStrategy.MaxUnavailable <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "updateStrategy.maxUnavailable"))
+	}
+	return errs
+}
+
+func (s *ProvisionerSpec) validateLimits() (errs *apis.FieldError) {
+	if s.Limits == nil {
+		return errs
+	}
+	for i, scoped := range s.Limits.Scoped {
+		path := fmt.Sprintf("limits.scoped[%d]", i)
+		for _, err := range validation.IsQualifiedName(scoped.Key) {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, %s", scoped.Key, err), path+".key"))
+		}
+		for _, value := range scoped.Values {
+			for _, err := range validation.IsValidLabelValue(value) {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, %s", value, err), path+".values"))
+			}
+		}
+		if len(scoped.Resources) == 0 && len(scoped.Percentages) == 0 {
+			errs = errs.Also(apis.ErrMissingOneOf(path+".resources", path+".percentages"))
+		}
+		for resourceName, percentage := range scoped.Percentages {
+			if percentage < 0 || percentage > 100 {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%d, must be between 0 and 100", percentage), fmt.Sprintf("%s.percentages[%s]", path, resourceName)))
+			}
+		}
+	}
+	return errs
+}
+
 // Validate the constraints
 func (c *Constraints) Validate(ctx context.Context) (errs *apis.FieldError) {
 	return errs.Also(
 		c.validateLabels(),
 		c.validateTaints(),
 		c.validateRequirements(),
+		c.validateInstanceSelectionStrategy(),
+		c.KubeletConfiguration.validate().ViaField("kubeletConfiguration"),
 		ValidateHook(ctx, c),
 	)
 }
 
+func (c *Constraints) validateInstanceSelectionStrategy() (errs *apis.FieldError) {
+	if c.InstanceSelectionStrategy != nil && !SupportedSelectionStrategies.Has(string(*c.InstanceSelectionStrategy)) {
+		return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, must be one of %s", *c.InstanceSelectionStrategy, SupportedSelectionStrategies.List()), "instanceSelectionStrategy"))
+	}
+	return errs
+}
+
 func (c *Constraints) validateLabels() (errs *apis.FieldError) {
 	for key, value := range c.Labels {
 		for _, err := range validation.IsQualifiedName(key) {
@@ -155,3 +258,71 @@ func (c *Constraints) validateRequirements() (errs *apis.FieldError) {
 	}
 	return errs
 }
+
+func (k *KubeletConfiguration) validate() (errs *apis.FieldError) {
+	if k == nil {
+		return errs
+	}
+	return errs.Also(
+		validateReservedResources(k.SystemReserved, "systemReserved"),
+		validateReservedResources(k.KubeReserved, "kubeReserved"),
+		validateEvictionThresholds(k.EvictionHard, "evictionHard"),
+		validateEvictionThresholds(k.EvictionSoft, "evictionSoft"),
+		k.validateEvictionSoftGracePeriod(),
+		k.validateImageGCThresholds(),
+	)
+}
+
+func validateReservedResources(reserved v1.ResourceList, fieldName string) (errs *apis.FieldError) {
+	for resourceName, quantity := range reserved {
+		if !SupportedReservedResources.Has(string(resourceName)) {
+			errs = errs.Also(apis.ErrInvalidKeyName(string(resourceName), fieldName, fmt.Sprintf("must be one of %s", SupportedReservedResources.List())))
+		}
+		if quantity.Sign() < 0 {
+			errs = errs.Also(apis.ErrInvalidValue("cannot be negative", fmt.Sprintf("%s[%s]", fieldName, resourceName)))
+		}
+	}
+	return errs
+}
+
+func validateEvictionThresholds(thresholds map[string]string, fieldName string) (errs *apis.FieldError) {
+	for signal, threshold := range thresholds {
+		if !SupportedEvictionSignals.Has(signal) {
+			errs = errs.Also(apis.ErrInvalidKeyName(signal, fieldName, fmt.Sprintf("must be one of %s", SupportedEvictionSignals.List())))
+		}
+		if percentage, ok := ParsePercentage(threshold); ok {
+			if percentage < 0 || percentage > 100 {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, percentage must be between 0 and 100", threshold), fmt.Sprintf("%s[%s]", fieldName, signal)))
+			}
+			continue
+		}
+		if quantity, err := resource.ParseQuantity(threshold); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, %s", threshold, err), fmt.Sprintf("%s[%s]", fieldName, signal)))
+		} else if quantity.Sign() < 0 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, cannot be negative", threshold), fmt.Sprintf("%s[%s]", fieldName, signal)))
+		}
+	}
+	return errs
+}
+
+func (k *KubeletConfiguration) validateEvictionSoftGracePeriod() (errs *apis.FieldError) {
+	for signal := range k.EvictionSoft {
+		if _, ok := k.EvictionSoftGracePeriod[signal]; !ok {
+			errs = errs.Also(apis.ErrMissingField(fmt.Sprintf("evictionSoftGracePeriod[%s]", signal)))
+		}
+	}
+	for signal := range k.EvictionSoftGracePeriod {
+		if _, ok := k.EvictionSoft[signal]; !ok {
+			errs = errs.Also(apis.ErrInvalidKeyName(signal, "evictionSoftGracePeriod", "must have a matching evictionSoft threshold"))
+		}
+	}
+	return errs
+}
+
+func (k *KubeletConfiguration) validateImageGCThresholds() (errs *apis.FieldError) {
+	if k.ImageGCHighThresholdPercent != nil && k.ImageGCLowThresholdPercent != nil &&
+		ptr.Int32Value(k.ImageGCLowThresholdPercent) >= ptr.Int32Value(k.ImageGCHighThresholdPercent) {
+		errs = errs.Also(apis.ErrInvalidValue("must be less than imageGCHighThresholdPercent", "imageGCLowThresholdPercent"))
+	}
+	return errs
+}
@@ -17,6 +17,7 @@ package v1alpha5
 import (
 	"context"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -40,11 +41,22 @@ var (
 		metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 		return nil
 	})
-	ProvisionerNameLabelKey         = Group + "/provisioner-name"
-	NotReadyTaintKey                = Group + "/not-ready"
-	DoNotEvictPodAnnotationKey      = Group + "/do-not-evict"
-	EmptinessTimestampAnnotationKey = Group + "/emptiness-timestamp"
-	TerminationFinalizer            = Group + "/termination"
+	ProvisionerNameLabelKey              = Group + "/provisioner-name"
+	OrphanedProvisionerNameLabelKey      = Group + "/orphaned-provisioner-name"
+	NotReadyTaintKey                     = Group + "/not-ready"
+	DoNotEvictPodAnnotationKey           = Group + "/do-not-evict"
+	DoNotConsolidateNodeAnnotationKey    = Group + "/do-not-consolidate"
+	EmptinessTimestampAnnotationKey      = Group + "/emptiness-timestamp"
+	DeprovisioningCandidateAnnotationKey = Group + "/deprovisioning-candidate"
+	DeprovisioningReasonAnnotationKey    = Group + "/deprovisioning-reason"
+	NominatedNodeAnnotationKey           = Group + "/nominated-node"
+	NominatedProvisionerAnnotationKey    = Group + "/nominated-provisioner"
+	NominatedTimestampAnnotationKey      = Group + "/nominated-timestamp"
+	ExpectedReadyTimestampAnnotationKey  = Group + "/expected-ready-timestamp"
+	InPlaceUpdateAnnotationKey           = Group + "/in-place-update"
+	TriggerAnnotationKey                 = Group + "/trigger"
+	TerminationFinalizer                 = Group + "/termination"
+	CleanupFinalizer                     = Group + "/cleanup"
 )
 
 const (
@@ -52,4 +64,11 @@ const (
 	// controller is able to take actions: it's correctly configured, can make
 	// necessary API calls, and isn't disabled.
 	Active apis.ConditionType = "Active"
+	// WithinLimits indicates that the resources provisioned by a provisioner
+	// don't exceed its limits, so that it's able to launch additional nodes.
+	WithinLimits apis.ConditionType = "WithinLimits"
+	// NodeOSUpdate is a node condition that reports the status of the latest
+	// in-place update of the node's operating system. It's Unknown while the
+	// update is in progress.
+	NodeOSUpdate v1.NodeConditionType = "OSUpdate"
 )
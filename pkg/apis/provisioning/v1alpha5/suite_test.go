@@ -18,6 +18,7 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	. "github.com/onsi/ginkgo"
@@ -26,6 +27,7 @@ import (
 	"knative.dev/pkg/ptr"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
@@ -66,6 +68,91 @@ var _ = Describe("Validation", func() {
 		Expect(provisioner.Validate(ctx)).To(Succeed())
 	})
 
+	It("should fail on a negative termination grace period", func() {
+		provisioner.Spec.TerminationGracePeriod = &metav1.Duration{Duration: -time.Minute}
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+	})
+	It("should succeed on a termination grace period", func() {
+		provisioner.Spec.TerminationGracePeriod = &metav1.Duration{Duration: time.Hour}
+		Expect(provisioner.Validate(ctx)).To(Succeed())
+	})
+
+	Context("Batching", func() {
+		It("should allow batch durations", func() {
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: 5 * time.Second}
+			provisioner.Spec.BatchMaxDuration = &metav1.Duration{Duration: time.Minute}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail on non-positive batch durations", func() {
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: 0}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.BatchIdleDuration = nil
+			provisioner.Spec.BatchMaxDuration = &metav1.Duration{Duration: -time.Second}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail if the idle duration exceeds the max duration", func() {
+			provisioner.Spec.BatchIdleDuration = &metav1.Duration{Duration: time.Minute}
+			provisioner.Spec.BatchMaxDuration = &metav1.Duration{Duration: time.Second}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("DeprovisioningMode", func() {
+		It("should allow supported deprovisioning modes", func() {
+			for _, mode := range []DeprovisioningMode{DeprovisioningModeDelete, DeprovisioningModeCordon} {
+				mode := mode
+				provisioner.Spec.DeprovisioningMode = &mode
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail on unsupported deprovisioning modes", func() {
+			mode := DeprovisioningMode("Drain")
+			provisioner.Spec.DeprovisioningMode = &mode
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("InstanceSelectionStrategy", func() {
+		It("should allow supported strategies", func() {
+			for _, strategy := range []InstanceSelectionStrategy{InstanceSelectionStrategyLowestPrice, InstanceSelectionStrategyCapacityOptimized, InstanceSelectionStrategyDiversified} {
+				strategy := strategy
+				provisioner.Spec.InstanceSelectionStrategy = &strategy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail on unsupported strategies", func() {
+			strategy := InstanceSelectionStrategy("Random")
+			provisioner.Spec.InstanceSelectionStrategy = &strategy
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("MaxNodesPerMinute", func() {
+		It("should allow a positive rate", func() {
+			provisioner.Spec.MaxNodesPerMinute = ptr.Int32(10)
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail on a rate that isn't positive", func() {
+			provisioner.Spec.MaxNodesPerMinute = ptr.Int32(0)
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("DeletionPolicy", func() {
+		It("should allow supported deletion policies", func() {
+			for _, policy := range []DeletionPolicy{DeletionPolicyDelete, DeletionPolicyOrphan} {
+				policy := policy
+				provisioner.Spec.DeletionPolicy = &policy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail on unsupported deletion policies", func() {
+			policy := DeletionPolicy("Retain")
+			provisioner.Spec.DeletionPolicy = &policy
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
 	Context("Limits", func() {
 		It("should allow undefined limits", func() {
 			provisioner.Spec.Limits = &Limits{}
@@ -75,6 +162,33 @@ var _ = Describe("Validation", func() {
 			provisioner.Spec.Limits = &Limits{Resources: v1.ResourceList{}}
 			Expect(provisioner.Validate(ctx)).To(Succeed())
 		})
+		It("should allow scoped limits", func() {
+			provisioner.Spec.Limits = &Limits{Scoped: []ScopedLimit{
+				{Key: v1.LabelTopologyZone, Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")}},
+				{Key: LabelCapacityType, Values: []string{"spot"}, Percentages: map[v1.ResourceName]int32{v1.ResourceCPU: 60}},
+			}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail on scoped limits without bounds", func() {
+			provisioner.Spec.Limits = &Limits{Scoped: []ScopedLimit{{Key: v1.LabelTopologyZone}}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail on scoped limits with invalid keys", func() {
+			provisioner.Spec.Limits = &Limits{Scoped: []ScopedLimit{{Key: "spaces are not allowed", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")}}}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail on percentages that are out of range", func() {
+			for _, percentage := range []int32{-1, 101} {
+				provisioner.Spec.Limits = &Limits{Scoped: []ScopedLimit{{Key: LabelCapacityType, Percentages: map[v1.ResourceName]int32{v1.ResourceCPU: percentage}}}}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+		It("should compare percentages of large quantities of memory without overflowing", func() {
+			limit := ScopedLimit{Key: LabelCapacityType, Percentages: map[v1.ResourceName]int32{v1.ResourceMemory: 60}}
+			total := v1.ResourceList{v1.ResourceMemory: resource.MustParse("200Ti")}
+			Expect(limit.ExceededBy("spot", v1.ResourceList{v1.ResourceMemory: resource.MustParse("100Ti")}, total)).To(Succeed())
+			Expect(limit.ExceededBy("spot", v1.ResourceList{v1.ResourceMemory: resource.MustParse("150Ti")}, total)).ToNot(Succeed())
+		})
 	})
 
 	Context("Labels", func() {
@@ -137,6 +251,59 @@ var _ = Describe("Validation", func() {
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 		})
 	})
+	Context("KubeletConfiguration", func() {
+		It("should succeed for valid kubelet configuration", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{
+				SystemReserved:              v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("100Mi")},
+				KubeReserved:                v1.ResourceList{v1.ResourceMemory: resource.MustParse("500Mi")},
+				EvictionHard:                map[string]string{"memory.available": "5%", "nodefs.available": "10%"},
+				EvictionSoft:                map[string]string{"memory.available": "500Mi"},
+				EvictionSoftGracePeriod:     map[string]metav1.Duration{"memory.available": {Duration: time.Minute}},
+				MaxPods:                     ptr.Int32(20),
+				PodsPerCore:                 ptr.Int32(4),
+				ImageGCHighThresholdPercent: ptr.Int32(80),
+				ImageGCLowThresholdPercent:  ptr.Int32(60),
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for unsupported reserved resources", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{SystemReserved: v1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for negative reserved resources", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{KubeReserved: v1.ResourceList{v1.ResourceMemory: resource.MustParse("-1Gi")}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for unknown eviction signals", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{EvictionHard: map[string]string{"memory.unknown": "100Mi"}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for invalid eviction thresholds", func() {
+			for _, threshold := range []string{"110%", "-1%", "abc", "-100Mi"} {
+				provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{EvictionHard: map[string]string{"memory.available": threshold}}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+		It("should fail for soft eviction thresholds without grace periods", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{EvictionSoft: map[string]string{"memory.available": "500Mi"}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for grace periods without soft eviction thresholds", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{EvictionSoftGracePeriod: map[string]metav1.Duration{"memory.available": {Duration: time.Minute}}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail when the image gc low threshold is not less than the high threshold", func() {
+			provisioner.Spec.KubeletConfiguration = &KubeletConfiguration{ImageGCHighThresholdPercent: ptr.Int32(50), ImageGCLowThresholdPercent: ptr.Int32(50)}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should resolve percentage eviction thresholds against capacity", func() {
+			kubeletConfig := &KubeletConfiguration{EvictionHard: map[string]string{"memory.available": "10%"}}
+			threshold, ok := kubeletConfig.EvictionThreshold("memory.available", resource.MustParse("10Gi"))
+			Expect(ok).To(BeTrue())
+			expected := resource.MustParse("1Gi")
+			Expect(threshold.Value()).To(Equal(expected.Value()))
+		})
+	})
 	Context("Validation", func() {
 		It("should allow supported ops", func() {
 			provisioner.Spec.Requirements = NewRequirements(
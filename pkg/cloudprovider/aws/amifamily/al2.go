@@ -66,3 +66,8 @@ func (a AL2) DefaultBlockDeviceMappings() []*v1alpha1.BlockDeviceMapping {
 		EBS:        &defaultEBS,
 	}}
 }
+
+// EphemeralBlockDevice is the root volume, which the kubelet and container runtime share with the OS
+func (a AL2) EphemeralBlockDevice() *string {
+	return aws.String("/dev/xvda")
+}
@@ -19,6 +19,8 @@ import (
 	"fmt"
 
 	"github.com/pelletier/go-toml/v2"
+	core "k8s.io/api/core/v1"
+	"knative.dev/pkg/ptr"
 )
 
 type Bottlerocket struct {
@@ -37,13 +39,18 @@ type settings struct {
 
 // kubernetes specific configuration for bottlerocket api
 type kubernetes struct {
-	APIServer          string              `toml:"api-server"`
-	ClusterCertificate *string             `toml:"cluster-certificate"`
-	ClusterName        string              `toml:"cluster-name,omitempty"`
-	ClusterDNSIP       string              `toml:"cluster-dns-ip,omitempty"`
-	NodeLabels         map[string]string   `toml:"node-labels,omitempty"`
-	NodeTaints         map[string][]string `toml:"node-taints,omitempty"`
-	MaxPods            int                 `toml:"max-pods,omitempty"`
+	APIServer                   string              `toml:"api-server"`
+	ClusterCertificate          *string             `toml:"cluster-certificate"`
+	ClusterName                 string              `toml:"cluster-name,omitempty"`
+	ClusterDNSIP                string              `toml:"cluster-dns-ip,omitempty"`
+	NodeLabels                  map[string]string   `toml:"node-labels,omitempty"`
+	NodeTaints                  map[string][]string `toml:"node-taints,omitempty"`
+	MaxPods                     int                 `toml:"max-pods,omitempty"`
+	SystemReserved              map[string]string   `toml:"system-reserved,omitempty"`
+	KubeReserved                map[string]string   `toml:"kube-reserved,omitempty"`
+	EvictionHard                map[string]string   `toml:"eviction-hard,omitempty"`
+	ImageGCHighThresholdPercent *string             `toml:"image-gc-high-threshold-percent,omitempty"`
+	ImageGCLowThresholdPercent  *string             `toml:"image-gc-low-threshold-percent,omitempty"`
 }
 
 func (b Bottlerocket) Script() string {
@@ -61,8 +68,25 @@ func (b Bottlerocket) Script() string {
 	if !b.AWSENILimitedPodDensity {
 		s.Settings.Kubernetes.MaxPods = 110
 	}
-	s.Settings.Kubernetes.NodeTaints = map[string][]string{}
+	// Bottlerocket does not support soft eviction thresholds or pods per core
+	if b.KubeletConfig != nil {
+		if b.KubeletConfig.MaxPods != nil {
+			s.Settings.Kubernetes.MaxPods = int(ptr.Int32Value(b.KubeletConfig.MaxPods))
+		}
+		s.Settings.Kubernetes.SystemReserved = resourceListToMap(b.KubeletConfig.SystemReserved)
+		s.Settings.Kubernetes.KubeReserved = resourceListToMap(b.KubeletConfig.KubeReserved)
+		s.Settings.Kubernetes.EvictionHard = b.KubeletConfig.EvictionHard
+		if b.KubeletConfig.ImageGCHighThresholdPercent != nil {
+			s.Settings.Kubernetes.ImageGCHighThresholdPercent = ptr.String(fmt.Sprint(ptr.Int32Value(b.KubeletConfig.ImageGCHighThresholdPercent)))
+		}
+		if b.KubeletConfig.ImageGCLowThresholdPercent != nil {
+			s.Settings.Kubernetes.ImageGCLowThresholdPercent = ptr.String(fmt.Sprint(ptr.Int32Value(b.KubeletConfig.ImageGCLowThresholdPercent)))
+		}
+	}
 	for _, taint := range b.Taints {
+		if s.Settings.Kubernetes.NodeTaints == nil {
+			s.Settings.Kubernetes.NodeTaints = map[string][]string{}
+		}
 		s.Settings.Kubernetes.NodeTaints[taint.Key] = append(s.Settings.Kubernetes.NodeTaints[taint.Key], fmt.Sprintf("%s:%s", taint.Value, taint.Effect))
 	}
 	script, err := toml.Marshal(s)
@@ -71,3 +95,14 @@ func (b Bottlerocket) Script() string {
 	}
 	return base64.StdEncoding.EncodeToString(script)
 }
+
+func resourceListToMap(resources core.ResourceList) map[string]string {
+	if len(resources) == 0 {
+		return nil
+	}
+	result := map[string]string{}
+	for name, quantity := range resources {
+		result[string(name)] = quantity.String()
+	}
+	return result
+}
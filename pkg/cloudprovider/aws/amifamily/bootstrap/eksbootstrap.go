@@ -18,9 +18,13 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
+	core "k8s.io/api/core/v1"
+	"knative.dev/pkg/ptr"
+
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 )
 
@@ -38,9 +42,12 @@ func (e EKS) Script() string {
 	userData.WriteString("exec > >(tee /var/log/user-data.log|logger -t user-data -s 2>/dev/console) 2>&1\n")
 	userData.WriteString(fmt.Sprintf("/etc/eks/bootstrap.sh '%s' --apiserver-endpoint='%s' %s", e.ClusterName, e.ClusterEndpoint, caBundleArg))
 
-	kubeletExtraArgs := strings.Join([]string{e.nodeLabelArg(), e.nodeTaintArg()}, " ")
+	kubeletExtraArgs := strings.Join(append([]string{e.nodeLabelArg(), e.nodeTaintArg()}, e.kubeletConfigArgs()...), " ")
 
-	if !e.AWSENILimitedPodDensity {
+	if e.KubeletConfig != nil && e.KubeletConfig.MaxPods != nil {
+		userData.WriteString(" \\\n--use-max-pods=false")
+		kubeletExtraArgs += fmt.Sprintf(" --max-pods=%d", ptr.Int32Value(e.KubeletConfig.MaxPods))
+	} else if !e.AWSENILimitedPodDensity {
 		userData.WriteString(" \\\n--use-max-pods=false")
 		kubeletExtraArgs += " --max-pods=110"
 	}
@@ -53,6 +60,63 @@ func (e EKS) Script() string {
 	return base64.StdEncoding.EncodeToString(userData.Bytes())
 }
 
+// kubeletConfigArgs translates the provisioner's kubelet configuration into
+// kubelet flags. Map values are sorted so that the user data is deterministic.
+func (e EKS) kubeletConfigArgs() []string {
+	if e.KubeletConfig == nil {
+		return nil
+	}
+	args := []string{}
+	if arg := joinResourceList(e.KubeletConfig.SystemReserved); arg != "" {
+		args = append(args, fmt.Sprintf("--system-reserved=%s", arg))
+	}
+	if arg := joinResourceList(e.KubeletConfig.KubeReserved); arg != "" {
+		args = append(args, fmt.Sprintf("--kube-reserved=%s", arg))
+	}
+	if arg := joinThresholds(e.KubeletConfig.EvictionHard); arg != "" {
+		args = append(args, fmt.Sprintf("--eviction-hard=%s", arg))
+	}
+	if arg := joinThresholds(e.KubeletConfig.EvictionSoft); arg != "" {
+		args = append(args, fmt.Sprintf("--eviction-soft=%s", arg))
+	}
+	if len(e.KubeletConfig.EvictionSoftGracePeriod) > 0 {
+		gracePeriods := []string{}
+		for signal, duration := range e.KubeletConfig.EvictionSoftGracePeriod {
+			gracePeriods = append(gracePeriods, fmt.Sprintf("%s=%s", signal, duration.Duration))
+		}
+		sort.Strings(gracePeriods)
+		args = append(args, fmt.Sprintf("--eviction-soft-grace-period=%s", strings.Join(gracePeriods, ",")))
+	}
+	if e.KubeletConfig.PodsPerCore != nil {
+		args = append(args, fmt.Sprintf("--pods-per-core=%d", ptr.Int32Value(e.KubeletConfig.PodsPerCore)))
+	}
+	if e.KubeletConfig.ImageGCHighThresholdPercent != nil {
+		args = append(args, fmt.Sprintf("--image-gc-high-threshold=%d", ptr.Int32Value(e.KubeletConfig.ImageGCHighThresholdPercent)))
+	}
+	if e.KubeletConfig.ImageGCLowThresholdPercent != nil {
+		args = append(args, fmt.Sprintf("--image-gc-low-threshold=%d", ptr.Int32Value(e.KubeletConfig.ImageGCLowThresholdPercent)))
+	}
+	return args
+}
+
+func joinResourceList(resources core.ResourceList) string {
+	values := []string{}
+	for name, quantity := range resources {
+		values = append(values, fmt.Sprintf("%s=%s", name, quantity.String()))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+func joinThresholds(thresholds map[string]string) string {
+	values := []string{}
+	for signal, threshold := range thresholds {
+		values = append(values, fmt.Sprintf("%s<%s", signal, threshold))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
 func (e EKS) nodeTaintArg() string {
 	nodeTaintsArg := ""
 	taintStrings := []string{}
@@ -61,6 +125,8 @@ func (e EKS) nodeTaintArg() string {
 		once.Do(func() { nodeTaintsArg = "--register-with-taints=" })
 		taintStrings = append(taintStrings, fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
 	}
+	// Taints and labels are hashed as sets, so sort them to render identical user data for equivalent inputs
+	sort.Strings(taintStrings)
 	return fmt.Sprintf("%s%s", nodeTaintsArg, strings.Join(taintStrings, ","))
 }
 
@@ -75,5 +141,6 @@ func (e EKS) nodeLabelArg() string {
 		once.Do(func() { nodeLabelArg = "--node-labels=" })
 		labelStrings = append(labelStrings, fmt.Sprintf("%s=%v", k, v))
 	}
+	sort.Strings(labelStrings)
 	return fmt.Sprintf("%s%s", nodeLabelArg, strings.Join(labelStrings, ","))
 }
@@ -74,3 +74,8 @@ func (b Bottlerocket) DefaultBlockDeviceMappings() []*v1alpha1.BlockDeviceMappin
 		},
 	}
 }
+
+// EphemeralBlockDevice is the data volume, since the OS volume only holds Bottlerocket itself
+func (b Bottlerocket) EphemeralBlockDevice() *string {
+	return aws.String("/dev/xvdb")
+}
@@ -16,6 +16,8 @@ package amifamily
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -23,6 +25,7 @@ import (
 	"github.com/patrickmn/go-cache"
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
@@ -30,6 +33,16 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 )
 
+// DefaultVMMemoryOverhead is the fraction of an instance's memory that is assumed to be
+// consumed by the hypervisor and operating system, and so is never visible to the kubelet.
+const DefaultVMMemoryOverhead = .075
+
+// MaxEphemeralStorageGiBs is the largest size that ephemeral block devices are
+// auto-sized to, which bounds the cost of a pod with very large requests
+const MaxEphemeralStorageGiBs = 1024
+
+const gibibyte = 1024 * 1024 * 1024
+
 var defaultEBS = v1alpha1.BlockDevice{
 	Encrypted:  aws.Bool(true),
 	VolumeType: aws.String(ec2.VolumeTypeGp3),
@@ -53,6 +66,22 @@ type Options struct {
 	SecurityGroupsIDs []string
 	Tags              map[string]string
 	Labels            map[string]string `hash:"ignore"`
+	// CapacityReservationID is the capacity block that instances are launched into, if any
+	CapacityReservationID string
+	// Tenancy of the instances, if it isn't the default tenancy
+	Tenancy string
+	// EFACount is the number of EFA network interfaces attached to the instances, if any
+	EFACount int64
+}
+
+// HashInclude excludes the fields that were added after launch templates were first named by their hash while
+// they're unset, so that launch templates that don't use them keep their names.
+func (o Options) HashInclude(field string, v interface{}) (bool, error) {
+	switch field {
+	case "CapacityReservationID", "Tenancy", "EFACount":
+		return !reflect.ValueOf(v).IsZero(), nil
+	}
+	return true, nil
 }
 
 // LaunchTemplate holds the dynamically generated launch template parameters
@@ -70,7 +99,10 @@ type AMIFamily interface {
 	UserData(kubeletConfig *v1alpha5.KubeletConfiguration, taints []core.Taint, labels map[string]string, caBundle *string) bootstrap.Bootstrapper
 	SSMAlias(version string, instanceType cloudprovider.InstanceType) string
 	DefaultBlockDeviceMappings() []*v1alpha1.BlockDeviceMapping
+	// EphemeralBlockDevice is the name of the device that backs the kubelet's root directory
+	EphemeralBlockDevice() *string
 	DefaultMetadataOptions() *v1alpha1.MetadataOptions
+	VMMemoryOverhead() float64
 }
 
 // New constructs a new launch template Resolver
@@ -86,7 +118,7 @@ func New(ssm ssmiface.SSMAPI, c *cache.Cache) *Resolver {
 // Resolve generates launch templates using the static options and dynamically generates launch template parameters.
 // Multiple ResolvedTemplates are returned based on the instanceTypes passed in to support special AMIs for certain instance types like GPUs.
 func (r Resolver) Resolve(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, options *Options) ([]*LaunchTemplate, error) {
-	amiFamily := r.getAMIFamily(constraints.AMIFamily, options)
+	amiFamily := GetAMIFamily(constraints.AMIFamily, options)
 	amiIDs := map[string][]cloudprovider.InstanceType{}
 	for _, instanceType := range instanceTypes {
 		amiID, err := r.amiProvider.Get(ctx, instanceType, amiFamily.SSMAlias(options.KubernetesVersion, instanceType))
@@ -116,7 +148,74 @@ func (r Resolver) Resolve(ctx context.Context, constraints *v1alpha1.Constraints
 	return resolvedTemplates, nil
 }
 
-func (r Resolver) getAMIFamily(amiFamily *string, options *Options) AMIFamily {
+// Verify checks that the AMI family publishes an AMI for each of the instance types on the Kubernetes version, so that
+// unsupported combinations of AMI family, architecture, and accelerators are discovered before launching instances.
+func (r Resolver) Verify(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, kubernetesVersion string) error {
+	amiFamily := GetAMIFamily(constraints.AMIFamily, &Options{})
+	queries := map[string]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		queries[amiFamily.SSMAlias(kubernetesVersion, instanceType)] = instanceType
+	}
+	for _, query := range sets.StringKeySet(queries).List() {
+		instanceType := queries[query]
+		if _, err := r.amiProvider.Get(ctx, instanceType, query); err != nil {
+			return fmt.Errorf("resolving AMI for %s instance types like %s on Kubernetes %s, %w", instanceType.Architecture(), instanceType.Name(), kubernetesVersion, err)
+		}
+	}
+	return nil
+}
+
+// EphemeralStorage returns the size of the AMI family's ephemeral block device,
+// falling back to the default volume size if the device isn't mapped or sized
+func EphemeralStorage(amiFamily AMIFamily, blockDeviceMappings []*v1alpha1.BlockDeviceMapping) *resource.Quantity {
+	if blockDeviceMappings == nil {
+		blockDeviceMappings = amiFamily.DefaultBlockDeviceMappings()
+	}
+	volumeSize := defaultEBS.VolumeSize
+	if ebs := ephemeralBlockDevice(amiFamily, blockDeviceMappings); ebs != nil && ebs.VolumeSize != nil {
+		volumeSize = ebs.VolumeSize
+	}
+	// Volume sizes are specified in GiBs, see launchTemplate.blockDeviceMappings
+	return resource.NewQuantity(volumeSize.ScaledValue(resource.Giga)*gibibyte, resource.BinarySI)
+}
+
+// WithEphemeralStorage returns a copy of the block device mappings with the AMI
+// family's ephemeral block device grown by the size, rounded up to a power of
+// two GiBs and capped at MaxEphemeralStorageGiBs. Sizes are rounded to a few
+// fixed buckets since the volume size is part of the launch template, and
+// every distinct size would otherwise create another launch template.
+func WithEphemeralStorage(amiFamily AMIFamily, blockDeviceMappings []*v1alpha1.BlockDeviceMapping, size resource.Quantity) []*v1alpha1.BlockDeviceMapping {
+	if blockDeviceMappings == nil {
+		blockDeviceMappings = amiFamily.DefaultBlockDeviceMappings()
+	}
+	result := make([]*v1alpha1.BlockDeviceMapping, 0, len(blockDeviceMappings))
+	for _, blockDeviceMapping := range blockDeviceMappings {
+		result = append(result, blockDeviceMapping.DeepCopy())
+	}
+	ebs := ephemeralBlockDevice(amiFamily, result)
+	if ebs == nil {
+		return result
+	}
+	volumeSize := EphemeralStorage(amiFamily, result).Value() + size.Value()
+	gibs := int64(1)
+	for gibs*gibibyte < volumeSize && gibs < MaxEphemeralStorageGiBs {
+		gibs *= 2
+	}
+	ebs.VolumeSize = resource.NewScaledQuantity(gibs, resource.Giga)
+	return result
+}
+
+func ephemeralBlockDevice(amiFamily AMIFamily, blockDeviceMappings []*v1alpha1.BlockDeviceMapping) *v1alpha1.BlockDevice {
+	for _, blockDeviceMapping := range blockDeviceMappings {
+		if aws.StringValue(blockDeviceMapping.DeviceName) == aws.StringValue(amiFamily.EphemeralBlockDevice()) {
+			return blockDeviceMapping.EBS
+		}
+	}
+	return nil
+}
+
+// GetAMIFamily returns the AMIFamily implementation for the provided family name, defaulting to AL2
+func GetAMIFamily(amiFamily *string, options *Options) AMIFamily {
 	switch aws.StringValue(amiFamily) {
 	case v1alpha1.AMIFamilyBottlerocket:
 		return &Bottlerocket{Options: options}
@@ -135,3 +234,9 @@ func (Options) DefaultMetadataOptions() *v1alpha1.MetadataOptions {
 		HTTPTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateRequired),
 	}
 }
+
+// VMMemoryOverhead returns the fraction of instance memory that is unavailable to the kubelet.
+// AMI families may override this if their kernel and hypervisor footprint differs from the default.
+func (Options) VMMemoryOverhead() float64 {
+	return DefaultVMMemoryOverhead
+}
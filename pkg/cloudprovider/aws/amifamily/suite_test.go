@@ -0,0 +1,131 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amifamily_test
+
+import (
+	"encoding/base64"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/amifamily"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/pelletier/go-toml/v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/ptr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Run with -update to regenerate the golden files after an intentional change to user data.
+// Changes to golden files alter the user data of every node launched for the affected AMI
+// family, so they should be called out in review.
+var update = flag.Bool("update", false, "update golden files")
+
+func TestAPIs(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/AWS/AMIFamily")
+}
+
+var options = &amifamily.Options{
+	ClusterName:             "test-cluster",
+	ClusterEndpoint:         "https://test-cluster",
+	AWSENILimitedPodDensity: true,
+}
+
+var customKubeletConfig = &v1alpha5.KubeletConfiguration{
+	ClusterDNS: []string{"10.0.0.10"},
+	MaxPods:    ptr.Int32(20),
+	SystemReserved: v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("100Mi"),
+	},
+	EvictionHard:                map[string]string{"memory.available": "5%"},
+	ImageGCHighThresholdPercent: ptr.Int32(80),
+}
+
+var customTaints = []v1.Taint{
+	{Key: "foo", Value: "bar", Effect: v1.TaintEffectNoSchedule},
+	{Key: "baz", Effect: v1.TaintEffectNoExecute},
+}
+
+var customLabels = map[string]string{"b": "2", "a": "1"}
+
+func render(amiFamily string, options *amifamily.Options, kubeletConfig *v1alpha5.KubeletConfiguration, taints []v1.Taint, labels map[string]string) string {
+	script := amifamily.GetAMIFamily(aws.String(amiFamily), options).UserData(kubeletConfig, taints, labels, ptr.String("ca-bundle")).Script()
+	userData, err := base64.StdEncoding.DecodeString(script)
+	Expect(err).ToNot(HaveOccurred())
+	return string(userData)
+}
+
+func golden(name string, actual string) string {
+	path := filepath.Join("testdata", name)
+	if *update {
+		Expect(os.WriteFile(path, []byte(actual), 0644)).To(Succeed())
+	}
+	expected, err := os.ReadFile(path)
+	Expect(err).ToNot(HaveOccurred(), "missing golden file %s, run with -update to create it", path)
+	return string(expected)
+}
+
+// expectGolden compares rendered user data to the named golden file
+func expectGolden(amiFamily string, name string, options *amifamily.Options, kubeletConfig *v1alpha5.KubeletConfiguration, taints []v1.Taint, labels map[string]string) {
+	actual := render(amiFamily, options, kubeletConfig, taints, labels)
+	expected := golden(name, actual)
+	if amiFamily == v1alpha1.AMIFamilyBottlerocket {
+		// Bottlerocket settings are compared semantically so that the TOML encoder's formatting isn't part of the contract
+		actualSettings, expectedSettings := map[string]interface{}{}, map[string]interface{}{}
+		Expect(toml.Unmarshal([]byte(actual), &actualSettings)).To(Succeed())
+		Expect(toml.Unmarshal([]byte(expected), &expectedSettings)).To(Succeed())
+		Expect(actualSettings).To(Equal(expectedSettings))
+		return
+	}
+	Expect(actual).To(Equal(expected))
+}
+
+var _ = Describe("UserData", func() {
+	Context("AL2", func() {
+		It("should render the default user data", func() {
+			expectGolden(v1alpha1.AMIFamilyAL2, "al2.golden", options, nil, nil, nil)
+		})
+		It("should render kubelet configuration, taints, and labels", func() {
+			expectGolden(v1alpha1.AMIFamilyAL2, "al2-custom.golden", options, customKubeletConfig, customTaints, customLabels)
+		})
+	})
+	Context("Ubuntu", func() {
+		It("should render user data without ENI-limited pod density", func() {
+			expectGolden(v1alpha1.AMIFamilyUbuntu, "ubuntu.golden", &amifamily.Options{ClusterName: "test-cluster", ClusterEndpoint: "https://test-cluster"}, nil, nil, nil)
+		})
+	})
+	Context("Bottlerocket", func() {
+		It("should render the default user data", func() {
+			expectGolden(v1alpha1.AMIFamilyBottlerocket, "bottlerocket.golden", options, nil, nil, nil)
+		})
+		It("should render kubelet configuration, taints, and labels", func() {
+			expectGolden(v1alpha1.AMIFamilyBottlerocket, "bottlerocket-custom.golden", options, customKubeletConfig, customTaints, customLabels)
+		})
+	})
+	It("should render identical user data regardless of the order of taints and labels", func() {
+		for _, amiFamily := range []string{v1alpha1.AMIFamilyAL2, v1alpha1.AMIFamilyUbuntu} {
+			Expect(render(amiFamily, options, customKubeletConfig, customTaints, customLabels)).To(Equal(
+				render(amiFamily, options, customKubeletConfig, []v1.Taint{customTaints[1], customTaints[0]}, map[string]string{"a": "1", "b": "2"})))
+		}
+	})
+})
@@ -57,3 +57,8 @@ func (u Ubuntu) DefaultBlockDeviceMappings() []*v1alpha1.BlockDeviceMapping {
 		EBS:        &defaultEBS,
 	}}
 }
+
+// EphemeralBlockDevice is the root volume, which the kubelet and container runtime share with the OS
+func (u Ubuntu) EphemeralBlockDevice() *string {
+	return aws.String("/dev/sda1")
+}
@@ -42,12 +42,33 @@ type AWS struct {
 	// InstanceProfile is the AWS identity that instances use.
 	// +optional
 	InstanceProfile *string `json:"instanceProfile,omitempty"`
+	// Role is the name of the AWS IAM role that instances use. If specified,
+	// Karpenter creates an instance profile for the provisioner with this role
+	// attached, and deletes it when the provisioner is deleted.
+	// +optional
+	Role *string `json:"role,omitempty"`
 	// SubnetSelector discovers subnets by tags. A value of "" is a wildcard.
 	// +optional
 	SubnetSelector map[string]string `json:"subnetSelector,omitempty"`
+	// SubnetSelectionStrategy determines which of the selected subnets instances are launched into in each zone, one
+	// of MostAvailableIPs, RoundRobin, or Pinned. Defaults to MostAvailableIPs.
+	// +optional
+	SubnetSelectionStrategy *string `json:"subnetSelectionStrategy,omitempty"`
 	// SecurityGroups specify the names of the security groups.
 	// +optional
 	SecurityGroupSelector map[string]string `json:"securityGroupSelector,omitempty"`
+	// CapacityBlockSelector discovers Capacity Blocks for ML by tags. Instances are launched into the selected
+	// blocks between their start and end dates when capacity-block is an allowed capacity type.
+	// +optional
+	CapacityBlockSelector map[string]string `json:"capacityBlockSelector,omitempty"`
+	// Tenancy of the instances, one of default, dedicated, or host. Instances with host tenancy are launched onto
+	// Dedicated Hosts that were allocated with auto-placement enabled. Defaults to default.
+	// +optional
+	Tenancy *string `json:"tenancy,omitempty"`
+	// PlacementGroup is the name of the placement group that instances are launched into, e.g. a cluster placement
+	// group for low-latency networking between nodes.
+	// +optional
+	PlacementGroup *string `json:"placementGroup,omitempty"`
 	// Tags to be applied on ec2 resources like instances and launch templates.
 	// +optional
 	Tags map[string]string `json:"tags,omitempty"`
@@ -61,6 +82,12 @@ type LaunchTemplate struct {
 	// as `launchTemplate` for backwards compatibility.
 	// +optional
 	LaunchTemplateName *string `json:"launchTemplate,omitempty"`
+	// LaunchTemplateManagedFields are the fields of the custom launch template that Karpenter manages, any of AMI,
+	// UserData, and BlockDeviceMappings. Karpenter resolves these fields as it would for a generated launch
+	// template, and launches instances from new versions of the custom launch template, based on its default
+	// version, with these fields replaced. If omitted, the custom launch template is used verbatim.
+	// +optional
+	LaunchTemplateManagedFields []string `json:"launchTemplateManagedFields,omitempty"`
 	// MetadataOptions for the generated launch template of provisioned nodes.
 	//
 	// This specifies the exposure of the Instance Metadata Service to
@@ -80,6 +107,18 @@ type LaunchTemplate struct {
 	// BlockDeviceMappings to be applied to provisioned nodes.
 	// +optionals
 	BlockDeviceMappings []*BlockDeviceMapping `json:"blockDeviceMappings,omitempty"`
+	// AutoSizeEphemeralStorage grows the block device that backs the kubelet's
+	// root directory to fit the ephemeral-storage requests of the pods that
+	// are scheduled to each node. If omitted, pods are scheduled against the
+	// configured size of the block device.
+	// +optional
+	AutoSizeEphemeralStorage *bool `json:"autoSizeEphemeralStorage,omitempty"`
+	// EFA attaches Elastic Fabric Adapter network interfaces to every node
+	// whose instance type supports them, one per network card. If omitted, EFA
+	// interfaces are only attached to nodes that are launched for pods that
+	// request the vpc.amazonaws.com/efa resource.
+	// +optional
+	EFA *bool `json:"efa,omitempty"`
 }
 
 // MetadataOptions contains parameters for specifying the exposure of the
@@ -215,3 +254,13 @@ func (a *AWS) Serialize(constraints *v1alpha5.Constraints) error {
 	constraints.Provider.Raw = bytes
 	return nil
 }
+
+// ManagesLaunchTemplateField returns true if Karpenter manages the field of the custom launch template
+func (l *LaunchTemplate) ManagesLaunchTemplateField(field string) bool {
+	for _, managedField := range l.LaunchTemplateManagedFields {
+		if managedField == field {
+			return true
+		}
+	}
+	return false
+}
@@ -17,6 +17,7 @@ package v1alpha1
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go/service/ec2"
 	v1 "k8s.io/api/core/v1"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
@@ -26,6 +27,40 @@ import (
 func (c *Constraints) Default(ctx context.Context) {
 	c.defaultArchitecture()
 	c.defaultCapacityTypes()
+	c.defaultPlacement()
+}
+
+// defaultPlacement requires the tenancy and placement group of the provider, if
+// they're set, so that pods that select them are provisioned by it. The
+// requirements are owned by the provider, and replace any existing
+// requirements for the same keys. Provisioners that don't set them are left
+// alone, and don't constrain which tenancy or placement group pods select.
+func (c *Constraints) defaultPlacement() {
+	placement := map[string]string{}
+	if c.Tenancy != nil {
+		placement[LabelTenancy] = *c.Tenancy
+	}
+	if c.PlacementGroup != nil {
+		placement[LabelPlacementGroup] = *c.PlacementGroup
+	}
+	if len(placement) == 0 {
+		return
+	}
+	requirements := []v1.NodeSelectorRequirement{}
+	for _, requirement := range c.Requirements.Requirements {
+		if _, ok := placement[requirement.Key]; !ok {
+			requirements = append(requirements, requirement)
+		}
+	}
+	c.Requirements = v1alpha5.NewRequirements(requirements...).Add(v1alpha5.NewLabelRequirements(placement).Requirements...)
+}
+
+// TenancyOrDefault returns the tenancy, or the default tenancy if it isn't set
+func TenancyOrDefault(tenancy *string) string {
+	if tenancy == nil {
+		return ec2.TenancyDefault
+	}
+	return *tenancy
 }
 
 func (c *Constraints) defaultCapacityTypes() {
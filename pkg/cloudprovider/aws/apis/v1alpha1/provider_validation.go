@@ -21,16 +21,26 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"knative.dev/pkg/apis"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 )
 
 const (
-	launchTemplatePath          = "launchTemplate"
-	securityGroupSelectorPath   = "securityGroupSelector"
-	fieldPathSubnetSelectorPath = "subnetSelector"
-	amiFamilyPath               = "amiFamily"
-	metadataOptionsPath         = "metadataOptions"
-	instanceProfilePath         = "instanceProfile"
-	blockDeviceMappingsPath     = "blockDeviceMappings"
+	launchTemplatePath           = "launchTemplate"
+	managedFieldsPath            = "launchTemplateManagedFields"
+	securityGroupSelectorPath    = "securityGroupSelector"
+	fieldPathSubnetSelectorPath  = "subnetSelector"
+	subnetSelectionStrategyPath  = "subnetSelectionStrategy"
+	capacityBlockSelectorPath    = "capacityBlockSelector"
+	tenancyPath                  = "tenancy"
+	placementGroupPath           = "placementGroup"
+	amiFamilyPath                = "amiFamily"
+	metadataOptionsPath          = "metadataOptions"
+	instanceProfilePath          = "instanceProfile"
+	rolePath                     = "role"
+	blockDeviceMappingsPath      = "blockDeviceMappings"
+	autoSizeEphemeralStoragePath = "autoSizeEphemeralStorage"
+	efaPath                      = "efa"
 )
 
 var (
@@ -38,6 +48,14 @@ var (
 	maxVolumeSize = *resource.NewScaledQuantity(64, resource.Tera)
 )
 
+// Validate the provider, and how it's combined with the provisioner's requirements
+func (c *Constraints) Validate() (errs *apis.FieldError) {
+	return errs.Also(
+		c.AWS.Validate(),
+		c.validateTenancyCapacityTypes(),
+	)
+}
+
 func (a *AWS) Validate() (errs *apis.FieldError) {
 	return a.validate().ViaField("provider")
 }
@@ -45,8 +63,11 @@ func (a *AWS) Validate() (errs *apis.FieldError) {
 func (a *AWS) validate() (errs *apis.FieldError) {
 	return errs.Also(
 		a.validateLaunchTemplate(),
+		a.validateRole(),
 		a.validateSubnets(),
 		a.validateSecurityGroups(),
+		a.validateCapacityBlocks(),
+		a.validatePlacement(),
 		a.validateTags(),
 		a.validateMetadataOptions(),
 		a.validateAMIFamily(),
@@ -56,7 +77,13 @@ func (a *AWS) validate() (errs *apis.FieldError) {
 
 func (a *AWS) validateLaunchTemplate() (errs *apis.FieldError) {
 	if a.LaunchTemplateName == nil {
-		return nil
+		if len(a.LaunchTemplateManagedFields) != 0 {
+			errs = errs.Also(apis.ErrGeneric("requires launchTemplate", managedFieldsPath))
+		}
+		return errs
+	}
+	for i, field := range a.LaunchTemplateManagedFields {
+		errs = errs.Also(a.validateStringEnum(field, fmt.Sprintf("%s[%d]", managedFieldsPath, i), SupportedLaunchTemplateManagedFields))
 	}
 	if a.SecurityGroupSelector != nil {
 		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, securityGroupSelectorPath))
@@ -64,15 +91,44 @@ func (a *AWS) validateLaunchTemplate() (errs *apis.FieldError) {
 	if a.MetadataOptions != nil {
 		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, metadataOptionsPath))
 	}
-	if a.AMIFamily != nil {
+	// The AMI family resolves the AMI and user data, if Karpenter manages them
+	if a.AMIFamily != nil && !a.ManagesLaunchTemplateField(LaunchTemplateFieldAMI) && !a.ManagesLaunchTemplateField(LaunchTemplateFieldUserData) {
 		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, amiFamilyPath))
 	}
 	if a.InstanceProfile != nil {
 		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, instanceProfilePath))
 	}
-	if len(a.BlockDeviceMappings) != 0 {
+	if len(a.BlockDeviceMappings) != 0 && !a.ManagesLaunchTemplateField(LaunchTemplateFieldBlockDeviceMappings) {
 		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, blockDeviceMappingsPath))
 	}
+	if a.AutoSizeEphemeralStorage != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, autoSizeEphemeralStoragePath))
+	}
+	if a.Role != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, rolePath))
+	}
+	if a.CapacityBlockSelector != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, capacityBlockSelectorPath))
+	}
+	if a.Tenancy != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, tenancyPath))
+	}
+	if a.EFA != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf(launchTemplatePath, efaPath))
+	}
+	return errs
+}
+
+func (a *AWS) validateRole() (errs *apis.FieldError) {
+	if a.Role == nil {
+		return nil
+	}
+	if a.InstanceProfile != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf(instanceProfilePath, rolePath))
+	}
+	if *a.Role == "" || strings.HasPrefix(*a.Role, "arn:") {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%q, must be the name of an IAM role", *a.Role), rolePath))
+	}
 	return errs
 }
 
@@ -85,6 +141,9 @@ func (a *AWS) validateSubnets() (errs *apis.FieldError) {
 			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("%s['%s']", fieldPathSubnetSelectorPath, key)))
 		}
 	}
+	if a.SubnetSelectionStrategy != nil {
+		errs = errs.Also(a.validateStringEnum(*a.SubnetSelectionStrategy, subnetSelectionStrategyPath, SupportedSubnetSelectionStrategies))
+	}
 	return errs
 }
 
@@ -103,6 +162,40 @@ func (a *AWS) validateSecurityGroups() (errs *apis.FieldError) {
 	return errs
 }
 
+func (a *AWS) validateCapacityBlocks() (errs *apis.FieldError) {
+	for key, value := range a.CapacityBlockSelector {
+		if key == "" || value == "" {
+			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("%s['%s']", capacityBlockSelectorPath, key)))
+		}
+	}
+	return errs
+}
+
+func (a *AWS) validatePlacement() (errs *apis.FieldError) {
+	if a.Tenancy != nil {
+		errs = errs.Also(a.validateStringEnum(*a.Tenancy, tenancyPath, SupportedTenancies))
+	}
+	if a.PlacementGroup != nil && *a.PlacementGroup == "" {
+		errs = errs.Also(apis.ErrInvalidValue("\"\"", placementGroupPath))
+	}
+	return errs
+}
+
+// validateTenancyCapacityTypes rejects dedicated and host tenancy for
+// provisioners that allow spot instances, which are only available on shared
+// hardware. Capacity types that aren't specified are defaulted to on-demand.
+func (c *Constraints) validateTenancyCapacityTypes() (errs *apis.FieldError) {
+	if TenancyOrDefault(c.Tenancy) == ec2.TenancyDefault {
+		return nil
+	}
+	allowsSpot := c.Labels[v1alpha5.LabelCapacityType] == CapacityTypeSpot ||
+		(c.Requirements.Keys().Has(v1alpha5.LabelCapacityType) && c.Requirements.Get(v1alpha5.LabelCapacityType).Has(CapacityTypeSpot))
+	if allowsSpot {
+		errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("%s tenancy isn't available for spot instances", *c.Tenancy), "provider.tenancy"))
+	}
+	return errs
+}
+
 func (a *AWS) validateTags() (errs *apis.FieldError) {
 	// Avoiding a check on number of tags (hard limit of 50) since that limit is shared by user
 	// defined and Karpenter tags, and the latter could change over time.
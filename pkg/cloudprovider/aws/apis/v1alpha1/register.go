@@ -24,9 +24,11 @@ import (
 )
 
 var (
-	CapacityTypeSpot       = ec2.DefaultTargetCapacityTypeSpot
-	CapacityTypeOnDemand   = ec2.DefaultTargetCapacityTypeOnDemand
-	AWSToKubeArchitectures = map[string]string{
+	CapacityTypeSpot     = ec2.DefaultTargetCapacityTypeSpot
+	CapacityTypeOnDemand = ec2.DefaultTargetCapacityTypeOnDemand
+	// CapacityTypeCapacityBlock launches instances into Capacity Blocks for ML selected by the provider
+	CapacityTypeCapacityBlock = "capacity-block"
+	AWSToKubeArchitectures    = map[string]string{
 		"x86_64":                   v1alpha5.ArchitectureAmd64,
 		v1alpha5.ArchitectureArm64: v1alpha5.ArchitectureArm64,
 	}
@@ -41,6 +43,40 @@ var (
 		AMIFamilyAL2,
 		AMIFamilyUbuntu,
 	}
+	// SubnetSelectionStrategyMostAvailableIPs launches instances into the subnet with the most available IP addresses
+	// in each zone, accounting for instances that were launched since the subnets were last described
+	SubnetSelectionStrategyMostAvailableIPs = "MostAvailableIPs"
+	// SubnetSelectionStrategyRoundRobin rotates through the subnets that have available IP addresses in each zone
+	SubnetSelectionStrategyRoundRobin = "RoundRobin"
+	// SubnetSelectionStrategyPinned always launches instances into the same subnet in each zone, the first by ID
+	SubnetSelectionStrategyPinned      = "Pinned"
+	SupportedSubnetSelectionStrategies = []string{
+		SubnetSelectionStrategyMostAvailableIPs,
+		SubnetSelectionStrategyRoundRobin,
+		SubnetSelectionStrategyPinned,
+	}
+	// LaunchTemplateFieldAMI is the AMI of a custom launch template, which Karpenter resolves from the AMI family
+	LaunchTemplateFieldAMI = "AMI"
+	// LaunchTemplateFieldUserData is the user data of a custom launch template, which Karpenter generates from the
+	// AMI family to bootstrap nodes into the cluster
+	LaunchTemplateFieldUserData = "UserData"
+	// LaunchTemplateFieldBlockDeviceMappings is the block device mappings of a custom launch template, which
+	// Karpenter takes from the provider's blockDeviceMappings, or the AMI family's defaults
+	LaunchTemplateFieldBlockDeviceMappings = "BlockDeviceMappings"
+	SupportedLaunchTemplateManagedFields   = []string{
+		LaunchTemplateFieldAMI,
+		LaunchTemplateFieldUserData,
+		LaunchTemplateFieldBlockDeviceMappings,
+	}
+	SupportedTenancies = []string{
+		ec2.TenancyDefault,
+		ec2.TenancyDedicated,
+		ec2.TenancyHost,
+	}
+	// LabelTenancy is the tenancy of the instances launched for the provisioner, so that pods can target it
+	LabelTenancy = "karpenter.k8s.aws/tenancy"
+	// LabelPlacementGroup is the placement group that instances are launched into for the provisioner, if any
+	LabelPlacementGroup = "karpenter.k8s.aws/placement-group"
 )
 
 var (
@@ -51,4 +87,5 @@ var (
 func init() {
 	Scheme.AddKnownTypes(schema.GroupVersion{Group: v1alpha5.ExtensionsGroup, Version: "v1alpha1"}, &AWS{})
 	v1alpha5.RestrictedLabelDomains = v1alpha5.RestrictedLabelDomains.Insert(AWSRestrictedLabelDomains...)
+	v1alpha5.WellKnownLabels = v1alpha5.WellKnownLabels.Insert(LabelTenancy, LabelPlacementGroup)
 }
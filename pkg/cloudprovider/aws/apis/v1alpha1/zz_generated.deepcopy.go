@@ -38,6 +38,11 @@ func (in *AWS) DeepCopyInto(out *AWS) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.Role != nil {
+		in, out := &in.Role, &out.Role
+		*out = new(string)
+		**out = **in
+	}
 	if in.SubnetSelector != nil {
 		in, out := &in.SubnetSelector, &out.SubnetSelector
 		*out = make(map[string]string, len(*in))
@@ -45,6 +50,11 @@ func (in *AWS) DeepCopyInto(out *AWS) {
 			(*out)[key] = val
 		}
 	}
+	if in.SubnetSelectionStrategy != nil {
+		in, out := &in.SubnetSelectionStrategy, &out.SubnetSelectionStrategy
+		*out = new(string)
+		**out = **in
+	}
 	if in.SecurityGroupSelector != nil {
 		in, out := &in.SecurityGroupSelector, &out.SecurityGroupSelector
 		*out = make(map[string]string, len(*in))
@@ -52,6 +62,23 @@ func (in *AWS) DeepCopyInto(out *AWS) {
 			(*out)[key] = val
 		}
 	}
+	if in.CapacityBlockSelector != nil {
+		in, out := &in.CapacityBlockSelector, &out.CapacityBlockSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tenancy != nil {
+		in, out := &in.Tenancy, &out.Tenancy
+		*out = new(string)
+		**out = **in
+	}
+	if in.PlacementGroup != nil {
+		in, out := &in.PlacementGroup, &out.PlacementGroup
+		*out = new(string)
+		**out = **in
+	}
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make(map[string]string, len(*in))
@@ -188,6 +215,11 @@ func (in *LaunchTemplate) DeepCopyInto(out *LaunchTemplate) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.LaunchTemplateManagedFields != nil {
+		in, out := &in.LaunchTemplateManagedFields, &out.LaunchTemplateManagedFields
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.MetadataOptions != nil {
 		in, out := &in.MetadataOptions, &out.MetadataOptions
 		*out = new(MetadataOptions)
@@ -204,6 +236,16 @@ func (in *LaunchTemplate) DeepCopyInto(out *LaunchTemplate) {
 			}
 		}
 	}
+	if in.AutoSizeEphemeralStorage != nil {
+		in, out := &in.AutoSizeEphemeralStorage, &out.AutoSizeEphemeralStorage
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EFA != nil {
+		in, out := &in.EFA, &out.EFA
+		*out = new(bool)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LaunchTemplate.
@@ -0,0 +1,131 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/patrickmn/go-cache"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/pretty"
+)
+
+const (
+	// CapacityBlockCacheTTL is short since the available instance count of a block changes with every launch
+	CapacityBlockCacheTTL = time.Minute
+	// CapacityBlockTerminationLeadTime is how long before the end of a block EC2 begins terminating its instances.
+	// Instances aren't launched into a block during this period.
+	CapacityBlockTerminationLeadTime = 30 * time.Minute
+)
+
+// CapacityBlock is a Capacity Block for ML reservation that instances can be launched into
+type CapacityBlock struct {
+	ID                     string
+	InstanceType           string
+	Zone                   string
+	StartDate              time.Time
+	EndDate                time.Time
+	AvailableInstanceCount int64
+}
+
+// Active returns true if instances can be launched into the block at the given time
+func (b *CapacityBlock) Active(now time.Time) bool {
+	return b.AvailableInstanceCount > 0 && !now.Before(b.StartDate) && now.Before(b.EndDate.Add(-CapacityBlockTerminationLeadTime))
+}
+
+type CapacityBlockProvider struct {
+	ec2api ec2iface.EC2API
+	cache  *cache.Cache
+}
+
+func NewCapacityBlockProvider(ec2api ec2iface.EC2API) *CapacityBlockProvider {
+	return &CapacityBlockProvider{
+		ec2api: ec2api,
+		cache:  cache.New(CapacityBlockCacheTTL, CacheCleanupInterval),
+	}
+}
+
+// Get the capacity blocks that match the selector of the constraints, whether or not they are active yet. Returns
+// nothing if the constraints don't select capacity blocks.
+func (p *CapacityBlockProvider) Get(ctx context.Context, constraints *v1alpha1.AWS) ([]*CapacityBlock, error) {
+	if len(constraints.CapacityBlockSelector) == 0 {
+		return nil, nil
+	}
+	filters := getCapacityBlockFilters(constraints)
+	hash, err := hashstructure.Hash(filters, hashstructure.FormatV2, nil)
+	if err != nil {
+		return nil, err
+	}
+	if capacityBlocks, ok := p.cache.Get(fmt.Sprint(hash)); ok {
+		return capacityBlocks.([]*CapacityBlock), nil
+	}
+	output, err := p.ec2api.DescribeCapacityReservationsWithContext(ctx, &ec2.DescribeCapacityReservationsInput{Filters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("describing capacity reservations %s, %w", pretty.Concise(filters), err)
+	}
+	capacityBlocks := []*CapacityBlock{}
+	for _, reservation := range output.CapacityReservations {
+		capacityBlocks = append(capacityBlocks, &CapacityBlock{
+			ID:                     aws.StringValue(reservation.CapacityReservationId),
+			InstanceType:           aws.StringValue(reservation.InstanceType),
+			Zone:                   aws.StringValue(reservation.AvailabilityZone),
+			StartDate:              aws.TimeValue(reservation.StartDate),
+			EndDate:                aws.TimeValue(reservation.EndDate),
+			AvailableInstanceCount: aws.Int64Value(reservation.AvailableInstanceCount),
+		})
+	}
+	p.cache.SetDefault(fmt.Sprint(hash), capacityBlocks)
+	logging.FromContext(ctx).Debugf("Discovered capacity blocks: %s", prettyCapacityBlocks(capacityBlocks))
+	return capacityBlocks, nil
+}
+
+func getCapacityBlockFilters(constraints *v1alpha1.AWS) []*ec2.Filter {
+	filters := []*ec2.Filter{{
+		// Blocks are "scheduled" until their start date, after which they are "active"
+		Name:   aws.String("state"),
+		Values: aws.StringSlice([]string{"scheduled", ec2.CapacityReservationStateActive}),
+	}}
+	for key, value := range constraints.CapacityBlockSelector {
+		if value == "*" {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(key)},
+			})
+		} else {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String(fmt.Sprintf("tag:%s", key)),
+				Values: []*string{aws.String(value)},
+			})
+		}
+	}
+	return filters
+}
+
+func prettyCapacityBlocks(capacityBlocks []*CapacityBlock) []string {
+	names := []string{}
+	for _, capacityBlock := range capacityBlocks {
+		names = append(names, fmt.Sprintf("%s (%s, %s, %s - %s)", capacityBlock.ID, capacityBlock.InstanceType, capacityBlock.Zone,
+			capacityBlock.StartDate.Format(time.RFC3339), capacityBlock.EndDate.Format(time.RFC3339)))
+	}
+	return names
+}
@@ -15,7 +15,9 @@ package aws
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -25,6 +27,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/patrickmn/go-cache"
 
@@ -35,10 +39,14 @@ import (
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/project"
+	"github.com/aws/karpenter/pkg/utils/resources"
 
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/transport"
+	"k8s.io/client-go/util/workqueue"
 	"knative.dev/pkg/apis"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/ptr"
@@ -61,62 +69,266 @@ func init() {
 }
 
 type CloudProvider struct {
-	instanceTypeProvider *InstanceTypeProvider
-	subnetProvider       *SubnetProvider
-	instanceProvider     *InstanceProvider
+	instanceTypeProvider    *InstanceTypeProvider
+	subnetProvider          *SubnetProvider
+	instanceProvider        *InstanceProvider
+	instanceProfileProvider *InstanceProfileProvider
+	inPlaceUpdateProvider   *InPlaceUpdateProvider
+	providerVerifier        *ProviderVerifier
+
+	// warmMu serializes warming the caches, so that provisioners started at
+	// the same time share the API calls they have in common
+	warmMu sync.Mutex
 }
 
 func NewCloudProvider(ctx context.Context, options cloudprovider.Options) *CloudProvider {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named("aws"))
-	sess := withUserAgent(session.Must(session.NewSession(
+	sess := withMetrics(withUserAgent(session.Must(session.NewSession(
 		request.WithRetryer(
 			&aws.Config{STSRegionalEndpoint: endpoints.RegionalSTSEndpoint},
 			client.DefaultRetryer{NumMaxRetries: client.DefaultRetryerMaxNumRetries},
 		),
-	)))
+	))))
 	if *sess.Config.Region == "" {
 		logging.FromContext(ctx).Debug("AWS region not configured, asking EC2 Instance Metadata Service")
 		*sess.Config.Region = getRegionFromIMDS(sess)
 	}
 	logging.FromContext(ctx).Debugf("Using AWS region %s", *sess.Config.Region)
+	opts := injection.GetOptions(ctx)
 	ec2api := ec2.New(sess)
-	subnetProvider := NewSubnetProvider(ec2api)
-	instanceTypeProvider := NewInstanceTypeProvider(ec2api, subnetProvider)
+	subnetProvider := NewSubnetProvider(ec2api, cacheTTL(opts.AWSSubnetCacheTTL, CacheTTL))
+	capacityBlockProvider := NewCapacityBlockProvider(ec2api)
+	instanceTypeProvider := NewInstanceTypeProvider(ec2api, subnetProvider, capacityBlockProvider, cacheTTL(opts.AWSInstanceTypesCacheTTL, InstanceTypesAndZonesCacheTTL))
+	iamapi := iam.New(sess)
+	instanceProfileProvider := NewInstanceProfileProvider(iamapi)
+	ssmapi := ssm.New(sess)
+	launchTemplateProvider := NewLaunchTemplateProvider(
+		ctx,
+		ec2api,
+		options.ClientSet,
+		amifamily.New(ssmapi, cache.New(cacheTTL(opts.AWSAMICacheTTL, CacheTTL), CacheCleanupInterval)),
+		NewSecurityGroupProvider(ec2api, cacheTTL(opts.AWSSecurityGroupCacheTTL, CacheTTL)),
+		NewClusterProvider(eks.New(sess), getCABundle(ctx)),
+		instanceProfileProvider,
+	)
 	return &CloudProvider{
-		instanceTypeProvider: instanceTypeProvider,
-		subnetProvider:       subnetProvider,
+		instanceTypeProvider:    instanceTypeProvider,
+		subnetProvider:          subnetProvider,
+		instanceProfileProvider: instanceProfileProvider,
+		inPlaceUpdateProvider:   NewInPlaceUpdateProvider(ssmapi),
+		providerVerifier:        NewProviderVerifier(ec2api, iamapi, subnetProvider, instanceTypeProvider, launchTemplateProvider),
 		instanceProvider: &InstanceProvider{ec2api, instanceTypeProvider, subnetProvider,
-			NewLaunchTemplateProvider(
-				ctx,
-				ec2api,
-				options.ClientSet,
-				amifamily.New(ssm.New(sess), cache.New(CacheTTL, CacheCleanupInterval)),
-				NewSecurityGroupProvider(ec2api),
-				getCABundle(ctx),
-			),
+			launchTemplateProvider,
+			NewSpotPlacementScoreProvider(ctx, ec2api, *sess.Config.Region),
+			capacityBlockProvider,
 		},
 	}
 }
 
-// Create a node given the constraints.
-func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) error {
-	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+// Create nodes for the node requests. Node requests that launch from the same
+// launch templates and capacity type are launched together by a single fleet
+// request, using the instance types and zones that all of them allow. Nodes
+// may be launched as larger instance types than some of the requests need, in
+// exchange for fewer calls to CreateFleet when a batch launches many nodes.
+func (c *CloudProvider) Create(ctx context.Context, nodeRequests []*cloudprovider.NodeRequest, callback func(*cloudprovider.NodeRequest, *v1.Node) error) error {
+	groups, err := c.groupNodeRequests(nodeRequests)
 	if err != nil {
 		return err
 	}
+	errs := make([]error, len(groups))
+	workqueue.ParallelizeUntil(ctx, len(groups), len(groups), func(i int) {
+		errs[i] = c.create(ctx, groups[i], callback)
+	})
+	return multierr.Combine(errs...)
+}
+
+// create launches nodes for a group of node requests, and assigns the nodes to
+// the requests in order. If the fleet is partially fulfilled, the last
+// requests in the group may receive fewer nodes than requested.
+func (c *CloudProvider) create(ctx context.Context, group *launchGroup, callback func(*cloudprovider.NodeRequest, *v1.Node) error) error {
+	quantity := 0
+	for _, nodeRequest := range group.nodeRequests {
+		quantity += nodeRequest.Quantity
+	}
 	// Create will only return an error if zero nodes could be launched.
 	// Partial fulfillment will be logged
-	nodes, err := c.instanceProvider.Create(ctx, vendorConstraints, instanceTypes, quantity)
+	nodes, err := c.instanceProvider.Create(ctx, group.launchConstraints(), group.instanceTypes, quantity)
 	if err != nil {
 		return fmt.Errorf("launching instances, %w", err)
 	}
 	var errs error
-	for _, node := range nodes {
-		errs = multierr.Append(errs, callback(node))
+	for _, nodeRequest := range group.nodeRequests {
+		for i := 0; i < nodeRequest.Quantity && len(nodes) > 0; i++ {
+			errs = multierr.Append(errs, callback(nodeRequest, nodes[0]))
+			nodes = nodes[1:]
+		}
 	}
 	return errs
 }
 
+// groupNodeRequests groups node requests that can be fulfilled by the same
+// fleet request, preserving the order of the requests
+func (c *CloudProvider) groupNodeRequests(nodeRequests []*cloudprovider.NodeRequest) ([]*launchGroup, error) {
+	groups := []*launchGroup{}
+	for _, nodeRequest := range nodeRequests {
+		constraints, err := launchConstraints(nodeRequest)
+		if err != nil {
+			return nil, err
+		}
+		capacityType := c.instanceProvider.getCapacityType(constraints, nodeRequest.InstanceTypeOptions)
+		key, err := launchKey(constraints, capacityType)
+		if err != nil {
+			return nil, err
+		}
+		grouped := false
+		for _, group := range groups {
+			if grouped = group.add(key, constraints, nodeRequest); grouped {
+				break
+			}
+		}
+		if !grouped {
+			groups = append(groups, &launchGroup{
+				key:           key,
+				constraints:   constraints,
+				capacityType:  capacityType,
+				instanceTypes: nodeRequest.InstanceTypeOptions,
+				zones:         constraints.Requirements.Zones(),
+				nodeRequests:  []*cloudprovider.NodeRequest{nodeRequest},
+			})
+		}
+	}
+	return groups, nil
+}
+
+// launchGroup is a group of node requests that are launched by a single fleet
+// request, as the instance types and into the zones that all of them allow
+type launchGroup struct {
+	key           string
+	constraints   *v1alpha1.Constraints
+	capacityType  string
+	instanceTypes []cloudprovider.InstanceType
+	zones         sets.String
+	nodeRequests  []*cloudprovider.NodeRequest
+}
+
+// add adds the node request to the group if it launches from the same launch
+// templates, and shares an offering of the group's capacity type with the
+// group's node requests
+func (g *launchGroup) add(key string, constraints *v1alpha1.Constraints, nodeRequest *cloudprovider.NodeRequest) bool {
+	if key != g.key {
+		return false
+	}
+	names := sets.NewString()
+	for _, instanceType := range nodeRequest.InstanceTypeOptions {
+		names.Insert(instanceType.Name())
+	}
+	// Instance types are kept in the group's order of preference
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, instanceType := range g.instanceTypes {
+		if names.Has(instanceType.Name()) {
+			instanceTypes = append(instanceTypes, instanceType)
+		}
+	}
+	zones := g.zones.Intersection(constraints.Requirements.Zones())
+	if !hasOffering(instanceTypes, zones, g.capacityType) {
+		return false
+	}
+	g.instanceTypes = instanceTypes
+	g.zones = zones
+	g.nodeRequests = append(g.nodeRequests, nodeRequest)
+	return true
+}
+
+// launchConstraints returns the constraints that the group's nodes are
+// launched with, which only allow the group's zones and capacity type
+func (g *launchGroup) launchConstraints() *v1alpha1.Constraints {
+	constraints := *g.constraints.Constraints
+	constraints.Requirements = constraints.Requirements.Add(
+		v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: g.zones.List()},
+		v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{g.capacityType}},
+	)
+	return &v1alpha1.Constraints{Constraints: &constraints, AWS: g.constraints.AWS}
+}
+
+// launchConstraints returns the node request's constraints, with the
+// ephemeral volume and EFA interfaces of its launch template resolved
+func launchConstraints(nodeRequest *cloudprovider.NodeRequest) (*v1alpha1.Constraints, error) {
+	vendorConstraints, err := v1alpha1.Deserialize(nodeRequest.Constraints)
+	if err != nil {
+		return nil, err
+	}
+	if ptr.BoolValue(vendorConstraints.AutoSizeEphemeralStorage) {
+		vendorConstraints.AWS = withEphemeralStorage(vendorConstraints.AWS, nodeRequest)
+	}
+	if !ptr.BoolValue(vendorConstraints.EFA) && requestsEFA(nodeRequest) {
+		vendorConstraints.AWS = vendorConstraints.AWS.DeepCopy()
+		vendorConstraints.EFA = ptr.Bool(true)
+	}
+	return vendorConstraints, nil
+}
+
+// launchKey is identical for constraints that launch from the same launch
+// templates with the capacity type. Requirements only narrow the instance
+// types, zones and capacity types that are launched, so they're excluded.
+// Constraints are keyed by their JSON, since quantities, e.g. of block device
+// volume sizes, keep their values in unexported fields, which aren't hashed.
+func launchKey(constraints *v1alpha1.Constraints, capacityType string) (string, error) {
+	key, err := json.Marshal(struct {
+		Labels                    map[string]string                   `json:"labels,omitempty"`
+		Taints                    v1alpha5.Taints                     `json:"taints,omitempty"`
+		KubeletConfiguration      *v1alpha5.KubeletConfiguration      `json:"kubeletConfiguration,omitempty"`
+		InstanceSelectionStrategy *v1alpha5.InstanceSelectionStrategy `json:"instanceSelectionStrategy,omitempty"`
+		AWS                       *v1alpha1.AWS                       `json:"aws"`
+		CapacityType              string                              `json:"capacityType"`
+	}{
+		Labels:                    constraints.Labels,
+		Taints:                    constraints.Taints,
+		KubeletConfiguration:      constraints.KubeletConfiguration,
+		InstanceSelectionStrategy: constraints.InstanceSelectionStrategy,
+		AWS:                       constraints.AWS,
+		CapacityType:              capacityType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("keying node request, %w", err)
+	}
+	return string(key), nil
+}
+
+// hasOffering returns true if any of the instance types is offered in any of
+// the zones with the capacity type
+func hasOffering(instanceTypes []cloudprovider.InstanceType, zones sets.String, capacityType string) bool {
+	for _, instanceType := range instanceTypes {
+		for _, offering := range instanceType.Offerings() {
+			if zones.Has(offering.Zone) && offering.CapacityType == capacityType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withEphemeralStorage returns a copy of the provider whose ephemeral block
+// device is grown to fit the ephemeral-storage requests of the node request's
+// pods and daemons, in addition to the kubelet's default nodefs.available
+// eviction threshold of 10%, so that they remain allocatable
+func withEphemeralStorage(provider *v1alpha1.AWS, nodeRequest *cloudprovider.NodeRequest) *v1alpha1.AWS {
+	requests := resources.Merge(nodeRequest.PodRequests, nodeRequest.DaemonRequests)[v1.ResourceEphemeralStorage]
+	provider = provider.DeepCopy()
+	provider.BlockDeviceMappings = amifamily.WithEphemeralStorage(
+		amifamily.GetAMIFamily(provider.AMIFamily, &amifamily.Options{}),
+		provider.BlockDeviceMappings,
+		*resource.NewQuantity(requests.Value()*10/9, resource.BinarySI),
+	)
+	return provider
+}
+
+// requestsEFA returns true if the node request's pods request EFA interfaces,
+// which are then attached to the nodes
+func requestsEFA(nodeRequest *cloudprovider.NodeRequest) bool {
+	_, ok := nodeRequest.PodRequests[resources.AWSEFA]
+	return ok
+}
+
 // GetInstanceTypes returns all available InstanceTypes despite accepting a Constraints struct (note that it does not utilize Requirements)
 func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provider *v1alpha5.Provider) ([]cloudprovider.InstanceType, error) {
 	vendorConstraints, err := v1alpha1.Deserialize(&v1alpha5.Constraints{Provider: provider})
@@ -126,17 +338,79 @@ func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provider *v1alpha5
 	return c.instanceTypeProvider.Get(ctx, vendorConstraints.AWS)
 }
 
+// Warm fills the caches of the AWS APIs that the provisioner's first launch
+// depends on, if --aws-cache-warmup is enabled, so that its first provisioning
+// round isn't delayed by dozens of API calls
+func (c *CloudProvider) Warm(ctx context.Context, constraints *v1alpha5.Constraints) error {
+	if !injection.GetOptions(ctx).AWSCacheWarmup {
+		return nil
+	}
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return err
+	}
+	c.warmMu.Lock()
+	defer c.warmMu.Unlock()
+	instanceTypes, err := c.instanceTypeProvider.Get(ctx, vendorConstraints.AWS)
+	if err != nil {
+		return fmt.Errorf("getting instance types, %w", err)
+	}
+	compatible := []cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		if isCompatible(vendorConstraints, instanceType) {
+			compatible = append(compatible, instanceType)
+		}
+	}
+	return c.instanceProvider.launchTemplateProvider.warm(ctx, vendorConstraints, compatible)
+}
+
 func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
 	return c.instanceProvider.Terminate(ctx, node)
 }
 
-// Validate the provisioner
+// Cleanup deletes the instance profile managed for the provisioner, if any,
+// once no nodes use it
+func (c *CloudProvider) Cleanup(ctx context.Context, provisionerName string) error {
+	return c.instanceProfileProvider.Delete(ctx, provisionerName)
+}
+
+// ManagesResources returns true if an instance profile is managed for
+// provisioners with the constraints, i.e. if they specify a role
+func (c *CloudProvider) ManagesResources(_ context.Context, constraints *v1alpha5.Constraints) bool {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		// Err on the side of cleaning up, since the provider can't be inspected
+		return true
+	}
+	return vendorConstraints.Role != nil
+}
+
+// ValidateInPlaceUpdate returns ErrInPlaceUpdateUnsupported for nodes that aren't running Bottlerocket
+func (c *CloudProvider) ValidateInPlaceUpdate(_ context.Context, node *v1.Node) error {
+	return c.inPlaceUpdateProvider.Validate(node)
+}
+
+// UpdateInPlace updates Bottlerocket nodes in place with the Bottlerocket update API
+func (c *CloudProvider) UpdateInPlace(ctx context.Context, node *v1.Node) (string, error) {
+	return c.inPlaceUpdateProvider.Update(ctx, node)
+}
+
+// GetInPlaceUpdate returns the status of an in-place update
+func (c *CloudProvider) GetInPlaceUpdate(ctx context.Context, node *v1.Node, id string) (cloudprovider.InPlaceUpdateStatus, error) {
+	return c.inPlaceUpdateProvider.Get(ctx, node, id)
+}
+
+// Validate the provisioner. At admission, the AWS resources that the provider
+// refers to are also verified to exist.
 func (c *CloudProvider) Validate(ctx context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
 	vendorConstraints, err := v1alpha1.Deserialize(constraints)
 	if err != nil {
 		return apis.ErrGeneric(err.Error())
 	}
-	return vendorConstraints.AWS.Validate()
+	if errs := vendorConstraints.Validate(); errs != nil {
+		return errs
+	}
+	return c.providerVerifier.Verify(ctx, vendorConstraints)
 }
 
 // Default the provisioner
@@ -152,11 +426,26 @@ func (c *CloudProvider) Default(ctx context.Context, constraints *v1alpha5.Const
 	}
 }
 
+// Comparators returns the property comparators that can order AWS instance
+// types. Their prices and interruption rates aren't known.
+func (c *CloudProvider) Comparators() sets.String {
+	return sets.NewString("generation")
+}
+
 // Name returns the CloudProvider implementation name.
 func (c *CloudProvider) Name() string {
 	return "aws"
 }
 
+// cacheTTL returns the configured TTL of a cache, or the default if it isn't
+// configured
+func cacheTTL(configured time.Duration, defaultTTL time.Duration) time.Duration {
+	if configured <= 0 {
+		return defaultTTL
+	}
+	return configured
+}
+
 // get the current region from EC2 IMDS
 func getRegionFromIMDS(sess *session.Session) string {
 	region, err := ec2metadata.New(sess).Region()
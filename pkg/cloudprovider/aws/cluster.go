@@ -0,0 +1,107 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/patrickmn/go-cache"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+const (
+	// ClusterCacheTTL bounds how long new launch templates may use a stale
+	// cluster endpoint or CA bundle after either is rotated
+	ClusterCacheTTL = 5 * time.Minute
+	clusterCacheKey = "cluster"
+)
+
+// Cluster is the information nodes need to join the cluster
+type Cluster struct {
+	Endpoint string
+	CABundle *string
+}
+
+// ClusterProvider discovers the cluster endpoint and CA bundle using the EKS
+// DescribeCluster API. A configured cluster endpoint takes precedence over the
+// discovered one, and if the cluster can't be described, e.g. because it isn't
+// managed by EKS, the configured endpoint and the CA bundle of Karpenter's own
+// client are used instead.
+type ClusterProvider struct {
+	sync.Mutex
+	eksapi   eksiface.EKSAPI
+	cache    *cache.Cache
+	caBundle *string
+	last     *Cluster
+}
+
+func NewClusterProvider(eksapi eksiface.EKSAPI, caBundle *string) *ClusterProvider {
+	return &ClusterProvider{
+		eksapi:   eksapi,
+		cache:    cache.New(ClusterCacheTTL, CacheCleanupInterval),
+		caBundle: caBundle,
+	}
+}
+
+func (p *ClusterProvider) Get(ctx context.Context) (*Cluster, error) {
+	p.Lock()
+	defer p.Unlock()
+	if cluster, ok := p.cache.Get(clusterCacheKey); ok {
+		return cluster.(*Cluster), nil
+	}
+	cluster, err := p.getCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p.last != nil && (p.last.Endpoint != cluster.Endpoint || ptr.StringValue(p.last.CABundle) != ptr.StringValue(cluster.CABundle)) {
+		logging.FromContext(ctx).Infof("Detected a change to the cluster endpoint or caBundle, new launch templates will use endpoint %s", cluster.Endpoint)
+	}
+	p.last = cluster
+	p.cache.SetDefault(clusterCacheKey, cluster)
+	return cluster, nil
+}
+
+func (p *ClusterProvider) getCluster(ctx context.Context) (*Cluster, error) {
+	opts := injection.GetOptions(ctx)
+	output, err := p.eksapi.DescribeClusterWithContext(ctx, &eks.DescribeClusterInput{Name: aws.String(opts.ClusterName)})
+	if err != nil {
+		if opts.ClusterEndpoint == "" {
+			return nil, fmt.Errorf("discovering cluster endpoint, %w", err)
+		}
+		logging.FromContext(ctx).Debugf("Unable to describe cluster %s, using the configured cluster endpoint, %s", opts.ClusterName, err)
+		return &Cluster{Endpoint: opts.ClusterEndpoint, CABundle: p.caBundle}, nil
+	}
+	cluster := &Cluster{Endpoint: opts.ClusterEndpoint, CABundle: p.caBundle}
+	if cluster.Endpoint == "" {
+		cluster.Endpoint = aws.StringValue(output.Cluster.Endpoint)
+	}
+	if output.Cluster.CertificateAuthority != nil && aws.StringValue(output.Cluster.CertificateAuthority.Data) != "" {
+		cluster.CABundle = output.Cluster.CertificateAuthority.Data
+	}
+	if cluster.Endpoint == "" {
+		return nil, fmt.Errorf("discovering cluster endpoint, cluster %s has no endpoint", opts.ClusterName)
+	}
+	logging.FromContext(ctx).Debugf("Discovered cluster endpoint %s and caBundle, length %d", cluster.Endpoint, len(ptr.StringValue(cluster.CABundle)))
+	return cluster, nil
+}
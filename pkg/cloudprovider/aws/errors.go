@@ -16,6 +16,8 @@ import (
 	"errors"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/ssm"
 
 	"github.com/aws/karpenter/pkg/utils/functional"
 )
@@ -25,9 +27,19 @@ var (
 	notFoundErrorCodes = []string{
 		"InvalidInstanceID.NotFound",
 		"InvalidLaunchTemplateName.NotFoundException",
+		iam.ErrCodeNoSuchEntityException,
+		ssm.ErrCodeInvocationDoesNotExist,
+		ssm.ErrCodeParameterNotFound,
 	}
 )
 
+// launchTemplateVersionNotFoundErrorCodes are returned when a launch template version that was launched from no
+// longer exists
+var launchTemplateVersionNotFoundErrorCodes = []string{
+	"InvalidLaunchTemplateId.VersionNotFound",
+	"InvalidLaunchTemplateName.NotFoundException",
+}
+
 // InsufficientCapacityErrorCode indicates that EC2 is temporarily lacking capacity for this
 // instance type and availability zone combination
 const InsufficientCapacityErrorCode = "InsufficientInstanceCapacity"
@@ -42,3 +54,13 @@ func isNotFound(err error) bool {
 	}
 	return false
 }
+
+// isLaunchTemplateVersionNotFound returns true if the err is an AWS error (even if it's wrapped) that means a launch
+// template version doesn't exist
+func isLaunchTemplateVersionNotFound(err error) bool {
+	var awsError awserr.Error
+	if errors.As(err, &awsError) {
+		return functional.ContainsString(launchTemplateVersionNotFoundErrorCodes, awsError.Code())
+	}
+	return false
+}
@@ -17,6 +17,7 @@ package fake
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
 
 	"github.com/Pallinder/go-randomdata"
@@ -48,10 +49,15 @@ type EC2Behavior struct {
 	DescribeInstanceTypesOutput         *ec2.DescribeInstanceTypesOutput
 	DescribeInstanceTypeOfferingsOutput *ec2.DescribeInstanceTypeOfferingsOutput
 	DescribeAvailabilityZonesOutput     *ec2.DescribeAvailabilityZonesOutput
+	GetSpotPlacementScoresOutput        *ec2.GetSpotPlacementScoresOutput
+	GetSpotPlacementScoresError         error
+	DescribeCapacityReservationsOutput  *ec2.DescribeCapacityReservationsOutput
 	CalledWithCreateFleetInput          set.Set
 	CalledWithCreateLaunchTemplateInput set.Set
+	CalledWithGetSpotPlacementScores    set.Set
 	Instances                           sync.Map
 	LaunchTemplates                     sync.Map
+	LaunchTemplateVersions              sync.Map
 	InsufficientCapacityPools           []CapacityPool
 }
 
@@ -69,8 +75,10 @@ func (e *EC2API) Reset() {
 	e.EC2Behavior = EC2Behavior{
 		CalledWithCreateFleetInput:          set.NewSet(),
 		CalledWithCreateLaunchTemplateInput: set.NewSet(),
+		CalledWithGetSpotPlacementScores:    set.NewSet(),
 		Instances:                           sync.Map{},
 		LaunchTemplates:                     sync.Map{},
+		LaunchTemplateVersions:              sync.Map{},
 		InsufficientCapacityPools:           []CapacityPool{},
 	}
 }
@@ -84,11 +92,31 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 	instanceIds := []*string{}
 	skippedPools := []CapacityPool{}
 	var spotInstanceRequestID *string
+	var instanceLifecycle *string
 
 	if aws.StringValue(input.TargetCapacitySpecification.DefaultTargetCapacityType) == v1alpha1.CapacityTypeSpot {
 		spotInstanceRequestID = aws.String(randomdata.SillyName())
 	}
+	if aws.StringValue(input.TargetCapacitySpecification.DefaultTargetCapacityType) == v1alpha1.CapacityTypeCapacityBlock {
+		instanceLifecycle = aws.String(v1alpha1.CapacityTypeCapacityBlock)
+	}
 
+	for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+		// Numbered versions must exist, e.g. the versions of custom launch templates
+		if versionNumber, err := strconv.ParseInt(aws.StringValue(launchTemplateConfig.LaunchTemplateSpecification.Version), 10, 64); err == nil {
+			if _, ok := e.LaunchTemplateVersions.Load(versionNumber); !ok {
+				return &ec2.CreateFleetOutput{Errors: []*ec2.CreateFleetError{{
+					ErrorCode: aws.String("InvalidLaunchTemplateId.VersionNotFound"),
+					LaunchTemplateAndOverrides: &ec2.LaunchTemplateAndOverridesResponse{
+						LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecification{
+							LaunchTemplateName: launchTemplateConfig.LaunchTemplateSpecification.LaunchTemplateName,
+							Version:            launchTemplateConfig.LaunchTemplateSpecification.Version,
+						},
+					},
+				}}}, nil
+			}
+		}
+	}
 	for i := 0; i < int(*input.TargetCapacitySpecification.TotalTargetCapacity); i++ {
 		skipInstance := false
 		for _, pool := range e.InsufficientCapacityPools {
@@ -108,7 +136,9 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 			Placement:             &ec2.Placement{AvailabilityZone: input.LaunchTemplateConfigs[0].Overrides[0].AvailabilityZone},
 			PrivateDnsName:        aws.String(randomdata.IpV4Address()),
 			InstanceType:          input.LaunchTemplateConfigs[0].Overrides[0].InstanceType,
+			SubnetId:              input.LaunchTemplateConfigs[0].Overrides[0].SubnetId,
 			SpotInstanceRequestId: spotInstanceRequestID,
+			InstanceLifecycle:     instanceLifecycle,
 		})
 		e.Instances.Store(*instances[i].InstanceId, instances[i])
 		instanceIds = append(instanceIds, instances[i].InstanceId)
@@ -143,6 +173,38 @@ func (e *EC2API) CreateLaunchTemplateWithContext(_ context.Context, input *ec2.C
 	return &ec2.CreateLaunchTemplateOutput{LaunchTemplate: launchTemplate}, nil
 }
 
+func (e *EC2API) CreateLaunchTemplateVersionWithContext(_ context.Context, input *ec2.CreateLaunchTemplateVersionInput, _ ...request.Option) (*ec2.CreateLaunchTemplateVersionOutput, error) {
+	versionNumber := int64(1)
+	e.LaunchTemplateVersions.Range(func(key, value interface{}) bool {
+		versionNumber++
+		return true
+	})
+	launchTemplateVersion := &ec2.LaunchTemplateVersion{
+		LaunchTemplateName: input.LaunchTemplateName,
+		VersionDescription: input.VersionDescription,
+		VersionNumber:      aws.Int64(versionNumber),
+		LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+			ImageId:  input.LaunchTemplateData.ImageId,
+			UserData: input.LaunchTemplateData.UserData,
+		},
+	}
+	e.LaunchTemplateVersions.Store(versionNumber, launchTemplateVersion)
+	return &ec2.CreateLaunchTemplateVersionOutput{LaunchTemplateVersion: launchTemplateVersion}, nil
+}
+
+func (e *EC2API) DescribeLaunchTemplateVersionsPagesWithContext(_ context.Context, input *ec2.DescribeLaunchTemplateVersionsInput, fn func(*ec2.DescribeLaunchTemplateVersionsOutput, bool) bool, _ ...request.Option) error {
+	output := &ec2.DescribeLaunchTemplateVersionsOutput{}
+	e.LaunchTemplateVersions.Range(func(key, value interface{}) bool {
+		launchTemplateVersion := value.(*ec2.LaunchTemplateVersion)
+		if aws.StringValue(launchTemplateVersion.LaunchTemplateName) == aws.StringValue(input.LaunchTemplateName) {
+			output.LaunchTemplateVersions = append(output.LaunchTemplateVersions, launchTemplateVersion)
+		}
+		return true
+	})
+	fn(output, true)
+	return nil
+}
+
 func (e *EC2API) DescribeInstancesWithContext(_ context.Context, input *ec2.DescribeInstancesInput, _ ...request.Option) (*ec2.DescribeInstancesOutput, error) {
 	if e.DescribeInstancesOutput != nil {
 		return e.DescribeInstancesOutput, nil
@@ -201,6 +263,13 @@ func (e *EC2API) DescribeSecurityGroupsWithContext(context.Context, *ec2.Describ
 	}}, nil
 }
 
+func (e *EC2API) DescribeCapacityReservationsWithContext(context.Context, *ec2.DescribeCapacityReservationsInput, ...request.Option) (*ec2.DescribeCapacityReservationsOutput, error) {
+	if e.DescribeCapacityReservationsOutput != nil {
+		return e.DescribeCapacityReservationsOutput, nil
+	}
+	return &ec2.DescribeCapacityReservationsOutput{}, nil
+}
+
 func (e *EC2API) DescribeAvailabilityZonesWithContext(context.Context, *ec2.DescribeAvailabilityZonesInput, ...request.Option) (*ec2.DescribeAvailabilityZonesOutput, error) {
 	if e.DescribeAvailabilityZonesOutput != nil {
 		return e.DescribeAvailabilityZonesOutput, nil
@@ -212,6 +281,19 @@ func (e *EC2API) DescribeAvailabilityZonesWithContext(context.Context, *ec2.Desc
 	}}, nil
 }
 
+func (e *EC2API) GetSpotPlacementScoresPagesWithContext(_ context.Context, input *ec2.GetSpotPlacementScoresInput, fn func(*ec2.GetSpotPlacementScoresOutput, bool) bool, _ ...request.Option) error {
+	e.CalledWithGetSpotPlacementScores.Add(input)
+	if e.GetSpotPlacementScoresError != nil {
+		return e.GetSpotPlacementScoresError
+	}
+	if e.GetSpotPlacementScoresOutput != nil {
+		fn(e.GetSpotPlacementScoresOutput, false)
+		return nil
+	}
+	fn(&ec2.GetSpotPlacementScoresOutput{}, false)
+	return nil
+}
+
 func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2.DescribeInstanceTypesInput, fn func(*ec2.DescribeInstanceTypesOutput, bool) bool, _ ...request.Option) error {
 	if e.DescribeInstanceTypesOutput != nil {
 		fn(e.DescribeInstanceTypesOutput, false)
@@ -243,6 +325,7 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 			{
 				InstanceType:                  aws.String("m5.large"),
 				SupportedUsageClasses:         DefaultSupportedUsageClasses,
+				DedicatedHostsSupported:       aws.Bool(true),
 				SupportedVirtualizationTypes:  []*string{aws.String("hvm")},
 				BurstablePerformanceSupported: aws.Bool(false),
 				BareMetal:                     aws.Bool(false),
@@ -264,6 +347,7 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 			{
 				InstanceType:                  aws.String("m5.xlarge"),
 				SupportedUsageClasses:         DefaultSupportedUsageClasses,
+				DedicatedHostsSupported:       aws.Bool(true),
 				SupportedVirtualizationTypes:  []*string{aws.String("hvm")},
 				BurstablePerformanceSupported: aws.Bool(false),
 				BareMetal:                     aws.Bool(false),
@@ -285,6 +369,7 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 			{
 				InstanceType:                  aws.String("p3.8xlarge"),
 				SupportedUsageClasses:         DefaultSupportedUsageClasses,
+				DedicatedHostsSupported:       aws.Bool(true),
 				SupportedVirtualizationTypes:  []*string{aws.String("hvm")},
 				BurstablePerformanceSupported: aws.Bool(false),
 				BareMetal:                     aws.Bool(false),
@@ -380,6 +465,10 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 				NetworkInfo: &ec2.NetworkInfo{
 					MaximumNetworkInterfaces:  aws.Int64(4),
 					Ipv4AddressesPerInterface: aws.Int64(60),
+					EfaSupported:              aws.Bool(true),
+					EfaInfo: &ec2.EfaInfo{
+						MaximumEfaInterfaces: aws.Int64(1),
+					},
 				},
 			},
 		},
@@ -0,0 +1,50 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+)
+
+// EKSAPI returns DescribeClusterOutput if set, and otherwise behaves as if
+// the cluster isn't managed by EKS.
+type EKSAPI struct {
+	eksiface.EKSAPI
+	DescribeClusterOutput *eks.DescribeClusterOutput
+	WantErr               error
+}
+
+// Reset must be called between tests otherwise tests will pollute
+// each other.
+func (e *EKSAPI) Reset() {
+	e.DescribeClusterOutput = nil
+	e.WantErr = nil
+}
+
+func (e *EKSAPI) DescribeClusterWithContext(_ context.Context, input *eks.DescribeClusterInput, _ ...request.Option) (*eks.DescribeClusterOutput, error) {
+	if e.WantErr != nil {
+		return nil, e.WantErr
+	}
+	if e.DescribeClusterOutput != nil {
+		return e.DescribeClusterOutput, nil
+	}
+	return nil, awserr.New(eks.ErrCodeResourceNotFoundException, fmt.Sprintf("No cluster found for name: %s.", *input.Name), nil)
+}
@@ -0,0 +1,149 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// IAMAPI stores instance profiles in memory
+type IAMAPI struct {
+	iamiface.IAMAPI
+	mu               sync.Mutex
+	InstanceProfiles map[string]*iam.InstanceProfile
+	Roles            map[string]*iam.Role
+	WantErr          error
+}
+
+// Reset must be called between tests otherwise tests will pollute
+// each other.
+func (i *IAMAPI) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.InstanceProfiles = map[string]*iam.InstanceProfile{}
+	i.Roles = map[string]*iam.Role{}
+	i.WantErr = nil
+}
+
+func (i *IAMAPI) GetInstanceProfileWithContext(_ context.Context, input *iam.GetInstanceProfileInput, _ ...request.Option) (*iam.GetInstanceProfileOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.WantErr != nil {
+		return nil, i.WantErr
+	}
+	instanceProfile, ok := i.InstanceProfiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, noSuchEntity(aws.StringValue(input.InstanceProfileName))
+	}
+	return &iam.GetInstanceProfileOutput{InstanceProfile: instanceProfile}, nil
+}
+
+func (i *IAMAPI) CreateInstanceProfileWithContext(_ context.Context, input *iam.CreateInstanceProfileInput, _ ...request.Option) (*iam.CreateInstanceProfileOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.WantErr != nil {
+		return nil, i.WantErr
+	}
+	name := aws.StringValue(input.InstanceProfileName)
+	if _, ok := i.InstanceProfiles[name]; ok {
+		return nil, awserr.New(iam.ErrCodeEntityAlreadyExistsException, fmt.Sprintf("Instance Profile %s already exists.", name), nil)
+	}
+	instanceProfile := &iam.InstanceProfile{InstanceProfileName: input.InstanceProfileName, Tags: input.Tags}
+	i.InstanceProfiles[name] = instanceProfile
+	return &iam.CreateInstanceProfileOutput{InstanceProfile: instanceProfile}, nil
+}
+
+func (i *IAMAPI) DeleteInstanceProfileWithContext(_ context.Context, input *iam.DeleteInstanceProfileInput, _ ...request.Option) (*iam.DeleteInstanceProfileOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.WantErr != nil {
+		return nil, i.WantErr
+	}
+	name := aws.StringValue(input.InstanceProfileName)
+	instanceProfile, ok := i.InstanceProfiles[name]
+	if !ok {
+		return nil, noSuchEntity(name)
+	}
+	if len(instanceProfile.Roles) != 0 {
+		return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Cannot delete entity, must remove roles from instance profile first.", nil)
+	}
+	delete(i.InstanceProfiles, name)
+	return &iam.DeleteInstanceProfileOutput{}, nil
+}
+
+func (i *IAMAPI) AddRoleToInstanceProfileWithContext(_ context.Context, input *iam.AddRoleToInstanceProfileInput, _ ...request.Option) (*iam.AddRoleToInstanceProfileOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.WantErr != nil {
+		return nil, i.WantErr
+	}
+	instanceProfile, ok := i.InstanceProfiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, noSuchEntity(aws.StringValue(input.InstanceProfileName))
+	}
+	if len(instanceProfile.Roles) != 0 {
+		return nil, awserr.New(iam.ErrCodeLimitExceededException, "Cannot exceed quota for InstanceSessionsPerInstanceProfile: 1", nil)
+	}
+	instanceProfile.Roles = append(instanceProfile.Roles, &iam.Role{RoleName: input.RoleName})
+	return &iam.AddRoleToInstanceProfileOutput{}, nil
+}
+
+func (i *IAMAPI) RemoveRoleFromInstanceProfileWithContext(_ context.Context, input *iam.RemoveRoleFromInstanceProfileInput, _ ...request.Option) (*iam.RemoveRoleFromInstanceProfileOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.WantErr != nil {
+		return nil, i.WantErr
+	}
+	instanceProfile, ok := i.InstanceProfiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, noSuchEntity(aws.StringValue(input.InstanceProfileName))
+	}
+	roles := []*iam.Role{}
+	for _, role := range instanceProfile.Roles {
+		if aws.StringValue(role.RoleName) != aws.StringValue(input.RoleName) {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == len(instanceProfile.Roles) {
+		return nil, noSuchEntity(aws.StringValue(input.RoleName))
+	}
+	instanceProfile.Roles = roles
+	return &iam.RemoveRoleFromInstanceProfileOutput{}, nil
+}
+
+func (i *IAMAPI) GetRoleWithContext(_ context.Context, input *iam.GetRoleInput, _ ...request.Option) (*iam.GetRoleOutput, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.WantErr != nil {
+		return nil, i.WantErr
+	}
+	role, ok := i.Roles[aws.StringValue(input.RoleName)]
+	if !ok {
+		return nil, noSuchEntity(aws.StringValue(input.RoleName))
+	}
+	return &iam.GetRoleOutput{Role: role}, nil
+}
+
+func noSuchEntity(name string) error {
+	return awserr.New(iam.ErrCodeNoSuchEntityException, fmt.Sprintf("The entity with name %s cannot be found.", name), nil)
+}
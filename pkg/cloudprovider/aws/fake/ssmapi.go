@@ -25,8 +25,9 @@ import (
 
 type SSMAPI struct {
 	ssmiface.SSMAPI
-	GetParameterOutput *ssm.GetParameterOutput
-	WantErr            error
+	GetParameterOutput         *ssm.GetParameterOutput
+	GetCommandInvocationOutput *ssm.GetCommandInvocationOutput
+	WantErr                    error
 }
 
 func (a SSMAPI) GetParameterWithContext(context.Context, *ssm.GetParameterInput, ...request.Option) (*ssm.GetParameterOutput, error) {
@@ -40,3 +41,20 @@ func (a SSMAPI) GetParameterWithContext(context.Context, *ssm.GetParameterInput,
 		Parameter: &ssm.Parameter{Value: aws.String("test-ami-id")},
 	}, nil
 }
+
+func (a SSMAPI) SendCommandWithContext(context.Context, *ssm.SendCommandInput, ...request.Option) (*ssm.SendCommandOutput, error) {
+	if a.WantErr != nil {
+		return nil, a.WantErr
+	}
+	return &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("test-command-id")}}, nil
+}
+
+func (a SSMAPI) GetCommandInvocationWithContext(context.Context, *ssm.GetCommandInvocationInput, ...request.Option) (*ssm.GetCommandInvocationOutput, error) {
+	if a.WantErr != nil {
+		return nil, a.WantErr
+	}
+	if a.GetCommandInvocationOutput != nil {
+		return a.GetCommandInvocationOutput, nil
+	}
+	return &ssm.GetCommandInvocationOutput{Status: aws.String(ssm.CommandInvocationStatusSuccess)}, nil
+}
@@ -0,0 +1,116 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+// bottlerocketUpdateCommands stage the latest Bottlerocket release with the update API, and reboot into it. The
+// command fails if no update can be applied, e.g. because the node is already up to date, so that the node is
+// replaced instead. The reboot is delayed so that the command reports success before the node goes down.
+var bottlerocketUpdateCommands = []string{
+	"apiclient update apply --check",
+	"(sleep 10 && apiclient reboot) > /dev/null 2>&1 &",
+}
+
+// InPlaceUpdateProvider updates the operating system of Bottlerocket nodes in place, by running commands in the
+// control container with Systems Manager
+type InPlaceUpdateProvider struct {
+	ssmapi ssmiface.SSMAPI
+}
+
+func NewInPlaceUpdateProvider(ssmapi ssmiface.SSMAPI) *InPlaceUpdateProvider {
+	return &InPlaceUpdateProvider{ssmapi: ssmapi}
+}
+
+// Validate returns ErrInPlaceUpdateUnsupported if the node isn't running Bottlerocket
+func (p *InPlaceUpdateProvider) Validate(node *v1.Node) error {
+	if !strings.HasPrefix(node.Status.NodeInfo.OSImage, "Bottlerocket") {
+		return cloudprovider.ErrInPlaceUpdateUnsupported
+	}
+	return nil
+}
+
+// Update starts an update of the node, and returns an ID made of the command ID, and the boot ID and OS image of the
+// node, so that the reboot into a new release can be observed.
+func (p *InPlaceUpdateProvider) Update(ctx context.Context, node *v1.Node) (string, error) {
+	if err := p.Validate(node); err != nil {
+		return "", err
+	}
+	id, err := getInstanceID(node)
+	if err != nil {
+		return "", fmt.Errorf("getting instance ID for node %s, %w", node.Name, err)
+	}
+	output, err := p.ssmapi.SendCommandWithContext(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String("AWS-RunShellScript"),
+		InstanceIds:  []*string{id},
+		Comment:      aws.String(fmt.Sprintf("Karpenter in-place update of node %s", node.Name)),
+		Parameters:   map[string][]*string{"commands": aws.StringSlice(bottlerocketUpdateCommands)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("sending update command to instance %s, %w", aws.StringValue(id), err)
+	}
+	logging.FromContext(ctx).Debugf("Sent update command %s to instance %s", aws.StringValue(output.Command.CommandId), aws.StringValue(id))
+	return fmt.Sprintf("%s/%s/%s", aws.StringValue(output.Command.CommandId), node.Status.NodeInfo.BootID, node.Status.NodeInfo.OSImage), nil
+}
+
+// Get the status of an update. The update succeeds once the command succeeded and the node rebooted into a different
+// OS image, and fails if it rebooted into the same one.
+func (p *InPlaceUpdateProvider) Get(ctx context.Context, node *v1.Node, updateID string) (cloudprovider.InPlaceUpdateStatus, error) {
+	parts := strings.SplitN(updateID, "/", 3)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("parsing in-place update ID %s", updateID)
+	}
+	commandID, bootID, osImage := parts[0], parts[1], parts[2]
+	id, err := getInstanceID(node)
+	if err != nil {
+		return "", fmt.Errorf("getting instance ID for node %s, %w", node.Name, err)
+	}
+	output, err := p.ssmapi.GetCommandInvocationWithContext(ctx, &ssm.GetCommandInvocationInput{CommandId: aws.String(commandID), InstanceId: id})
+	if isNotFound(err) {
+		// The invocation is eventually consistent with the command
+		return cloudprovider.InPlaceUpdatePending, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting update command %s, %w", commandID, err)
+	}
+	switch aws.StringValue(output.Status) {
+	case ssm.CommandInvocationStatusSuccess:
+		if node.Status.NodeInfo.BootID == bootID {
+			return cloudprovider.InPlaceUpdatePending, nil
+		}
+		if node.Status.NodeInfo.OSImage == osImage {
+			logging.FromContext(ctx).Errorf("Node rebooted into the same OS image %s after update command %s", osImage, commandID)
+			return cloudprovider.InPlaceUpdateFailed, nil
+		}
+		return cloudprovider.InPlaceUpdateSucceeded, nil
+	case ssm.CommandInvocationStatusCancelled, ssm.CommandInvocationStatusTimedOut, ssm.CommandInvocationStatusFailed:
+		logging.FromContext(ctx).Errorf("Update command %s %s, %s", commandID, aws.StringValue(output.Status), aws.StringValue(output.StandardErrorContent))
+		return cloudprovider.InPlaceUpdateFailed, nil
+	default:
+		return cloudprovider.InPlaceUpdatePending, nil
+	}
+}
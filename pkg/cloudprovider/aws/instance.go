@@ -17,7 +17,6 @@ package aws
 import (
 	"context"
 	"fmt"
-	"sort"
 	"strings"
 	"time"
 
@@ -31,10 +30,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/options"
 )
@@ -49,21 +50,26 @@ const (
 	nvidiaGPUResourceName v1.ResourceName = "nvidia.com/gpu"
 	amdGPUResourceName    v1.ResourceName = "amd.com/gpu"
 	awsNeuronResourceName v1.ResourceName = "aws.amazon.com/neuron"
+	awsEFAResourceName    v1.ResourceName = "vpc.amazonaws.com/efa"
 )
 
 type InstanceProvider struct {
-	ec2api                 ec2iface.EC2API
-	instanceTypeProvider   *InstanceTypeProvider
-	subnetProvider         *SubnetProvider
-	launchTemplateProvider *LaunchTemplateProvider
+	ec2api                     ec2iface.EC2API
+	instanceTypeProvider       *InstanceTypeProvider
+	subnetProvider             *SubnetProvider
+	launchTemplateProvider     *LaunchTemplateProvider
+	spotPlacementScoreProvider *SpotPlacementScoreProvider
+	capacityBlockProvider      *CapacityBlockProvider
 }
 
-func NewInstanceProvider(ec2api ec2iface.EC2API, instanceTypeProvider *InstanceTypeProvider, subnetProvider *SubnetProvider, launchTemplateProvider *LaunchTemplateProvider) *InstanceProvider {
+func NewInstanceProvider(ec2api ec2iface.EC2API, instanceTypeProvider *InstanceTypeProvider, subnetProvider *SubnetProvider, launchTemplateProvider *LaunchTemplateProvider, spotPlacementScoreProvider *SpotPlacementScoreProvider, capacityBlockProvider *CapacityBlockProvider) *InstanceProvider {
 	return &InstanceProvider{
-		ec2api:                 ec2api,
-		instanceTypeProvider:   instanceTypeProvider,
-		subnetProvider:         subnetProvider,
-		launchTemplateProvider: launchTemplateProvider,
+		ec2api:                     ec2api,
+		instanceTypeProvider:       instanceTypeProvider,
+		subnetProvider:             subnetProvider,
+		launchTemplateProvider:     launchTemplateProvider,
+		spotPlacementScoreProvider: spotPlacementScoreProvider,
+		capacityBlockProvider:      capacityBlockProvider,
 	}
 }
 
@@ -98,12 +104,20 @@ func (p *InstanceProvider) Create(ctx context.Context, constraints *v1alpha1.Con
 			aws.StringValue(instance.Placement.AvailabilityZone),
 			getCapacityType(instance),
 		)
+		p.reserveIPs(instance, instanceTypes)
 		// Convert Instance to Node
 		node, err := p.instanceToNode(ctx, instance, instanceTypes)
 		if err != nil {
 			logging.FromContext(ctx).Errorf("creating Node from an EC2 Instance: %s", err)
 			continue
 		}
+		node.Labels[v1alpha1.LabelTenancy] = v1alpha1.TenancyOrDefault(constraints.Tenancy)
+		if constraints.PlacementGroup != nil {
+			node.Labels[v1alpha1.LabelPlacementGroup] = *constraints.PlacementGroup
+		}
+		if ptr.BoolValue(constraints.EFA) {
+			p.addEFAs(node, instanceTypes)
+		}
 		nodes = append(nodes, node)
 	}
 	if len(nodes) == 0 {
@@ -112,6 +126,33 @@ func (p *InstanceProvider) Create(ctx context.Context, constraints *v1alpha1.Con
 	return nodes, nil
 }
 
+// addEFAs advertises the EFA interfaces that are attached to the instance, which the EFA device plugin reports once
+// it is running on the node
+func (p *InstanceProvider) addEFAs(node *v1.Node, instanceTypes []cloudprovider.InstanceType) {
+	for _, instanceType := range instanceTypes {
+		if instanceType.Name() == node.Labels[v1.LabelInstanceTypeStable] && !instanceType.AWSEFAs().IsZero() {
+			node.Status.Capacity[awsEFAResourceName] = *instanceType.AWSEFAs()
+			node.Status.Allocatable[awsEFAResourceName] = *instanceType.AWSEFAs()
+		}
+	}
+}
+
+// reserveIPs accounts for the IP addresses that the instance consumes in its subnet, so that bursts of launches are
+// spread across subnets before the subnets are described again. Each pod that fits on the instance is assumed to
+// consume an IP address, in addition to the instance's primary address.
+func (p *InstanceProvider) reserveIPs(instance *ec2.Instance, instanceTypes []cloudprovider.InstanceType) {
+	if instance.SubnetId == nil {
+		return
+	}
+	ips := int64(1)
+	for _, instanceType := range instanceTypes {
+		if instanceType.Name() == aws.StringValue(instance.InstanceType) {
+			ips += instanceType.Pods().Value()
+		}
+	}
+	p.subnetProvider.Reserve(aws.StringValue(instance.SubnetId), ips)
+}
+
 func (p *InstanceProvider) Terminate(ctx context.Context, node *v1.Node) error {
 	id, err := getInstanceID(node)
 	if err != nil {
@@ -151,15 +192,22 @@ func (p *InstanceProvider) launchInstances(ctx context.Context, constraints *v1a
 		},
 	}
 	if capacityType == v1alpha1.CapacityTypeSpot {
-		createFleetInput.SpotOptions = &ec2.SpotOptionsRequest{AllocationStrategy: aws.String(ec2.SpotAllocationStrategyCapacityOptimizedPrioritized)}
+		createFleetInput.SpotOptions = &ec2.SpotOptionsRequest{AllocationStrategy: aws.String(spotAllocationStrategy(constraints))}
 	} else {
 		createFleetInput.OnDemandOptions = &ec2.OnDemandOptionsRequest{AllocationStrategy: aws.String(ec2.FleetOnDemandAllocationStrategyLowestPrice)}
 	}
 	createFleetOutput, err := p.ec2api.CreateFleetWithContext(ctx, createFleetInput)
 	if err != nil {
+		if isLaunchTemplateVersionNotFound(err) {
+			for _, launchTemplateConfig := range launchTemplateConfigs {
+				p.launchTemplateProvider.InvalidateVersion(ctx, aws.StringValue(launchTemplateConfig.LaunchTemplateSpecification.LaunchTemplateName),
+					aws.StringValue(launchTemplateConfig.LaunchTemplateSpecification.Version))
+			}
+		}
 		return nil, fmt.Errorf("creating fleet %w", err)
 	}
 	p.updateUnavailableOfferingsCache(ctx, createFleetOutput.Errors, capacityType)
+	p.invalidateLaunchTemplateVersions(ctx, createFleetOutput.Errors)
 	instanceIds := combineFleetInstances(*createFleetOutput)
 	if len(instanceIds) == 0 {
 		return nil, combineFleetErrors(createFleetOutput.Errors)
@@ -170,23 +218,42 @@ func (p *InstanceProvider) launchInstances(ctx context.Context, constraints *v1a
 	return instanceIds, nil
 }
 
+// spotAllocationStrategy returns the CreateFleet allocation strategy of the
+// provisioner's instance selection strategy. Override priorities are only
+// honored by capacity-optimized-prioritized.
+func spotAllocationStrategy(constraints *v1alpha1.Constraints) string {
+	switch v1alpha5.InstanceSelectionStrategyOrDefault(constraints.InstanceSelectionStrategy) {
+	case v1alpha5.InstanceSelectionStrategyLowestPrice:
+		return ec2.SpotAllocationStrategyLowestPrice
+	case v1alpha5.InstanceSelectionStrategyDiversified:
+		return ec2.SpotAllocationStrategyDiversified
+	default:
+		return ec2.SpotAllocationStrategyCapacityOptimizedPrioritized
+	}
+}
+
 func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, capacityType string) ([]*ec2.FleetLaunchTemplateConfigRequest, error) {
 	// Get subnets given the constraints
 	subnets, err := p.subnetProvider.Get(ctx, constraints.AWS)
 	if err != nil {
 		return nil, fmt.Errorf("getting subnets, %w", err)
 	}
+	if capacityType == v1alpha1.CapacityTypeCapacityBlock {
+		return p.getCapacityBlockLaunchTemplateConfigs(ctx, constraints, instanceTypes, p.subnetProvider.ZonalSubnets(constraints.AWS, subnets))
+	}
+	zonalSubnets := p.subnetProvider.ZonalSubnets(constraints.AWS, subnets)
 	var launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest
-	launchTemplates, err := p.launchTemplateProvider.Get(ctx, constraints, instanceTypes, map[string]string{v1alpha5.LabelCapacityType: capacityType})
+	launchTemplates, err := p.launchTemplateProvider.Get(ctx, constraints, instanceTypes, map[string]string{v1alpha5.LabelCapacityType: capacityType}, "")
 	if err != nil {
 		return nil, fmt.Errorf("getting launch templates, %w", err)
 	}
-	for launchTemplateName, instanceTypes := range launchTemplates {
+	scores := p.getSpotPlacementScores(ctx, instanceTypes, capacityType)
+	for launchTemplate, instanceTypes := range launchTemplates {
 		launchTemplateConfig := &ec2.FleetLaunchTemplateConfigRequest{
-			Overrides: p.getOverrides(instanceTypes, subnets, constraints.Requirements.Zones(), capacityType),
+			Overrides: p.getOverrides(instanceTypes, zonalSubnets, constraints.Requirements.Zones(), capacityType, scores, constraints.PlacementGroup),
 			LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
-				LaunchTemplateName: aws.String(launchTemplateName),
-				Version:            aws.String("$Latest"),
+				LaunchTemplateName: aws.String(launchTemplate.Name),
+				Version:            aws.String(launchTemplate.Version),
 			},
 		}
 		if len(launchTemplateConfig.Overrides) > 0 {
@@ -199,17 +266,68 @@ func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, constra
 	return launchTemplateConfigs, nil
 }
 
-// getOverrides creates and returns launch template overrides for the cross product of instanceTypeOptions and subnets (with subnets being constrained by
-// zones and the offerings in instanceTypeOptions)
-func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.InstanceType, subnets []*ec2.Subnet, zones sets.String, capacityType string) []*ec2.FleetLaunchTemplateOverridesRequest {
-	// sort subnets in ascending order of available IP addresses and populate map with most available subnet per AZ
-	zonalSubnets := map[string]*ec2.Subnet{}
-	sort.Slice(subnets, func(i, j int) bool {
-		return aws.Int64Value(subnets[i].AvailableIpAddressCount) < aws.Int64Value(subnets[j].AvailableIpAddressCount)
-	})
-	for _, subnet := range subnets {
-		zonalSubnets[*subnet.AvailabilityZone] = subnet
+// getCapacityBlockLaunchTemplateConfigs creates a launch template config for each active capacity block that matches
+// the instance types and zones of the request. Each launch template targets a single capacity block, since instances
+// must name the capacity reservation that they are launched into.
+func (p *InstanceProvider) getCapacityBlockLaunchTemplateConfigs(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, zonalSubnets map[string]*ec2.Subnet) ([]*ec2.FleetLaunchTemplateConfigRequest, error) {
+	capacityBlocks, err := p.capacityBlockProvider.Get(ctx, constraints.AWS)
+	if err != nil {
+		return nil, fmt.Errorf("getting capacity blocks, %w", err)
+	}
+	var launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest
+	for _, capacityBlock := range capacityBlocks {
+		if !capacityBlock.Active(time.Now()) {
+			continue
+		}
+		var blockInstanceTypes []cloudprovider.InstanceType
+		for _, instanceType := range instanceTypes {
+			if instanceType.Name() == capacityBlock.InstanceType {
+				blockInstanceTypes = append(blockInstanceTypes, instanceType)
+			}
+		}
+		if len(blockInstanceTypes) == 0 {
+			continue
+		}
+		launchTemplates, err := p.launchTemplateProvider.Get(ctx, constraints, blockInstanceTypes, map[string]string{v1alpha5.LabelCapacityType: v1alpha1.CapacityTypeCapacityBlock}, capacityBlock.ID)
+		if err != nil {
+			return nil, fmt.Errorf("getting launch templates, %w", err)
+		}
+		zones := constraints.Requirements.Zones().Intersection(sets.NewString(capacityBlock.Zone))
+		for launchTemplate, instanceTypes := range launchTemplates {
+			launchTemplateConfig := &ec2.FleetLaunchTemplateConfigRequest{
+				Overrides: p.getOverrides(instanceTypes, zonalSubnets, zones, v1alpha1.CapacityTypeCapacityBlock, nil, constraints.PlacementGroup),
+				LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+					LaunchTemplateName: aws.String(launchTemplate.Name),
+					Version:            aws.String(launchTemplate.Version),
+				},
+			}
+			if len(launchTemplateConfig.Overrides) > 0 {
+				launchTemplateConfigs = append(launchTemplateConfigs, launchTemplateConfig)
+			}
+		}
+	}
+	if len(launchTemplateConfigs) == 0 {
+		return nil, fmt.Errorf("no capacity blocks are currently available given the constraints")
+	}
+	return launchTemplateConfigs, nil
+}
+
+// getSpotPlacementScores returns spot placement scores keyed by zone, or nil if they aren't used for this request
+func (p *InstanceProvider) getSpotPlacementScores(ctx context.Context, instanceTypes []cloudprovider.InstanceType, capacityType string) map[string]int64 {
+	if capacityType != v1alpha1.CapacityTypeSpot || !injection.GetOptions(ctx).AWSSpotPlacementScores || p.spotPlacementScoreProvider == nil {
+		return nil
+	}
+	names := []string{}
+	for _, instanceType := range instanceTypes {
+		names = append(names, instanceType.Name())
 	}
+	return p.spotPlacementScoreProvider.Get(ctx, names)
+}
+
+// getOverrides creates and returns launch template overrides for the cross product of instanceTypeOptions and the
+// selected subnet of each zone (with zones being constrained by zones and the offerings in instanceTypeOptions). Instances
+// are launched into the placement group, if any.
+func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.InstanceType, zonalSubnets map[string]*ec2.Subnet, zones sets.String, capacityType string, scores map[string]int64, placementGroup *string) []*ec2.FleetLaunchTemplateOverridesRequest {
 	var overrides []*ec2.FleetLaunchTemplateOverridesRequest
 	for i, instanceType := range instanceTypeOptions {
 		for _, offering := range instanceType.Offerings() {
@@ -230,11 +348,15 @@ func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.Inst
 				// CreateFleet so that we can figure out the zone rather than additional API calls to look up the subnet
 				AvailabilityZone: subnet.AvailabilityZone,
 			}
+			if placementGroup != nil {
+				override.Placement = &ec2.Placement{GroupName: placementGroup}
+			}
 			// Add a priority for spot requests since we are using the capacity-optimized-prioritized spot allocation strategy
 			// to reduce the likelihood of getting an excessively large instance type.
-			// instanceTypeOptions are sorted by vcpus and memory so this prioritizes smaller instance types.
+			// instanceTypeOptions are ordered by --instance-type-ordering, by size unless configured otherwise. Spot placement
+			// scores, if known, order the zones of each instance type without changing the order between instance types.
 			if capacityType == v1alpha1.CapacityTypeSpot {
-				override.Priority = aws.Float64(float64(i))
+				override.Priority = aws.Float64(float64(i) + spotPlacementTieBreaker(scores, offering.Zone))
 			}
 			overrides = append(overrides, override)
 		}
@@ -242,6 +364,16 @@ func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.Inst
 	return overrides
 }
 
+// spotPlacementTieBreaker returns a fraction in [0, 1) that breaks ties between
+// zones for the same instance type, favoring zones with deeper spot capacity
+// pools. Zones without a score are ranked last when scores are known.
+func spotPlacementTieBreaker(scores map[string]int64, zone string) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	return float64(MaxSpotPlacementScore-scores[zone]) / float64(MaxSpotPlacementScore+1)
+}
+
 func (p *InstanceProvider) getInstances(ctx context.Context, ids []*string) ([]*ec2.Instance, error) {
 	describeInstancesOutput, err := p.ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids})
 	if isNotFound(err) {
@@ -325,15 +457,31 @@ func (p *InstanceProvider) updateUnavailableOfferingsCache(ctx context.Context,
 	}
 }
 
-// getCapacityType selects spot if both constraints are flexible and there is an
-// available offering. The AWS Cloud Provider defaults to [ on-demand ], so spot
-// must be explicitly included in capacity type requirements.
+// invalidateLaunchTemplateVersions evicts the cached versions of custom launch templates that CreateFleet couldn't
+// find, so that they're recreated by the next launch
+func (p *InstanceProvider) invalidateLaunchTemplateVersions(ctx context.Context, errors []*ec2.CreateFleetError) {
+	for _, err := range errors {
+		if functional.ContainsString(launchTemplateVersionNotFoundErrorCodes, aws.StringValue(err.ErrorCode)) && err.LaunchTemplateAndOverrides != nil {
+			p.launchTemplateProvider.InvalidateVersion(ctx, aws.StringValue(err.LaunchTemplateAndOverrides.LaunchTemplateSpecification.LaunchTemplateName),
+				aws.StringValue(err.LaunchTemplateAndOverrides.LaunchTemplateSpecification.Version))
+		}
+	}
+}
+
+// getCapacityType selects capacity blocks, then spot, if the constraints are
+// flexible to them and there is an available offering. Capacity blocks are paid
+// for upfront, so they're used before any other capacity. The AWS Cloud Provider
+// defaults to [ on-demand ], so spot and capacity blocks must be explicitly
+// included in capacity type requirements.
 func (p *InstanceProvider) getCapacityType(constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) string {
-	if constraints.Requirements.CapacityTypes().Has(v1alpha1.CapacityTypeSpot) {
+	for _, capacityType := range []string{v1alpha1.CapacityTypeCapacityBlock, v1alpha1.CapacityTypeSpot} {
+		if !constraints.Requirements.CapacityTypes().Has(capacityType) {
+			continue
+		}
 		for _, instanceType := range instanceTypes {
 			for _, offering := range instanceType.Offerings() {
-				if constraints.Requirements.Zones().Has(offering.Zone) && offering.CapacityType == v1alpha1.CapacityTypeSpot {
-					return v1alpha1.CapacityTypeSpot
+				if constraints.Requirements.Zones().Has(offering.Zone) && offering.CapacityType == capacityType {
+					return capacityType
 				}
 			}
 		}
@@ -361,6 +509,9 @@ func combineFleetErrors(errors []*ec2.CreateFleetError) (errs error) {
 }
 
 func getCapacityType(instance *ec2.Instance) string {
+	if aws.StringValue(instance.InstanceLifecycle) == v1alpha1.CapacityTypeCapacityBlock {
+		return v1alpha1.CapacityTypeCapacityBlock
+	}
 	if instance.SpotInstanceRequestId != nil {
 		return v1alpha1.CapacityTypeSpot
 	}
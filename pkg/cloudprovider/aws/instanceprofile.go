@@ -0,0 +1,151 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/patrickmn/go-cache"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+const instanceProfileNameFormat = "Karpenter-%s-%s"
+
+// InstanceProfileProvider manages instance profiles for provisioners that
+// specify a role rather than an instance profile. Each provisioner has its own
+// instance profile, which is deleted once the provisioner and its nodes are gone.
+type InstanceProfileProvider struct {
+	iamapi iamiface.IAMAPI
+	cache  *cache.Cache
+}
+
+func NewInstanceProfileProvider(iamapi iamiface.IAMAPI) *InstanceProfileProvider {
+	return &InstanceProfileProvider{
+		iamapi: iamapi,
+		cache:  cache.New(CacheTTL, CacheCleanupInterval),
+	}
+}
+
+// Get returns the name of the provisioner's instance profile, creating it
+// and attaching the role if necessary. New instance profiles may take a few
+// seconds to become usable by EC2, so launches immediately after creation
+// may fail and be retried.
+func (p *InstanceProfileProvider) Get(ctx context.Context, provisionerName string, role string) (string, error) {
+	name := instanceProfileName(injection.GetOptions(ctx).ClusterName, provisionerName)
+	if cachedRole, ok := p.cache.Get(name); ok && cachedRole.(string) == role {
+		return name, nil
+	}
+	instanceProfile, err := p.ensureInstanceProfile(ctx, name, provisionerName)
+	if err != nil {
+		return "", err
+	}
+	if err := p.ensureRole(ctx, instanceProfile, role); err != nil {
+		return "", err
+	}
+	p.cache.SetDefault(name, role)
+	return name, nil
+}
+
+// Delete removes the provisioner's instance profile, if it exists
+func (p *InstanceProfileProvider) Delete(ctx context.Context, provisionerName string) error {
+	name := instanceProfileName(injection.GetOptions(ctx).ClusterName, provisionerName)
+	p.cache.Delete(name)
+	output, err := p.iamapi.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("getting instance profile %s, %w", name, err)
+	}
+	// Roles must be removed before an instance profile can be deleted
+	for _, role := range output.InstanceProfile.Roles {
+		if _, err := p.iamapi.RemoveRoleFromInstanceProfileWithContext(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+			InstanceProfileName: aws.String(name),
+			RoleName:            role.RoleName,
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("removing role %s from instance profile %s, %w", aws.StringValue(role.RoleName), name, err)
+		}
+	}
+	if _, err := p.iamapi.DeleteInstanceProfileWithContext(ctx, &iam.DeleteInstanceProfileInput{InstanceProfileName: aws.String(name)}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("deleting instance profile %s, %w", name, err)
+	}
+	logging.FromContext(ctx).Infof("Deleted instance profile %s", name)
+	return nil
+}
+
+func (p *InstanceProfileProvider) ensureInstanceProfile(ctx context.Context, name string, provisionerName string) (*iam.InstanceProfile, error) {
+	output, err := p.iamapi.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if err == nil {
+		return output.InstanceProfile, nil
+	}
+	if !isNotFound(err) {
+		return nil, fmt.Errorf("getting instance profile %s, %w", name, err)
+	}
+	clusterName := injection.GetOptions(ctx).ClusterName
+	created, err := p.iamapi.CreateInstanceProfileWithContext(ctx, &iam.CreateInstanceProfileInput{
+		InstanceProfileName: aws.String(name),
+		Tags: []*iam.Tag{
+			{Key: aws.String(v1alpha5.ProvisionerNameLabelKey), Value: aws.String(provisionerName)},
+			{Key: aws.String(fmt.Sprintf("%s/cluster/%s", v1alpha5.Group, clusterName)), Value: aws.String("owned")},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating instance profile %s, %w", name, err)
+	}
+	logging.FromContext(ctx).Infof("Created instance profile %s", name)
+	return created.InstanceProfile, nil
+}
+
+// ensureRole attaches the role to the instance profile, replacing any other
+// role since an instance profile may only contain a single role.
+func (p *InstanceProfileProvider) ensureRole(ctx context.Context, instanceProfile *iam.InstanceProfile, role string) error {
+	for _, attached := range instanceProfile.Roles {
+		if aws.StringValue(attached.RoleName) == role {
+			return nil
+		}
+		if _, err := p.iamapi.RemoveRoleFromInstanceProfileWithContext(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+			InstanceProfileName: instanceProfile.InstanceProfileName,
+			RoleName:            attached.RoleName,
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("removing role %s from instance profile %s, %w", aws.StringValue(attached.RoleName), aws.StringValue(instanceProfile.InstanceProfileName), err)
+		}
+	}
+	if _, err := p.iamapi.AddRoleToInstanceProfileWithContext(ctx, &iam.AddRoleToInstanceProfileInput{
+		InstanceProfileName: instanceProfile.InstanceProfileName,
+		RoleName:            aws.String(role),
+	}); err != nil {
+		return fmt.Errorf("adding role %s to instance profile %s, %w", role, aws.StringValue(instanceProfile.InstanceProfileName), err)
+	}
+	logging.FromContext(ctx).Infof("Added role %s to instance profile %s", role, aws.StringValue(instanceProfile.InstanceProfileName))
+	return nil
+}
+
+// instanceProfileName is derived from the provisioner name, which may be longer
+// than the 128 characters allowed for instance profile names
+func instanceProfileName(clusterName string, provisionerName string) string {
+	hash, err := hashstructure.Hash(provisionerName, hashstructure.FormatV2, nil)
+	if err != nil {
+		panic(fmt.Sprintf("hashing instance profile name, %s", err))
+	}
+	return fmt.Sprintf(instanceProfileNameFormat, clusterName, fmt.Sprint(hash))
+}
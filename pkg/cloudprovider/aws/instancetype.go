@@ -16,6 +16,8 @@ package aws
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/aws/amazon-vpc-resource-controller-k8s/pkg/aws/vpc"
 	"github.com/aws/aws-sdk-go/aws"
@@ -26,23 +28,104 @@ import (
 	"knative.dev/pkg/ptr"
 
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/amifamily"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
-	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
-// EC2VMAvailableMemoryFactor assumes the EC2 VM will consume <7.25% of the memory of a given machine
-const EC2VMAvailableMemoryFactor = .925
+const (
+	mebibyte = 1024 * 1024
+	// ebsVolumeLimitKey is the resource name that the kubelet reports the
+	// attachable EBS volume limit with
+	ebsVolumeLimitKey v1.ResourceName = "attachable-volumes-aws-ebs"
+	// nitroAttachments are shared by the network interfaces, EBS volumes and
+	// NVMe instance store volumes of most Nitro instance types, see
+	// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/volume_limits.html
+	nitroAttachments = 28
+	// xenEBSVolumes are the EBS volumes that Xen instance types can attach
+	xenEBSVolumes = 39
+)
 
 type InstanceType struct {
-	ec2.InstanceTypeInfo
+	*ec2.InstanceTypeInfo
 	AvailableOfferings []cloudprovider.Offering
 	MaxPods            *int32
+	// VMMemoryOverhead is the fraction of memory consumed by the hypervisor and
+	// operating system, which varies by AMI family
+	VMMemoryOverhead float64
+	// EphemeralVolumeSize is the size of the block device that backs the
+	// kubelet's root directory, which varies by AMI family and block device mappings
+	EphemeralVolumeSize *resource.Quantity
+	// resources only depend on the instance type info, so they're computed once
+	// when the instance type is discovered and shared by every provisioner's copy
+	resources *instanceTypeResources
+}
+
+// instanceTypeResources must be treated as immutable, since they're shared
+type instanceTypeResources struct {
+	cpu        resource.Quantity
+	awsPodENI  resource.Quantity
+	nvidiaGPUs resource.Quantity
+	amdGPUs    resource.Quantity
+	awsNeurons resource.Quantity
+	awsEFAs    resource.Quantity
+	overhead   *cloudprovider.InstanceTypeOverhead
+}
+
+func newInstanceType(info *ec2.InstanceTypeInfo) *InstanceType {
+	instanceType := &InstanceType{InstanceTypeInfo: info}
+	instanceType.resources = instanceType.computeResources()
+	return instanceType
+}
+
+func (i *InstanceType) computeResources() *instanceTypeResources {
+	return &instanceTypeResources{
+		cpu:        *resource.NewQuantity(aws.Int64Value(i.VCpuInfo.DefaultVCpus), resource.DecimalSI),
+		awsPodENI:  i.computeAWSPodENI(),
+		nvidiaGPUs: i.computeGPUs("NVIDIA"),
+		amdGPUs:    i.computeGPUs("AMD"),
+		awsNeurons: i.computeAWSNeurons(),
+		awsEFAs:    i.computeAWSEFAs(),
+		overhead:   i.computeOverhead(),
+	}
+}
+
+// computed returns the shared resources, falling back to computing them for
+// instance types that weren't constructed by the InstanceTypeProvider
+func (i *InstanceType) computed() *instanceTypeResources {
+	if i.resources != nil {
+		return i.resources
+	}
+	return i.computeResources()
 }
 
 func (i *InstanceType) Name() string {
 	return aws.StringValue(i.InstanceType)
 }
 
+// Family returns the instance family, e.g. m5 for m5.large
+func (i *InstanceType) Family() string {
+	return strings.SplitN(i.Name(), ".", 2)[0]
+}
+
+// Generation returns the generation of the instance type, which is the number
+// that follows the instance family's letters in its name, e.g. 5 for m5.large
+func (i *InstanceType) Generation() (int, bool) {
+	family := i.Family()
+	start := strings.IndexAny(family, "0123456789")
+	if start < 0 {
+		return 0, false
+	}
+	end := start
+	for end < len(family) && family[end] >= '0' && family[end] <= '9' {
+		end++
+	}
+	generation, err := strconv.Atoi(family[start:end])
+	if err != nil {
+		return 0, false
+	}
+	return generation, true
+}
+
 func (i *InstanceType) Offerings() []cloudprovider.Offering {
 	return i.AvailableOfferings
 }
@@ -61,87 +144,154 @@ func (i *InstanceType) Architecture() string {
 }
 
 func (i *InstanceType) CPU() *resource.Quantity {
-	return resources.Quantity(fmt.Sprint(*i.VCpuInfo.DefaultVCpus))
+	cpu := i.computed().cpu
+	return &cpu
 }
 
+// Memory returns the capacity visible to the kubelet, which excludes the VM memory overhead
 func (i *InstanceType) Memory() *resource.Quantity {
-	return resources.Quantity(
-		fmt.Sprintf("%dMi", int32(
-			float64(*i.MemoryInfo.SizeInMiB)*EC2VMAvailableMemoryFactor,
-		)),
+	vmMemoryOverhead := i.VMMemoryOverhead
+	if vmMemoryOverhead == 0 {
+		vmMemoryOverhead = amifamily.DefaultVMMemoryOverhead
+	}
+	return resource.NewQuantity(
+		int64(int32(float64(*i.MemoryInfo.SizeInMiB)*(1-vmMemoryOverhead)))*mebibyte,
+		resource.BinarySI,
 	)
 }
 
 func (i *InstanceType) Pods() *resource.Quantity {
 	if i.MaxPods != nil {
-		return resources.Quantity(fmt.Sprint(ptr.Int32Value(i.MaxPods)))
+		return resource.NewQuantity(int64(ptr.Int32Value(i.MaxPods)), resource.DecimalSI)
 	}
-	return resources.Quantity(fmt.Sprint(i.eniLimitedPods()))
+	return resource.NewQuantity(i.eniLimitedPods(), resource.DecimalSI)
 }
 
 func (i *InstanceType) AWSPodENI() *resource.Quantity {
-	// https://docs.aws.amazon.com/eks/latest/userguide/security-groups-for-pods.html#supported-instance-types
-	limits, ok := vpc.Limits[aws.StringValue(i.InstanceType)]
-	if ok && limits.IsTrunkingCompatible {
-		return resources.Quantity(fmt.Sprint(limits.BranchInterface))
+	awsPodENI := i.computed().awsPodENI
+	return &awsPodENI
+}
+
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	if i.EphemeralVolumeSize == nil {
+		return amifamily.EphemeralStorage(amifamily.GetAMIFamily(nil, &amifamily.Options{}), nil)
 	}
-	return resources.Quantity("0")
+	ephemeralStorage := i.EphemeralVolumeSize.DeepCopy()
+	return &ephemeralStorage
 }
 
 func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
-	count := int64(0)
-	if i.GpuInfo != nil {
-		for _, gpu := range i.GpuInfo.Gpus {
-			if *i.GpuInfo.Gpus[0].Manufacturer == "NVIDIA" {
-				count += *gpu.Count
+	nvidiaGPUs := i.computed().nvidiaGPUs
+	return &nvidiaGPUs
+}
+
+func (i *InstanceType) AMDGPUs() *resource.Quantity {
+	amdGPUs := i.computed().amdGPUs
+	return &amdGPUs
+}
+
+func (i *InstanceType) AWSNeurons() *resource.Quantity {
+	awsNeurons := i.computed().awsNeurons
+	return &awsNeurons
+}
+
+func (i *InstanceType) AWSEFAs() *resource.Quantity {
+	awsEFAs := i.computed().awsEFAs
+	return &awsEFAs
+}
+
+func (i *InstanceType) Resources() v1.ResourceList {
+	return cloudprovider.KnownResources(i)
+}
+
+// VolumeLimits returns the number of EBS volumes that can be attached besides
+// the root volume. Attachments of Nitro instance types are shared with the
+// primary network interface and instance store volumes.
+func (i *InstanceType) VolumeLimits() v1.ResourceList {
+	limit := int64(xenEBSVolumes)
+	if aws.StringValue(i.Hypervisor) == ec2.InstanceTypeHypervisorNitro {
+		limit = nitroAttachments - 1
+		if i.InstanceStorageInfo != nil {
+			for _, disk := range i.InstanceStorageInfo.Disks {
+				limit -= aws.Int64Value(disk.Count)
 			}
 		}
 	}
-	return resources.Quantity(fmt.Sprint(count))
+	// The root volume is always attached
+	limit--
+	return v1.ResourceList{ebsVolumeLimitKey: *resource.NewQuantity(limit, resource.DecimalSI)}
 }
 
-func (i *InstanceType) AMDGPUs() *resource.Quantity {
+// Overhead returns the shared overhead, which callers must not modify
+func (i *InstanceType) Overhead() *cloudprovider.InstanceTypeOverhead {
+	return i.computed().overhead
+}
+
+func (i *InstanceType) computeAWSPodENI() resource.Quantity {
+	// https://docs.aws.amazon.com/eks/latest/userguide/security-groups-for-pods.html#supported-instance-types
+	limits, ok := vpc.Limits[aws.StringValue(i.InstanceType)]
+	if ok && limits.IsTrunkingCompatible {
+		return *resource.NewQuantity(int64(limits.BranchInterface), resource.DecimalSI)
+	}
+	return *resource.NewQuantity(0, resource.DecimalSI)
+}
+
+func (i *InstanceType) computeGPUs(manufacturer string) resource.Quantity {
 	count := int64(0)
 	if i.GpuInfo != nil {
 		for _, gpu := range i.GpuInfo.Gpus {
-			if *i.GpuInfo.Gpus[0].Manufacturer == "AMD" {
+			if *i.GpuInfo.Gpus[0].Manufacturer == manufacturer {
 				count += *gpu.Count
 			}
 		}
 	}
-	return resources.Quantity(fmt.Sprint(count))
+	return *resource.NewQuantity(count, resource.DecimalSI)
 }
 
-func (i *InstanceType) AWSNeurons() *resource.Quantity {
+func (i *InstanceType) computeAWSNeurons() resource.Quantity {
 	count := int64(0)
 	if i.InferenceAcceleratorInfo != nil {
 		for _, accelerator := range i.InferenceAcceleratorInfo.Accelerators {
 			count += *accelerator.Count
 		}
 	}
-	return resources.Quantity(fmt.Sprint(count))
+	return *resource.NewQuantity(count, resource.DecimalSI)
+}
+
+// computeAWSEFAs returns the maximum number of EFA interfaces, which is one per network card on instance types that
+// support EFA
+func (i *InstanceType) computeAWSEFAs() resource.Quantity {
+	if i.NetworkInfo == nil || !aws.BoolValue(i.NetworkInfo.EfaSupported) || i.NetworkInfo.EfaInfo == nil {
+		return *resource.NewQuantity(0, resource.DecimalSI)
+	}
+	return *resource.NewQuantity(aws.Int64Value(i.NetworkInfo.EfaInfo.MaximumEfaInterfaces), resource.DecimalSI)
 }
 
-// Overhead computes overhead for https://kubernetes.io/docs/tasks/administer-cluster/reserve-compute-resources/#node-allocatable
+// computeOverhead computes overhead for https://kubernetes.io/docs/tasks/administer-cluster/reserve-compute-resources/#node-allocatable
 // using calculations copied from https://github.com/bottlerocket-os/bottlerocket#kubernetes-settings.
 // While this doesn't calculate the correct overhead for non-ENI-limited nodes, we're using this approach until further
 // analysis can be performed
-func (i *InstanceType) Overhead() v1.ResourceList {
-	overhead := v1.ResourceList{
-		v1.ResourceCPU: *resource.NewMilliQuantity(
-			100, // system-reserved
-			resource.DecimalSI),
-		v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dMi",
-			// kube-reserved
-			((11*i.eniLimitedPods())+255)+
-				// system-reserved
-				100+
-				// eviction threshold https://github.com/kubernetes/kubernetes/blob/ea0764452222146c47ec826977f49d7001b0ea8c/pkg/kubelet/apis/config/v1beta1/defaults_linux.go#L23
-				100,
-		)),
-	}
-	// kube-reserved Computed from
-	// https://github.com/bottlerocket-os/bottlerocket/pull/1388/files#diff-bba9e4e3e46203be2b12f22e0d654ebd270f0b478dd34f40c31d7aa695620f2fR611
+func (i *InstanceType) computeOverhead() *cloudprovider.InstanceTypeOverhead {
+	return &cloudprovider.InstanceTypeOverhead{
+		KubeReserved: v1.ResourceList{
+			v1.ResourceCPU:    i.kubeReservedCPU(),
+			v1.ResourceMemory: *resource.NewQuantity(((11*i.eniLimitedPods())+255)*mebibyte, resource.BinarySI),
+		},
+		SystemReserved: v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewMilliQuantity(100, resource.DecimalSI),
+			v1.ResourceMemory: resource.MustParse("100Mi"),
+		},
+		// https://github.com/kubernetes/kubernetes/blob/ea0764452222146c47ec826977f49d7001b0ea8c/pkg/kubelet/apis/config/v1beta1/defaults_linux.go#L23
+		EvictionThreshold: v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse("100Mi"),
+		},
+	}
+}
+
+// kubeReservedCPU computed from
+// https://github.com/bottlerocket-os/bottlerocket/pull/1388/files#diff-bba9e4e3e46203be2b12f22e0d654ebd270f0b478dd34f40c31d7aa695620f2fR611
+func (i *InstanceType) kubeReservedCPU() resource.Quantity {
+	reserved := resource.NewMilliQuantity(0, resource.DecimalSI)
 	for _, cpuRange := range []struct {
 		start      int64
 		end        int64
@@ -152,17 +302,15 @@ func (i *InstanceType) Overhead() v1.ResourceList {
 		{start: 2000, end: 4000, percentage: 0.005},
 		{start: 4000, end: 1 << 31, percentage: 0.0025},
 	} {
-		if cpu := i.CPU().MilliValue(); cpu >= cpuRange.start {
+		if cpu := aws.Int64Value(i.VCpuInfo.DefaultVCpus) * 1000; cpu >= cpuRange.start {
 			r := float64(cpuRange.end - cpuRange.start)
 			if cpu < cpuRange.end {
 				r = float64(cpu - cpuRange.start)
 			}
-			cpuOverhead := overhead[v1.ResourceCPU]
-			cpuOverhead.Add(*resource.NewMilliQuantity(int64(r*cpuRange.percentage), resource.DecimalSI))
-			overhead[v1.ResourceCPU] = cpuOverhead
+			reserved.Add(*resource.NewMilliQuantity(int64(r*cpuRange.percentage), resource.DecimalSI))
 		}
 	}
-	return overhead
+	return *reserved
 }
 
 // The number of pods per node is calculated using the formula:
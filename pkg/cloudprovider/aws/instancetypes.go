@@ -23,11 +23,13 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/patrickmn/go-cache"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/ptr"
 
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/amifamily"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
@@ -42,8 +44,9 @@ const (
 )
 
 type InstanceTypeProvider struct {
-	ec2api         ec2iface.EC2API
-	subnetProvider *SubnetProvider
+	ec2api                ec2iface.EC2API
+	subnetProvider        *SubnetProvider
+	capacityBlockProvider *CapacityBlockProvider
 	// Has two entries: one for all the instance types and one for all zones; values cached *before* considering insufficient capacity errors
 	// from the unavailableOfferings cache
 	cache *cache.Cache
@@ -51,12 +54,13 @@ type InstanceTypeProvider struct {
 	unavailableOfferings *cache.Cache
 }
 
-func NewInstanceTypeProvider(ec2api ec2iface.EC2API, subnetProvider *SubnetProvider) *InstanceTypeProvider {
+func NewInstanceTypeProvider(ec2api ec2iface.EC2API, subnetProvider *SubnetProvider, capacityBlockProvider *CapacityBlockProvider, ttl time.Duration) *InstanceTypeProvider {
 	return &InstanceTypeProvider{
-		ec2api:               ec2api,
-		subnetProvider:       subnetProvider,
-		cache:                cache.New(InstanceTypesAndZonesCacheTTL, CacheCleanupInterval),
-		unavailableOfferings: cache.New(InsufficientCapacityErrorCacheTTL, InsufficientCapacityErrorCacheCleanupInterval),
+		ec2api:                ec2api,
+		subnetProvider:        subnetProvider,
+		capacityBlockProvider: capacityBlockProvider,
+		cache:                 cache.New(ttl, CacheCleanupInterval),
+		unavailableOfferings:  cache.New(InsufficientCapacityErrorCacheTTL, InsufficientCapacityErrorCacheCleanupInterval),
 	}
 }
 
@@ -81,25 +85,62 @@ func (p *InstanceTypeProvider) Get(ctx context.Context, provider *v1alpha1.AWS)
 	if err != nil {
 		return nil, err
 	}
+	// Get zones with capacity blocks that can be launched into now
+	capacityBlocks, err := p.capacityBlockProvider.Get(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	capacityBlockZones := map[string]sets.String{}
+	for _, capacityBlock := range capacityBlocks {
+		if !capacityBlock.Active(time.Now()) {
+			continue
+		}
+		if _, ok := capacityBlockZones[capacityBlock.InstanceType]; !ok {
+			capacityBlockZones[capacityBlock.InstanceType] = sets.NewString()
+		}
+		capacityBlockZones[capacityBlock.InstanceType].Insert(capacityBlock.Zone)
+	}
+	amiFamily := amifamily.GetAMIFamily(provider.AMIFamily, &amifamily.Options{})
+	vmMemoryOverhead := amiFamily.VMMemoryOverhead()
+	ephemeralVolumeSize := amifamily.EphemeralStorage(amiFamily, provider.BlockDeviceMappings)
+	if ptr.BoolValue(provider.AutoSizeEphemeralStorage) {
+		// The block device is grown to fit the pods at launch
+		ephemeralVolumeSize = resource.NewQuantity(amifamily.MaxEphemeralStorageGiBs*1024*mebibyte, resource.BinarySI)
+	}
 	result := []cloudprovider.InstanceType{}
-	for _, instanceType := range instanceTypes {
-		offerings := p.createOfferings(instanceType, subnetZones, instanceTypeZones[instanceType.Name()])
-		if len(offerings) > 0 {
-			instanceType.AvailableOfferings = offerings
-			result = append(result, instanceType)
+	for _, cached := range instanceTypes {
+		// Only instance types that are offered on Dedicated Hosts run on single-tenant hardware
+		if v1alpha1.TenancyOrDefault(provider.Tenancy) != ec2.TenancyDefault && !aws.BoolValue(cached.DedicatedHostsSupported) {
+			continue
 		}
+		// Copy the cached instance type, since the fields below vary by provisioner. The copy is shallow, so the
+		// instance type info and its computed resources are shared rather than duplicated for every provisioner.
+		instanceType := *cached
+		instanceType.VMMemoryOverhead = vmMemoryOverhead
+		instanceType.EphemeralVolumeSize = ephemeralVolumeSize
 		if !injection.GetOptions(ctx).AWSENILimitedPodDensity {
 			instanceType.MaxPods = ptr.Int32(110)
 		}
+		offerings := p.createOfferings(&instanceType, subnetZones, instanceTypeZones[instanceType.Name()], capacityBlockZones[instanceType.Name()])
+		if len(offerings) > 0 {
+			instanceType.AvailableOfferings = offerings
+			result = append(result, &instanceType)
+		}
 	}
 	return result, nil
 }
 
-func (p *InstanceTypeProvider) createOfferings(instanceType *InstanceType, subnetZones sets.String, availableZones sets.String) []cloudprovider.Offering {
+func (p *InstanceTypeProvider) createOfferings(instanceType *InstanceType, subnetZones sets.String, availableZones sets.String, capacityBlockZones sets.String) []cloudprovider.Offering {
 	offerings := []cloudprovider.Offering{}
+	// while usage classes should be a distinct set, there's no guarantee of that
+	capacityTypes := sets.NewString(aws.StringValueSlice(instanceType.SupportedUsageClasses)...)
 	for zone := range subnetZones.Intersection(availableZones) {
-		// while usage classes should be a distinct set, there's no guarantee of that
-		for capacityType := range sets.NewString(aws.StringValueSlice(instanceType.SupportedUsageClasses)...) {
+		zoneCapacityTypes := capacityTypes
+		// capacity blocks are only offered in their zone, and only while instances can be launched into them
+		if capacityBlockZones.Has(zone) {
+			zoneCapacityTypes = capacityTypes.Union(sets.NewString(v1alpha1.CapacityTypeCapacityBlock))
+		}
+		for capacityType := range zoneCapacityTypes {
 			// exclude any offerings that have recently seen an insufficient capacity error from EC2
 			if _, isUnavailable := p.unavailableOfferings.Get(UnavailableOfferingsCacheKey(capacityType, instanceType.Name(), zone)); !isUnavailable {
 				offerings = append(offerings, cloudprovider.Offering{Zone: zone, CapacityType: capacityType})
@@ -147,7 +188,7 @@ func (p *InstanceTypeProvider) getInstanceTypes(ctx context.Context) (map[string
 	}, func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
 		for _, instanceType := range page.InstanceTypes {
 			if p.filter(instanceType) {
-				instanceTypes[aws.StringValue(instanceType.InstanceType)] = &InstanceType{InstanceTypeInfo: *instanceType}
+				instanceTypes[aws.StringValue(instanceType.InstanceType)] = newInstanceType(instanceType)
 			}
 		}
 		return true
@@ -43,34 +43,52 @@ import (
 const (
 	launchTemplateNameFormat  = "Karpenter-%s-%s"
 	kubernetesVersionCacheKey = "kubernetesVersion"
+	// launchTemplateVersionDescriptionFormat identifies the versions of custom launch templates that Karpenter
+	// created, by the default version they're based on and the hash of the fields that Karpenter manages
+	launchTemplateVersionDescriptionFormat = "Karpenter-%d-%d"
+	latestVersion                          = "$Latest"
 )
 
+// launchTemplateRef is a version of a launch template that instances are launched from
+type launchTemplateRef struct {
+	Name    string
+	Version string
+}
+
 type LaunchTemplateProvider struct {
 	sync.Mutex
-	ec2api                ec2iface.EC2API
-	clientSet             *kubernetes.Clientset
-	amiFamily             *amifamily.Resolver
-	securityGroupProvider *SecurityGroupProvider
-	cache                 *cache.Cache
-	logger                *zap.SugaredLogger
-	caBundle              *string
+	ec2api                  ec2iface.EC2API
+	clientSet               *kubernetes.Clientset
+	amiFamily               *amifamily.Resolver
+	securityGroupProvider   *SecurityGroupProvider
+	cache                   *cache.Cache
+	versionCache            *cache.Cache
+	logger                  *zap.SugaredLogger
+	clusterProvider         *ClusterProvider
+	instanceProfileProvider *InstanceProfileProvider
 }
 
-func NewLaunchTemplateProvider(ctx context.Context, ec2api ec2iface.EC2API, clientSet *kubernetes.Clientset, amiFamily *amifamily.Resolver, securityGroupProvider *SecurityGroupProvider, caBundle *string) *LaunchTemplateProvider {
+func NewLaunchTemplateProvider(ctx context.Context, ec2api ec2iface.EC2API, clientSet *kubernetes.Clientset, amiFamily *amifamily.Resolver, securityGroupProvider *SecurityGroupProvider, clusterProvider *ClusterProvider, instanceProfileProvider *InstanceProfileProvider) *LaunchTemplateProvider {
 	l := &LaunchTemplateProvider{
-		ec2api:                ec2api,
-		clientSet:             clientSet,
-		logger:                logging.FromContext(ctx).Named("launchtemplate"),
-		amiFamily:             amiFamily,
-		securityGroupProvider: securityGroupProvider,
-		cache:                 cache.New(CacheTTL, CacheCleanupInterval),
-		caBundle:              caBundle,
+		ec2api:                  ec2api,
+		clientSet:               clientSet,
+		logger:                  logging.FromContext(ctx).Named("launchtemplate"),
+		amiFamily:               amiFamily,
+		securityGroupProvider:   securityGroupProvider,
+		cache:                   cache.New(CacheTTL, CacheCleanupInterval),
+		versionCache:            cache.New(CacheTTL, CacheCleanupInterval),
+		clusterProvider:         clusterProvider,
+		instanceProfileProvider: instanceProfileProvider,
 	}
 	l.cache.OnEvicted(l.onCacheEvicted)
 	l.hydrateCache(ctx)
 	return l
 }
 
+// launchTemplateName returns a name that is stable for equivalent launch templates. Every node is launched from a
+// template with this name, so any change to the hash options renames every existing template. Fields that are added
+// to the hashed structs must be excluded while they're unset, see amifamily.Options.HashInclude. User data rendering
+// is covered by golden files in the amifamily package.
 func launchTemplateName(options *amifamily.LaunchTemplate) string {
 	hash, err := hashstructure.Hash(options, hashstructure.FormatV2, nil)
 	if err != nil {
@@ -79,50 +97,182 @@ func launchTemplateName(options *amifamily.LaunchTemplate) string {
 	return fmt.Sprintf(launchTemplateNameFormat, options.ClusterName, fmt.Sprint(hash))
 }
 
-func (p *LaunchTemplateProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, additionalLabels map[string]string) (map[string][]cloudprovider.InstanceType, error) {
+// Get launch templates for the instance types, keyed by name and version. If a capacity reservation ID is provided,
+// the launch templates launch instances into that capacity block.
+func (p *LaunchTemplateProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, additionalLabels map[string]string, capacityReservationID string) (map[launchTemplateRef][]cloudprovider.InstanceType, error) {
 	// If Launch Template is directly specified then just use it
-	if constraints.LaunchTemplateName != nil {
-		return map[string][]cloudprovider.InstanceType{ptr.StringValue(constraints.LaunchTemplateName): instanceTypes}, nil
-	}
-	instanceProfile, err := p.getInstanceProfile(ctx, constraints)
-	if err != nil {
-		return nil, err
+	if constraints.LaunchTemplateName != nil && len(constraints.LaunchTemplateManagedFields) == 0 {
+		return map[launchTemplateRef][]cloudprovider.InstanceType{{Name: ptr.StringValue(constraints.LaunchTemplateName), Version: latestVersion}: instanceTypes}, nil
 	}
-	// Get constrained security groups
-	securityGroupsIDs, err := p.securityGroupProvider.Get(ctx, constraints)
-	if err != nil {
-		return nil, err
+	// The instance profile and security groups of custom launch templates are never managed
+	var instanceProfile string
+	var securityGroupsIDs []string
+	if constraints.LaunchTemplateName == nil {
+		var err error
+		if instanceProfile, err = p.getInstanceProfile(ctx, constraints); err != nil {
+			return nil, err
+		}
+		// Get constrained security groups
+		if securityGroupsIDs, err = p.securityGroupProvider.Get(ctx, constraints); err != nil {
+			return nil, err
+		}
 	}
 	kubeServerVersion, err := p.kubeServerVersion(ctx)
 	if err != nil {
 		return nil, err
 	}
-	resolvedLaunchTemplates, err := p.amiFamily.Resolve(ctx, constraints, instanceTypes, &amifamily.Options{
-		ClusterName:             injection.GetOptions(ctx).ClusterName,
-		ClusterEndpoint:         injection.GetOptions(ctx).ClusterEndpoint,
-		AWSENILimitedPodDensity: injection.GetOptions(ctx).AWSENILimitedPodDensity,
-		InstanceProfile:         instanceProfile,
-		SecurityGroupsIDs:       securityGroupsIDs,
-		Tags:                    constraints.Tags,
-		Labels:                  functional.UnionStringMaps(constraints.Labels, additionalLabels),
-		CABundle:                p.caBundle,
-		KubernetesVersion:       kubeServerVersion,
-	})
+	// The CA bundle is part of the user data, so a rotated CA results in new launch templates
+	cluster, err := p.clusterProvider.Get(ctx)
 	if err != nil {
 		return nil, err
 	}
-	launchTemplates := map[string][]cloudprovider.InstanceType{}
+	var resolvedLaunchTemplates []*amifamily.LaunchTemplate
+	for efaCount, instanceTypes := range efaCounts(constraints, instanceTypes) {
+		resolved, err := p.amiFamily.Resolve(ctx, constraints, instanceTypes, &amifamily.Options{
+			ClusterName:             injection.GetOptions(ctx).ClusterName,
+			ClusterEndpoint:         cluster.Endpoint,
+			AWSENILimitedPodDensity: injection.GetOptions(ctx).AWSENILimitedPodDensity,
+			InstanceProfile:         instanceProfile,
+			SecurityGroupsIDs:       securityGroupsIDs,
+			Tags:                    constraints.Tags,
+			Labels:                  functional.UnionStringMaps(constraints.Labels, additionalLabels),
+			CABundle:                cluster.CABundle,
+			KubernetesVersion:       kubeServerVersion,
+			CapacityReservationID:   capacityReservationID,
+			Tenancy:                 tenancy(constraints),
+			EFACount:                efaCount,
+		})
+		if err != nil {
+			return nil, err
+		}
+		resolvedLaunchTemplates = append(resolvedLaunchTemplates, resolved...)
+	}
+	launchTemplates := map[launchTemplateRef][]cloudprovider.InstanceType{}
 	for _, resolvedLaunchTemplate := range resolvedLaunchTemplates {
+		if constraints.LaunchTemplateName != nil {
+			version, err := p.ensureLaunchTemplateVersion(ctx, constraints, resolvedLaunchTemplate)
+			if err != nil {
+				return nil, err
+			}
+			launchTemplates[launchTemplateRef{Name: *constraints.LaunchTemplateName, Version: version}] = resolvedLaunchTemplate.InstanceTypes
+			continue
+		}
 		// Ensure the launch template exists, or create it
 		ec2LaunchTemplate, err := p.ensureLaunchTemplate(ctx, resolvedLaunchTemplate)
 		if err != nil {
 			return nil, err
 		}
-		launchTemplates[*ec2LaunchTemplate.LaunchTemplateName] = resolvedLaunchTemplate.InstanceTypes
+		launchTemplates[launchTemplateRef{Name: *ec2LaunchTemplate.LaunchTemplateName, Version: latestVersion}] = resolvedLaunchTemplate.InstanceTypes
 	}
 	return launchTemplates, nil
 }
 
+// ensureLaunchTemplateVersion returns the version of the custom launch template with the fields that Karpenter manages
+// replaced by the resolved launch template, creating it from the launch template's default version if it doesn't
+// exist. Versions are immutable, so they're reused until the default version changes.
+func (p *LaunchTemplateProvider) ensureLaunchTemplateVersion(ctx context.Context, constraints *v1alpha1.Constraints, options *amifamily.LaunchTemplate) (string, error) {
+	// Ensure that multiple threads don't attempt to create the same version
+	p.Lock()
+	defer p.Unlock()
+
+	name := aws.StringValue(constraints.LaunchTemplateName)
+	defaultVersion, err := p.defaultVersion(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	data := p.managedLaunchTemplateData(constraints, options)
+	hash, err := hashstructure.Hash(data, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true, IgnoreZeroValue: true})
+	if err != nil {
+		return "", fmt.Errorf("hashing launch template data, %w", err)
+	}
+	description := fmt.Sprintf(launchTemplateVersionDescriptionFormat, defaultVersion, hash)
+	key := fmt.Sprintf("%s/%s", name, description)
+	if version, ok := p.versionCache.Get(key); ok {
+		return version.(string), nil
+	}
+	// Attempt to find a version that was created before a restart
+	version := ""
+	if err := p.ec2api.DescribeLaunchTemplateVersionsPagesWithContext(ctx, &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateName: aws.String(name),
+	}, func(output *ec2.DescribeLaunchTemplateVersionsOutput, _ bool) bool {
+		for _, launchTemplateVersion := range output.LaunchTemplateVersions {
+			if aws.StringValue(launchTemplateVersion.VersionDescription) == description {
+				version = fmt.Sprint(aws.Int64Value(launchTemplateVersion.VersionNumber))
+				return false
+			}
+		}
+		return true
+	}); err != nil {
+		return "", fmt.Errorf("describing launch template versions, %w", err)
+	}
+	if version == "" {
+		output, err := p.ec2api.CreateLaunchTemplateVersionWithContext(ctx, &ec2.CreateLaunchTemplateVersionInput{
+			LaunchTemplateName: aws.String(name),
+			SourceVersion:      aws.String(fmt.Sprint(defaultVersion)),
+			VersionDescription: aws.String(description),
+			LaunchTemplateData: data,
+		})
+		if err != nil {
+			return "", fmt.Errorf("creating launch template version, %w", err)
+		}
+		version = fmt.Sprint(aws.Int64Value(output.LaunchTemplateVersion.VersionNumber))
+		logging.FromContext(ctx).Debugf("Created version %s of launch template %s", version, name)
+	}
+	p.versionCache.Set(key, version, cache.NoExpiration)
+	return version, nil
+}
+
+// InvalidateVersion evicts a version of the custom launch template that no longer exists, e.g. because it was
+// deleted, so that it's found or created again by the next launch. The default version is evicted with it, since
+// it may have changed too.
+func (p *LaunchTemplateProvider) InvalidateVersion(ctx context.Context, name string, version string) {
+	p.Lock()
+	defer p.Unlock()
+	for key, item := range p.versionCache.Items() {
+		if strings.HasPrefix(key, name+"/") && item.Object == version {
+			p.versionCache.Delete(key)
+			p.versionCache.Delete(name)
+			logging.FromContext(ctx).Debugf("Invalidated version %s of launch template %s", version, name)
+		}
+	}
+}
+
+// defaultVersion returns the default version number of the custom launch template, which is cached so that changes
+// to the default version are picked up
+func (p *LaunchTemplateProvider) defaultVersion(ctx context.Context, name string) (int64, error) {
+	if version, ok := p.versionCache.Get(name); ok {
+		return version.(int64), nil
+	}
+	output, err := p.ec2api.DescribeLaunchTemplatesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("describing launch template %s, %w", name, err)
+	}
+	if len(output.LaunchTemplates) != 1 {
+		return 0, fmt.Errorf("expected to find one launch template, but found %d", len(output.LaunchTemplates))
+	}
+	version := aws.Int64Value(output.LaunchTemplates[0].DefaultVersionNumber)
+	p.versionCache.SetDefault(name, version)
+	return version, nil
+}
+
+// managedLaunchTemplateData returns the fields of the resolved launch template that Karpenter manages for the custom
+// launch template
+func (p *LaunchTemplateProvider) managedLaunchTemplateData(constraints *v1alpha1.Constraints, options *amifamily.LaunchTemplate) *ec2.RequestLaunchTemplateData {
+	data := &ec2.RequestLaunchTemplateData{}
+	if constraints.ManagesLaunchTemplateField(v1alpha1.LaunchTemplateFieldAMI) {
+		data.ImageId = aws.String(options.AMIID)
+	}
+	if constraints.ManagesLaunchTemplateField(v1alpha1.LaunchTemplateFieldUserData) {
+		data.UserData = aws.String(options.UserData.Script())
+	}
+	if constraints.ManagesLaunchTemplateField(v1alpha1.LaunchTemplateFieldBlockDeviceMappings) {
+		data.BlockDeviceMappings = p.blockDeviceMappings(options.BlockDeviceMappings)
+	}
+	return data
+}
+
 func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, options *amifamily.LaunchTemplate) (*ec2.LaunchTemplate, error) {
 	// Ensure that multiple threads don't attempt to create the same launch template
 	p.Lock()
@@ -158,7 +308,7 @@ func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, optio
 }
 
 func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, options *amifamily.LaunchTemplate) (*ec2.LaunchTemplate, error) {
-	output, err := p.ec2api.CreateLaunchTemplateWithContext(ctx, &ec2.CreateLaunchTemplateInput{
+	input := &ec2.CreateLaunchTemplateInput{
 		LaunchTemplateName: aws.String(launchTemplateName(options)),
 		LaunchTemplateData: &ec2.RequestLaunchTemplateData{
 			BlockDeviceMappings: p.blockDeviceMappings(options.BlockDeviceMappings),
@@ -179,7 +329,24 @@ func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, optio
 			ResourceType: aws.String(ec2.ResourceTypeLaunchTemplate),
 			Tags:         v1alpha1.MergeTags(ctx, options.Tags),
 		}},
-	})
+	}
+	if options.CapacityReservationID != "" {
+		input.LaunchTemplateData.InstanceMarketOptions = &ec2.LaunchTemplateInstanceMarketOptionsRequest{
+			MarketType: aws.String(v1alpha1.CapacityTypeCapacityBlock),
+		}
+		input.LaunchTemplateData.CapacityReservationSpecification = &ec2.LaunchTemplateCapacityReservationSpecificationRequest{
+			CapacityReservationTarget: &ec2.CapacityReservationTarget{CapacityReservationId: aws.String(options.CapacityReservationID)},
+		}
+	}
+	if options.Tenancy != "" {
+		input.LaunchTemplateData.Placement = &ec2.LaunchTemplatePlacementRequest{Tenancy: aws.String(options.Tenancy)}
+	}
+	if options.EFACount > 0 {
+		// Security groups are specified by each network interface instead
+		input.LaunchTemplateData.SecurityGroupIds = nil
+		input.LaunchTemplateData.NetworkInterfaces = efaNetworkInterfaces(options)
+	}
+	output, err := p.ec2api.CreateLaunchTemplateWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -187,6 +354,50 @@ func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, optio
 	return output.LaunchTemplate, nil
 }
 
+// efaCounts groups the instance types by the number of EFA interfaces that are
+// attached to them, which is zero for all instance types unless EFA is enabled
+func efaCounts(constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) map[int64][]cloudprovider.InstanceType {
+	if !ptr.BoolValue(constraints.EFA) {
+		return map[int64][]cloudprovider.InstanceType{0: instanceTypes}
+	}
+	efaCounts := map[int64][]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		efaCount := instanceType.AWSEFAs().Value()
+		efaCounts[efaCount] = append(efaCounts[efaCount], instanceType)
+	}
+	return efaCounts
+}
+
+// efaNetworkInterfaces attaches an EFA interface to each network card. Only the
+// interface on the first network card is the primary interface, and the subnet
+// of every interface is the subnet that CreateFleet launches the instance into.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/efa-start.html
+func efaNetworkInterfaces(options *amifamily.LaunchTemplate) []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest {
+	networkInterfaces := []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{}
+	for i := int64(0); i < options.EFACount; i++ {
+		deviceIndex := int64(1)
+		if i == 0 {
+			deviceIndex = 0
+		}
+		networkInterfaces = append(networkInterfaces, &ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			NetworkCardIndex: aws.Int64(i),
+			DeviceIndex:      aws.Int64(deviceIndex),
+			InterfaceType:    aws.String(ec2.NetworkInterfaceTypeEfa),
+			Groups:           aws.StringSlice(options.SecurityGroupsIDs),
+		})
+	}
+	return networkInterfaces
+}
+
+// tenancy returns the tenancy of the instances if it isn't the default, so that
+// launch templates for the default tenancy are unchanged
+func tenancy(constraints *v1alpha1.Constraints) string {
+	if t := v1alpha1.TenancyOrDefault(constraints.Tenancy); t != ec2.TenancyDefault {
+		return t
+	}
+	return ""
+}
+
 func (p *LaunchTemplateProvider) blockDeviceMappings(blockDeviceMappings []*v1alpha1.BlockDeviceMapping) []*ec2.LaunchTemplateBlockDeviceMappingRequest {
 	blockDeviceMappingsRequest := []*ec2.LaunchTemplateBlockDeviceMappingRequest{}
 	for _, blockDeviceMapping := range blockDeviceMappings {
@@ -245,13 +456,35 @@ func (p *LaunchTemplateProvider) getInstanceProfile(ctx context.Context, constra
 	if constraints.InstanceProfile != nil {
 		return aws.StringValue(constraints.InstanceProfile), nil
 	}
+	if constraints.Role != nil {
+		return p.instanceProfileProvider.Get(ctx, injection.GetNamespacedName(ctx).Name, aws.StringValue(constraints.Role))
+	}
 	defaultProfile := injection.GetOptions(ctx).AWSDefaultInstanceProfile
 	if defaultProfile == "" {
-		return "", errors.New("neither spec.provider.instanceProfile nor --aws-default-instance-profile is specified")
+		return "", errors.New("neither spec.provider.instanceProfile, spec.provider.role, nor --aws-default-instance-profile is specified")
 	}
 	return defaultProfile, nil
 }
 
+// warm fills the caches of the security groups, Kubernetes version, cluster,
+// and AMIs that the launch templates of the instance types depend on
+func (p *LaunchTemplateProvider) warm(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) error {
+	if constraints.LaunchTemplateName != nil {
+		return nil
+	}
+	if _, err := p.securityGroupProvider.Get(ctx, constraints); err != nil {
+		return fmt.Errorf("getting security groups, %w", err)
+	}
+	kubeServerVersion, err := p.kubeServerVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("getting kubernetes version, %w", err)
+	}
+	if _, err := p.clusterProvider.Get(ctx); err != nil {
+		return fmt.Errorf("getting cluster, %w", err)
+	}
+	return p.amiFamily.Verify(ctx, constraints, instanceTypes, kubeServerVersion)
+}
+
 func (p *LaunchTemplateProvider) kubeServerVersion(ctx context.Context) (string, error) {
 	if version, ok := p.cache.Get(kubernetesVersionCacheKey); ok {
 		return version.(string), nil
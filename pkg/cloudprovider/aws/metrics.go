@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter/pkg/metrics"
+)
+
+const (
+	metricLabelService   = "service"
+	metricLabelOperation = "operation"
+)
+
+var (
+	apiDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider_aws",
+			Name:      "api_duration_seconds",
+			Help:      "Duration of AWS API calls in seconds, including retries. Broken down by service and operation.",
+			Buckets:   metrics.DurationBuckets(),
+		},
+		[]string{metricLabelService, metricLabelOperation},
+	)
+	apiCallsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider_aws",
+			Name:      "api_calls_total",
+			Help:      "Number of AWS API calls. Broken down by service, operation, and the error code of failed calls, which is empty for calls that succeeded.",
+		},
+		[]string{metricLabelService, metricLabelOperation, metrics.ErrorLabel},
+	)
+	apiThrottlesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider_aws",
+			Name:      "api_throttles_total",
+			Help:      "Number of attempts of AWS API calls that were throttled, including attempts that were retried. Broken down by service and operation.",
+		},
+		[]string{metricLabelService, metricLabelOperation},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(apiDurationHistogram, apiCallsCounter, apiThrottlesCounter)
+}
+
+// withMetrics instruments every API call made with the session, so that
+// throttling and errors can be alerted on without the SDK's debug logging
+func withMetrics(sess *session.Session) *session.Session {
+	sess.Handlers.Retry.PushFront(observeAPIAttempt)
+	sess.Handlers.Complete.PushBack(observeAPICall)
+	return sess
+}
+
+// observeAPIAttempt is called after each failed attempt of an API call, before
+// it's retried
+func observeAPIAttempt(r *request.Request) {
+	if r.IsErrorThrottle() {
+		apiThrottlesCounter.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name).Inc()
+	}
+}
+
+// observeAPICall is called once an API call completes, after any retries
+func observeAPICall(r *request.Request) {
+	apiDurationHistogram.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name).Observe(time.Since(r.Time).Seconds())
+	apiCallsCounter.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name, errorCode(r.Error)).Inc()
+}
+
+// errorCode returns the AWS error code of the error, or an empty string if the
+// call succeeded
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return "Unknown"
+}
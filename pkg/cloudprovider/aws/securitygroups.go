@@ -17,6 +17,7 @@ package aws
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -33,10 +34,10 @@ type SecurityGroupProvider struct {
 	cache  *cache.Cache
 }
 
-func NewSecurityGroupProvider(ec2api ec2iface.EC2API) *SecurityGroupProvider {
+func NewSecurityGroupProvider(ec2api ec2iface.EC2API, ttl time.Duration) *SecurityGroupProvider {
 	return &SecurityGroupProvider{
 		ec2api: ec2api,
-		cache:  cache.New(CacheTTL, CacheCleanupInterval),
+		cache:  cache.New(ttl, CacheCleanupInterval),
 	}
 }
 
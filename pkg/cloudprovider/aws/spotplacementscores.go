@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/patrickmn/go-cache"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// SpotPlacementScoresRefreshInterval is intentionally long, since EC2 limits
+	// the number of distinct configurations that may be scored in a 24 hour period.
+	SpotPlacementScoresRefreshInterval = 1 * time.Hour
+	// SpotPlacementScoresCacheTTL keeps scores across a failed refresh
+	SpotPlacementScoresCacheTTL = 3 * SpotPlacementScoresRefreshInterval
+	// SpotPlacementScoresRequestTTL is how long the scores of a set of instance
+	// types are refreshed after they were last requested
+	SpotPlacementScoresRequestTTL = 24 * time.Hour
+	// MaxSpotPlacementScore is the highest score returned by EC2
+	MaxSpotPlacementScore = 10
+	zoneIDsCacheKey       = "zone-ids"
+)
+
+// SpotPlacementScoreProvider fetches EC2 Spot placement scores for sets of
+// instance types in the background. Scores range from 1 to 10 and indicate how
+// likely a Spot request is to succeed in each availability zone. They're only
+// used to break ties between the zones of an instance type in CreateFleet's
+// priorities, and never change the order between instance types.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/spot-placement-score.html
+type SpotPlacementScoreProvider struct {
+	ec2api ec2iface.EC2API
+	region string
+	cache  *cache.Cache
+	// requested are the sorted instance types of each requested set, keyed like the cache
+	requested *cache.Cache
+	// wake triggers fetching the scores of newly requested sets
+	wake chan struct{}
+}
+
+// NewSpotPlacementScoreProvider returns a provider that refreshes scores in the
+// region until the context is done
+func NewSpotPlacementScoreProvider(ctx context.Context, ec2api ec2iface.EC2API, region string) *SpotPlacementScoreProvider {
+	p := &SpotPlacementScoreProvider{
+		ec2api:    ec2api,
+		region:    region,
+		cache:     cache.New(SpotPlacementScoresCacheTTL, CacheCleanupInterval),
+		requested: cache.New(SpotPlacementScoresRequestTTL, CacheCleanupInterval),
+		wake:      make(chan struct{}, 1),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// Get returns Spot placement scores keyed by zone name for the instance types,
+// without waiting on the API. Scores that aren't known yet are fetched in the
+// background, and Get returns no scores until they are, so that callers fall
+// back to their default behavior.
+func (p *SpotPlacementScoreProvider) Get(ctx context.Context, instanceTypes []string) map[string]int64 {
+	sorted := append([]string{}, instanceTypes...)
+	sort.Strings(sorted)
+	key := strings.Join(sorted, ",")
+	p.requested.SetDefault(key, sorted)
+	if scores, ok := p.cache.Get(key); ok {
+		return scores.(map[string]int64)
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (p *SpotPlacementScoreProvider) run(ctx context.Context) {
+	ticker := time.NewTicker(SpotPlacementScoresRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Refresh(ctx, true)
+		case <-p.wake:
+			p.Refresh(ctx, false)
+		}
+	}
+}
+
+// Refresh fetches the scores of the requested sets of instance types, or only
+// of those without scores unless all is set. If a fetch fails, the previous
+// scores are kept until they expire; sets without scores are cached as having
+// none, so the API isn't retried until the next refresh.
+func (p *SpotPlacementScoreProvider) Refresh(ctx context.Context, all bool) {
+	for key, item := range p.requested.Items() {
+		_, ok := p.cache.Get(key)
+		if ok && !all {
+			continue
+		}
+		scores, err := p.getScores(ctx, item.Object.([]string))
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Getting spot placement scores, %s", err)
+			if ok {
+				continue
+			}
+			scores = map[string]int64{}
+		}
+		p.cache.SetDefault(key, scores)
+	}
+}
+
+func (p *SpotPlacementScoreProvider) getScores(ctx context.Context, instanceTypes []string) (map[string]int64, error) {
+	zoneNames, err := p.getZoneNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scores := map[string]int64{}
+	if err := p.ec2api.GetSpotPlacementScoresPagesWithContext(ctx, &ec2.GetSpotPlacementScoresInput{
+		InstanceTypes:          aws.StringSlice(instanceTypes),
+		TargetCapacity:         aws.Int64(1),
+		SingleAvailabilityZone: aws.Bool(true),
+		RegionNames:            aws.StringSlice([]string{p.region}),
+	}, func(output *ec2.GetSpotPlacementScoresOutput, _ bool) bool {
+		for _, score := range output.SpotPlacementScores {
+			if zoneName, ok := zoneNames[aws.StringValue(score.AvailabilityZoneId)]; ok {
+				scores[zoneName] = aws.Int64Value(score.Score)
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("fetching spot placement scores, %w", err)
+	}
+	logging.FromContext(ctx).Debugf("Discovered spot placement scores %v for instance types %v", scores, instanceTypes)
+	return scores, nil
+}
+
+// getZoneNames maps zone ids, which are used by spot placement scores, to zone names
+func (p *SpotPlacementScoreProvider) getZoneNames(ctx context.Context) (map[string]string, error) {
+	if zoneNames, ok := p.cache.Get(zoneIDsCacheKey); ok {
+		return zoneNames.(map[string]string), nil
+	}
+	output, err := p.ec2api.DescribeAvailabilityZonesWithContext(ctx, &ec2.DescribeAvailabilityZonesInput{})
+	if err != nil {
+		return nil, fmt.Errorf("describing availability zones, %w", err)
+	}
+	zoneNames := map[string]string{}
+	for _, zone := range output.AvailabilityZones {
+		zoneNames[aws.StringValue(zone.ZoneId)] = aws.StringValue(zone.ZoneName)
+	}
+	p.cache.SetDefault(zoneIDsCacheKey, zoneNames)
+	return zoneNames, nil
+}
@@ -17,6 +17,9 @@ package aws
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -32,12 +35,19 @@ import (
 type SubnetProvider struct {
 	ec2api ec2iface.EC2API
 	cache  *cache.Cache
+
+	mu sync.Mutex
+	// reserved is the number of IP addresses that are estimated to have been
+	// consumed by instances launched into each subnet since it was described
+	reserved map[string]int64
+	// next is the index of the next subnet in each zone for round-robin selection
+	next map[string]int
 }
 
-func NewSubnetProvider(ec2api ec2iface.EC2API) *SubnetProvider {
+func NewSubnetProvider(ec2api ec2iface.EC2API, ttl time.Duration) *SubnetProvider {
 	return &SubnetProvider{
 		ec2api: ec2api,
-		cache:  cache.New(CacheTTL, CacheCleanupInterval),
+		cache:  cache.New(ttl, CacheCleanupInterval),
 	}
 }
 
@@ -58,10 +68,90 @@ func (p *SubnetProvider) Get(ctx context.Context, constraints *v1alpha1.AWS) ([]
 		return nil, fmt.Errorf("no subnets matched selector %v", constraints.SubnetSelector)
 	}
 	p.cache.SetDefault(fmt.Sprint(hash), output.Subnets)
+	p.mu.Lock()
+	for _, subnet := range output.Subnets {
+		delete(p.reserved, aws.StringValue(subnet.SubnetId))
+	}
+	p.mu.Unlock()
 	logging.FromContext(ctx).Debugf("Discovered subnets: %s", prettySubnets(output.Subnets))
 	return output.Subnets, nil
 }
 
+// ZonalSubnets selects the subnet that instances are launched into in each zone
+// using the provider's subnet selection strategy.
+func (p *SubnetProvider) ZonalSubnets(constraints *v1alpha1.AWS, subnets []*ec2.Subnet) map[string]*ec2.Subnet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	candidates := map[string][]*ec2.Subnet{}
+	for _, subnet := range subnets {
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		candidates[zone] = append(candidates[zone], subnet)
+	}
+	zonalSubnets := map[string]*ec2.Subnet{}
+	for zone, zoneSubnets := range candidates {
+		// Order by ID so that selection is stable between calls
+		sort.Slice(zoneSubnets, func(i, j int) bool {
+			return aws.StringValue(zoneSubnets[i].SubnetId) < aws.StringValue(zoneSubnets[j].SubnetId)
+		})
+		switch aws.StringValue(constraints.SubnetSelectionStrategy) {
+		case v1alpha1.SubnetSelectionStrategyPinned:
+			zonalSubnets[zone] = zoneSubnets[0]
+		case v1alpha1.SubnetSelectionStrategyRoundRobin:
+			zonalSubnets[zone] = p.nextSubnet(zone, zoneSubnets)
+		default:
+			zonalSubnets[zone] = p.mostAvailableSubnet(zoneSubnets)
+		}
+	}
+	return zonalSubnets
+}
+
+// Reserve records that IP addresses in the subnet were consumed by a launch, so
+// that they're accounted for until the subnet is described again.
+func (p *SubnetProvider) Reserve(subnetID string, ips int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reserved == nil {
+		p.reserved = map[string]int64{}
+	}
+	p.reserved[subnetID] += ips
+}
+
+// availableIPs returns the number of IP addresses in the subnet that are
+// estimated to be available. Must be called with the lock held.
+func (p *SubnetProvider) availableIPs(subnet *ec2.Subnet) int64 {
+	return aws.Int64Value(subnet.AvailableIpAddressCount) - p.reserved[aws.StringValue(subnet.SubnetId)]
+}
+
+// mostAvailableSubnet returns the subnet with the most available IP addresses,
+// preferring the first by ID on ties. Must be called with the lock held.
+func (p *SubnetProvider) mostAvailableSubnet(subnets []*ec2.Subnet) *ec2.Subnet {
+	selected := subnets[0]
+	for _, subnet := range subnets[1:] {
+		if p.availableIPs(subnet) > p.availableIPs(selected) {
+			selected = subnet
+		}
+	}
+	return selected
+}
+
+// nextSubnet returns the next subnet in the zone with available IP addresses,
+// or the next subnet if none have any. Must be called with the lock held.
+func (p *SubnetProvider) nextSubnet(zone string, subnets []*ec2.Subnet) *ec2.Subnet {
+	if p.next == nil {
+		p.next = map[string]int{}
+	}
+	start := p.next[zone]
+	for i := 0; i < len(subnets); i++ {
+		subnet := subnets[(start+i)%len(subnets)]
+		if p.availableIPs(subnet) > 0 {
+			p.next[zone] = start + i + 1
+			return subnet
+		}
+	}
+	p.next[zone] = start + 1
+	return subnets[start%len(subnets)]
+}
+
 func getFilters(constraints *v1alpha1.AWS) []*ec2.Filter {
 	filters := []*ec2.Filter{}
 	// Filter by subnet
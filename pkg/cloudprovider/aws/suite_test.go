@@ -22,25 +22,36 @@ import (
 	"math"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/amazon-vpc-resource-controller-k8s/pkg/aws/vpc"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/amifamily"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/amifamily/bootstrap"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/fake"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
 	. "github.com/aws/karpenter/pkg/test/expectations"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/options"
 	"github.com/aws/karpenter/pkg/utils/resources"
+	"github.com/mitchellh/hashstructure/v2"
 	"github.com/patrickmn/go-cache"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/ssm"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
@@ -49,8 +60,10 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/apis"
 	. "knative.dev/pkg/logging/testing"
 	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var ctx context.Context
@@ -60,8 +73,18 @@ var launchTemplateCache *cache.Cache
 var securityGroupCache *cache.Cache
 var subnetCache *cache.Cache
 var amiCache *cache.Cache
+var launchTemplateVersionCache *cache.Cache
+var cloudProvider *CloudProvider
 var unavailableOfferingsCache *cache.Cache
+var spotPlacementScoresCache *cache.Cache
+var spotPlacementScoreProvider *SpotPlacementScoreProvider
+var clusterCache *cache.Cache
+var instanceProfileCache *cache.Cache
+var capacityBlockCache *cache.Cache
 var fakeEC2API *fake.EC2API
+var fakeEKSAPI *fake.EKSAPI
+var fakeIAMAPI *fake.IAMAPI
+var fakeSSMAPI *fake.SSMAPI
 var provisioners *provisioning.Controller
 var selectionController *selection.Controller
 
@@ -87,39 +110,74 @@ var _ = BeforeSuite(func() {
 		securityGroupCache = cache.New(CacheTTL, CacheCleanupInterval)
 		subnetCache = cache.New(CacheTTL, CacheCleanupInterval)
 		amiCache = cache.New(CacheTTL, CacheCleanupInterval)
+		launchTemplateVersionCache = cache.New(CacheTTL, CacheCleanupInterval)
+		spotPlacementScoresCache = cache.New(SpotPlacementScoresCacheTTL, CacheCleanupInterval)
+		spotPlacementScoreProvider = &SpotPlacementScoreProvider{
+			ec2api:    fakeEC2API,
+			region:    "test-region",
+			cache:     spotPlacementScoresCache,
+			requested: cache.New(SpotPlacementScoresRequestTTL, CacheCleanupInterval),
+		}
+		clusterCache = cache.New(ClusterCacheTTL, CacheCleanupInterval)
+		instanceProfileCache = cache.New(CacheTTL, CacheCleanupInterval)
+		capacityBlockCache = cache.New(CapacityBlockCacheTTL, CacheCleanupInterval)
 		fakeEC2API = &fake.EC2API{}
+		fakeEKSAPI = &fake.EKSAPI{}
+		fakeIAMAPI = &fake.IAMAPI{}
+		fakeSSMAPI = &fake.SSMAPI{}
+		instanceProfileProvider := &InstanceProfileProvider{
+			iamapi: fakeIAMAPI,
+			cache:  instanceProfileCache,
+		}
 		subnetProvider := &SubnetProvider{
 			ec2api: fakeEC2API,
 			cache:  subnetCache,
 		}
+		capacityBlockProvider := &CapacityBlockProvider{
+			ec2api: fakeEC2API,
+			cache:  capacityBlockCache,
+		}
 		instanceTypeProvider := &InstanceTypeProvider{
-			ec2api:               fakeEC2API,
-			subnetProvider:       subnetProvider,
-			cache:                cache.New(InstanceTypesAndZonesCacheTTL, CacheCleanupInterval),
-			unavailableOfferings: unavailableOfferingsCache,
+			ec2api:                fakeEC2API,
+			subnetProvider:        subnetProvider,
+			capacityBlockProvider: capacityBlockProvider,
+			cache:                 cache.New(InstanceTypesAndZonesCacheTTL, CacheCleanupInterval),
+			unavailableOfferings:  unavailableOfferingsCache,
 		}
 		securityGroupProvider := &SecurityGroupProvider{
 			ec2api: fakeEC2API,
 			cache:  securityGroupCache,
 		}
 		clientSet := kubernetes.NewForConfigOrDie(e.Config)
-		cloudProvider := &CloudProvider{
-			subnetProvider:       subnetProvider,
-			instanceTypeProvider: instanceTypeProvider,
+		launchTemplateProvider := &LaunchTemplateProvider{
+			ec2api:                fakeEC2API,
+			amiFamily:             amifamily.New(fakeSSMAPI, amiCache),
+			clientSet:             clientSet,
+			securityGroupProvider: securityGroupProvider,
+			cache:                 launchTemplateCache,
+			versionCache:          launchTemplateVersionCache,
+			clusterProvider: &ClusterProvider{
+				eksapi:   fakeEKSAPI,
+				cache:    clusterCache,
+				caBundle: ptr.String("ca-bundle"),
+			},
+			instanceProfileProvider: instanceProfileProvider,
+		}
+		cloudProvider = &CloudProvider{
+			subnetProvider:          subnetProvider,
+			instanceTypeProvider:    instanceTypeProvider,
+			instanceProfileProvider: instanceProfileProvider,
+			inPlaceUpdateProvider:   NewInPlaceUpdateProvider(fake.SSMAPI{}),
+			providerVerifier:        NewProviderVerifier(fakeEC2API, fakeIAMAPI, subnetProvider, instanceTypeProvider, launchTemplateProvider),
 			instanceProvider: &InstanceProvider{
-				fakeEC2API, instanceTypeProvider, subnetProvider, &LaunchTemplateProvider{
-					ec2api:                fakeEC2API,
-					amiFamily:             amifamily.New(fake.SSMAPI{}, amiCache),
-					clientSet:             clientSet,
-					securityGroupProvider: securityGroupProvider,
-					cache:                 launchTemplateCache,
-					caBundle:              ptr.String("ca-bundle"),
-				},
+				fakeEC2API, instanceTypeProvider, subnetProvider, launchTemplateProvider,
+				spotPlacementScoreProvider,
+				capacityBlockProvider,
 			},
 		}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioners = provisioning.NewController(ctx, e.Client, clientSet.CoreV1(), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioners)
+		provisioners = provisioning.NewController(ctx, e.Client, clientSet.CoreV1(), cloudProvider, events.NewRecorder(test.NewEventRecorder()))
+		selectionController = selection.NewController(e.Client, provisioners, events.NewRecorder(test.NewEventRecorder()))
 	})
 
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
@@ -140,11 +198,20 @@ var _ = Describe("Allocation", func() {
 		}
 		provisioner = ProvisionerWithProvider(&v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())}}, provider)
 		fakeEC2API.Reset()
+		fakeEKSAPI.Reset()
+		fakeIAMAPI.Reset()
+		fakeSSMAPI.WantErr = nil
 		launchTemplateCache.Flush()
 		securityGroupCache.Flush()
 		subnetCache.Flush()
 		unavailableOfferingsCache.Flush()
 		amiCache.Flush()
+		launchTemplateVersionCache.Flush()
+		spotPlacementScoresCache.Flush()
+		spotPlacementScoreProvider.requested.Flush()
+		clusterCache.Flush()
+		instanceProfileCache.Flush()
+		capacityBlockCache.Flush()
 	})
 
 	AfterEach(func() {
@@ -175,7 +242,7 @@ var _ = Describe("Allocation", func() {
 							Limits:   v1.ResourceList{resources.AWSPodENI: resource.MustParse("1")},
 						},
 					})) {
-					node := ExpectScheduled(ctx, env.Client, pod)
+					node := ExpectNominated(ctx, env.Client, pod)
 					Expect(node.Labels).To(HaveKey(v1.LabelInstanceTypeStable))
 					supportsPodENI := func() bool {
 						limits, ok := vpc.Limits[node.Labels[v1.LabelInstanceTypeStable]]
@@ -207,7 +274,7 @@ var _ = Describe("Allocation", func() {
 							Limits:   v1.ResourceList{resources.NvidiaGPU: resource.MustParse("4")},
 						},
 					})) {
-					node := ExpectScheduled(ctx, env.Client, pod)
+					node := ExpectNominated(ctx, env.Client, pod)
 					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "p3.8xlarge"))
 					Expect(node.Status.Capacity).To(HaveKeyWithValue(nvidiaGPUResourceName, resource.MustParse("4")))
 					nodeNames.Insert(node.Name)
@@ -241,14 +308,15 @@ var _ = Describe("Allocation", func() {
 						},
 					}),
 				) {
-					node := ExpectScheduled(ctx, env.Client, pod)
-
 					// This test has a GPU workload that nearly maxes out the test instance type.  It's intended to ensure
 					// that the second pod won't get a GPU node since it doesn't require one, even though it's compatible
 					// with the first pod that does require a GPU.
+					var node *v1.Node
 					if _, isGpuPod := pod.Spec.Containers[0].Resources.Requests[resources.NvidiaGPU]; isGpuPod {
+						node = ExpectNominated(ctx, env.Client, pod)
 						Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "p3.8xlarge"))
 					} else {
+						node = ExpectScheduled(ctx, env.Client, pod)
 						Expect(node.Labels).ToNot(HaveKeyWithValue(v1.LabelInstanceTypeStable, "p3.8xlarge"))
 					}
 					nodeNames.Insert(node.Name)
@@ -280,7 +348,7 @@ var _ = Describe("Allocation", func() {
 						},
 					}),
 				) {
-					node := ExpectScheduled(ctx, env.Client, pod)
+					node := ExpectNominated(ctx, env.Client, pod)
 					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "inf1.6xlarge"))
 					Expect(node.Status.Capacity).To(HaveKeyWithValue(awsNeuronResourceName, resource.MustParse("4")))
 					nodeNames.Insert(node.Name)
@@ -313,7 +381,7 @@ var _ = Describe("Allocation", func() {
 				}
 				nodeNames := sets.NewString()
 				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pods...) {
-					node := ExpectScheduled(ctx, env.Client, pod)
+					node := ExpectNominated(ctx, env.Client, pod)
 					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "inf1.2xlarge"))
 					nodeNames.Insert(node.Name)
 				}
@@ -339,7 +407,7 @@ var _ = Describe("Allocation", func() {
 				ExpectNotScheduled(ctx, env.Client, pod)
 
 				pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
-				node := ExpectScheduled(ctx, env.Client, pod)
+				node := ExpectNominated(ctx, env.Client, pod)
 				Expect(node.Labels).To(SatisfyAll(
 					HaveKeyWithValue(v1.LabelInstanceTypeStable, "p3.8xlarge"),
 					HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1b")))
@@ -392,7 +460,7 @@ var _ = Describe("Allocation", func() {
 				fakeEC2API.InsufficientCapacityPools = []fake.CapacityPool{}
 				unavailableOfferingsCache.Delete(UnavailableOfferingsCacheKey(v1alpha1.CapacityTypeOnDemand, "inf1.6xlarge", "test-zone-1a"))
 				pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
-				node := ExpectScheduled(ctx, env.Client, pod)
+				node := ExpectNominated(ctx, env.Client, pod)
 				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "inf1.6xlarge"))
 			})
 			It("should launch on-demand capacity if flexible to both spot and on demand, but spot if unavailable", func() {
@@ -424,8 +492,359 @@ var _ = Describe("Allocation", func() {
 				node := ExpectScheduled(ctx, env.Client, pod)
 				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeSpot))
 			})
+			It("should launch spot capacity with the allocation strategy of the instance selection strategy", func() {
+				for strategy, allocationStrategy := range map[v1alpha5.InstanceSelectionStrategy]string{
+					v1alpha5.InstanceSelectionStrategyLowestPrice:       ec2.SpotAllocationStrategyLowestPrice,
+					v1alpha5.InstanceSelectionStrategyCapacityOptimized: ec2.SpotAllocationStrategyCapacityOptimizedPrioritized,
+					v1alpha5.InstanceSelectionStrategyDiversified:       ec2.SpotAllocationStrategyDiversified,
+				} {
+					strategy := strategy
+					provisioner.Spec.InstanceSelectionStrategy = &strategy
+					provisioner.Spec.Requirements = v1alpha5.NewRequirements(
+						v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot}})
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+					input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+					Expect(*input.SpotOptions.AllocationStrategy).To(Equal(allocationStrategy))
+				}
+			})
+		})
+		Context("Capacity Blocks", func() {
+			capacityBlock := func(start time.Time, end time.Time) *ec2.CapacityReservation {
+				return &ec2.CapacityReservation{
+					CapacityReservationId:  aws.String("cr-test"),
+					InstanceType:           aws.String("m5.large"),
+					AvailabilityZone:       aws.String("test-zone-1a"),
+					StartDate:              aws.Time(start),
+					EndDate:                aws.Time(end),
+					AvailableInstanceCount: aws.Int64(1),
+				}
+			}
+			BeforeEach(func() {
+				provider, _ := ProviderFromProvisioner(provisioner)
+				provider.CapacityBlockSelector = map[string]string{"foo": "bar"}
+				provisioner = ProvisionerWithProvider(provisioner, provider)
+				provisioner.Spec.Requirements = v1alpha5.NewRequirements(
+					v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeCapacityBlock, v1alpha1.CapacityTypeOnDemand}})
+			})
+			It("should launch into an active capacity block", func() {
+				fakeEC2API.DescribeCapacityReservationsOutput = &ec2.DescribeCapacityReservationsOutput{CapacityReservations: []*ec2.CapacityReservation{
+					capacityBlock(time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)),
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeCapacityBlock))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "m5.large"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1a"))
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(*input.TargetCapacitySpecification.DefaultTargetCapacityType).To(Equal(v1alpha1.CapacityTypeCapacityBlock))
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				launchTemplate := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(*launchTemplate.LaunchTemplateData.InstanceMarketOptions.MarketType).To(Equal(v1alpha1.CapacityTypeCapacityBlock))
+				Expect(*launchTemplate.LaunchTemplateData.CapacityReservationSpecification.CapacityReservationTarget.CapacityReservationId).To(Equal("cr-test"))
+			})
+			It("should not launch into a capacity block before it starts", func() {
+				fakeEC2API.DescribeCapacityReservationsOutput = &ec2.DescribeCapacityReservationsOutput{CapacityReservations: []*ec2.CapacityReservation{
+					capacityBlock(time.Now().Add(time.Hour), time.Now().Add(24*time.Hour)),
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeOnDemand))
+			})
+			It("should not launch into a capacity block that is about to end", func() {
+				fakeEC2API.DescribeCapacityReservationsOutput = &ec2.DescribeCapacityReservationsOutput{CapacityReservations: []*ec2.CapacityReservation{
+					capacityBlock(time.Now().Add(-time.Hour), time.Now().Add(CapacityBlockTerminationLeadTime/2)),
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeOnDemand))
+			})
+			It("should not schedule pods that require a capacity block outside of its zone", func() {
+				fakeEC2API.DescribeCapacityReservationsOutput = &ec2.DescribeCapacityReservationsOutput{CapacityReservations: []*ec2.CapacityReservation{
+					capacityBlock(time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)),
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{
+						v1alpha5.LabelCapacityType: v1alpha1.CapacityTypeCapacityBlock,
+						v1.LabelTopologyZone:       "test-zone-1b",
+					},
+				}))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+		})
+		Context("Placement", func() {
+			It("should launch instances with the default tenancy", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.LabelTenancy, ec2.TenancyDefault))
+				Expect(node.Labels).ToNot(HaveKey(v1alpha1.LabelPlacementGroup))
+				launchTemplate := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(launchTemplate.LaunchTemplateData.Placement).To(BeNil())
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, override := range input.LaunchTemplateConfigs[0].Overrides {
+					Expect(override.Placement).To(BeNil())
+				}
+			})
+			It("should launch instances with dedicated tenancy", func() {
+				provider.Tenancy = aws.String(ec2.TenancyDedicated)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.LabelTenancy, ec2.TenancyDedicated))
+				launchTemplate := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(aws.StringValue(launchTemplate.LaunchTemplateData.Placement.Tenancy)).To(Equal(ec2.TenancyDedicated))
+			})
+			It("should launch instances into the placement group", func() {
+				provider.PlacementGroup = aws.String("test-placement-group")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.LabelPlacementGroup, "test-placement-group"))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(input.LaunchTemplateConfigs[0].Overrides).ToNot(BeEmpty())
+				for _, override := range input.LaunchTemplateConfigs[0].Overrides {
+					Expect(aws.StringValue(override.Placement.GroupName)).To(Equal("test-placement-group"))
+				}
+			})
+			It("should schedule pods that select the tenancy and placement group", func() {
+				provider.Tenancy = aws.String(ec2.TenancyHost)
+				provider.PlacementGroup = aws.String("test-placement-group")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{
+						v1alpha1.LabelTenancy:        ec2.TenancyHost,
+						v1alpha1.LabelPlacementGroup: "test-placement-group",
+					},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.LabelTenancy, ec2.TenancyHost))
+			})
+			It("should only launch instance types that support dedicated tenancy", func() {
+				provider.Tenancy = aws.String(ec2.TenancyDedicated)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, ltc := range input.LaunchTemplateConfigs {
+					for _, override := range ltc.Overrides {
+						Expect(aws.StringValue(override.InstanceType)).To(BeElementOf("m5.large", "m5.xlarge", "p3.8xlarge"))
+					}
+				}
+			})
+			It("should not schedule pods that select another tenancy", func() {
+				provider.Tenancy = aws.String(ec2.TenancyHost)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{v1alpha1.LabelTenancy: ec2.TenancyDedicated},
+				}))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should not schedule pods that select a placement group", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{v1alpha1.LabelPlacementGroup: "test-placement-group"},
+				}))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+		})
+		Context("EFA", func() {
+			It("should launch instances with EFA interfaces for pods that request them", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{
+						Requests: v1.ResourceList{resources.AWSEFA: resource.MustParse("1")},
+						Limits:   v1.ResourceList{resources.AWSEFA: resource.MustParse("1")},
+					},
+				}))[0]
+				node := ExpectNominated(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "inf1.6xlarge"))
+				Expect(node.Status.Capacity).To(HaveKeyWithValue(awsEFAResourceName, resource.MustParse("1")))
+				launchTemplate := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(launchTemplate.LaunchTemplateData.SecurityGroupIds).To(BeNil())
+				Expect(launchTemplate.LaunchTemplateData.NetworkInterfaces).To(HaveLen(1))
+				networkInterface := launchTemplate.LaunchTemplateData.NetworkInterfaces[0]
+				Expect(aws.StringValue(networkInterface.InterfaceType)).To(Equal(ec2.NetworkInterfaceTypeEfa))
+				Expect(aws.Int64Value(networkInterface.NetworkCardIndex)).To(BeNumerically("==", 0))
+				Expect(aws.Int64Value(networkInterface.DeviceIndex)).To(BeNumerically("==", 0))
+				Expect(aws.StringValueSlice(networkInterface.Groups)).To(ConsistOf("test-security-group-1", "test-security-group-2", "test-security-group-3"))
+			})
+			It("should launch instances with EFA interfaces if enabled", func() {
+				provider.EFA = aws.Bool(true)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "inf1.6xlarge"},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Status.Capacity).To(HaveKeyWithValue(awsEFAResourceName, resource.MustParse("1")))
+				launchTemplate := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(launchTemplate.LaunchTemplateData.NetworkInterfaces).To(HaveLen(1))
+			})
+			It("should not attach EFA interfaces to instances that do not support them", func() {
+				provider.EFA = aws.Bool(true)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Status.Capacity).ToNot(HaveKey(awsEFAResourceName))
+				launchTemplate := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(launchTemplate.LaunchTemplateData.NetworkInterfaces).To(BeEmpty())
+				Expect(launchTemplate.LaunchTemplateData.SecurityGroupIds).ToNot(BeEmpty())
+			})
+			It("should not launch instances with EFA interfaces by default", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Status.Capacity).ToNot(HaveKey(awsEFAResourceName))
+				launchTemplate := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(launchTemplate.LaunchTemplateData.NetworkInterfaces).To(BeEmpty())
+			})
+		})
+		Context("Spot Placement Scores", func() {
+			BeforeEach(func() {
+				provisioner.Spec.Requirements = v1alpha5.NewRequirements(
+					v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot}})
+				fakeEC2API.GetSpotPlacementScoresOutput = &ec2.GetSpotPlacementScoresOutput{SpotPlacementScores: []*ec2.SpotPlacementScore{
+					{AvailabilityZoneId: aws.String("testzone1a"), Score: aws.Int64(3)},
+					{AvailabilityZoneId: aws.String("testzone1b"), Score: aws.Int64(9)},
+					{AvailabilityZoneId: aws.String("testzone1c"), Score: aws.Int64(6)},
+				}}
+			})
+			It("should prioritize zones with higher spot placement scores", func() {
+				localOpts := opts
+				localOpts.AWSSpotPlacementScores = true
+				localCtx := injection.WithOptions(ctx, localOpts)
+				// Scores are fetched in the background, so the first launch doesn't wait on them
+				pod := ExpectProvisioned(localCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				ExpectScheduled(localCtx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithGetSpotPlacementScores.Cardinality()).To(Equal(0))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, ltc := range input.LaunchTemplateConfigs {
+					for _, override := range ltc.Overrides {
+						Expect(*override.Priority).To(Equal(math.Trunc(*override.Priority)))
+					}
+				}
+
+				spotPlacementScoreProvider.Refresh(localCtx, false)
+				Expect(fakeEC2API.CalledWithGetSpotPlacementScores.Cardinality()).To(Equal(1))
+				scoresInput := fakeEC2API.CalledWithGetSpotPlacementScores.Pop().(*ec2.GetSpotPlacementScoresInput)
+				Expect(aws.StringValueSlice(scoresInput.RegionNames)).To(ConsistOf("test-region"))
+				pod = ExpectProvisioned(localCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				ExpectScheduled(localCtx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithGetSpotPlacementScores.Cardinality()).To(Equal(0))
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input = fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				priorities := map[string]map[string]float64{}
+				for _, ltc := range input.LaunchTemplateConfigs {
+					for _, override := range ltc.Overrides {
+						if _, ok := priorities[*override.InstanceType]; !ok {
+							priorities[*override.InstanceType] = map[string]float64{}
+						}
+						priorities[*override.InstanceType][*override.AvailabilityZone] = *override.Priority
+					}
+				}
+				compared := 0
+				for _, zones := range priorities {
+					a, okA := zones["test-zone-1a"]
+					b, okB := zones["test-zone-1b"]
+					if okA && okB {
+						Expect(b).To(BeNumerically("<", a))
+						compared++
+					}
+				}
+				Expect(compared).To(BeNumerically(">", 0))
+			})
+			It("should keep scores if a refresh fails", func() {
+				localOpts := opts
+				localOpts.AWSSpotPlacementScores = true
+				localCtx := injection.WithOptions(ctx, localOpts)
+				Expect(spotPlacementScoreProvider.Get(localCtx, []string{"m5.large"})).To(BeNil())
+				spotPlacementScoreProvider.Refresh(localCtx, false)
+				Expect(spotPlacementScoreProvider.Get(localCtx, []string{"m5.large"})).To(HaveKeyWithValue("test-zone-1b", int64(9)))
+
+				fakeEC2API.GetSpotPlacementScoresError = fmt.Errorf("failed")
+				spotPlacementScoreProvider.Refresh(localCtx, true)
+				Expect(fakeEC2API.CalledWithGetSpotPlacementScores.Cardinality()).To(Equal(2))
+				Expect(spotPlacementScoreProvider.Get(localCtx, []string{"m5.large"})).To(HaveKeyWithValue("test-zone-1b", int64(9)))
+			})
+			It("should not fetch spot placement scores if disabled", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithGetSpotPlacementScores.Cardinality()).To(Equal(0))
+			})
 		})
 		Context("LaunchTemplates", func() {
+			launchTemplate := func(securityGroupIDs []string, taints []v1.Taint, kubeletConfig *v1alpha5.KubeletConfiguration) *amifamily.LaunchTemplate {
+				return &amifamily.LaunchTemplate{
+					Options: &amifamily.Options{
+						ClusterName:       "test-cluster",
+						ClusterEndpoint:   "https://test-cluster",
+						InstanceProfile:   "test-instance-profile",
+						KubernetesVersion: "1.21",
+						SecurityGroupsIDs: securityGroupIDs,
+						CABundle:          ptr.String("ca-bundle"),
+					},
+					UserData: bootstrap.EKS{Options: bootstrap.Options{
+						ClusterName:     "test-cluster",
+						ClusterEndpoint: "https://test-cluster",
+						KubeletConfig:   kubeletConfig,
+						Taints:          taints,
+						Labels:          map[string]string{"foo": "bar", "baz": "qux"},
+					}},
+					AMIID: "ami-123",
+				}
+			}
+			It("should not change the launch template name when taints are reordered", func() {
+				taints := []v1.Taint{{Key: "a", Effect: v1.TaintEffectNoSchedule}, {Key: "b", Effect: v1.TaintEffectNoExecute}}
+				Expect(launchTemplateName(launchTemplate([]string{"sg-1"}, taints, nil))).To(Equal(
+					launchTemplateName(launchTemplate([]string{"sg-1"}, []v1.Taint{taints[1], taints[0]}, nil))))
+			})
+			It("should not change the launch template name for unset fields that were added later", func() {
+				options := &amifamily.Options{
+					ClusterName:       "test-cluster",
+					ClusterEndpoint:   "https://test-cluster",
+					InstanceProfile:   "test-instance-profile",
+					KubernetesVersion: "1.21",
+					SecurityGroupsIDs: []string{"sg-1"},
+				}
+				// The fields of amifamily.Options when launch templates were first named by their hash, which
+				// includes the name of the type
+				type Options struct {
+					ClusterName             string
+					ClusterEndpoint         string
+					AWSENILimitedPodDensity bool
+					InstanceProfile         string
+					KubernetesVersion       string
+					SecurityGroupsIDs       []string
+					Tags                    map[string]string
+				}
+				original, err := hashstructure.Hash(Options{
+					ClusterName:       options.ClusterName,
+					ClusterEndpoint:   options.ClusterEndpoint,
+					InstanceProfile:   options.InstanceProfile,
+					KubernetesVersion: options.KubernetesVersion,
+					SecurityGroupsIDs: options.SecurityGroupsIDs,
+				}, hashstructure.FormatV2, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hashstructure.Hash(options, hashstructure.FormatV2, nil)).To(Equal(original))
+				options.Tenancy = ec2.TenancyDedicated
+				Expect(hashstructure.Hash(options, hashstructure.FormatV2, nil)).ToNot(Equal(original))
+			})
+			It("should not change the launch template name for unset kubelet configuration that was added later", func() {
+				kubeletConfig := &v1alpha5.KubeletConfiguration{ClusterDNS: []string{"10.0.100.10"}}
+				type KubeletConfiguration struct {
+					ClusterDNS []string
+				}
+				original, err := hashstructure.Hash(KubeletConfiguration{ClusterDNS: kubeletConfig.ClusterDNS}, hashstructure.FormatV2, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hashstructure.Hash(kubeletConfig, hashstructure.FormatV2, nil)).To(Equal(original))
+				kubeletConfig.MaxPods = ptr.Int32(20)
+				Expect(hashstructure.Hash(kubeletConfig, hashstructure.FormatV2, nil)).ToNot(Equal(original))
+			})
+			It("should not change the launch template name for ignored fields", func() {
+				template := launchTemplate([]string{"sg-1"}, nil, nil)
+				name := launchTemplateName(template)
+				template.CABundle = ptr.String("rotated-ca-bundle")
+				template.InstanceTypes = []cloudprovider.InstanceType{&InstanceType{InstanceTypeInfo: &ec2.InstanceTypeInfo{InstanceType: aws.String("m5.large")}}}
+				Expect(launchTemplateName(template)).To(Equal(name))
+			})
+			It("should change the launch template name when the rendered configuration changes", func() {
+				name := launchTemplateName(launchTemplate([]string{"sg-1"}, nil, nil))
+				Expect(launchTemplateName(launchTemplate([]string{"sg-1"}, nil, &v1alpha5.KubeletConfiguration{MaxPods: ptr.Int32(20)}))).ToNot(Equal(name))
+				Expect(launchTemplateName(launchTemplate([]string{"sg-2"}, nil, nil))).ToNot(Equal(name))
+			})
 			It("should use same launch template for equivalent constraints", func() {
 				t1 := v1.Toleration{
 					Key:      "Abacus",
@@ -560,6 +979,65 @@ var _ = Describe("Allocation", func() {
 				Expect(*launchTemplate.LaunchTemplateName).To(Equal("test-launch-template"))
 				Expect(*launchTemplate.Version).To(Equal("$Latest"))
 			})
+			Context("Managed Fields", func() {
+				BeforeEach(func() {
+					fakeEC2API.LaunchTemplates.Store("test-launch-template", &ec2.LaunchTemplate{
+						LaunchTemplateName:   aws.String("test-launch-template"),
+						DefaultVersionNumber: aws.Int64(3),
+					})
+					provider.LaunchTemplateName = aws.String("test-launch-template")
+					provider.SecurityGroupSelector = nil
+					provider.LaunchTemplateManagedFields = []string{v1alpha1.LaunchTemplateFieldAMI, v1alpha1.LaunchTemplateFieldUserData}
+				})
+				It("should launch from a version of the launch template with the managed fields replaced", func() {
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(0))
+					value, ok := fakeEC2API.LaunchTemplateVersions.Load(int64(1))
+					Expect(ok).To(BeTrue())
+					launchTemplateVersion := value.(*ec2.LaunchTemplateVersion)
+					Expect(*launchTemplateVersion.LaunchTemplateData.ImageId).To(Equal("test-ami-id"))
+					userData, err := base64.StdEncoding.DecodeString(*launchTemplateVersion.LaunchTemplateData.UserData)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(userData)).To(ContainSubstring("/etc/eks/bootstrap.sh"))
+					Expect(*launchTemplateVersion.VersionDescription).To(HavePrefix("Karpenter-3-"))
+
+					input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+					launchTemplate := input.LaunchTemplateConfigs[0].LaunchTemplateSpecification
+					Expect(*launchTemplate.LaunchTemplateName).To(Equal("test-launch-template"))
+					Expect(*launchTemplate.Version).To(Equal("1"))
+				})
+				It("should not replace unmanaged fields", func() {
+					provider.LaunchTemplateManagedFields = []string{v1alpha1.LaunchTemplateFieldAMI}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					value, ok := fakeEC2API.LaunchTemplateVersions.Load(int64(1))
+					Expect(ok).To(BeTrue())
+					Expect(value.(*ec2.LaunchTemplateVersion).LaunchTemplateData.UserData).To(BeNil())
+				})
+				It("should reuse the version of the launch template that was created before a restart", func() {
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					launchTemplateVersionCache.Flush()
+					pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					_, ok := fakeEC2API.LaunchTemplateVersions.Load(int64(2))
+					Expect(ok).To(BeFalse())
+				})
+				It("should recreate versions of the launch template that no longer exist", func() {
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					fakeEC2API.LaunchTemplateVersions.Delete(int64(1))
+					// The cached version fails to launch, and is evicted
+					pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectNotScheduled(ctx, env.Client, pod)
+					pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					_, ok := fakeEC2API.LaunchTemplateVersions.Load(int64(1))
+					Expect(ok).To(BeTrue())
+					Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(3))
+				})
+			})
 		})
 		Context("Subnets", func() {
 			It("should default to the cluster's subnets", func() {
@@ -587,6 +1065,49 @@ var _ = Describe("Allocation", func() {
 				createFleetInput := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
 				Expect(aws.StringValue(createFleetInput.LaunchTemplateConfigs[0].Overrides[0].SubnetId)).To(Equal("test-subnet-2"))
 			})
+			Context("Subnet Selection Strategy", func() {
+				var subnetProvider *SubnetProvider
+				var subnets []*ec2.Subnet
+				BeforeEach(func() {
+					subnetProvider = &SubnetProvider{ec2api: fakeEC2API, cache: subnetCache}
+					subnets = []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-2"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(99)},
+						{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(100)},
+						{SubnetId: aws.String("test-subnet-3"), AvailabilityZone: aws.String("test-zone-1b"), AvailableIpAddressCount: aws.Int64(10)},
+					}
+				})
+				zonalSubnet := func(zone string) string {
+					return aws.StringValue(subnetProvider.ZonalSubnets(provider, subnets)[zone].SubnetId)
+				}
+				It("should account for reserved IP addresses", func() {
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-1"))
+					Expect(zonalSubnet("test-zone-1b")).To(Equal("test-subnet-3"))
+					subnetProvider.Reserve("test-subnet-1", 2)
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-2"))
+				})
+				It("should rotate through subnets with available IP addresses", func() {
+					provider.SubnetSelectionStrategy = aws.String(v1alpha1.SubnetSelectionStrategyRoundRobin)
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-1"))
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-2"))
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-1"))
+					subnetProvider.Reserve("test-subnet-2", 99)
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-1"))
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-1"))
+				})
+				It("should pin the first subnet by ID", func() {
+					provider.SubnetSelectionStrategy = aws.String(v1alpha1.SubnetSelectionStrategyPinned)
+					subnetProvider.Reserve("test-subnet-1", 100)
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-1"))
+					Expect(zonalSubnet("test-zone-1a")).To(Equal("test-subnet-1"))
+				})
+				It("should reset reserved IP addresses when subnets are described", func() {
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: subnets}
+					subnetProvider.Reserve("test-subnet-1", 2)
+					described, err := subnetProvider.Get(ctx, provider)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(aws.StringValue(subnetProvider.ZonalSubnets(provider, described)["test-zone-1a"].SubnetId)).To(Equal("test-subnet-1"))
+				})
+			})
 		})
 		Context("Security Groups", func() {
 			It("should default to the clusters security groups", func() {
@@ -615,6 +1136,60 @@ var _ = Describe("Allocation", func() {
 				))
 			})
 		})
+		Context("Cluster", func() {
+			userData := func(ctx context.Context) string {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, err := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(err).ToNot(HaveOccurred())
+				return string(userData)
+			}
+			describeCluster := func(endpoint string, caBundle string) *eks.DescribeClusterOutput {
+				return &eks.DescribeClusterOutput{Cluster: &eks.Cluster{
+					Endpoint:             aws.String(endpoint),
+					CertificateAuthority: &eks.Certificate{Data: aws.String(caBundle)},
+				}}
+			}
+			It("should discover the cluster endpoint and caBundle if the endpoint isn't configured", func() {
+				fakeEKSAPI.DescribeClusterOutput = describeCluster("https://discovered-cluster", "discovered-ca-bundle")
+				localOpts := opts
+				localOpts.ClusterEndpoint = ""
+				userData := userData(injection.WithOptions(ctx, localOpts))
+				Expect(userData).To(ContainSubstring("--apiserver-endpoint='https://discovered-cluster'"))
+				Expect(userData).To(ContainSubstring("--b64-cluster-ca='discovered-ca-bundle'"))
+			})
+			It("should prefer the configured cluster endpoint", func() {
+				fakeEKSAPI.DescribeClusterOutput = describeCluster("https://discovered-cluster", "discovered-ca-bundle")
+				userData := userData(ctx)
+				Expect(userData).To(ContainSubstring("--apiserver-endpoint='https://test-cluster'"))
+				Expect(userData).To(ContainSubstring("--b64-cluster-ca='discovered-ca-bundle'"))
+			})
+			It("should fall back to the configured cluster endpoint if the cluster can't be described", func() {
+				userData := userData(ctx)
+				Expect(userData).To(ContainSubstring("--apiserver-endpoint='https://test-cluster'"))
+				Expect(userData).To(ContainSubstring("--b64-cluster-ca='ca-bundle'"))
+			})
+			It("should fail to launch if the cluster endpoint can't be discovered or configured", func() {
+				localOpts := opts
+				localOpts.ClusterEndpoint = ""
+				localCtx := injection.WithOptions(ctx, localOpts)
+				pod := ExpectProvisioned(localCtx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectNotScheduled(localCtx, env.Client, pod)
+			})
+			It("should use a new launch template once the caBundle is rotated", func() {
+				fakeEKSAPI.DescribeClusterOutput = describeCluster("https://test-cluster", "ca-bundle-1")
+				Expect(userData(ctx)).To(ContainSubstring("--b64-cluster-ca='ca-bundle-1'"))
+				name := aws.StringValue(fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput).LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName)
+
+				fakeEKSAPI.DescribeClusterOutput = describeCluster("https://test-cluster", "ca-bundle-2")
+				clusterCache.Flush()
+				Expect(userData(ctx)).To(ContainSubstring("--b64-cluster-ca='ca-bundle-2'"))
+				rotated := aws.StringValue(fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput).LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName)
+				Expect(rotated).ToNot(Equal(name))
+			})
+		})
 		Context("User Data", func() {
 			It("should not specify --use-max-pods=false when using ENI-based pod density", func() {
 				opts.AWSENILimitedPodDensity = true
@@ -647,6 +1222,39 @@ var _ = Describe("Allocation", func() {
 					userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
 					Expect(string(userData)).To(ContainSubstring("--dns-cluster-ip='10.0.10.100'"))
 				})
+				It("should pass reserved resources and eviction thresholds as kubelet args", func() {
+					provisioner.Spec.KubeletConfiguration = &v1alpha5.KubeletConfiguration{
+						SystemReserved: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("100Mi")},
+						KubeReserved:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("500Mi")},
+						EvictionHard:   map[string]string{"memory.available": "5%"},
+					}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+					input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+					userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+					Expect(string(userData)).To(ContainSubstring("--system-reserved=cpu=100m,memory=100Mi"))
+					Expect(string(userData)).To(ContainSubstring("--kube-reserved=memory=500Mi"))
+					Expect(string(userData)).To(ContainSubstring("--eviction-hard=memory.available<5%"))
+				})
+				It("should pass max pods and image gc thresholds as kubelet args", func() {
+					provisioner.Spec.KubeletConfiguration = &v1alpha5.KubeletConfiguration{
+						MaxPods:                     ptr.Int32(20),
+						PodsPerCore:                 ptr.Int32(4),
+						ImageGCHighThresholdPercent: ptr.Int32(80),
+						ImageGCLowThresholdPercent:  ptr.Int32(60),
+					}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+					input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+					userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+					Expect(string(userData)).To(ContainSubstring("--use-max-pods=false"))
+					Expect(string(userData)).To(ContainSubstring("--max-pods=20"))
+					Expect(string(userData)).To(ContainSubstring("--pods-per-core=4"))
+					Expect(string(userData)).To(ContainSubstring("--image-gc-high-threshold=80"))
+					Expect(string(userData)).To(ContainSubstring("--image-gc-low-threshold=60"))
+				})
 			})
 			Context("Instance Profile", func() {
 				It("should use the default instance profile if none specified on the Provisioner", func() {
@@ -668,6 +1276,52 @@ var _ = Describe("Allocation", func() {
 					input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
 					Expect(*input.LaunchTemplateData.IamInstanceProfile.Name).To(Equal("overridden-profile"))
 				})
+				It("should create an instance profile with the role on the Provisioner when specified", func() {
+					provider.Role = aws.String("test-role")
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					name := instanceProfileName("test-cluster", provisioner.Name)
+					Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+					input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+					Expect(*input.LaunchTemplateData.IamInstanceProfile.Name).To(Equal(name))
+					Expect(fakeIAMAPI.InstanceProfiles).To(HaveKey(name))
+					Expect(fakeIAMAPI.InstanceProfiles[name].Roles).To(HaveLen(1))
+					Expect(aws.StringValue(fakeIAMAPI.InstanceProfiles[name].Roles[0].RoleName)).To(Equal("test-role"))
+				})
+				It("should replace the role of the managed instance profile when the role changes", func() {
+					provider.Role = aws.String("test-role")
+					ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0])
+					provider.Role = aws.String("updated-role")
+					ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0])
+					name := instanceProfileName("test-cluster", provisioner.Name)
+					Expect(fakeIAMAPI.InstanceProfiles[name].Roles).To(HaveLen(1))
+					Expect(aws.StringValue(fakeIAMAPI.InstanceProfiles[name].Roles[0].RoleName)).To(Equal("updated-role"))
+				})
+				It("should delete the managed instance profile when the Provisioner is deleted", func() {
+					provider.Role = aws.String("test-role")
+					ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0])
+					Expect(fakeIAMAPI.InstanceProfiles).To(HaveKey(instanceProfileName("test-cluster", provisioner.Name)))
+					ExpectDeleted(ctx, env.Client, provisioner)
+					ExpectReconcileSucceeded(ctx, provisioners, client.ObjectKeyFromObject(provisioner))
+					Expect(fakeIAMAPI.InstanceProfiles).To(BeEmpty())
+				})
+				It("should only add the cleanup finalizer to Provisioners with a managed instance profile", func() {
+					ExpectApplied(ctx, env.Client, provisioner)
+					ExpectReconcileSucceeded(ctx, provisioners, client.ObjectKeyFromObject(provisioner))
+					Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+					Expect(provisioner.Finalizers).ToNot(ContainElement(v1alpha5.CleanupFinalizer))
+
+					provider.Role = aws.String("test-role")
+					ExpectApplied(ctx, env.Client, ProvisionerWithProvider(provisioner, provider))
+					ExpectReconcileSucceeded(ctx, provisioners, client.ObjectKeyFromObject(provisioner))
+					Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+					Expect(provisioner.Finalizers).To(ContainElement(v1alpha5.CleanupFinalizer))
+				})
+				It("should not fail to delete a Provisioner without a managed instance profile", func() {
+					ExpectApplied(ctx, env.Client, provisioner)
+					ExpectDeleted(ctx, env.Client, provisioner)
+					ExpectReconcileSucceeded(ctx, provisioners, client.ObjectKeyFromObject(provisioner))
+				})
 			})
 		})
 		Context("Metadata Options", func() {
@@ -760,6 +1414,196 @@ var _ = Describe("Allocation", func() {
 				Expect(input.LaunchTemplateData.BlockDeviceMappings[1].Ebs.Iops).To(BeNil())
 			})
 		})
+		Context("Ephemeral Storage", func() {
+			It("should not schedule pods whose ephemeral storage requests exceed the default root volume", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("30Gi")}}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should schedule pods against the size of the ephemeral block device", func() {
+				provider, _ := ProviderFromProvisioner(provisioner)
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/xvda"),
+					EBS:        &v1alpha1.BlockDevice{VolumeSize: resource.NewScaledQuantity(40, resource.Giga)},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("30Gi")}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+			})
+			It("should describe ephemeral storage from the AMI family's ephemeral block device", func() {
+				instanceTypeProvider := NewInstanceTypeProvider(fakeEC2API, &SubnetProvider{ec2api: fakeEC2API, cache: subnetCache}, &CapacityBlockProvider{ec2api: fakeEC2API, cache: capacityBlockCache}, InstanceTypesAndZonesCacheTTL)
+				bottlerocket := provider.DeepCopy()
+				bottlerocket.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				bottlerocket.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{
+					{DeviceName: aws.String("/dev/xvda"), EBS: &v1alpha1.BlockDevice{VolumeSize: resource.NewScaledQuantity(4, resource.Giga)}},
+					{DeviceName: aws.String("/dev/xvdb"), EBS: &v1alpha1.BlockDevice{VolumeSize: resource.NewScaledQuantity(100, resource.Giga)}},
+				}
+				instanceTypes, err := instanceTypeProvider.Get(ctx, bottlerocket)
+				Expect(err).ToNot(HaveOccurred())
+				for _, instanceType := range instanceTypes {
+					Expect(instanceType.EphemeralStorage().String()).To(Equal("100Gi"))
+				}
+			})
+			It("should auto-size the ephemeral block device to fit the pods", func() {
+				provider, _ := ProviderFromProvisioner(provisioner)
+				provider.AutoSizeEphemeralStorage = aws.Bool(true)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("90Gi")}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(len(input.LaunchTemplateData.BlockDeviceMappings)).To(Equal(1))
+				// The default 20GiB, plus the requests and their 10% eviction threshold, rounded up to a power of two
+				Expect(*input.LaunchTemplateData.BlockDeviceMappings[0].Ebs.VolumeSize).To(Equal(int64(128)))
+			})
+			It("should share launch templates between pods whose requests round to the same size", func() {
+				provider, _ := ProviderFromProvisioner(provisioner)
+				provider.AutoSizeEphemeralStorage = aws.Bool(true)
+				for _, requests := range []string{"90Gi", "95Gi"} {
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+						test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse(requests)}}},
+					))[0]
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(*input.LaunchTemplateData.BlockDeviceMappings[0].Ebs.VolumeSize).To(Equal(int64(128)))
+			})
+			It("should auto-size the ephemeral block device to fit the daemons", func() {
+				ExpectCreated(ctx, env.Client, test.DaemonSet(test.DaemonSetOptions{PodOptions: test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("18Gi")}},
+				}}))
+				provider, _ := ProviderFromProvisioner(provisioner)
+				provider.AutoSizeEphemeralStorage = aws.Bool(true)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("90Gi")}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				// The default 20GiB, plus 120GiB for the pod's and daemon's requests and their eviction threshold
+				Expect(*input.LaunchTemplateData.BlockDeviceMappings[0].Ebs.VolumeSize).To(Equal(int64(256)))
+			})
+			It("should not auto-size the ephemeral block device beyond the maximum", func() {
+				provider, _ := ProviderFromProvisioner(provisioner)
+				provider.AutoSizeEphemeralStorage = aws.Bool(true)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("2Ti")}}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should only auto-size bottlerocket's data volume", func() {
+				provider, _ := ProviderFromProvisioner(provisioner)
+				provider.AMIFamily = &v1alpha1.AMIFamilyBottlerocket
+				provider.AutoSizeEphemeralStorage = aws.Bool(true)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("9Gi")}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(len(input.LaunchTemplateData.BlockDeviceMappings)).To(Equal(2))
+				Expect(*input.LaunchTemplateData.BlockDeviceMappings[0].Ebs.VolumeSize).To(Equal(int64(4)))
+				Expect(*input.LaunchTemplateData.BlockDeviceMappings[1].Ebs.VolumeSize).To(Equal(int64(32)))
+			})
+		})
+	})
+	Context("Allocatable", func() {
+		var instanceType *InstanceType
+		BeforeEach(func() {
+			instanceType = &InstanceType{InstanceTypeInfo: &ec2.InstanceTypeInfo{
+				InstanceType: aws.String("m5.large"),
+				VCpuInfo:     &ec2.VCpuInfo{DefaultVCpus: aws.Int64(2)},
+				MemoryInfo:   &ec2.MemoryInfo{SizeInMiB: aws.Int64(8 * 1024)},
+				NetworkInfo: &ec2.NetworkInfo{
+					MaximumNetworkInterfaces:  aws.Int64(3),
+					Ipv4AddressesPerInterface: aws.Int64(10),
+				},
+			}}
+		})
+		It("should subtract the default VM memory overhead from capacity", func() {
+			Expect(instanceType.Memory().String()).To(Equal("7577Mi"))
+		})
+		It("should subtract the AMI family's VM memory overhead from capacity", func() {
+			instanceType.VMMemoryOverhead = 0.1
+			Expect(instanceType.Memory().String()).To(Equal("7372Mi"))
+		})
+		It("should compute kube-reserved, system-reserved, and eviction overhead", func() {
+			overhead := instanceType.Overhead()
+			Expect(overhead.KubeReserved.Memory().String()).To(Equal("574Mi"))
+			Expect(overhead.KubeReserved.Cpu().String()).To(Equal("70m"))
+			Expect(overhead.SystemReserved.Memory().String()).To(Equal("100Mi"))
+			Expect(overhead.SystemReserved.Cpu().String()).To(Equal("100m"))
+			Expect(overhead.EvictionThreshold.Memory().String()).To(Equal("100Mi"))
+			total := overhead.Total()
+			Expect(total.Memory().String()).To(Equal("774Mi"))
+		})
+		It("should limit EBS volumes of Xen instance types", func() {
+			limit := instanceType.VolumeLimits()["attachable-volumes-aws-ebs"]
+			Expect(limit.Value()).To(BeNumerically("==", 38))
+		})
+		It("should share the EBS volume attachments of Nitro instance types with the network interface and instance store volumes", func() {
+			instanceType.Hypervisor = aws.String(ec2.InstanceTypeHypervisorNitro)
+			instanceType.InstanceStorageInfo = &ec2.InstanceStorageInfo{Disks: []*ec2.DiskInfo{{Count: aws.Int64(2)}}}
+			limit := instanceType.VolumeLimits()["attachable-volumes-aws-ebs"]
+			Expect(limit.Value()).To(BeNumerically("==", 24))
+		})
+		It("should share instance type info and computed resources across provisioners", func() {
+			instanceTypeProvider := NewInstanceTypeProvider(fakeEC2API, &SubnetProvider{ec2api: fakeEC2API, cache: subnetCache}, &CapacityBlockProvider{ec2api: fakeEC2API, cache: capacityBlockCache}, InstanceTypesAndZonesCacheTTL)
+			bottlerocket := provider.DeepCopy()
+			bottlerocket.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+			al2, err := instanceTypeProvider.Get(ctx, provider)
+			Expect(err).ToNot(HaveOccurred())
+			br, err := instanceTypeProvider.Get(ctx, bottlerocket)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(al2).To(HaveLen(len(br)))
+			byName := map[string]*InstanceType{}
+			for _, instanceType := range br {
+				byName[instanceType.Name()] = instanceType.(*InstanceType)
+			}
+			for _, instanceType := range al2 {
+				a, b := instanceType.(*InstanceType), byName[instanceType.Name()]
+				Expect(b).ToNot(BeNil())
+				Expect(a).ToNot(BeIdenticalTo(b))
+				Expect(a.InstanceTypeInfo).To(BeIdenticalTo(b.InstanceTypeInfo))
+				Expect(a.Overhead()).To(BeIdenticalTo(b.Overhead()))
+				Expect(a.CPU().Equal(*b.CPU())).To(BeTrue())
+			}
+		})
+	})
+	Context("Node Requests", func() {
+		It("should launch node requests with different instance type options with a single fleet request", func() {
+			// Two of the pods fill the largest instance types, so the third is packed onto smaller instance types
+			pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("12")}}}),
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("12")}}}),
+				test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("12")}}}),
+			)
+			nodes := sets.NewString()
+			for _, pod := range pods {
+				nodes.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+			}
+			Expect(nodes.Len()).To(Equal(2))
+			Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+			input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+			Expect(*input.TargetCapacitySpecification.TotalTargetCapacity).To(BeNumerically("==", 2))
+		})
+		It("should launch node requests that don't share a zone with separate fleet requests", func() {
+			pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a"}}),
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1b"}}),
+			)
+			for _, pod := range pods {
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+			Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(2))
+			for fakeEC2API.CalledWithCreateFleetInput.Cardinality() > 0 {
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(*input.TargetCapacitySpecification.TotalTargetCapacity).To(BeNumerically("==", 1))
+			}
+		})
 	})
 	Context("Defaulting", func() {
 		// Intent here is that if updates occur on the controller, the Provisioner doesn't need to be recreated
@@ -774,6 +1618,119 @@ var _ = Describe("Allocation", func() {
 			provisioner.SetDefaults(ctx)
 			Expect(provisioner.Spec.Requirements.CapacityTypes().UnsortedList()).To(ConsistOf(v1alpha1.CapacityTypeOnDemand))
 			Expect(provisioner.Spec.Requirements.Architectures().UnsortedList()).To(ConsistOf(v1alpha5.ArchitectureAmd64))
+			Expect(provisioner.Spec.Requirements.Keys().Has(v1alpha1.LabelTenancy)).To(BeFalse())
+			Expect(provisioner.Spec.Requirements.Keys().Has(v1alpha1.LabelPlacementGroup)).To(BeFalse())
+		})
+		It("should require the tenancy and placement group of the provider", func() {
+			provider.Tenancy = aws.String(ec2.TenancyDedicated)
+			provider.PlacementGroup = aws.String("test-placement-group")
+			provisioner = ProvisionerWithProvider(provisioner, provider)
+			provisioner.SetDefaults(ctx)
+			Expect(provisioner.Spec.Requirements.Get(v1alpha1.LabelTenancy).Values().UnsortedList()).To(ConsistOf(ec2.TenancyDedicated))
+			Expect(provisioner.Spec.Requirements.Get(v1alpha1.LabelPlacementGroup).Values().UnsortedList()).To(ConsistOf("test-placement-group"))
+		})
+		It("should replace placement requirements when the provider changes", func() {
+			provider.Tenancy = aws.String(ec2.TenancyDedicated)
+			provider.PlacementGroup = aws.String("test-placement-group")
+			provisioner = ProvisionerWithProvider(provisioner, provider)
+			provisioner.SetDefaults(ctx)
+			provider.Tenancy = aws.String(ec2.TenancyHost)
+			provider.PlacementGroup = nil
+			provisioner = ProvisionerWithProvider(provisioner, provider)
+			provisioner.SetDefaults(ctx)
+			Expect(provisioner.Spec.Requirements.Get(v1alpha1.LabelTenancy).Values().UnsortedList()).To(ConsistOf(ec2.TenancyHost))
+			Expect(provisioner.Spec.Requirements.Keys().Has(v1alpha1.LabelPlacementGroup)).To(BeFalse())
+		})
+	})
+	Context("Metrics", func() {
+		apiRequest := func(operation string, err error) *request.Request {
+			return &request.Request{
+				ClientInfo: metadata.ClientInfo{ServiceName: "ec2"},
+				Operation:  &request.Operation{Name: operation},
+				Time:       time.Now(),
+				Error:      err,
+			}
+		}
+		counterValue := func(name string, labels map[string]string) float64 {
+			for _, metric := range ExpectMetric(name).GetMetric() {
+				matched := 0
+				for _, label := range metric.GetLabel() {
+					if value, ok := labels[label.GetName()]; ok && value == label.GetValue() {
+						matched++
+					}
+				}
+				if matched == len(labels) {
+					return metric.GetCounter().GetValue()
+				}
+			}
+			return 0
+		}
+		It("should count API calls by error code", func() {
+			observeAPICall(apiRequest("DescribeInstanceTypes", nil))
+			observeAPICall(apiRequest("DescribeInstanceTypes", awserr.New("UnauthorizedOperation", "", nil)))
+			Expect(counterValue("karpenter_cloudprovider_aws_api_calls_total", map[string]string{"operation": "DescribeInstanceTypes", "error": ""})).To(BeNumerically(">=", 1))
+			Expect(counterValue("karpenter_cloudprovider_aws_api_calls_total", map[string]string{"operation": "DescribeInstanceTypes", "error": "UnauthorizedOperation"})).To(BeNumerically(">=", 1))
+			Expect(ExpectMetric("karpenter_cloudprovider_aws_api_duration_seconds").GetMetric()).ToNot(BeEmpty())
+		})
+		It("should count throttled attempts", func() {
+			before := counterValue("karpenter_cloudprovider_aws_api_throttles_total", map[string]string{"service": "ec2", "operation": "CreateFleet"})
+			observeAPIAttempt(apiRequest("CreateFleet", awserr.New("RequestLimitExceeded", "", nil)))
+			observeAPIAttempt(apiRequest("CreateFleet", awserr.New("InvalidParameterValue", "", nil)))
+			Expect(counterValue("karpenter_cloudprovider_aws_api_throttles_total", map[string]string{"service": "ec2", "operation": "CreateFleet"})).To(Equal(before + 1))
+		})
+	})
+	Context("In-Place Updates", func() {
+		var node *v1.Node
+		BeforeEach(func() {
+			node = &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+				Spec:       v1.NodeSpec{ProviderID: "aws:///test-zone-1a/i-01234567890123456"},
+				Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{OSImage: "Bottlerocket OS 1.7.0 (aws-k8s-1.21)", BootID: "test-boot-id"}},
+			}
+		})
+		It("should not update nodes that aren't running Bottlerocket", func() {
+			node.Status.NodeInfo.OSImage = "Amazon Linux 2"
+			Expect(NewInPlaceUpdateProvider(fake.SSMAPI{}).Validate(node)).To(MatchError(cloudprovider.ErrInPlaceUpdateUnsupported))
+		})
+		It("should succeed once the node rebooted into a new OS image", func() {
+			provider := NewInPlaceUpdateProvider(fake.SSMAPI{})
+			id, err := provider.Update(ctx, node)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provider.Get(ctx, node, id)).To(Equal(cloudprovider.InPlaceUpdatePending))
+			node.Status.NodeInfo.BootID = "updated-boot-id"
+			node.Status.NodeInfo.OSImage = "Bottlerocket OS 1.8.0 (aws-k8s-1.21)"
+			Expect(provider.Get(ctx, node, id)).To(Equal(cloudprovider.InPlaceUpdateSucceeded))
+		})
+		It("should fail if the node rebooted into the same OS image", func() {
+			provider := NewInPlaceUpdateProvider(fake.SSMAPI{})
+			id, err := provider.Update(ctx, node)
+			Expect(err).ToNot(HaveOccurred())
+			node.Status.NodeInfo.BootID = "updated-boot-id"
+			Expect(provider.Get(ctx, node, id)).To(Equal(cloudprovider.InPlaceUpdateFailed))
+		})
+		It("should fail if the update command failed", func() {
+			provider := NewInPlaceUpdateProvider(fake.SSMAPI{GetCommandInvocationOutput: &ssm.GetCommandInvocationOutput{
+				Status:               aws.String(ssm.CommandInvocationStatusFailed),
+				StandardErrorContent: aws.String("no update available"),
+			}})
+			id, err := provider.Update(ctx, node)
+			Expect(err).ToNot(HaveOccurred())
+			node.Status.NodeInfo.BootID = "updated-boot-id"
+			Expect(provider.Get(ctx, node, id)).To(Equal(cloudprovider.InPlaceUpdateFailed))
+		})
+	})
+	Context("Warm", func() {
+		It("should fill the security group and AMI caches", func() {
+			localOpts := opts
+			localOpts.AWSCacheWarmup = true
+			Expect(cloudProvider.Warm(injection.WithOptions(ctx, localOpts), &provisioner.Spec.Constraints)).To(Succeed())
+			Expect(securityGroupCache.ItemCount()).To(BeNumerically(">", 0))
+			Expect(amiCache.ItemCount()).To(BeNumerically(">", 0))
+		})
+		It("should not call any APIs if cache warmup is disabled", func() {
+			Expect(cloudProvider.Warm(ctx, &provisioner.Spec.Constraints)).To(Succeed())
+			Expect(securityGroupCache.ItemCount()).To(BeZero())
+			Expect(amiCache.ItemCount()).To(BeZero())
 		})
 	})
 	Context("Validation", func() {
@@ -799,6 +1756,113 @@ var _ = Describe("Allocation", func() {
 				}
 			})
 		})
+		Context("SubnetSelectionStrategy", func() {
+			It("should allow supported strategies", func() {
+				for _, strategy := range v1alpha1.SupportedSubnetSelectionStrategies {
+					provider.SubnetSelectionStrategy = aws.String(strategy)
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow unsupported strategies", func() {
+				provider.SubnetSelectionStrategy = aws.String("Random")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("Placement", func() {
+			It("should allow supported tenancies", func() {
+				for _, tenancy := range v1alpha1.SupportedTenancies {
+					provider.Tenancy = aws.String(tenancy)
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow dedicated or host tenancy for spot instances", func() {
+				provisioner.Spec.Requirements = v1alpha5.NewRequirements(v1.NodeSelectorRequirement{
+					Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand},
+				})
+				for _, tenancy := range []string{ec2.TenancyDedicated, ec2.TenancyHost} {
+					provider.Tenancy = aws.String(tenancy)
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+				provider.Tenancy = aws.String(ec2.TenancyDefault)
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should allow dedicated tenancy for on-demand instances", func() {
+				provisioner.Spec.Requirements = v1alpha5.NewRequirements(v1.NodeSelectorRequirement{
+					Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeOnDemand},
+				})
+				provider.Tenancy = aws.String(ec2.TenancyDedicated)
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should not allow unsupported tenancies", func() {
+				provider.Tenancy = aws.String("shared")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a tenancy with a custom launch template", func() {
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.Tenancy = aws.String(ec2.TenancyDedicated)
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should allow a placement group with a custom launch template", func() {
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.PlacementGroup = aws.String("test-placement-group")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should not allow an empty placement group", func() {
+				provider.PlacementGroup = aws.String("")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("LaunchTemplateManagedFields", func() {
+			It("should allow supported fields with a custom launch template", func() {
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.LaunchTemplateManagedFields = v1alpha1.SupportedLaunchTemplateManagedFields
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should not allow unsupported fields", func() {
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.LaunchTemplateManagedFields = []string{"SecurityGroups"}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow managed fields without a custom launch template", func() {
+				provider.LaunchTemplateManagedFields = []string{v1alpha1.LaunchTemplateFieldAMI}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should allow an AMI family if the AMI or user data is managed", func() {
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				provider.LaunchTemplateManagedFields = []string{v1alpha1.LaunchTemplateFieldUserData}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should allow block device mappings if they're managed", func() {
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/xvda"),
+					EBS:        &v1alpha1.BlockDevice{VolumeSize: resource.NewScaledQuantity(50, resource.Giga)},
+				}}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				provider.LaunchTemplateManagedFields = []string{v1alpha1.LaunchTemplateFieldBlockDeviceMappings}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+		})
+		Context("EFA", func() {
+			It("should allow EFA", func() {
+				provider.EFA = aws.Bool(true)
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should not allow EFA with a custom launch template", func() {
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.EFA = aws.Bool(true)
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+		})
 		Context("SecurityGroupSelector", func() {
 			It("should not allow with a custom launch template", func() {
 				provider, err := ProviderFromProvisioner(provisioner)
@@ -821,6 +1885,65 @@ var _ = Describe("Allocation", func() {
 				}
 			})
 		})
+		Context("CapacityBlockSelector", func() {
+			It("should allow a capacity block selector", func() {
+				provider, err := ProviderFromProvisioner(provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				provider.CapacityBlockSelector = map[string]string{"key": "value"}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should not allow with a custom launch template", func() {
+				provider, err := ProviderFromProvisioner(provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				provider.CapacityBlockSelector = map[string]string{"key": "value"}
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow empty string keys or values", func() {
+				provider, err := ProviderFromProvisioner(provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				for key, value := range map[string]string{
+					"":    "value",
+					"key": "",
+				} {
+					provider.CapacityBlockSelector = map[string]string{key: value}
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+		})
+		Context("Role", func() {
+			It("should allow a role", func() {
+				provider, err := ProviderFromProvisioner(provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				provider.Role = aws.String("test-role")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).To(Succeed())
+			})
+			It("should not allow a role with an instance profile", func() {
+				provider, err := ProviderFromProvisioner(provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				provider.Role = aws.String("test-role")
+				provider.InstanceProfile = aws.String("test-instance-profile")
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a role with a custom launch template", func() {
+				provider, err := ProviderFromProvisioner(provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				provider.Role = aws.String("test-role")
+				provider.LaunchTemplateName = aws.String("my-lt")
+				provider.SecurityGroupSelector = nil
+				Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow an empty role or a role ARN", func() {
+				provider, err := ProviderFromProvisioner(provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				for _, role := range []string{"", "arn:aws:iam::123456789012:role/test-role"} {
+					provider.Role = aws.String(role)
+					Expect(ProvisionerWithProvider(provisioner, provider).Validate(ctx)).ToNot(Succeed())
+				}
+			})
+		})
 		Context("Labels", func() {
 			It("should not allow unrecognized labels with the aws label prefix", func() {
 				provisioner.Spec.Labels = map[string]string{"node.k8s.aws/foo": randomdata.SillyName()}
@@ -960,6 +2083,14 @@ var _ = Describe("Allocation", func() {
 					provisioner := ProvisionerWithProvider(provisioner, provider)
 					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 				})
+				It("should not allow auto-sizing ephemeral storage with a custom launch template", func() {
+					provider, err := ProviderFromProvisioner(provisioner)
+					Expect(err).ToNot(HaveOccurred())
+					provider.LaunchTemplateName = aws.String("my-lt")
+					provider.AutoSizeEphemeralStorage = aws.Bool(true)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				})
 				It("should validate minimal device mapping", func() {
 					provider, err := ProviderFromProvisioner(provisioner)
 					Expect(err).ToNot(HaveOccurred())
@@ -1029,6 +2160,65 @@ var _ = Describe("Allocation", func() {
 			})
 		})
 	})
+	Context("Verification", func() {
+		var admissionCtx context.Context
+		BeforeEach(func() {
+			admissionCtx = apis.WithinCreate(ctx)
+			fakeIAMAPI.InstanceProfiles["test-instance-profile"] = &iam.InstanceProfile{InstanceProfileName: aws.String("test-instance-profile")}
+		})
+		It("should verify a provider whose resources exist", func() {
+			Expect(provisioner.Validate(admissionCtx)).To(Succeed())
+		})
+		It("should only verify at admission", func() {
+			delete(fakeIAMAPI.InstanceProfiles, "test-instance-profile")
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should not verify updates that don't change the constraints", func() {
+			delete(fakeIAMAPI.InstanceProfiles, "test-instance-profile")
+			Expect(provisioner.Validate(apis.WithinUpdate(ctx, provisioner.DeepCopy()))).To(Succeed())
+		})
+		It("should not allow a subnet selector that matches no subnets", func() {
+			fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{}
+			Expect(provisioner.Validate(admissionCtx)).ToNot(Succeed())
+		})
+		It("should not allow a security group selector that matches no security groups", func() {
+			fakeEC2API.DescribeSecurityGroupsOutput = &ec2.DescribeSecurityGroupsOutput{}
+			Expect(provisioner.Validate(admissionCtx)).ToNot(Succeed())
+		})
+		It("should not allow an instance profile that doesn't exist", func() {
+			provider.InstanceProfile = aws.String("missing-instance-profile")
+			Expect(ProvisionerWithProvider(provisioner, provider).Validate(admissionCtx)).ToNot(Succeed())
+		})
+		It("should allow a role that exists", func() {
+			fakeIAMAPI.Roles["test-role"] = &iam.Role{RoleName: aws.String("test-role")}
+			provider.Role = aws.String("test-role")
+			Expect(ProvisionerWithProvider(provisioner, provider).Validate(admissionCtx)).To(Succeed())
+		})
+		It("should not allow a role that doesn't exist", func() {
+			provider.Role = aws.String("missing-role")
+			Expect(ProvisionerWithProvider(provisioner, provider).Validate(admissionCtx)).ToNot(Succeed())
+		})
+		It("should not allow a launch template that doesn't exist", func() {
+			provider.LaunchTemplateName = aws.String("missing-launch-template")
+			provider.SecurityGroupSelector = nil
+			Expect(ProvisionerWithProvider(provisioner, provider).Validate(admissionCtx)).ToNot(Succeed())
+		})
+		It("should not allow an AMI family without AMIs for the allowed architectures", func() {
+			fakeSSMAPI.WantErr = awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+			Expect(provisioner.Validate(admissionCtx)).ToNot(Succeed())
+		})
+		It("should not allow requirements that no instance types satisfy", func() {
+			provisioner.Spec.Requirements = v1alpha5.NewRequirements(
+				v1.NodeSelectorRequirement{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown-instance-type"}},
+			)
+			Expect(provisioner.Validate(admissionCtx)).ToNot(Succeed())
+		})
+		It("should ignore errors that don't mean resources are missing", func() {
+			fakeIAMAPI.WantErr = awserr.New("AccessDenied", "access denied", nil)
+			delete(fakeIAMAPI.InstanceProfiles, "test-instance-profile")
+			Expect(provisioner.Validate(admissionCtx)).To(Succeed())
+		})
+	})
 })
 
 // ExpectTags verifies that the expected tags are a subset of the tags found
@@ -0,0 +1,190 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+// ProviderVerificationTimeout bounds the AWS API calls made to verify a provider at admission, which must complete
+// well within the webhook's timeout
+const ProviderVerificationTimeout = 5 * time.Second
+
+// ProviderVerifier checks that the AWS resources a provider refers to exist, so that misconfigured provisioners are
+// rejected at admission rather than when they first launch instances. Only missing resources are rejected. Other
+// errors, like throttling or missing permissions, are logged and ignored so that the availability of AWS APIs never
+// blocks changes to provisioners.
+type ProviderVerifier struct {
+	ec2api                 ec2iface.EC2API
+	iamapi                 iamiface.IAMAPI
+	subnetProvider         *SubnetProvider
+	instanceTypeProvider   *InstanceTypeProvider
+	launchTemplateProvider *LaunchTemplateProvider
+}
+
+func NewProviderVerifier(ec2api ec2iface.EC2API, iamapi iamiface.IAMAPI, subnetProvider *SubnetProvider, instanceTypeProvider *InstanceTypeProvider, launchTemplateProvider *LaunchTemplateProvider) *ProviderVerifier {
+	return &ProviderVerifier{
+		ec2api:                 ec2api,
+		iamapi:                 iamapi,
+		subnetProvider:         subnetProvider,
+		instanceTypeProvider:   instanceTypeProvider,
+		launchTemplateProvider: launchTemplateProvider,
+	}
+}
+
+// Verify the constraints of a provisioner that is being created or updated. Verification only happens at admission,
+// and updates are only verified if they change the constraints.
+func (v *ProviderVerifier) Verify(ctx context.Context, constraints *v1alpha1.Constraints) (errs *apis.FieldError) {
+	if !apis.IsInCreate(ctx) && !apis.IsInUpdate(ctx) {
+		return nil
+	}
+	if baseline, ok := apis.GetBaseline(ctx).(*v1alpha5.Provisioner); ok && equality.Semantic.DeepEqual(&baseline.Spec.Constraints, constraints.Constraints) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, ProviderVerificationTimeout)
+	defer cancel()
+	errs = errs.Also(v.verifySubnets(ctx, constraints))
+	if constraints.LaunchTemplateName != nil {
+		errs = errs.Also(v.verifyLaunchTemplate(ctx, constraints)).ViaField("provider")
+		if constraints.ManagesLaunchTemplateField(v1alpha1.LaunchTemplateFieldAMI) {
+			errs = errs.Also(v.verifyAMIs(ctx, constraints))
+		}
+		return errs
+	}
+	return errs.Also(
+		v.verifySecurityGroups(ctx, constraints),
+		v.verifyInstanceProfile(ctx, constraints),
+	).ViaField("provider").Also(
+		v.verifyAMIs(ctx, constraints),
+	)
+}
+
+func (v *ProviderVerifier) verifySubnets(ctx context.Context, constraints *v1alpha1.Constraints) *apis.FieldError {
+	_, err := v.subnetProvider.Get(ctx, constraints.AWS)
+	return v.fieldError(ctx, err, "subnetSelector")
+}
+
+func (v *ProviderVerifier) verifySecurityGroups(ctx context.Context, constraints *v1alpha1.Constraints) *apis.FieldError {
+	_, err := v.launchTemplateProvider.securityGroupProvider.Get(ctx, constraints)
+	return v.fieldError(ctx, err, "securityGroupSelector")
+}
+
+func (v *ProviderVerifier) verifyLaunchTemplate(ctx context.Context, constraints *v1alpha1.Constraints) *apis.FieldError {
+	_, err := v.ec2api.DescribeLaunchTemplatesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateNames: []*string{constraints.LaunchTemplateName},
+	})
+	if err != nil {
+		err = fmt.Errorf("describing launch template %s, %w", aws.StringValue(constraints.LaunchTemplateName), err)
+	}
+	return v.fieldError(ctx, err, "launchTemplate")
+}
+
+// verifyInstanceProfile checks the instance profile that instances will use. Instance profiles that Karpenter manages
+// for a role aren't created until launch, so only the role is checked.
+func (v *ProviderVerifier) verifyInstanceProfile(ctx context.Context, constraints *v1alpha1.Constraints) *apis.FieldError {
+	if constraints.Role != nil {
+		_, err := v.iamapi.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: constraints.Role})
+		if err != nil {
+			err = fmt.Errorf("getting role %s, %w", aws.StringValue(constraints.Role), err)
+		}
+		return v.fieldError(ctx, err, "role")
+	}
+	instanceProfile := aws.StringValue(constraints.InstanceProfile)
+	if instanceProfile == "" {
+		instanceProfile = injection.GetOptions(ctx).AWSDefaultInstanceProfile
+	}
+	if instanceProfile == "" {
+		return nil
+	}
+	_, err := v.iamapi.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(instanceProfile)})
+	if err != nil {
+		err = fmt.Errorf("getting instance profile %s, %w", instanceProfile, err)
+	}
+	return v.fieldError(ctx, err, "instanceProfile")
+}
+
+// verifyAMIs checks that the AMI family has an AMI for every instance type that the requirements allow, which catches
+// combinations of AMI family and architecture that can never launch.
+func (v *ProviderVerifier) verifyAMIs(ctx context.Context, constraints *v1alpha1.Constraints) *apis.FieldError {
+	instanceTypes, err := v.instanceTypeProvider.Get(ctx, constraints.AWS)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("Unable to verify AMIs, %s", err)
+		return nil
+	}
+	var compatible []cloudprovider.InstanceType
+	for _, instanceType := range instanceTypes {
+		if isCompatible(constraints, instanceType) {
+			compatible = append(compatible, instanceType)
+		}
+	}
+	if len(compatible) == 0 {
+		return apis.ErrGeneric("no instance types satisfy the requirements", "requirements")
+	}
+	kubernetesVersion, err := v.launchTemplateProvider.kubeServerVersion(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("Unable to verify AMIs, %s", err)
+		return nil
+	}
+	return v.fieldError(ctx, v.launchTemplateProvider.amiFamily.Verify(ctx, constraints, compatible, kubernetesVersion), "amiFamily").ViaField("provider")
+}
+
+// fieldError rejects the field if the error means that the resource doesn't exist. AWS API errors that don't, like
+// throttling or access denied, are ignored.
+func (v *ProviderVerifier) fieldError(ctx context.Context, err error, path string) *apis.FieldError {
+	if err == nil {
+		return nil
+	}
+	var awsError awserr.Error
+	if errors.As(err, &awsError) && !isNotFound(err) {
+		logging.FromContext(ctx).Warnf("Unable to verify %s, %s", path, err)
+		return nil
+	}
+	return apis.ErrGeneric(err.Error(), path)
+}
+
+// isCompatible returns true if the instance type's architecture and name are allowed by the constraints
+func isCompatible(constraints *v1alpha1.Constraints, instanceType cloudprovider.InstanceType) bool {
+	for key, value := range map[string]string{
+		v1.LabelArchStable:         instanceType.Architecture(),
+		v1.LabelInstanceTypeStable: instanceType.Name(),
+	} {
+		if label, ok := constraints.Labels[key]; ok && label != value {
+			return false
+		}
+		if !constraints.Requirements.Get(key).Has(value) {
+			return false
+		}
+	}
+	return true
+}
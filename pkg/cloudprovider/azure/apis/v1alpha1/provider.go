@@ -0,0 +1,97 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+// Constraints wraps generic constraints with Azure specific parameters
+type Constraints struct {
+	*v1alpha5.Constraints
+	*Azure
+}
+
+// Azure contains parameters specific to this cloud provider
+// +kubebuilder:object:root=true
+type Azure struct {
+	// TypeMeta includes version and kind of the extensions, inferred if not provided.
+	// +optional
+	metav1.TypeMeta `json:",inline"`
+	// ResourceGroup that virtual machines are launched in. Defaults to the
+	// controller's --azure-resource-group, typically the cluster's node
+	// resource group.
+	// +optional
+	ResourceGroup *string `json:"resourceGroup,omitempty"`
+	// SubnetID is the resource ID of the subnet that virtual machines' network
+	// interfaces are attached to.
+	SubnetID *string `json:"subnetID,omitempty"`
+	// ImageID is the resource ID of the image, or shared image gallery image
+	// version, that virtual machines are launched from.
+	ImageID *string `json:"imageID,omitempty"`
+	// CustomData is the base64 encoded cloud-init data that bootstraps
+	// virtual machines into the cluster.
+	// +optional
+	CustomData *string `json:"customData,omitempty"`
+	// AdminUsername is the name of the administrator account of virtual
+	// machines. Defaults to azureuser.
+	// +optional
+	AdminUsername *string `json:"adminUsername,omitempty"`
+	// SSHPublicKey is authorized for the administrator account. Password
+	// authentication is disabled.
+	SSHPublicKey *string `json:"sshPublicKey,omitempty"`
+	// OSDiskSizeGB is the size of the OS disk. Defaults to the size of the image.
+	// +optional
+	OSDiskSizeGB *int32 `json:"osDiskSizeGB,omitempty"`
+	// UserAssignedIdentities are the resource IDs of managed identities that
+	// are assigned to virtual machines, e.g. the cluster's kubelet identity.
+	// +optional
+	UserAssignedIdentities []string `json:"userAssignedIdentities,omitempty"`
+	// Tags to be applied on Azure resources like virtual machines and network interfaces.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func Deserialize(constraints *v1alpha5.Constraints) (*Constraints, error) {
+	if constraints.Provider == nil {
+		return nil, fmt.Errorf("invariant violated: spec.provider is not defined. Is the defaulting webhook installed?")
+	}
+	azure := &Azure{}
+	_, gvk, err := Codec.UniversalDeserializer().Decode(constraints.Provider.Raw, nil, azure)
+	if err != nil {
+		return nil, err
+	}
+	if gvk != nil {
+		azure.SetGroupVersionKind(*gvk)
+	}
+	return &Constraints{constraints, azure}, nil
+}
+
+func (a *Azure) Serialize(constraints *v1alpha5.Constraints) error {
+	if constraints.Provider == nil {
+		return fmt.Errorf("invariant violated: spec.provider is not defined. Is the defaulting webhook installed?")
+	}
+	bytes, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	constraints.Provider.Raw = bytes
+	return nil
+}
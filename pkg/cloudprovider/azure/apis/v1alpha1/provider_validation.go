@@ -0,0 +1,98 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	"knative.dev/pkg/apis"
+)
+
+const (
+	subnetIDPath               = "subnetID"
+	imageIDPath                = "imageID"
+	sshPublicKeyPath           = "sshPublicKey"
+	osDiskSizeGBPath           = "osDiskSizeGB"
+	userAssignedIdentitiesPath = "userAssignedIdentities"
+	tagsPath                   = "tags"
+)
+
+func (a *Azure) Validate() (errs *apis.FieldError) {
+	return a.validate().ViaField("provider")
+}
+
+func (a *Azure) validate() (errs *apis.FieldError) {
+	return errs.Also(
+		validateResourceID(a.SubnetID, subnetIDPath),
+		validateResourceID(a.ImageID, imageIDPath),
+		a.validateSSHPublicKey(),
+		a.validateOSDiskSizeGB(),
+		a.validateUserAssignedIdentities(),
+		a.validateTags(),
+	)
+}
+
+func validateResourceID(id *string, path string) (errs *apis.FieldError) {
+	if id == nil {
+		return errs.Also(apis.ErrMissingField(path))
+	}
+	if !isResourceID(*id) {
+		return errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%q, must be an Azure resource ID", *id), path))
+	}
+	return errs
+}
+
+func (a *Azure) validateSSHPublicKey() (errs *apis.FieldError) {
+	if a.SSHPublicKey == nil || *a.SSHPublicKey == "" {
+		return errs.Also(apis.ErrMissingField(sshPublicKeyPath))
+	}
+	return errs
+}
+
+func (a *Azure) validateOSDiskSizeGB() (errs *apis.FieldError) {
+	if a.OSDiskSizeGB != nil && *a.OSDiskSizeGB <= 0 {
+		return errs.Also(apis.ErrInvalidValue("must be positive", osDiskSizeGBPath))
+	}
+	return errs
+}
+
+func (a *Azure) validateUserAssignedIdentities() (errs *apis.FieldError) {
+	for i, id := range a.UserAssignedIdentities {
+		if !isResourceID(id) {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%q, must be an Azure resource ID", id), userAssignedIdentitiesPath, i))
+		}
+	}
+	return errs
+}
+
+func (a *Azure) validateTags() (errs *apis.FieldError) {
+	for tagKey, tagValue := range a.Tags {
+		if tagKey == "" {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf(
+				"the tag with key : '' and value : '%s' is invalid because empty tag keys aren't supported", tagValue), tagsPath))
+		}
+		if strings.ContainsAny(tagKey, "<>%&\\?/") {
+			errs = errs.Also(apis.ErrInvalidKeyName(tagKey, tagsPath, "tag keys may not contain <, >, %, &, \\, ?, or /"))
+		}
+	}
+	return errs
+}
+
+// isResourceID returns true if the id looks like an Azure resource ID, e.g.
+// /subscriptions/<id>/resourceGroups/<name>/providers/<namespace>/<type>/<name>
+func isResourceID(id string) bool {
+	return strings.HasPrefix(strings.ToLower(id), "/subscriptions/") && strings.Contains(strings.ToLower(id), "/providers/")
+}
@@ -0,0 +1,45 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+var (
+	CapacityTypeSpot         = "spot"
+	CapacityTypeOnDemand     = "on-demand"
+	AzureToKubeArchitectures = map[string]string{
+		"x64":   v1alpha5.ArchitectureAmd64,
+		"Arm64": v1alpha5.ArchitectureArm64,
+	}
+	AzureRestrictedLabelDomains = []string{
+		"kubernetes.azure.com",
+	}
+)
+
+var (
+	Scheme = runtime.NewScheme()
+	Codec  = serializer.NewCodecFactory(Scheme, serializer.EnableStrict)
+)
+
+func init() {
+	Scheme.AddKnownTypes(schema.GroupVersion{Group: v1alpha5.ExtensionsGroup, Version: "v1alpha1"}, &Azure{})
+	v1alpha5.RestrictedLabelDomains = v1alpha5.RestrictedLabelDomains.Insert(AzureRestrictedLabelDomains...)
+}
@@ -0,0 +1,44 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+var (
+	// ProvisionerNameTagKey is karpenter.sh/provisioner-name, since Azure tag keys can't contain /
+	ProvisionerNameTagKey = strings.ReplaceAll(v1alpha5.ProvisionerNameLabelKey, "/", "_")
+	// ClusterNameTagKey is karpenter.sh/cluster, since Azure tag keys can't contain /
+	ClusterNameTagKey = v1alpha5.Group + "_cluster"
+)
+
+func MergeTags(ctx context.Context, custom ...map[string]string) map[string]string {
+	tags := map[string]string{
+		// karpenter.sh_provisioner-name: <provisioner-name>
+		ProvisionerNameTagKey: injection.GetNamespacedName(ctx).Name,
+		// karpenter.sh_cluster: <cluster-name>
+		ClusterNameTagKey: injection.GetOptions(ctx).ClusterName,
+	}
+	for _, t := range custom {
+		tags = functional.UnionStringMaps(tags, t)
+	}
+	return tags
+}
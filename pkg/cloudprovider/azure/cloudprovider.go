@@ -0,0 +1,172 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/compute"
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+const (
+	// Name of the cloud provider
+	Name = "azure"
+	// CacheCleanupInterval triggers cache cleanup (lazy eviction) at this interval.
+	CacheCleanupInterval = 10 * time.Minute
+	// requestTimeout bounds calls to the Azure Resource Manager and Instance Metadata Service
+	requestTimeout = time.Minute
+)
+
+type CloudProvider struct {
+	instanceTypeProvider *InstanceTypeProvider
+	instanceProvider     *InstanceProvider
+}
+
+// NewCloudProvider authenticates with the managed identity of the host.
+// The subscription, location and resource group are discovered from the
+// Instance Metadata Service unless they're configured.
+func NewCloudProvider(ctx context.Context, options cloudprovider.Options) *CloudProvider {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(Name))
+	opts := injection.GetOptions(ctx)
+	httpClient := &http.Client{Timeout: requestTimeout}
+	subscriptionID, location, resourceGroup := opts.AzureSubscriptionID, opts.AzureLocation, opts.AzureResourceGroup
+	if subscriptionID == "" || location == "" || resourceGroup == "" {
+		logging.FromContext(ctx).Debug("Azure subscription, location or resource group not configured, asking Instance Metadata Service")
+		metadata, err := compute.GetInstanceMetadata(ctx, httpClient)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to call the instance metadata service, %s", err))
+		}
+		if subscriptionID == "" {
+			subscriptionID = metadata.SubscriptionID
+		}
+		if location == "" {
+			location = metadata.Location
+		}
+		if resourceGroup == "" {
+			resourceGroup = metadata.ResourceGroupName
+		}
+	}
+	logging.FromContext(ctx).Debugf("Using Azure subscription %s, location %s, resource group %s", subscriptionID, location, resourceGroup)
+	return newCloudProvider(compute.NewResourceManager(httpClient, subscriptionID, compute.NewManagedIdentityTokens(httpClient, opts.AzureClientID)), location, resourceGroup)
+}
+
+func newCloudProvider(computeAPI compute.API, location string, resourceGroup string) *CloudProvider {
+	instanceTypeProvider := NewInstanceTypeProvider(computeAPI, location)
+	return &CloudProvider{
+		instanceTypeProvider: instanceTypeProvider,
+		instanceProvider:     NewInstanceProvider(computeAPI, instanceTypeProvider, location, resourceGroup),
+	}
+}
+
+// Create nodes for the node requests. Virtual machines are launched
+// individually, so node requests are not grouped.
+func (c *CloudProvider) Create(ctx context.Context, nodeRequests []*cloudprovider.NodeRequest, callback func(*cloudprovider.NodeRequest, *v1.Node) error) error {
+	errs := make([]error, len(nodeRequests))
+	workqueue.ParallelizeUntil(ctx, len(nodeRequests), len(nodeRequests), func(i int) {
+		errs[i] = c.create(ctx, nodeRequests[i], callback)
+	})
+	return multierr.Combine(errs...)
+}
+
+func (c *CloudProvider) create(ctx context.Context, nodeRequest *cloudprovider.NodeRequest, callback func(*cloudprovider.NodeRequest, *v1.Node) error) error {
+	vendorConstraints, err := v1alpha1.Deserialize(nodeRequest.Constraints)
+	if err != nil {
+		return err
+	}
+	// Create will only return an error if zero nodes could be launched.
+	// Partial fulfillment will be logged
+	nodes, err := c.instanceProvider.Create(ctx, vendorConstraints, nodeRequest.InstanceTypeOptions, nodeRequest.Quantity)
+	if err != nil {
+		return fmt.Errorf("launching virtual machines, %w", err)
+	}
+	var errs error
+	for _, node := range nodes {
+		errs = multierr.Append(errs, callback(nodeRequest, node))
+	}
+	return errs
+}
+
+// GetInstanceTypes returns all available InstanceTypes in the location
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provider *v1alpha5.Provider) ([]cloudprovider.InstanceType, error) {
+	vendorConstraints, err := v1alpha1.Deserialize(&v1alpha5.Constraints{Provider: provider})
+	if err != nil {
+		return nil, apis.ErrGeneric(err.Error())
+	}
+	return c.instanceTypeProvider.Get(ctx, vendorConstraints.Azure)
+}
+
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	return c.instanceProvider.Terminate(ctx, node)
+}
+
+// Cleanup is a no-op, since no resources are managed on behalf of provisioners
+func (c *CloudProvider) Cleanup(context.Context, string) error {
+	return nil
+}
+
+// ValidateInPlaceUpdate returns ErrInPlaceUpdateUnsupported, since nodes are replaced instead
+func (c *CloudProvider) ValidateInPlaceUpdate(context.Context, *v1.Node) error {
+	return cloudprovider.ErrInPlaceUpdateUnsupported
+}
+
+// UpdateInPlace is not supported, so nodes are replaced instead
+func (c *CloudProvider) UpdateInPlace(context.Context, *v1.Node) (string, error) {
+	return "", cloudprovider.ErrInPlaceUpdateUnsupported
+}
+
+// GetInPlaceUpdate is not supported
+func (c *CloudProvider) GetInPlaceUpdate(context.Context, *v1.Node, string) (cloudprovider.InPlaceUpdateStatus, error) {
+	return "", cloudprovider.ErrInPlaceUpdateUnsupported
+}
+
+// Validate the provisioner
+func (c *CloudProvider) Validate(ctx context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return apis.ErrGeneric(err.Error())
+	}
+	return vendorConstraints.Azure.Validate()
+}
+
+// Default the provisioner
+func (c *CloudProvider) Default(ctx context.Context, constraints *v1alpha5.Constraints) {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to deserialize provider, %s", err)
+		return
+	}
+	vendorConstraints.Default(ctx)
+	if err := vendorConstraints.Serialize(constraints); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to serialize provider, %s", err)
+	}
+}
+
+// Name returns the CloudProvider implementation name.
+func (c *CloudProvider) Name() string {
+	return Name
+}
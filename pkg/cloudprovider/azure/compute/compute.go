@@ -0,0 +1,222 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// API is the subset of the Azure Resource Manager API used to launch
+// and terminate virtual machines
+type API interface {
+	// ListResourceSKUs returns the SKUs available to the subscription in the location
+	ListResourceSKUs(ctx context.Context, location string) ([]*ResourceSKU, error)
+	// CreateNetworkInterface creates or updates a network interface
+	CreateNetworkInterface(ctx context.Context, resourceGroup string, name string, networkInterface *NetworkInterface) (*NetworkInterface, error)
+	// DeleteNetworkInterface deletes a network interface
+	DeleteNetworkInterface(ctx context.Context, resourceGroup string, name string) error
+	// CreateVirtualMachine creates or updates a virtual machine
+	CreateVirtualMachine(ctx context.Context, resourceGroup string, name string, virtualMachine *VirtualMachine) (*VirtualMachine, error)
+	// DeleteVirtualMachine deletes a virtual machine, and its network
+	// interfaces and disks that are configured to be deleted with it
+	DeleteVirtualMachine(ctx context.Context, resourceGroup string, name string) error
+}
+
+// ResourceSKU describes a SKU, see
+// https://docs.microsoft.com/en-us/rest/api/compute/resource-skus/list
+type ResourceSKU struct {
+	Name         string                   `json:"name"`
+	ResourceType string                   `json:"resourceType"`
+	Family       string                   `json:"family,omitempty"`
+	Locations    []string                 `json:"locations,omitempty"`
+	LocationInfo []ResourceSKULocation    `json:"locationInfo,omitempty"`
+	Capabilities []ResourceSKUCapability  `json:"capabilities,omitempty"`
+	Restrictions []ResourceSKURestriction `json:"restrictions,omitempty"`
+}
+
+type ResourceSKULocation struct {
+	Location string   `json:"location"`
+	Zones    []string `json:"zones,omitempty"`
+}
+
+type ResourceSKUCapability struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ResourceSKURestriction struct {
+	Type            string                     `json:"type"`
+	Values          []string                   `json:"values,omitempty"`
+	RestrictionInfo ResourceSKURestrictionInfo `json:"restrictionInfo,omitempty"`
+	ReasonCode      string                     `json:"reasonCode,omitempty"`
+}
+
+type ResourceSKURestrictionInfo struct {
+	Locations []string `json:"locations,omitempty"`
+	Zones     []string `json:"zones,omitempty"`
+}
+
+// NetworkInterface, see
+// https://docs.microsoft.com/en-us/rest/api/virtualnetwork/network-interfaces/create-or-update
+type NetworkInterface struct {
+	ID         string                     `json:"id,omitempty"`
+	Name       string                     `json:"name,omitempty"`
+	Location   string                     `json:"location"`
+	Tags       map[string]string          `json:"tags,omitempty"`
+	Properties NetworkInterfaceProperties `json:"properties"`
+}
+
+type NetworkInterfaceProperties struct {
+	IPConfigurations []IPConfiguration `json:"ipConfigurations"`
+}
+
+type IPConfiguration struct {
+	Name       string                    `json:"name"`
+	Properties IPConfigurationProperties `json:"properties"`
+}
+
+type IPConfigurationProperties struct {
+	Primary                   bool        `json:"primary"`
+	PrivateIPAddress          string      `json:"privateIPAddress,omitempty"`
+	PrivateIPAllocationMethod string      `json:"privateIPAllocationMethod"`
+	Subnet                    SubResource `json:"subnet"`
+}
+
+type SubResource struct {
+	ID string `json:"id"`
+}
+
+// VirtualMachine, see
+// https://docs.microsoft.com/en-us/rest/api/compute/virtual-machines/create-or-update
+type VirtualMachine struct {
+	ID         string                   `json:"id,omitempty"`
+	Name       string                   `json:"name,omitempty"`
+	Location   string                   `json:"location"`
+	Zones      []string                 `json:"zones,omitempty"`
+	Tags       map[string]string        `json:"tags,omitempty"`
+	Identity   *VirtualMachineIdentity  `json:"identity,omitempty"`
+	Properties VirtualMachineProperties `json:"properties"`
+}
+
+type VirtualMachineIdentity struct {
+	Type                   string              `json:"type"`
+	UserAssignedIdentities map[string]struct{} `json:"userAssignedIdentities,omitempty"`
+}
+
+type VirtualMachineProperties struct {
+	HardwareProfile   HardwareProfile `json:"hardwareProfile"`
+	StorageProfile    StorageProfile  `json:"storageProfile"`
+	OSProfile         OSProfile       `json:"osProfile"`
+	NetworkProfile    NetworkProfile  `json:"networkProfile"`
+	Priority          string          `json:"priority,omitempty"`
+	EvictionPolicy    string          `json:"evictionPolicy,omitempty"`
+	BillingProfile    *BillingProfile `json:"billingProfile,omitempty"`
+	ProvisioningState string          `json:"provisioningState,omitempty"`
+}
+
+type HardwareProfile struct {
+	VMSize string `json:"vmSize"`
+}
+
+type StorageProfile struct {
+	ImageReference SubResource `json:"imageReference"`
+	OSDisk         OSDisk      `json:"osDisk"`
+}
+
+type OSDisk struct {
+	CreateOption string `json:"createOption"`
+	DeleteOption string `json:"deleteOption,omitempty"`
+	DiskSizeGB   *int32 `json:"diskSizeGB,omitempty"`
+}
+
+type OSProfile struct {
+	ComputerName       string             `json:"computerName"`
+	AdminUsername      string             `json:"adminUsername"`
+	CustomData         string             `json:"customData,omitempty"`
+	LinuxConfiguration LinuxConfiguration `json:"linuxConfiguration"`
+}
+
+type LinuxConfiguration struct {
+	DisablePasswordAuthentication bool             `json:"disablePasswordAuthentication"`
+	SSH                           SSHConfiguration `json:"ssh"`
+}
+
+type SSHConfiguration struct {
+	PublicKeys []SSHPublicKey `json:"publicKeys"`
+}
+
+type SSHPublicKey struct {
+	Path    string `json:"path"`
+	KeyData string `json:"keyData"`
+}
+
+type NetworkProfile struct {
+	NetworkInterfaces []NetworkInterfaceReference `json:"networkInterfaces"`
+}
+
+type NetworkInterfaceReference struct {
+	ID         string                              `json:"id"`
+	Properties NetworkInterfaceReferenceProperties `json:"properties"`
+}
+
+type NetworkInterfaceReferenceProperties struct {
+	Primary      bool   `json:"primary"`
+	DeleteOption string `json:"deleteOption,omitempty"`
+}
+
+type BillingProfile struct {
+	MaxPrice float64 `json:"maxPrice"`
+}
+
+// Error is returned by the Azure Resource Manager API, see
+// https://docs.microsoft.com/en-us/azure/azure-resource-manager/management/common-deployment-errors
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// capacityErrorCodes are returned when a SKU can't be allocated in a zone
+var capacityErrorCodes = map[string]struct{}{
+	"SkuNotAvailable":                       {},
+	"AllocationFailed":                      {},
+	"ZonalAllocationFailed":                 {},
+	"OverconstrainedAllocationRequest":      {},
+	"OverconstrainedZonalAllocationRequest": {},
+	"OperationNotAllowed":                   {}, // Returned when the subscription's quota is exceeded
+}
+
+// IsNotFound returns true if the resource doesn't exist
+func IsNotFound(err error) bool {
+	var azureErr *Error
+	return errors.As(err, &azureErr) && azureErr.StatusCode == http.StatusNotFound
+}
+
+// IsCapacityError returns true if the SKU couldn't be allocated, e.g. in the zone
+func IsCapacityError(err error) bool {
+	var azureErr *Error
+	if !errors.As(err, &azureErr) {
+		return false
+	}
+	_, ok := capacityErrorCodes[azureErr.Code]
+	return ok
+}
@@ -0,0 +1,327 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compute
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/karpenter/pkg/utils/project"
+)
+
+const (
+	resourceManagerEndpoint = "https://management.azure.com"
+	imdsEndpoint            = "http://169.254.169.254/metadata"
+	skusAPIVersion          = "2021-07-01"
+	computeAPIVersion       = "2021-11-01"
+	networkAPIVersion       = "2021-05-01"
+	// tokenRefreshWindow is how long before expiry tokens are refreshed
+	tokenRefreshWindow = 5 * time.Minute
+	// defaultPollInterval is how often long-running operations are polled, unless they return Retry-After
+	defaultPollInterval = 5 * time.Second
+)
+
+// ResourceManager implements API with the Azure Resource Manager REST
+// API, authenticating with the managed identity of the host
+type ResourceManager struct {
+	httpClient     *http.Client
+	subscriptionID string
+	tokens         *ManagedIdentityTokens
+}
+
+func NewResourceManager(httpClient *http.Client, subscriptionID string, tokens *ManagedIdentityTokens) *ResourceManager {
+	return &ResourceManager{httpClient: httpClient, subscriptionID: subscriptionID, tokens: tokens}
+}
+
+func (r *ResourceManager) ListResourceSKUs(ctx context.Context, location string) ([]*ResourceSKU, error) {
+	skus := []*ResourceSKU{}
+	next := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Compute/skus?api-version=%s&$filter=%s",
+		resourceManagerEndpoint, r.subscriptionID, skusAPIVersion, url.QueryEscape(fmt.Sprintf("location eq '%s'", location)))
+	for next != "" {
+		page := struct {
+			Value    []*ResourceSKU `json:"value"`
+			NextLink string         `json:"nextLink"`
+		}{}
+		if err := r.do(ctx, http.MethodGet, next, nil, &page); err != nil {
+			return nil, err
+		}
+		skus = append(skus, page.Value...)
+		next = page.NextLink
+	}
+	return skus, nil
+}
+
+func (r *ResourceManager) CreateNetworkInterface(ctx context.Context, resourceGroup string, name string, networkInterface *NetworkInterface) (*NetworkInterface, error) {
+	created := &NetworkInterface{}
+	if err := r.do(ctx, http.MethodPut, r.resourceURL(resourceGroup, "Microsoft.Network/networkInterfaces", name, networkAPIVersion), networkInterface, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *ResourceManager) DeleteNetworkInterface(ctx context.Context, resourceGroup string, name string) error {
+	return r.do(ctx, http.MethodDelete, r.resourceURL(resourceGroup, "Microsoft.Network/networkInterfaces", name, networkAPIVersion), nil, nil)
+}
+
+func (r *ResourceManager) CreateVirtualMachine(ctx context.Context, resourceGroup string, name string, virtualMachine *VirtualMachine) (*VirtualMachine, error) {
+	created := &VirtualMachine{}
+	if err := r.do(ctx, http.MethodPut, r.resourceURL(resourceGroup, "Microsoft.Compute/virtualMachines", name, computeAPIVersion), virtualMachine, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *ResourceManager) DeleteVirtualMachine(ctx context.Context, resourceGroup string, name string) error {
+	return r.do(ctx, http.MethodDelete, r.resourceURL(resourceGroup, "Microsoft.Compute/virtualMachines", name, computeAPIVersion), nil, nil)
+}
+
+func (r *ResourceManager) resourceURL(resourceGroup string, resourceType string, name string, apiVersion string) string {
+	return fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/%s/%s?api-version=%s",
+		resourceManagerEndpoint, r.subscriptionID, resourceGroup, resourceType, name, apiVersion)
+}
+
+// do sends the request, waiting for any long-running operation that it starts
+// to complete, and decodes the result into out if it's not nil, see
+// https://docs.microsoft.com/en-us/azure/azure-resource-manager/management/async-operations.
+// Error responses and failed operations are returned as *Error.
+func (r *ResourceManager) do(ctx context.Context, method string, url string, in interface{}, out interface{}) error {
+	response, err := r.send(ctx, method, url, in)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := errorFor(response); err != nil {
+		return err
+	}
+	if operation := response.Header.Get("Azure-AsyncOperation"); operation != "" {
+		if err := r.pollAsyncOperation(ctx, operation, retryAfter(response)); err != nil {
+			return err
+		}
+		return r.result(ctx, method, url, out)
+	}
+	if location := response.Header.Get("Location"); location != "" && response.StatusCode == http.StatusAccepted {
+		if err := r.pollLocation(ctx, location, retryAfter(response)); err != nil {
+			return err
+		}
+		return r.result(ctx, method, url, out)
+	}
+	if out == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// result reads the resource once its long-running operation has completed,
+// since the operation's own responses don't include it
+func (r *ResourceManager) result(ctx context.Context, method string, url string, out interface{}) error {
+	if out == nil || method == http.MethodDelete {
+		return nil
+	}
+	return r.do(ctx, http.MethodGet, url, nil, out)
+}
+
+// pollAsyncOperation polls an Azure-AsyncOperation URL until the operation
+// reaches a terminal status, returning the operation's error if it didn't succeed
+func (r *ResourceManager) pollAsyncOperation(ctx context.Context, url string, interval time.Duration) error {
+	for {
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		}
+		response, err := r.send(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		operation := struct {
+			Status string       `json:"status"`
+			Error  errorDetails `json:"error"`
+		}{}
+		err = errorFor(response)
+		if err == nil {
+			err = json.NewDecoder(response.Body).Decode(&operation)
+		}
+		interval = retryAfter(response)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		switch operation.Status {
+		case "Succeeded":
+			return nil
+		case "Failed", "Canceled":
+			return &Error{StatusCode: response.StatusCode, Code: operation.Error.Code, Message: operation.Error.Message}
+		}
+	}
+}
+
+// pollLocation polls a Location URL until it stops returning 202 Accepted
+func (r *ResourceManager) pollLocation(ctx context.Context, url string, interval time.Duration) error {
+	for {
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		}
+		response, err := r.send(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		err = errorFor(response)
+		interval = retryAfter(response)
+		response.Body.Close()
+		if err != nil || response.StatusCode != http.StatusAccepted {
+			return err
+		}
+	}
+}
+
+func (r *ResourceManager) send(ctx context.Context, method string, url string, in interface{}) (*http.Response, error) {
+	token, err := r.tokens.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting token, %w", err)
+	}
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", fmt.Sprintf("karpenter.sh-%s", project.Version))
+	return r.httpClient.Do(request)
+}
+
+type errorDetails struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorFor returns an *Error if the response failed
+func errorFor(response *http.Response) error {
+	if response.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	failure := struct {
+		Error errorDetails `json:"error"`
+	}{}
+	// The error body is best effort, the status code is always returned
+	_ = json.NewDecoder(response.Body).Decode(&failure)
+	return &Error{StatusCode: response.StatusCode, Code: failure.Error.Code, Message: failure.Error.Message}
+}
+
+// retryAfter returns how long to wait before polling again
+func retryAfter(response *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultPollInterval
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
+
+// ManagedIdentityTokens gets and caches tokens for the Azure Resource Manager
+// from the Instance Metadata Service, see
+// https://docs.microsoft.com/en-us/azure/active-directory/managed-identities-azure-resources/how-to-use-vm-token
+type ManagedIdentityTokens struct {
+	mu         sync.Mutex
+	httpClient *http.Client
+	// clientID selects a user assigned identity, if the host has several
+	clientID  string
+	token     string
+	expiresOn time.Time
+}
+
+func NewManagedIdentityTokens(httpClient *http.Client, clientID string) *ManagedIdentityTokens {
+	return &ManagedIdentityTokens{httpClient: httpClient, clientID: clientID}
+}
+
+// Get returns a cached token, refreshing it if it's about to expire
+func (t *ManagedIdentityTokens) Get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Add(tokenRefreshWindow).Before(t.expiresOn) {
+		return t.token, nil
+	}
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {resourceManagerEndpoint + "/"}}
+	if t.clientID != "" {
+		query.Set("client_id", t.clientID)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}{}
+	if err := getInstanceMetadataService(ctx, t.httpClient, "/identity/oauth2/token?"+query.Encode(), &token); err != nil {
+		return "", err
+	}
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("parsing token expiry %s, %w", token.ExpiresOn, err)
+	}
+	t.token = token.AccessToken
+	t.expiresOn = time.Unix(expiresOn, 0)
+	return t.token, nil
+}
+
+// InstanceMetadata describes the virtual machine that the controller is running on
+type InstanceMetadata struct {
+	SubscriptionID    string `json:"subscriptionId"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	Location          string `json:"location"`
+}
+
+// GetInstanceMetadata from the Instance Metadata Service
+func GetInstanceMetadata(ctx context.Context, httpClient *http.Client) (*InstanceMetadata, error) {
+	metadata := &InstanceMetadata{}
+	if err := getInstanceMetadataService(ctx, httpClient, "/instance/compute?api-version=2021-02-01", metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func getInstanceMetadataService(ctx context.Context, httpClient *http.Client, path string, out interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsEndpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Metadata", "true")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("calling instance metadata service, %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("calling instance metadata service, got status %d", response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
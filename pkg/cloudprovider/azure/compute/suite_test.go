@@ -0,0 +1,166 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compute_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/compute"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var server *httptest.Server
+var resourceManager *compute.ResourceManager
+
+// handlers respond to requests by method and path, in order
+var handlers map[string][]http.HandlerFunc
+var mu sync.Mutex
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/Azure/Compute")
+}
+
+var _ = BeforeSuite(func() {
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/metadata/identity/oauth2/token") {
+			Expect(json.NewEncoder(w).Encode(map[string]string{
+				"access_token": "test-token",
+				"expires_on":   fmt.Sprint(time.Now().Add(time.Hour).Unix()),
+			})).To(Succeed())
+			return
+		}
+		mu.Lock()
+		key := r.Method + " " + r.URL.Path
+		queue := handlers[key]
+		if len(queue) == 0 {
+			mu.Unlock()
+			Fail("unexpected request " + key)
+			return
+		}
+		handlers[key] = queue[1:]
+		mu.Unlock()
+		queue[0](w, r)
+	}))
+	target, err := url.Parse(server.URL)
+	Expect(err).ToNot(HaveOccurred())
+	httpClient := &http.Client{Transport: rewrite{target: target}}
+	resourceManager = compute.NewResourceManager(httpClient, "test-subscription", compute.NewManagedIdentityTokens(httpClient, ""))
+})
+
+var _ = AfterSuite(func() {
+	server.Close()
+})
+
+var _ = BeforeEach(func() {
+	handlers = map[string][]http.HandlerFunc{}
+})
+
+var _ = AfterEach(func() {
+	for key, queue := range handlers {
+		Expect(queue).To(BeEmpty(), "expected request "+key)
+	}
+})
+
+const (
+	virtualMachinePath   = "/subscriptions/test-subscription/resourceGroups/test-resource-group/providers/Microsoft.Compute/virtualMachines/test-vm"
+	networkInterfacePath = "/subscriptions/test-subscription/resourceGroups/test-resource-group/providers/Microsoft.Network/networkInterfaces/test-nic"
+	operationPath        = "/subscriptions/test-subscription/providers/Microsoft.Compute/locations/test-location/operations/test-operation"
+)
+
+var _ = Describe("ResourceManager", func() {
+	It("should wait for virtual machines to be created", func() {
+		Handle(http.MethodPut, virtualMachinePath, Respond(http.StatusCreated, map[string]string{"Azure-AsyncOperation": server.URL + operationPath}, `{"properties":{"provisioningState":"Creating"}}`))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusOK, nil, `{"status":"InProgress"}`))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusOK, nil, `{"status":"Succeeded"}`))
+		Handle(http.MethodGet, virtualMachinePath, Respond(http.StatusOK, nil, `{"name":"test-vm","properties":{"provisioningState":"Succeeded"}}`))
+		virtualMachine, err := resourceManager.CreateVirtualMachine(ctx, "test-resource-group", "test-vm", &compute.VirtualMachine{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(virtualMachine.Properties.ProvisioningState).To(Equal("Succeeded"))
+	})
+	It("should return the errors of failed operations", func() {
+		Handle(http.MethodPut, virtualMachinePath, Respond(http.StatusCreated, map[string]string{"Azure-AsyncOperation": server.URL + operationPath}, `{}`))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusOK, nil, `{"status":"Failed","error":{"code":"ZonalAllocationFailed","message":"Allocation failed"}}`))
+		_, err := resourceManager.CreateVirtualMachine(ctx, "test-resource-group", "test-vm", &compute.VirtualMachine{})
+		Expect(compute.IsCapacityError(err)).To(BeTrue())
+	})
+	It("should wait for virtual machines to be deleted", func() {
+		Handle(http.MethodDelete, virtualMachinePath, Respond(http.StatusAccepted, map[string]string{"Azure-AsyncOperation": server.URL + operationPath}, ""))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusOK, nil, `{"status":"InProgress"}`))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusOK, nil, `{"status":"Succeeded"}`))
+		Expect(resourceManager.DeleteVirtualMachine(ctx, "test-resource-group", "test-vm")).To(Succeed())
+	})
+	It("should wait for operations that only return a location", func() {
+		Handle(http.MethodDelete, networkInterfacePath, Respond(http.StatusAccepted, map[string]string{"Location": server.URL + operationPath}, ""))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusAccepted, nil, ""))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusNoContent, nil, ""))
+		Expect(resourceManager.DeleteNetworkInterface(ctx, "test-resource-group", "test-nic")).To(Succeed())
+	})
+	It("should return the errors of failed locations", func() {
+		Handle(http.MethodDelete, networkInterfacePath, Respond(http.StatusAccepted, map[string]string{"Location": server.URL + operationPath}, ""))
+		Handle(http.MethodGet, operationPath, Respond(http.StatusBadRequest, nil, `{"error":{"code":"NicInUse","message":"test error"}}`))
+		err := resourceManager.DeleteNetworkInterface(ctx, "test-resource-group", "test-nic")
+		Expect(err).To(Equal(&compute.Error{StatusCode: http.StatusBadRequest, Code: "NicInUse", Message: "test error"}))
+	})
+	It("should return immediately for synchronous responses", func() {
+		Handle(http.MethodDelete, networkInterfacePath, Respond(http.StatusNotFound, nil, `{"error":{"code":"NotFound"}}`))
+		Expect(compute.IsNotFound(resourceManager.DeleteNetworkInterface(ctx, "test-resource-group", "test-nic"))).To(BeTrue())
+	})
+})
+
+// rewrite sends requests for the Azure endpoints to the test server
+type rewrite struct {
+	target *url.URL
+}
+
+func (r rewrite) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.URL.Scheme = r.target.Scheme
+	request.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func Handle(method string, path string, handler http.HandlerFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	key := method + " " + path
+	handlers[key] = append(handlers[key], handler)
+}
+
+// Respond with the status, headers and body, polling again without delay
+func Respond(status int, headers map[string]string, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		for key, value := range headers {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/compute"
+)
+
+const (
+	SubscriptionID = "test-subscription"
+	Location       = "test-location"
+)
+
+// CapacityPool is an instance type and zone that fails to allocate
+type CapacityPool struct {
+	InstanceType string
+	Zone         string
+	Spot         bool
+}
+
+// ComputeBehavior must be reset between tests otherwise tests will
+// pollute each other.
+type ComputeBehavior struct {
+	ResourceSKUs              []*compute.ResourceSKU
+	InsufficientCapacityPools []CapacityPool
+	// CreateVirtualMachineError is returned by CreateVirtualMachine if set
+	CreateVirtualMachineError error
+	NetworkInterfaces         sync.Map
+	VirtualMachines           sync.Map
+	// CalledWithCreateVirtualMachine records every virtual machine requested, including failed requests
+	CalledWithCreateVirtualMachine []*compute.VirtualMachine
+	mu                             sync.Mutex
+}
+
+type ComputeAPI struct {
+	ComputeBehavior
+}
+
+// Reset must be called between tests otherwise tests will pollute
+// each other.
+func (c *ComputeAPI) Reset() {
+	c.ComputeBehavior = ComputeBehavior{}
+}
+
+func (c *ComputeAPI) ListResourceSKUs(_ context.Context, location string) ([]*compute.ResourceSKU, error) {
+	if c.ResourceSKUs != nil {
+		return c.ResourceSKUs, nil
+	}
+	return []*compute.ResourceSKU{
+		ResourceSKU("Standard_D2s_v3", "standardDSv3Family", location, "x64", 2, 8, 0, true, "1", "2", "3"),
+		ResourceSKU("Standard_D4s_v3", "standardDSv3Family", location, "x64", 4, 16, 0, true, "1", "2", "3"),
+		ResourceSKU("Standard_D4ps_v5", "standardDPSv5Family", location, "Arm64", 4, 16, 0, true, "1", "2", "3"),
+		ResourceSKU("Standard_NC12s_v3", "standardNCSv3Family", location, "x64", 12, 224, 2, false, "1"),
+		ResourceSKU("Standard_NV16as_v4", "standardNVSv4Family", location, "x64", 16, 56, 1, false),
+		{Name: "Standard_LRS", ResourceType: "disks", Locations: []string{location}},
+	}, nil
+}
+
+func (c *ComputeAPI) CreateNetworkInterface(_ context.Context, resourceGroup string, name string, networkInterface *compute.NetworkInterface) (*compute.NetworkInterface, error) {
+	created := *networkInterface
+	created.Name = name
+	created.ID = resourceID(resourceGroup, "Microsoft.Network/networkInterfaces", name)
+	c.NetworkInterfaces.Store(created.ID, &created)
+	return &created, nil
+}
+
+func (c *ComputeAPI) DeleteNetworkInterface(_ context.Context, resourceGroup string, name string) error {
+	id := resourceID(resourceGroup, "Microsoft.Network/networkInterfaces", name)
+	// Network interfaces can't be deleted while a virtual machine, even a failed one, references them
+	var attached bool
+	c.VirtualMachines.Range(func(_, virtualMachine interface{}) bool {
+		for _, networkInterface := range virtualMachine.(*compute.VirtualMachine).Properties.NetworkProfile.NetworkInterfaces {
+			attached = attached || networkInterface.ID == id
+		}
+		return !attached
+	})
+	if attached {
+		return &compute.Error{StatusCode: http.StatusBadRequest, Code: "NicInUse", Message: id}
+	}
+	if _, ok := c.NetworkInterfaces.LoadAndDelete(id); !ok {
+		return &compute.Error{StatusCode: http.StatusNotFound, Code: "NotFound", Message: id}
+	}
+	return nil
+}
+
+func (c *ComputeAPI) CreateVirtualMachine(_ context.Context, resourceGroup string, name string, virtualMachine *compute.VirtualMachine) (*compute.VirtualMachine, error) {
+	c.mu.Lock()
+	c.CalledWithCreateVirtualMachine = append(c.CalledWithCreateVirtualMachine, virtualMachine)
+	c.mu.Unlock()
+	if c.CreateVirtualMachineError != nil {
+		return nil, c.CreateVirtualMachineError
+	}
+	for _, pool := range c.InsufficientCapacityPools {
+		zone := "0"
+		if len(virtualMachine.Zones) > 0 {
+			zone = fmt.Sprintf("%s-%s", virtualMachine.Location, virtualMachine.Zones[0])
+		}
+		if pool.InstanceType == virtualMachine.Properties.HardwareProfile.VMSize && pool.Zone == zone && pool.Spot == (virtualMachine.Properties.Priority == "Spot") {
+			// Allocation fails asynchronously, leaving the virtual machine behind in the failed state
+			c.storeVirtualMachine(resourceGroup, name, virtualMachine, "Failed")
+			return nil, &compute.Error{StatusCode: http.StatusOK, Code: "ZonalAllocationFailed", Message: "Allocation failed"}
+		}
+	}
+	return c.storeVirtualMachine(resourceGroup, name, virtualMachine, "Succeeded"), nil
+}
+
+func (c *ComputeAPI) storeVirtualMachine(resourceGroup string, name string, virtualMachine *compute.VirtualMachine, provisioningState string) *compute.VirtualMachine {
+	created := *virtualMachine
+	created.Name = name
+	created.ID = resourceID(resourceGroup, "Microsoft.Compute/virtualMachines", name)
+	created.Properties.ProvisioningState = provisioningState
+	c.VirtualMachines.Store(created.ID, &created)
+	return &created
+}
+
+func (c *ComputeAPI) DeleteVirtualMachine(_ context.Context, resourceGroup string, name string) error {
+	id := resourceID(resourceGroup, "Microsoft.Compute/virtualMachines", name)
+	virtualMachine, ok := c.VirtualMachines.LoadAndDelete(id)
+	if !ok {
+		return &compute.Error{StatusCode: http.StatusNotFound, Code: "NotFound", Message: id}
+	}
+	// Network interfaces are deleted with the virtual machine
+	for _, networkInterface := range virtualMachine.(*compute.VirtualMachine).Properties.NetworkProfile.NetworkInterfaces {
+		c.NetworkInterfaces.Delete(networkInterface.ID)
+	}
+	return nil
+}
+
+// ResourceSKU returns a virtual machine SKU in the location and zones. SKUs
+// without zones aren't zonal in the location.
+func ResourceSKU(name string, family string, location string, architecture string, vCPUs int, memoryGB int, gpus int, lowPriorityCapable bool, zones ...string) *compute.ResourceSKU {
+	capabilities := []compute.ResourceSKUCapability{
+		{Name: "vCPUs", Value: fmt.Sprint(vCPUs)},
+		{Name: "MemoryGB", Value: fmt.Sprint(memoryGB)},
+		{Name: "CpuArchitectureType", Value: architecture},
+		{Name: "LowPriorityCapable", Value: map[bool]string{true: "True", false: "False"}[lowPriorityCapable]},
+	}
+	if gpus > 0 {
+		capabilities = append(capabilities, compute.ResourceSKUCapability{Name: "GPUs", Value: fmt.Sprint(gpus)})
+	}
+	return &compute.ResourceSKU{
+		Name:         name,
+		ResourceType: "virtualMachines",
+		Family:       family,
+		Locations:    []string{location},
+		LocationInfo: []compute.ResourceSKULocation{{Location: location, Zones: zones}},
+		Capabilities: capabilities,
+	}
+}
+
+func resourceID(resourceGroup string, resourceType string, name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s", SubscriptionID, resourceGroup, resourceType, name)
+}
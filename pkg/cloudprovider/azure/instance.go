@@ -0,0 +1,320 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/util/workqueue"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/compute"
+)
+
+const (
+	// ProviderIDPrefix matches the provider IDs of the Azure cloud controller manager
+	ProviderIDPrefix      = "azure://"
+	defaultAdminUsername  = "azureuser"
+	nvidiaGPUResourceName = v1.ResourceName("nvidia.com/gpu")
+	amdGPUResourceName    = v1.ResourceName("amd.com/gpu")
+)
+
+type InstanceProvider struct {
+	computeAPI           compute.API
+	instanceTypeProvider *InstanceTypeProvider
+	location             string
+	// resourceGroup is used if the provisioner doesn't set one
+	resourceGroup string
+}
+
+func NewInstanceProvider(computeAPI compute.API, instanceTypeProvider *InstanceTypeProvider, location string, resourceGroup string) *InstanceProvider {
+	return &InstanceProvider{
+		computeAPI:           computeAPI,
+		instanceTypeProvider: instanceTypeProvider,
+		location:             location,
+		resourceGroup:        resourceGroup,
+	}
+}
+
+// launchOption is an instance type and zone that a virtual machine may be launched in
+type launchOption struct {
+	instanceType cloudprovider.InstanceType
+	zone         string
+}
+
+// Create virtual machines given the constraints. Azure doesn't have an API to
+// launch a heterogeneous fleet, so each virtual machine is launched separately,
+// trying the instance types in priority order and falling back to the next
+// option when an offering can't be allocated. Create only returns an error if
+// zero nodes could be launched.
+func (p *InstanceProvider) Create(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) ([]*v1.Node, error) {
+	capacityType := p.getCapacityType(constraints, instanceTypes)
+	options := p.getLaunchOptions(constraints, instanceTypes, capacityType)
+	if len(options) == 0 {
+		return nil, fmt.Errorf("no capacity offerings are currently available given the constraints")
+	}
+	launched := make([]*v1.Node, quantity)
+	errs := make([]error, quantity)
+	workqueue.ParallelizeUntil(ctx, quantity, quantity, func(i int) {
+		launched[i], errs[i] = p.launch(ctx, constraints, options, capacityType)
+	})
+	nodes := []*v1.Node{}
+	for _, node := range launched {
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, multierr.Combine(errs...)
+	} else if len(nodes) != quantity {
+		logging.FromContext(ctx).Errorf("Failed to launch %d virtual machines out of the %d virtual machines requested: %s",
+			quantity-len(nodes), quantity, multierr.Combine(errs...).Error())
+	}
+	return nodes, nil
+}
+
+func (p *InstanceProvider) Terminate(ctx context.Context, node *v1.Node) error {
+	resourceGroup, name, err := parseProviderID(node.Spec.ProviderID)
+	if err != nil {
+		return fmt.Errorf("getting virtual machine for node %s, %w", node.Name, err)
+	}
+	if err := p.computeAPI.DeleteVirtualMachine(ctx, resourceGroup, name); err != nil {
+		if compute.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("deleting virtual machine %s, %w", name, err)
+	}
+	return nil
+}
+
+// launch a single virtual machine using the first option that can be allocated
+func (p *InstanceProvider) launch(ctx context.Context, constraints *v1alpha1.Constraints, options []launchOption, capacityType string) (*v1.Node, error) {
+	resourceGroup := p.getResourceGroup(constraints)
+	name := fmt.Sprintf("karpenter-%s", rand.String(10))
+	tags := v1alpha1.MergeTags(ctx, constraints.Tags)
+	var errs error
+	for _, option := range options {
+		// Another launch may have observed that the offering is unavailable
+		if p.instanceTypeProvider.isUnavailable(option.instanceType.Name(), option.zone, capacityType) {
+			continue
+		}
+		virtualMachine, err := p.create(ctx, constraints, resourceGroup, name, option, capacityType, tags)
+		if err == nil {
+			logging.FromContext(ctx).Infof("Launched virtual machine: %s, type: %s, zone: %s, capacityType: %s",
+				name, option.instanceType.Name(), option.zone, capacityType)
+			return p.virtualMachineToNode(virtualMachine, option, capacityType), nil
+		}
+		errs = multierr.Append(errs, err)
+		if !compute.IsCapacityError(err) {
+			break
+		}
+		p.instanceTypeProvider.CacheUnavailable(ctx, option.instanceType.Name(), option.zone, capacityType)
+	}
+	if errs == nil {
+		return nil, fmt.Errorf("no capacity offerings are currently available given the constraints")
+	}
+	return nil, errs
+}
+
+// create a virtual machine with its own network interface, deleting both if
+// the virtual machine can't be created
+func (p *InstanceProvider) create(ctx context.Context, constraints *v1alpha1.Constraints, resourceGroup string, name string,
+	option launchOption, capacityType string, tags map[string]string) (*compute.VirtualMachine, error) {
+	networkInterfaceName := name + "-nic"
+	networkInterface, err := p.computeAPI.CreateNetworkInterface(ctx, resourceGroup, networkInterfaceName, &compute.NetworkInterface{
+		Location: p.location,
+		Tags:     tags,
+		Properties: compute.NetworkInterfaceProperties{
+			IPConfigurations: []compute.IPConfiguration{{
+				Name: "ipconfig1",
+				Properties: compute.IPConfigurationProperties{
+					Primary:                   true,
+					PrivateIPAllocationMethod: "Dynamic",
+					Subnet:                    compute.SubResource{ID: ptr.StringValue(constraints.SubnetID)},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating network interface, %w", err)
+	}
+	virtualMachine, err := p.computeAPI.CreateVirtualMachine(ctx, resourceGroup, name,
+		p.getVirtualMachine(constraints, name, networkInterface.ID, option, capacityType, tags))
+	if err == nil {
+		return virtualMachine, nil
+	}
+	// A virtual machine that fails to allocate is left behind in the failed state, holding
+	// the network interface until its deletion completes. Deleting it also deletes the
+	// network interface, which is otherwise deleted explicitly since it was never attached.
+	if err := p.computeAPI.DeleteVirtualMachine(ctx, resourceGroup, name); err != nil && !compute.IsNotFound(err) {
+		logging.FromContext(ctx).Errorf("Deleting failed virtual machine %s, %s", name, err)
+	}
+	if err := p.computeAPI.DeleteNetworkInterface(ctx, resourceGroup, networkInterfaceName); err != nil && !compute.IsNotFound(err) {
+		logging.FromContext(ctx).Errorf("Deleting network interface %s, %s", networkInterfaceName, err)
+	}
+	return nil, fmt.Errorf("creating virtual machine, %w", err)
+}
+
+// getLaunchOptions returns the instance types and zones that satisfy the
+// constraints, in priority order of the instance types
+func (p *InstanceProvider) getLaunchOptions(constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, capacityType string) []launchOption {
+	options := []launchOption{}
+	for _, instanceType := range instanceTypes {
+		for _, offering := range instanceType.Offerings() {
+			if offering.CapacityType != capacityType || !constraints.Requirements.Zones().Has(offering.Zone) {
+				continue
+			}
+			options = append(options, launchOption{instanceType: instanceType, zone: offering.Zone})
+		}
+	}
+	return options
+}
+
+func (p *InstanceProvider) getVirtualMachine(constraints *v1alpha1.Constraints, name string, networkInterfaceID string, option launchOption, capacityType string, tags map[string]string) *compute.VirtualMachine {
+	adminUsername := defaultAdminUsername
+	if constraints.AdminUsername != nil {
+		adminUsername = *constraints.AdminUsername
+	}
+	virtualMachine := &compute.VirtualMachine{
+		Location: p.location,
+		Tags:     tags,
+		Properties: compute.VirtualMachineProperties{
+			HardwareProfile: compute.HardwareProfile{VMSize: option.instanceType.Name()},
+			StorageProfile: compute.StorageProfile{
+				ImageReference: compute.SubResource{ID: ptr.StringValue(constraints.ImageID)},
+				OSDisk: compute.OSDisk{
+					CreateOption: "FromImage",
+					DeleteOption: "Delete",
+					DiskSizeGB:   constraints.OSDiskSizeGB,
+				},
+			},
+			OSProfile: compute.OSProfile{
+				ComputerName:  name,
+				AdminUsername: adminUsername,
+				CustomData:    ptr.StringValue(constraints.CustomData),
+				LinuxConfiguration: compute.LinuxConfiguration{
+					DisablePasswordAuthentication: true,
+					SSH: compute.SSHConfiguration{PublicKeys: []compute.SSHPublicKey{{
+						Path:    fmt.Sprintf("/home/%s/.ssh/authorized_keys", adminUsername),
+						KeyData: ptr.StringValue(constraints.SSHPublicKey),
+					}}},
+				},
+			},
+			NetworkProfile: compute.NetworkProfile{NetworkInterfaces: []compute.NetworkInterfaceReference{{
+				ID:         networkInterfaceID,
+				Properties: compute.NetworkInterfaceReferenceProperties{Primary: true, DeleteOption: "Delete"},
+			}}},
+		},
+	}
+	if option.zone != NonZonalZone {
+		// Zones are named <location>-<zone>
+		virtualMachine.Zones = []string{option.zone[strings.LastIndex(option.zone, "-")+1:]}
+	}
+	if capacityType == v1alpha1.CapacityTypeSpot {
+		virtualMachine.Properties.Priority = "Spot"
+		virtualMachine.Properties.EvictionPolicy = "Delete"
+		// Pay up to the on-demand price, so that virtual machines aren't evicted due to price
+		virtualMachine.Properties.BillingProfile = &compute.BillingProfile{MaxPrice: -1}
+	}
+	if len(constraints.UserAssignedIdentities) > 0 {
+		virtualMachine.Identity = &compute.VirtualMachineIdentity{Type: "UserAssigned", UserAssignedIdentities: map[string]struct{}{}}
+		for _, id := range constraints.UserAssignedIdentities {
+			virtualMachine.Identity.UserAssignedIdentities[id] = struct{}{}
+		}
+	}
+	return virtualMachine
+}
+
+func (p *InstanceProvider) virtualMachineToNode(virtualMachine *compute.VirtualMachine, option launchOption, capacityType string) *v1.Node {
+	resources := v1.ResourceList{}
+	for resourceName, quantity := range map[v1.ResourceName]*resource.Quantity{
+		v1.ResourcePods:       option.instanceType.Pods(),
+		v1.ResourceCPU:        option.instanceType.CPU(),
+		v1.ResourceMemory:     option.instanceType.Memory(),
+		nvidiaGPUResourceName: option.instanceType.NvidiaGPUs(),
+		amdGPUResourceName:    option.instanceType.AMDGPUs(),
+	} {
+		if !quantity.IsZero() {
+			resources[resourceName] = *quantity
+		}
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			// The kubelet registers with the lowercase computer name
+			Name: strings.ToLower(virtualMachine.Properties.OSProfile.ComputerName),
+			Labels: map[string]string{
+				v1.LabelTopologyZone:       option.zone,
+				v1.LabelInstanceTypeStable: option.instanceType.Name(),
+				v1alpha5.LabelCapacityType: capacityType,
+			},
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: ProviderIDPrefix + virtualMachine.ID,
+		},
+		Status: v1.NodeStatus{
+			Allocatable: resources,
+			Capacity:    resources,
+			NodeInfo: v1.NodeSystemInfo{
+				Architecture:    option.instanceType.Architecture(),
+				OSImage:         virtualMachine.Properties.StorageProfile.ImageReference.ID,
+				OperatingSystem: v1alpha5.OperatingSystemLinux,
+			},
+		},
+	}
+}
+
+func (p *InstanceProvider) getResourceGroup(constraints *v1alpha1.Constraints) string {
+	if constraints.ResourceGroup != nil {
+		return *constraints.ResourceGroup
+	}
+	return p.resourceGroup
+}
+
+// getCapacityType selects spot if both constraints are flexible and there is an
+// available offering. The Azure Cloud Provider defaults to [ on-demand ], so spot
+// must be explicitly included in capacity type requirements.
+func (p *InstanceProvider) getCapacityType(constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) string {
+	if constraints.Requirements.CapacityTypes().Has(v1alpha1.CapacityTypeSpot) {
+		for _, instanceType := range instanceTypes {
+			for _, offering := range instanceType.Offerings() {
+				if constraints.Requirements.Zones().Has(offering.Zone) && offering.CapacityType == v1alpha1.CapacityTypeSpot {
+					return v1alpha1.CapacityTypeSpot
+				}
+			}
+		}
+	}
+	return v1alpha1.CapacityTypeOnDemand
+}
+
+// parseProviderID returns the resource group and name of the virtual machine, e.g.
+// azure:///subscriptions/<id>/resourceGroups/<resource-group>/providers/Microsoft.Compute/virtualMachines/<name>
+func parseProviderID(providerID string) (string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(providerID, ProviderIDPrefix), "/")
+	if !strings.HasPrefix(providerID, ProviderIDPrefix) || len(parts) != 9 || !strings.EqualFold(parts[3], "resourceGroups") {
+		return "", "", fmt.Errorf("parsing provider id %s", providerID)
+	}
+	return parts[4], parts[8], nil
+}
@@ -0,0 +1,207 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/compute"
+	"github.com/aws/karpenter/pkg/utils/resources"
+)
+
+const (
+	// maxPods matches the default of AKS nodes using kubenet and Azure CNI overlay
+	maxPods = 110
+	// defaultOSDiskSizeGB matches the size of the Ubuntu marketplace images,
+	// which the OS disk defaults to when its size isn't specified
+	defaultOSDiskSizeGB = 30
+)
+
+type InstanceType struct {
+	compute.ResourceSKU
+	AvailableOfferings []cloudprovider.Offering
+	// OSDiskSizeGB is the OS disk size of the provisioner, which backs the
+	// kubelet's root directory
+	OSDiskSizeGB *int32
+}
+
+func (i *InstanceType) Name() string {
+	return i.ResourceSKU.Name
+}
+
+func (i *InstanceType) Offerings() []cloudprovider.Offering {
+	return i.AvailableOfferings
+}
+
+func (i *InstanceType) OperatingSystems() sets.String {
+	return sets.NewString("linux")
+}
+
+func (i *InstanceType) Architecture() string {
+	architecture := i.capability("CpuArchitectureType")
+	if value, ok := v1alpha1.AzureToKubeArchitectures[architecture]; ok {
+		return value
+	}
+	return architecture // Unrecognized, but used for error printing
+}
+
+func (i *InstanceType) CPU() *resource.Quantity {
+	return resources.Quantity(fmt.Sprint(i.intCapability("vCPUs")))
+}
+
+func (i *InstanceType) Memory() *resource.Quantity {
+	memoryGB, err := strconv.ParseFloat(i.capability("MemoryGB"), 64)
+	if err != nil {
+		return resources.Quantity("0")
+	}
+	return resources.Quantity(fmt.Sprintf("%dMi", int64(memoryGB*1024)))
+}
+
+func (i *InstanceType) Pods() *resource.Quantity {
+	return resources.Quantity(fmt.Sprint(maxPods))
+}
+
+func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
+	if i.isAMDGPU() {
+		return resources.Quantity("0")
+	}
+	return resources.Quantity(fmt.Sprint(i.intCapability("GPUs")))
+}
+
+func (i *InstanceType) AMDGPUs() *resource.Quantity {
+	if !i.isAMDGPU() {
+		return resources.Quantity("0")
+	}
+	return resources.Quantity(fmt.Sprint(i.intCapability("GPUs")))
+}
+
+func (i *InstanceType) AWSNeurons() *resource.Quantity {
+	return resources.Quantity("0")
+}
+
+func (i *InstanceType) AWSPodENI() *resource.Quantity {
+	return resources.Quantity("0")
+}
+
+func (i *InstanceType) AWSEFAs() *resource.Quantity {
+	return resources.Quantity("0")
+}
+
+func (i *InstanceType) Resources() v1.ResourceList {
+	return cloudprovider.KnownResources(i)
+}
+
+// EphemeralStorage is the size of the OS disk, since AKS images don't place the
+// kubelet's root directory on the temporary disk
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	sizeGB := int64(defaultOSDiskSizeGB)
+	if i.OSDiskSizeGB != nil {
+		sizeGB = int64(*i.OSDiskSizeGB)
+	}
+	return resources.Quantity(fmt.Sprintf("%dGi", sizeGB))
+}
+
+// Overhead computes overhead for https://kubernetes.io/docs/tasks/administer-cluster/reserve-compute-resources/#node-allocatable
+// using the reservations of AKS nodes, see
+// https://docs.microsoft.com/en-us/azure/aks/concepts-clusters-workloads#resource-reservations
+func (i *InstanceType) Overhead() *cloudprovider.InstanceTypeOverhead {
+	return &cloudprovider.InstanceTypeOverhead{
+		KubeReserved: v1.ResourceList{
+			v1.ResourceCPU:    i.kubeReservedCPU(),
+			v1.ResourceMemory: i.kubeReservedMemory(),
+		},
+		SystemReserved: v1.ResourceList{},
+		EvictionThreshold: v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse("750Mi"),
+		},
+	}
+}
+
+// kubeReservedCPU is reserved in steps by the number of cores
+func (i *InstanceType) kubeReservedCPU() resource.Quantity {
+	cores := i.CPU().Value()
+	for _, step := range []struct {
+		cores    int64
+		reserved int64
+	}{
+		{cores: 1, reserved: 60},
+		{cores: 2, reserved: 100},
+		{cores: 4, reserved: 140},
+		{cores: 8, reserved: 180},
+		{cores: 16, reserved: 260},
+		{cores: 32, reserved: 420},
+	} {
+		if cores <= step.cores {
+			return *resource.NewMilliQuantity(step.reserved, resource.DecimalSI)
+		}
+	}
+	return *resource.NewMilliQuantity(740, resource.DecimalSI)
+}
+
+// kubeReservedMemory is a regressive rate of the memory
+func (i *InstanceType) kubeReservedMemory() resource.Quantity {
+	reserved := resource.NewQuantity(0, resource.BinarySI)
+	gibibyte := int64(1 << 30)
+	for _, memoryRange := range []struct {
+		start      int64
+		end        int64
+		percentage float64
+	}{
+		{start: 0, end: 4 * gibibyte, percentage: 0.25},
+		{start: 4 * gibibyte, end: 8 * gibibyte, percentage: 0.20},
+		{start: 8 * gibibyte, end: 16 * gibibyte, percentage: 0.10},
+		{start: 16 * gibibyte, end: 128 * gibibyte, percentage: 0.06},
+		{start: 128 * gibibyte, end: 1 << 62, percentage: 0.02},
+	} {
+		if memory := i.Memory().Value(); memory >= memoryRange.start {
+			r := float64(memoryRange.end - memoryRange.start)
+			if memory < memoryRange.end {
+				r = float64(memory - memoryRange.start)
+			}
+			reserved.Add(*resource.NewQuantity(int64(r*memoryRange.percentage), resource.BinarySI))
+		}
+	}
+	return *reserved
+}
+
+// isAMDGPU is true for the NVv4 family, which is the only family of AMD GPUs
+func (i *InstanceType) isAMDGPU() bool {
+	return strings.Contains(strings.ToLower(i.Family), "nvsv4")
+}
+
+func (i *InstanceType) capability(name string) string {
+	for _, capability := range i.Capabilities {
+		if capability.Name == name {
+			return capability.Value
+		}
+	}
+	return ""
+}
+
+func (i *InstanceType) intCapability(name string) int64 {
+	value, err := strconv.ParseInt(i.capability(name), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,173 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/compute"
+)
+
+const (
+	InstanceTypesCacheKey                         = "types"
+	InstanceTypesCacheTTL                         = 5 * time.Minute
+	InsufficientCapacityErrorCacheTTL             = 45 * time.Second
+	InsufficientCapacityErrorCacheCleanupInterval = 5 * time.Minute
+	// NonZonalZone is the zone label of virtual machines that aren't launched
+	// in an availability zone, matching the label applied by the Azure cloud
+	// controller manager
+	NonZonalZone = "0"
+)
+
+type InstanceTypeProvider struct {
+	computeAPI compute.API
+	location   string
+	// Has one entry for all the instance types; values cached *before*
+	// considering allocation failures from the unavailableOfferings cache
+	cache *cache.Cache
+	// key: <capacityType>:<instanceType>:<zone>, value: struct{}{}
+	unavailableOfferings *cache.Cache
+}
+
+func NewInstanceTypeProvider(computeAPI compute.API, location string) *InstanceTypeProvider {
+	return &InstanceTypeProvider{
+		computeAPI:           computeAPI,
+		location:             location,
+		cache:                cache.New(InstanceTypesCacheTTL, CacheCleanupInterval),
+		unavailableOfferings: cache.New(InsufficientCapacityErrorCacheTTL, InsufficientCapacityErrorCacheCleanupInterval),
+	}
+}
+
+// Get all instance type options available to the subscription in the location
+func (p *InstanceTypeProvider) Get(ctx context.Context, provider *v1alpha1.Azure) ([]cloudprovider.InstanceType, error) {
+	skus, err := p.getResourceSKUs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []cloudprovider.InstanceType{}
+	for _, sku := range skus {
+		if offerings := p.createOfferings(sku); len(offerings) > 0 {
+			result = append(result, &InstanceType{ResourceSKU: *sku, AvailableOfferings: offerings, OSDiskSizeGB: provider.OSDiskSizeGB})
+		}
+	}
+	return result, nil
+}
+
+func (p *InstanceTypeProvider) createOfferings(sku *compute.ResourceSKU) []cloudprovider.Offering {
+	capacityTypes := []string{v1alpha1.CapacityTypeOnDemand}
+	if (&InstanceType{ResourceSKU: *sku}).capability("LowPriorityCapable") == "True" {
+		capacityTypes = append(capacityTypes, v1alpha1.CapacityTypeSpot)
+	}
+	offerings := []cloudprovider.Offering{}
+	for zone := range p.zones(sku) {
+		for _, capacityType := range capacityTypes {
+			// exclude any offerings that have recently failed to allocate
+			if _, isUnavailable := p.unavailableOfferings.Get(UnavailableOfferingsCacheKey(capacityType, sku.Name, zone)); !isUnavailable {
+				offerings = append(offerings, cloudprovider.Offering{Zone: zone, CapacityType: capacityType})
+			}
+		}
+	}
+	return offerings
+}
+
+// zones returns the zones the SKU can be launched in, named <location>-<zone>.
+// SKUs without availability zones in the location are launched without a zone.
+func (p *InstanceTypeProvider) zones(sku *compute.ResourceSKU) sets.String {
+	restricted := sets.NewString()
+	for _, restriction := range sku.Restrictions {
+		if restriction.Type == "Zone" {
+			restricted.Insert(restriction.RestrictionInfo.Zones...)
+		}
+	}
+	zones := sets.NewString()
+	for _, locationInfo := range sku.LocationInfo {
+		if !strings.EqualFold(locationInfo.Location, p.location) {
+			continue
+		}
+		if len(locationInfo.Zones) == 0 {
+			zones.Insert(NonZonalZone)
+		}
+		for _, zone := range locationInfo.Zones {
+			if !restricted.Has(zone) {
+				zones.Insert(fmt.Sprintf("%s-%s", strings.ToLower(p.location), zone))
+			}
+		}
+	}
+	return zones
+}
+
+// getResourceSKUs retrieves the virtual machine SKUs that aren't restricted in the location
+func (p *InstanceTypeProvider) getResourceSKUs(ctx context.Context) ([]*compute.ResourceSKU, error) {
+	if cached, ok := p.cache.Get(InstanceTypesCacheKey); ok {
+		return cached.([]*compute.ResourceSKU), nil
+	}
+	skus, err := p.computeAPI.ListResourceSKUs(ctx, p.location)
+	if err != nil {
+		return nil, fmt.Errorf("listing resource skus, %w", err)
+	}
+	result := []*compute.ResourceSKU{}
+	for _, sku := range skus {
+		if p.filter(sku) {
+			result = append(result, sku)
+		}
+	}
+	logging.FromContext(ctx).Debugf("Discovered %d Azure virtual machine sizes", len(result))
+	p.cache.SetDefault(InstanceTypesCacheKey, result)
+	return result, nil
+}
+
+// filter the SKUs to include virtual machines that the subscription may launch in the location
+func (p *InstanceTypeProvider) filter(sku *compute.ResourceSKU) bool {
+	if sku.ResourceType != "virtualMachines" {
+		return false
+	}
+	for _, restriction := range sku.Restrictions {
+		// e.g. NotAvailableForSubscription
+		if restriction.Type == "Location" {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheUnavailable allows the InstanceProvider to communicate recently observed allocation failures in the
+// provided offerings
+func (p *InstanceTypeProvider) CacheUnavailable(ctx context.Context, instanceType string, zone string, capacityType string) {
+	logging.FromContext(ctx).Debugf("Allocation failed for offering { instanceType: %s, zone: %s, capacityType: %s }, avoiding for %s",
+		instanceType,
+		zone,
+		capacityType,
+		InsufficientCapacityErrorCacheTTL)
+	// even if the key is already in the cache, we still need to call Set to extend the cached entry's TTL
+	p.unavailableOfferings.SetDefault(UnavailableOfferingsCacheKey(capacityType, instanceType, zone), struct{}{})
+}
+
+func UnavailableOfferingsCacheKey(capacityType string, instanceType string, zone string) string {
+	return fmt.Sprintf("%s:%s:%s", capacityType, instanceType, zone)
+}
+
+func (p *InstanceTypeProvider) isUnavailable(instanceType string, zone string, capacityType string) bool {
+	_, isUnavailable := p.unavailableOfferings.Get(UnavailableOfferingsCacheKey(capacityType, instanceType, zone))
+	return isUnavailable
+}
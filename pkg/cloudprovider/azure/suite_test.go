@@ -0,0 +1,355 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/compute"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure/fake"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+)
+
+const (
+	testResourceGroup = "test-resource-group"
+	testSubnetID      = "/subscriptions/test-subscription/resourceGroups/test-vnet-resource-group/providers/Microsoft.Network/virtualNetworks/test-vnet/subnets/test-subnet"
+	testImageID       = "/subscriptions/test-subscription/resourceGroups/test-image-resource-group/providers/Microsoft.Compute/images/test-image"
+)
+
+var ctx context.Context
+var fakeComputeAPI *fake.ComputeAPI
+var cloudProvider *CloudProvider
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/Azure")
+}
+
+var _ = BeforeSuite(func() {
+	ctx = injection.WithOptions(ctx, options.Options{ClusterName: "test-cluster"})
+	ctx = injection.WithNamespacedName(ctx, types.NamespacedName{Name: "test-provisioner"})
+	fakeComputeAPI = &fake.ComputeAPI{}
+})
+
+var _ = Describe("Azure", func() {
+	var provider *v1alpha1.Azure
+	var constraints *v1alpha5.Constraints
+
+	BeforeEach(func() {
+		fakeComputeAPI.Reset()
+		cloudProvider = newCloudProvider(fakeComputeAPI, fake.Location, testResourceGroup)
+		provider = &v1alpha1.Azure{
+			SubnetID:     ptr.String(testSubnetID),
+			ImageID:      ptr.String(testImageID),
+			SSHPublicKey: ptr.String("ssh-rsa test-key"),
+		}
+		constraints = &v1alpha5.Constraints{
+			Requirements: v1alpha5.NewRequirements(
+				v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-location-1", "test-location-2", "test-location-3", NonZonalZone}},
+				v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeOnDemand}},
+			),
+		}
+	})
+
+	Context("GetInstanceTypes", func() {
+		It("should only return virtual machine sizes", func() {
+			instanceTypes := ExpectInstanceTypes(provider)
+			Expect(instanceTypes).To(HaveLen(5))
+			Expect(instanceTypes).ToNot(HaveKey("Standard_LRS"))
+		})
+		It("should exclude sizes that aren't available to the subscription", func() {
+			sku := fake.ResourceSKU("Standard_D2s_v3", "standardDSv3Family", fake.Location, "x64", 2, 8, 0, true, "1")
+			sku.Restrictions = []compute.ResourceSKURestriction{{Type: "Location", Values: []string{fake.Location}, ReasonCode: "NotAvailableForSubscription"}}
+			fakeComputeAPI.ResourceSKUs = []*compute.ResourceSKU{sku}
+			Expect(ExpectInstanceTypes(provider)).To(BeEmpty())
+		})
+		It("should offer zones named by location, excluding restricted zones", func() {
+			sku := fake.ResourceSKU("Standard_D2s_v3", "standardDSv3Family", fake.Location, "x64", 2, 8, 0, false, "1", "2", "3")
+			sku.Restrictions = []compute.ResourceSKURestriction{{Type: "Zone", RestrictionInfo: compute.ResourceSKURestrictionInfo{Zones: []string{"2"}}}}
+			fakeComputeAPI.ResourceSKUs = []*compute.ResourceSKU{sku}
+			Expect(ExpectInstanceTypes(provider)["Standard_D2s_v3"].Offerings()).To(ConsistOf(
+				cloudprovider.Offering{Zone: "test-location-1", CapacityType: v1alpha1.CapacityTypeOnDemand},
+				cloudprovider.Offering{Zone: "test-location-3", CapacityType: v1alpha1.CapacityTypeOnDemand},
+			))
+		})
+		It("should offer spot if the size is low priority capable", func() {
+			Expect(ExpectInstanceTypes(provider)["Standard_D2s_v3"].Offerings()).To(ContainElements(
+				cloudprovider.Offering{Zone: "test-location-1", CapacityType: v1alpha1.CapacityTypeSpot},
+				cloudprovider.Offering{Zone: "test-location-1", CapacityType: v1alpha1.CapacityTypeOnDemand},
+			))
+			for _, offering := range ExpectInstanceTypes(provider)["Standard_NC12s_v3"].Offerings() {
+				Expect(offering.CapacityType).To(Equal(v1alpha1.CapacityTypeOnDemand))
+			}
+		})
+		It("should offer sizes that aren't zonal without a zone", func() {
+			Expect(ExpectInstanceTypes(provider)["Standard_NV16as_v4"].Offerings()).To(ConsistOf(
+				cloudprovider.Offering{Zone: NonZonalZone, CapacityType: v1alpha1.CapacityTypeOnDemand},
+			))
+		})
+		It("should describe resources from the size's capabilities", func() {
+			instanceTypes := ExpectInstanceTypes(provider)
+			Expect(instanceTypes["Standard_D4s_v3"].CPU().String()).To(Equal("4"))
+			Expect(instanceTypes["Standard_D4s_v3"].Memory().String()).To(Equal("16Gi"))
+			Expect(instanceTypes["Standard_D4s_v3"].Pods().String()).To(Equal("110"))
+			Expect(instanceTypes["Standard_D4s_v3"].Architecture()).To(Equal(v1alpha5.ArchitectureAmd64))
+			Expect(instanceTypes["Standard_D4ps_v5"].Architecture()).To(Equal(v1alpha5.ArchitectureArm64))
+			Expect(instanceTypes["Standard_NC12s_v3"].NvidiaGPUs().String()).To(Equal("2"))
+			Expect(instanceTypes["Standard_NC12s_v3"].AMDGPUs().IsZero()).To(BeTrue())
+			Expect(instanceTypes["Standard_NV16as_v4"].AMDGPUs().String()).To(Equal("1"))
+			Expect(instanceTypes["Standard_NV16as_v4"].NvidiaGPUs().IsZero()).To(BeTrue())
+		})
+		It("should describe ephemeral storage from the OS disk size", func() {
+			Expect(ExpectInstanceTypes(provider)["Standard_D4s_v3"].EphemeralStorage().String()).To(Equal("30Gi"))
+			provider.OSDiskSizeGB = ptr.Int32(128)
+			Expect(ExpectInstanceTypes(provider)["Standard_D4s_v3"].EphemeralStorage().String()).To(Equal("128Gi"))
+		})
+		It("should reserve a regressive rate of memory", func() {
+			overhead := ExpectInstanceTypes(provider)["Standard_D4s_v3"].Overhead()
+			// 25% of the first 4GiB, 20% of the next 4GiB, 10% of the next 8GiB
+			Expect(overhead.KubeReserved.Memory().Value()).To(BeNumerically("==", 2791728742))
+			Expect(overhead.KubeReserved.Cpu().String()).To(Equal("140m"))
+			Expect(overhead.EvictionThreshold.Memory().String()).To(Equal("750Mi"))
+		})
+	})
+	Context("Create", func() {
+		It("should launch a virtual machine with a network interface", func() {
+			node := ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(node.Name).To(HavePrefix("karpenter-"))
+			Expect(node.Spec.ProviderID).To(HavePrefix("azure:///subscriptions/test-subscription/resourceGroups/test-resource-group/providers/Microsoft.Compute/virtualMachines/karpenter-"))
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "Standard_D2s_v3"))
+			Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeOnDemand))
+			Expect(node.Status.Capacity).To(HaveKeyWithValue(v1.ResourceCPU, resource.MustParse("2")))
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine).To(HaveLen(1))
+			virtualMachine := fakeComputeAPI.CalledWithCreateVirtualMachine[0]
+			Expect(virtualMachine.Location).To(Equal(fake.Location))
+			Expect(virtualMachine.Properties.HardwareProfile.VMSize).To(Equal("Standard_D2s_v3"))
+			Expect(virtualMachine.Properties.StorageProfile.ImageReference.ID).To(Equal(testImageID))
+			Expect(virtualMachine.Properties.StorageProfile.OSDisk.DeleteOption).To(Equal("Delete"))
+			Expect(virtualMachine.Properties.OSProfile.AdminUsername).To(Equal("azureuser"))
+			Expect(virtualMachine.Properties.OSProfile.LinuxConfiguration.DisablePasswordAuthentication).To(BeTrue())
+			Expect(virtualMachine.Properties.NetworkProfile.NetworkInterfaces).To(HaveLen(1))
+			Expect(virtualMachine.Properties.NetworkProfile.NetworkInterfaces[0].Properties.DeleteOption).To(Equal("Delete"))
+			Expect(virtualMachine.Properties.Priority).To(BeEmpty())
+			networkInterface, ok := fakeComputeAPI.NetworkInterfaces.Load(virtualMachine.Properties.NetworkProfile.NetworkInterfaces[0].ID)
+			Expect(ok).To(BeTrue())
+			Expect(networkInterface.(*compute.NetworkInterface).Properties.IPConfigurations[0].Properties.Subnet.ID).To(Equal(testSubnetID))
+		})
+		It("should launch in a zone that satisfies the requirements", func() {
+			constraints.Requirements = constraints.Requirements.Add(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-location-2"}})
+			node := ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-location-2"))
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine[0].Zones).To(ConsistOf("2"))
+		})
+		It("should launch sizes that aren't zonal without a zone", func() {
+			node := ExpectCreated(constraints, provider, "Standard_NV16as_v4")
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, NonZonalZone))
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine[0].Zones).To(BeEmpty())
+		})
+		It("should launch spot virtual machines if spot is allowed", func() {
+			constraints.Requirements = v1alpha5.NewRequirements(
+				v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-location-1"}},
+				v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}},
+			)
+			node := ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeSpot))
+			virtualMachine := fakeComputeAPI.CalledWithCreateVirtualMachine[0]
+			Expect(virtualMachine.Properties.Priority).To(Equal("Spot"))
+			Expect(virtualMachine.Properties.EvictionPolicy).To(Equal("Delete"))
+			Expect(virtualMachine.Properties.BillingProfile.MaxPrice).To(BeNumerically("==", -1))
+		})
+		It("should tag resources with the provisioner, cluster and custom tags", func() {
+			provider.Tags = map[string]string{"team": "test-team"}
+			ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine[0].Tags).To(Equal(map[string]string{
+				"karpenter.sh_provisioner-name": "test-provisioner",
+				"karpenter.sh_cluster":          "test-cluster",
+				"team":                          "test-team",
+			}))
+		})
+		It("should assign user assigned identities", func() {
+			identity := "/subscriptions/test-subscription/resourceGroups/test-resource-group/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test-identity"
+			provider.UserAssignedIdentities = []string{identity}
+			ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine[0].Identity.Type).To(Equal("UserAssigned"))
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine[0].Identity.UserAssignedIdentities).To(HaveKey(identity))
+		})
+		It("should launch in the provisioner's resource group", func() {
+			provider.ResourceGroup = ptr.String("custom-resource-group")
+			node := ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(node.Spec.ProviderID).To(ContainSubstring("/resourceGroups/custom-resource-group/"))
+		})
+		It("should fall back to the next offering if allocation fails", func() {
+			constraints.Requirements = constraints.Requirements.Add(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-location-1"}})
+			fakeComputeAPI.InsufficientCapacityPools = []fake.CapacityPool{{InstanceType: "Standard_D2s_v3", Zone: "test-location-1"}}
+			node := ExpectCreated(constraints, provider, "Standard_D2s_v3", "Standard_D4s_v3")
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "Standard_D4s_v3"))
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine).To(HaveLen(2))
+			// The virtual machine that failed to allocate is deleted with its network interface
+			Expect(ExpectVirtualMachines()).To(ConsistOf(ContainSubstring(node.Name)))
+			// The offering is unavailable until the cache expires
+			for _, offering := range ExpectInstanceTypes(provider)["Standard_D2s_v3"].Offerings() {
+				Expect(offering).ToNot(Equal(cloudprovider.Offering{Zone: "test-location-1", CapacityType: v1alpha1.CapacityTypeOnDemand}))
+			}
+		})
+		It("should fail and clean up the network interface if the virtual machine can't be created", func() {
+			fakeComputeAPI.CreateVirtualMachineError = &compute.Error{StatusCode: http.StatusBadRequest, Code: "InvalidParameter", Message: "test error"}
+			Expect(cloudProvider.Create(ctx, NodeRequests(constraints, provider, 1, "Standard_D2s_v3"), func(*cloudprovider.NodeRequest, *v1.Node) error {
+				Fail("should not launch nodes")
+				return nil
+			})).ToNot(Succeed())
+			// Other offerings aren't attempted for errors unrelated to capacity
+			Expect(fakeComputeAPI.CalledWithCreateVirtualMachine).To(HaveLen(1))
+			ExpectNoNetworkInterfaces()
+		})
+		It("should clean up virtual machines that fail to allocate", func() {
+			constraints.Requirements = constraints.Requirements.Add(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-location-1"}})
+			fakeComputeAPI.InsufficientCapacityPools = []fake.CapacityPool{{InstanceType: "Standard_D2s_v3", Zone: "test-location-1"}}
+			Expect(cloudProvider.Create(ctx, NodeRequests(constraints, provider, 1, "Standard_D2s_v3"), func(*cloudprovider.NodeRequest, *v1.Node) error {
+				Fail("should not launch nodes")
+				return nil
+			})).ToNot(Succeed())
+			Expect(ExpectVirtualMachines()).To(BeEmpty())
+			ExpectNoNetworkInterfaces()
+		})
+		It("should launch the requested quantity of virtual machines", func() {
+			nodes := []*v1.Node{}
+			Expect(cloudProvider.Create(ctx, NodeRequests(constraints, provider, 3, "Standard_D2s_v3"), func(_ *cloudprovider.NodeRequest, node *v1.Node) error {
+				nodes = append(nodes, node)
+				return nil
+			})).To(Succeed())
+			Expect(nodes).To(HaveLen(3))
+		})
+	})
+	Context("Delete", func() {
+		It("should delete the virtual machine and its network interface", func() {
+			node := ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+			ExpectNoNetworkInterfaces()
+		})
+		It("should succeed if the virtual machine doesn't exist", func() {
+			node := ExpectCreated(constraints, provider, "Standard_D2s_v3")
+			Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+			Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+		})
+		It("should fail for provider IDs that aren't Azure virtual machines", func() {
+			Expect(cloudProvider.Delete(ctx, &v1.Node{Spec: v1.NodeSpec{ProviderID: "aws:///test-zone-1a/i-01234567890"}})).ToNot(Succeed())
+		})
+	})
+	Context("Validation", func() {
+		It("should succeed with the required fields", func() {
+			Expect(cloudProvider.Validate(ctx, ConstraintsWithProvider(constraints, provider))).To(BeNil())
+		})
+		It("should fail without a subnet, image or ssh public key", func() {
+			provider.SubnetID = nil
+			provider.ImageID = nil
+			provider.SSHPublicKey = nil
+			Expect(cloudProvider.Validate(ctx, ConstraintsWithProvider(constraints, provider))).ToNot(BeNil())
+		})
+		It("should fail for ids that aren't resource ids", func() {
+			provider.SubnetID = ptr.String("test-subnet")
+			Expect(cloudProvider.Validate(ctx, ConstraintsWithProvider(constraints, provider))).ToNot(BeNil())
+		})
+		It("should fail for tag keys containing /", func() {
+			provider.Tags = map[string]string{"karpenter.sh/test": "test"}
+			Expect(cloudProvider.Validate(ctx, ConstraintsWithProvider(constraints, provider))).ToNot(BeNil())
+		})
+	})
+	Context("Defaulting", func() {
+		It("should default the architecture and capacity type", func() {
+			constraints = ConstraintsWithProvider(&v1alpha5.Constraints{}, provider)
+			cloudProvider.Default(ctx, constraints)
+			Expect(constraints.Requirements.Architectures().UnsortedList()).To(ConsistOf(v1alpha5.ArchitectureAmd64))
+			Expect(constraints.Requirements.CapacityTypes().UnsortedList()).To(ConsistOf(v1alpha1.CapacityTypeOnDemand))
+		})
+	})
+	Context("Provider IDs", func() {
+		It("should parse the resource group and name", func() {
+			resourceGroup, name, err := parseProviderID("azure:///subscriptions/test-subscription/resourceGroups/test-resource-group/providers/Microsoft.Compute/virtualMachines/test-vm")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resourceGroup).To(Equal("test-resource-group"))
+			Expect(name).To(Equal("test-vm"))
+		})
+	})
+})
+
+func ExpectInstanceTypes(provider *v1alpha1.Azure) map[string]cloudprovider.InstanceType {
+	instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, ConstraintsWithProvider(&v1alpha5.Constraints{}, provider).Provider)
+	Expect(err).ToNot(HaveOccurred())
+	result := map[string]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		result[instanceType.Name()] = instanceType
+	}
+	return result
+}
+
+func ExpectCreated(constraints *v1alpha5.Constraints, provider *v1alpha1.Azure, instanceTypeNames ...string) *v1.Node {
+	nodes := []*v1.Node{}
+	Expect(cloudProvider.Create(ctx, NodeRequests(constraints, provider, 1, instanceTypeNames...), func(_ *cloudprovider.NodeRequest, node *v1.Node) error {
+		nodes = append(nodes, node)
+		return nil
+	})).To(Succeed())
+	Expect(nodes).To(HaveLen(1))
+	return nodes[0]
+}
+
+func ExpectNoNetworkInterfaces() {
+	fakeComputeAPI.NetworkInterfaces.Range(func(key, _ interface{}) bool {
+		Fail("unexpected network interface " + key.(string))
+		return true
+	})
+}
+
+func ExpectVirtualMachines() []string {
+	ids := []string{}
+	fakeComputeAPI.VirtualMachines.Range(func(key, _ interface{}) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+	return ids
+}
+
+func NodeRequests(constraints *v1alpha5.Constraints, provider *v1alpha1.Azure, quantity int, instanceTypeNames ...string) []*cloudprovider.NodeRequest {
+	instanceTypes := ExpectInstanceTypes(provider)
+	options := []cloudprovider.InstanceType{}
+	for _, name := range instanceTypeNames {
+		Expect(instanceTypes).To(HaveKey(name))
+		options = append(options, instanceTypes[name])
+	}
+	return []*cloudprovider.NodeRequest{{Constraints: ConstraintsWithProvider(constraints, provider), InstanceTypeOptions: options, Quantity: quantity}}
+}
+
+func ConstraintsWithProvider(constraints *v1alpha5.Constraints, provider *v1alpha1.Azure) *v1alpha5.Constraints {
+	raw, err := json.Marshal(provider)
+	Expect(err).ToNot(HaveOccurred())
+	constraints.Provider = &runtime.RawExtension{Raw: raw}
+	return constraints
+}
@@ -0,0 +1,143 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package external
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/multierr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+// Name of the external cloud provider
+const Name = "external"
+
+// CloudProvider proxies requests to an out-of-tree cloud provider, typically
+// running as a sidecar, that implements the CloudProviderServer gRPC service.
+type CloudProvider struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewCloudProvider connects to the out-of-tree cloud provider listening on
+// --external-cloud-provider-address
+func NewCloudProvider(ctx context.Context, _ cloudprovider.Options) cloudprovider.CloudProvider {
+	address := injection.GetOptions(ctx).ExternalCloudProviderAddress
+	conn, err := grpc.DialContext(ctx, address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codecName)),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("connecting to external cloud provider at %s, %s", address, err))
+	}
+	logging.FromContext(ctx).Infof("Using external cloud provider at %s", address)
+	return &CloudProvider{conn: conn}
+}
+
+// Create nodes for the node requests. Instance type options are sent by name,
+// and each launched node is matched back to its node request.
+func (c *CloudProvider) Create(ctx context.Context, nodeRequests []*cloudprovider.NodeRequest, callback func(*cloudprovider.NodeRequest, *v1.Node) error) error {
+	request := &CreateRequest{}
+	for _, nodeRequest := range nodeRequests {
+		instanceTypeOptions := []string{}
+		for _, instanceType := range nodeRequest.InstanceTypeOptions {
+			instanceTypeOptions = append(instanceTypeOptions, instanceType.Name())
+		}
+		request.NodeRequests = append(request.NodeRequests, &NodeRequest{
+			Constraints:         nodeRequest.Constraints,
+			InstanceTypeOptions: instanceTypeOptions,
+			Quantity:            nodeRequest.Quantity,
+		})
+	}
+	response := &CreateResponse{}
+	if err := c.conn.Invoke(ctx, fullMethod("Create"), request, response); err != nil {
+		return fmt.Errorf("creating nodes, %w", err)
+	}
+	var errs error
+	for _, node := range response.Nodes {
+		if node.NodeRequest < 0 || node.NodeRequest >= len(nodeRequests) || node.Node == nil {
+			errs = multierr.Append(errs, fmt.Errorf("invalid node for node request %d", node.NodeRequest))
+			continue
+		}
+		errs = multierr.Append(errs, callback(nodeRequests[node.NodeRequest], node.Node))
+	}
+	return errs
+}
+
+// Delete the node's underlying instance
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	if err := c.conn.Invoke(ctx, fullMethod("Delete"), &DeleteRequest{Node: node}, &DeleteResponse{}); err != nil {
+		return fmt.Errorf("deleting node %s, %w", node.Name, err)
+	}
+	return nil
+}
+
+// GetInstanceTypes returns the instance types supported by the out-of-tree
+// cloud provider
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provider *v1alpha5.Provider) ([]cloudprovider.InstanceType, error) {
+	response := &GetInstanceTypesResponse{}
+	if err := c.conn.Invoke(ctx, fullMethod("GetInstanceTypes"), &GetInstanceTypesRequest{Provider: provider}, response); err != nil {
+		return nil, fmt.Errorf("getting instance types, %w", err)
+	}
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, serialized := range response.InstanceTypes {
+		instanceTypes = append(instanceTypes, instanceType{serialized: serialized})
+	}
+	return instanceTypes, nil
+}
+
+// Cleanup is not supported by out-of-tree cloud providers
+func (c *CloudProvider) Cleanup(context.Context, string) error {
+	return nil
+}
+
+// ValidateInPlaceUpdate returns ErrInPlaceUpdateUnsupported, since out-of-tree
+// cloud providers can't update nodes in place
+func (c *CloudProvider) ValidateInPlaceUpdate(context.Context, *v1.Node) error {
+	return cloudprovider.ErrInPlaceUpdateUnsupported
+}
+
+// UpdateInPlace is not supported by out-of-tree cloud providers, so nodes are
+// replaced instead
+func (c *CloudProvider) UpdateInPlace(context.Context, *v1.Node) (string, error) {
+	return "", cloudprovider.ErrInPlaceUpdateUnsupported
+}
+
+// GetInPlaceUpdate is not supported by out-of-tree cloud providers
+func (c *CloudProvider) GetInPlaceUpdate(context.Context, *v1.Node, string) (cloudprovider.InPlaceUpdateStatus, error) {
+	return "", cloudprovider.ErrInPlaceUpdateUnsupported
+}
+
+// Default is not supported by out-of-tree cloud providers
+func (c *CloudProvider) Default(context.Context, *v1alpha5.Constraints) {
+}
+
+// Validate is not supported by out-of-tree cloud providers
+func (c *CloudProvider) Validate(context.Context, *v1alpha5.Constraints) *apis.FieldError {
+	return nil
+}
+
+// Name returns the CloudProvider implementation name.
+func (c *CloudProvider) Name() string {
+	return Name
+}
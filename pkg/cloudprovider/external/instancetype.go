@@ -0,0 +1,151 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package external
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/resources"
+)
+
+// InstanceType is the serialized form of a cloudprovider.InstanceType.
+// Capacity is keyed by resource name, e.g. cpu, memory, pods, ephemeral-storage,
+// and extended resources such as nvidia.com/gpu.
+type InstanceType struct {
+	Name             string          `json:"name"`
+	Offerings        []Offering      `json:"offerings"`
+	Architecture     string          `json:"architecture"`
+	OperatingSystems []string        `json:"operatingSystems"`
+	Capacity         v1.ResourceList `json:"capacity"`
+	Overhead         Overhead        `json:"overhead"`
+}
+
+// Offering is the serialized form of a cloudprovider.Offering
+type Offering struct {
+	CapacityType string `json:"capacityType"`
+	Zone         string `json:"zone"`
+}
+
+// Overhead is the serialized form of a cloudprovider.InstanceTypeOverhead
+type Overhead struct {
+	KubeReserved      v1.ResourceList `json:"kubeReserved,omitempty"`
+	SystemReserved    v1.ResourceList `json:"systemReserved,omitempty"`
+	EvictionThreshold v1.ResourceList `json:"evictionThreshold,omitempty"`
+}
+
+// NewInstanceType serializes the instance type
+func NewInstanceType(instanceType cloudprovider.InstanceType) *InstanceType {
+	offerings := []Offering{}
+	for _, offering := range instanceType.Offerings() {
+		offerings = append(offerings, Offering{CapacityType: offering.CapacityType, Zone: offering.Zone})
+	}
+	overhead := instanceType.Overhead()
+	return &InstanceType{
+		Name:             instanceType.Name(),
+		Offerings:        offerings,
+		Architecture:     instanceType.Architecture(),
+		OperatingSystems: instanceType.OperatingSystems().List(),
+		Capacity:         instanceType.Resources(),
+		Overhead: Overhead{
+			KubeReserved:      overhead.KubeReserved,
+			SystemReserved:    overhead.SystemReserved,
+			EvictionThreshold: overhead.EvictionThreshold,
+		},
+	}
+}
+
+// instanceType implements cloudprovider.InstanceType for an instance type
+// returned by an out-of-tree cloud provider
+type instanceType struct {
+	serialized *InstanceType
+}
+
+func (i instanceType) Name() string {
+	return i.serialized.Name
+}
+
+func (i instanceType) Offerings() []cloudprovider.Offering {
+	offerings := []cloudprovider.Offering{}
+	for _, offering := range i.serialized.Offerings {
+		offerings = append(offerings, cloudprovider.Offering{CapacityType: offering.CapacityType, Zone: offering.Zone})
+	}
+	return offerings
+}
+
+func (i instanceType) Architecture() string {
+	return i.serialized.Architecture
+}
+
+func (i instanceType) OperatingSystems() sets.String {
+	return sets.NewString(i.serialized.OperatingSystems...)
+}
+
+func (i instanceType) CPU() *resource.Quantity {
+	return i.capacity(v1.ResourceCPU)
+}
+
+func (i instanceType) Memory() *resource.Quantity {
+	return i.capacity(v1.ResourceMemory)
+}
+
+func (i instanceType) Pods() *resource.Quantity {
+	return i.capacity(v1.ResourcePods)
+}
+
+func (i instanceType) NvidiaGPUs() *resource.Quantity {
+	return i.capacity(resources.NvidiaGPU)
+}
+
+func (i instanceType) AMDGPUs() *resource.Quantity {
+	return i.capacity(resources.AMDGPU)
+}
+
+func (i instanceType) AWSNeurons() *resource.Quantity {
+	return i.capacity(resources.AWSNeuron)
+}
+
+func (i instanceType) AWSPodENI() *resource.Quantity {
+	return i.capacity(resources.AWSPodENI)
+}
+
+func (i instanceType) AWSEFAs() *resource.Quantity {
+	return i.capacity(resources.AWSEFA)
+}
+
+func (i instanceType) EphemeralStorage() *resource.Quantity {
+	return i.capacity(v1.ResourceEphemeralStorage)
+}
+
+// Resources returns the capacity of every resource, including extended
+// resources that the cloud provider has no accessor for
+func (i instanceType) Resources() v1.ResourceList {
+	return i.serialized.Capacity.DeepCopy()
+}
+
+func (i instanceType) Overhead() *cloudprovider.InstanceTypeOverhead {
+	return &cloudprovider.InstanceTypeOverhead{
+		KubeReserved:      i.serialized.Overhead.KubeReserved,
+		SystemReserved:    i.serialized.Overhead.SystemReserved,
+		EvictionThreshold: i.serialized.Overhead.EvictionThreshold,
+	}
+}
+
+func (i instanceType) capacity(name v1.ResourceName) *resource.Quantity {
+	quantity := i.serialized.Capacity[name]
+	return &quantity
+}
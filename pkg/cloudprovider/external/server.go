@@ -0,0 +1,115 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package external
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+// Server implements CloudProviderServer for a cloud provider written in Go,
+// so that it can be run out-of-tree without modifying the Karpenter binary.
+type Server struct {
+	cloudProvider cloudprovider.CloudProvider
+}
+
+// NewServer serves the cloud provider, e.g. with
+// RegisterCloudProviderServer(grpc.NewServer(), NewServer(cloudProvider))
+func NewServer(cloudProvider cloudprovider.CloudProvider) *Server {
+	return &Server{cloudProvider: cloudProvider}
+}
+
+func (s *Server) GetInstanceTypes(ctx context.Context, request *GetInstanceTypesRequest) (*GetInstanceTypesResponse, error) {
+	instanceTypes, err := s.cloudProvider.GetInstanceTypes(ctx, request.Provider)
+	if err != nil {
+		return nil, err
+	}
+	response := &GetInstanceTypesResponse{InstanceTypes: []*InstanceType{}}
+	for _, instanceType := range instanceTypes {
+		response.InstanceTypes = append(response.InstanceTypes, NewInstanceType(instanceType))
+	}
+	return response, nil
+}
+
+func (s *Server) Create(ctx context.Context, request *CreateRequest) (*CreateResponse, error) {
+	nodeRequests := []*cloudprovider.NodeRequest{}
+	indices := map[*cloudprovider.NodeRequest]int{}
+	for i, nodeRequest := range request.NodeRequests {
+		instanceTypeOptions, err := s.getInstanceTypes(ctx, nodeRequest)
+		if err != nil {
+			return nil, err
+		}
+		nodeRequests = append(nodeRequests, &cloudprovider.NodeRequest{
+			Constraints:         nodeRequest.Constraints,
+			InstanceTypeOptions: instanceTypeOptions,
+			Quantity:            nodeRequest.Quantity,
+		})
+		indices[nodeRequests[i]] = i
+	}
+	// Cloud providers may call back concurrently
+	mu := sync.Mutex{}
+	response := &CreateResponse{Nodes: []*Node{}}
+	err := s.cloudProvider.Create(ctx, nodeRequests, func(nodeRequest *cloudprovider.NodeRequest, node *v1.Node) error {
+		mu.Lock()
+		defer mu.Unlock()
+		response.Nodes = append(response.Nodes, &Node{NodeRequest: indices[nodeRequest], Node: node})
+		return nil
+	})
+	if err != nil {
+		if len(response.Nodes) == 0 {
+			return nil, err
+		}
+		// Return the nodes that were launched, so that pods are bound to them
+		logging.FromContext(ctx).Errorf("Partially created nodes, %s", err)
+	}
+	return response, nil
+}
+
+func (s *Server) Delete(ctx context.Context, request *DeleteRequest) (*DeleteResponse, error) {
+	if err := s.cloudProvider.Delete(ctx, request.Node); err != nil {
+		return nil, err
+	}
+	return &DeleteResponse{}, nil
+}
+
+// getInstanceTypes resolves the node request's instance type options by name
+func (s *Server) getInstanceTypes(ctx context.Context, nodeRequest *NodeRequest) ([]cloudprovider.InstanceType, error) {
+	if nodeRequest.Constraints == nil {
+		return nil, fmt.Errorf("node request is missing constraints")
+	}
+	instanceTypes, err := s.cloudProvider.GetInstanceTypes(ctx, nodeRequest.Constraints.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("getting instance types, %w", err)
+	}
+	byName := map[string]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		byName[instanceType.Name()] = instanceType
+	}
+	instanceTypeOptions := []cloudprovider.InstanceType{}
+	for _, name := range nodeRequest.InstanceTypeOptions {
+		instanceType, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("instance type %s not found", name)
+		}
+		instanceTypeOptions = append(instanceTypeOptions, instanceType)
+	}
+	return instanceTypeOptions, nil
+}
@@ -0,0 +1,142 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+// ServiceName is the fully qualified name of the gRPC service implemented by
+// out-of-tree cloud providers
+const ServiceName = "karpenter.cloudprovider.v1alpha1.CloudProvider"
+
+// Messages are encoded as JSON, using the same serialization as the Kubernetes
+// API, so that out-of-tree cloud providers may be implemented in any language
+// without generated protobuf code. Requests are sent with the content type
+// "application/grpc+json".
+const codecName = "json"
+
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (codec) Name() string                               { return codecName }
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+type GetInstanceTypesRequest struct {
+	Provider *v1alpha5.Provider `json:"provider,omitempty"`
+}
+
+type GetInstanceTypesResponse struct {
+	InstanceTypes []*InstanceType `json:"instanceTypes"`
+}
+
+type CreateRequest struct {
+	NodeRequests []*NodeRequest `json:"nodeRequests"`
+}
+
+// NodeRequest is a request for a quantity of nodes, referring to instance
+// types by the names returned from GetInstanceTypes
+type NodeRequest struct {
+	Constraints         *v1alpha5.Constraints `json:"constraints"`
+	InstanceTypeOptions []string              `json:"instanceTypeOptions"`
+	Quantity            int                   `json:"quantity"`
+}
+
+type CreateResponse struct {
+	Nodes []*Node `json:"nodes"`
+}
+
+// Node is a node that was launched for the node request at the given index of
+// the CreateRequest
+type Node struct {
+	NodeRequest int      `json:"nodeRequest"`
+	Node        *v1.Node `json:"node"`
+}
+
+type DeleteRequest struct {
+	Node *v1.Node `json:"node"`
+}
+
+type DeleteResponse struct{}
+
+// CloudProviderServer is implemented by out-of-tree cloud providers
+type CloudProviderServer interface {
+	// GetInstanceTypes returns the instance types supported by the cloud provider
+	GetInstanceTypes(context.Context, *GetInstanceTypesRequest) (*GetInstanceTypesResponse, error)
+	// Create launches nodes for the node requests. Partial fulfillment is not
+	// an error, and returns the nodes that were launched.
+	Create(context.Context, *CreateRequest) (*CreateResponse, error)
+	// Delete terminates the node's underlying instance
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+}
+
+// RegisterCloudProviderServer registers the cloud provider with a gRPC server
+func RegisterCloudProviderServer(s *grpc.Server, srv CloudProviderServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*CloudProviderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("GetInstanceTypes", func() interface{} { return &GetInstanceTypesRequest{} }, func(srv CloudProviderServer, ctx context.Context, request interface{}) (interface{}, error) {
+			return srv.GetInstanceTypes(ctx, request.(*GetInstanceTypesRequest))
+		}),
+		unaryMethod("Create", func() interface{} { return &CreateRequest{} }, func(srv CloudProviderServer, ctx context.Context, request interface{}) (interface{}, error) {
+			return srv.Create(ctx, request.(*CreateRequest))
+		}),
+		unaryMethod("Delete", func() interface{} { return &DeleteRequest{} }, func(srv CloudProviderServer, ctx context.Context, request interface{}) (interface{}, error) {
+			return srv.Delete(ctx, request.(*DeleteRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// unaryMethod describes a unary method of the service, decoding the request
+// and applying the server's interceptor if one is configured
+func unaryMethod(name string, newRequest func() interface{}, call func(CloudProviderServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			request := newRequest()
+			if err := dec(request); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, request interface{}) (interface{}, error) {
+				return call(srv.(CloudProviderServer), ctx, request)
+			}
+			if interceptor == nil {
+				return handler(ctx, request)
+			}
+			return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod(name)}, handler)
+		},
+	}
+}
+
+func fullMethod(name string) string {
+	return fmt.Sprintf("/%s/%s", ServiceName, name)
+}
@@ -0,0 +1,163 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package external_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/external"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+	"github.com/aws/karpenter/pkg/utils/resources"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var dir string
+var server *grpc.Server
+var cloudProvider cloudprovider.CloudProvider
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/External")
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+	dir, err = os.MkdirTemp("", "karpenter-external")
+	Expect(err).ToNot(HaveOccurred())
+	socket := filepath.Join(dir, "cloudprovider.sock")
+	listener, err := net.Listen("unix", socket)
+	Expect(err).ToNot(HaveOccurred())
+	server = grpc.NewServer()
+	external.RegisterCloudProviderServer(server, external.NewServer(&fake.CloudProvider{InstanceTypes: []cloudprovider.InstanceType{
+		fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default-instance-type"}),
+		fake.NewInstanceType(fake.InstanceTypeOptions{Name: "gpu-instance-type", NvidiaGPUs: resource.MustParse("2")}),
+	}}))
+	go func() {
+		defer GinkgoRecover()
+		Expect(server.Serve(listener)).To(Succeed())
+	}()
+	cloudProvider = external.NewCloudProvider(injection.WithOptions(ctx, options.Options{
+		ExternalCloudProviderAddress: fmt.Sprintf("unix://%s", socket),
+	}), cloudprovider.Options{})
+})
+
+var _ = AfterSuite(func() {
+	server.Stop()
+	Expect(os.RemoveAll(dir)).To(Succeed())
+})
+
+var _ = Describe("External", func() {
+	var constraints *v1alpha5.Constraints
+	BeforeEach(func() {
+		constraints = &v1alpha5.Constraints{
+			Labels: map[string]string{"test-key": "test-value"},
+			Requirements: v1alpha5.NewRequirements(
+				v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}},
+				v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"spot"}},
+			),
+		}
+	})
+
+	Context("GetInstanceTypes", func() {
+		It("should return the instance types of the out-of-tree cloud provider", func() {
+			instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instanceTypes).To(HaveLen(2))
+			Expect(instanceTypes[0].Name()).To(Equal("default-instance-type"))
+			Expect(instanceTypes[0].CPU().String()).To(Equal("4"))
+			Expect(instanceTypes[0].Memory().String()).To(Equal("4Gi"))
+			Expect(instanceTypes[0].Pods().String()).To(Equal("5"))
+			Expect(instanceTypes[0].EphemeralStorage().String()).To(Equal("20Gi"))
+			Expect(instanceTypes[0].NvidiaGPUs().IsZero()).To(BeTrue())
+			Expect(instanceTypes[0].Architecture()).To(Equal("amd64"))
+			Expect(instanceTypes[0].OperatingSystems().List()).To(ConsistOf("linux", "windows", "darwin"))
+			Expect(instanceTypes[0].Offerings()).To(ContainElement(cloudprovider.Offering{CapacityType: "spot", Zone: "test-zone-1"}))
+			Expect(instanceTypes[1].Name()).To(Equal("gpu-instance-type"))
+			Expect(instanceTypes[1].NvidiaGPUs().String()).To(Equal("2"))
+		})
+	})
+	Context("Create", func() {
+		It("should launch nodes for each node request", func() {
+			instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+			nodeRequests := []*cloudprovider.NodeRequest{
+				{Constraints: constraints, InstanceTypeOptions: instanceTypes[:1], Quantity: 2},
+				{Constraints: constraints, InstanceTypeOptions: instanceTypes[1:], Quantity: 1},
+			}
+			nodes := map[*cloudprovider.NodeRequest][]*v1.Node{}
+			Expect(cloudProvider.Create(ctx, nodeRequests, func(nodeRequest *cloudprovider.NodeRequest, node *v1.Node) error {
+				nodes[nodeRequest] = append(nodes[nodeRequest], node)
+				return nil
+			})).To(Succeed())
+			Expect(nodes[nodeRequests[0]]).To(HaveLen(2))
+			Expect(nodes[nodeRequests[1]]).To(HaveLen(1))
+			for _, node := range nodes[nodeRequests[0]] {
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "default-instance-type"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, "spot"))
+			}
+			Expect(nodes[nodeRequests[1]][0].Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "gpu-instance-type"))
+		})
+		It("should fail for unknown instance types", func() {
+			nodeRequests := []*cloudprovider.NodeRequest{{
+				Constraints:         constraints,
+				InstanceTypeOptions: []cloudprovider.InstanceType{fake.NewInstanceType(fake.InstanceTypeOptions{Name: "unknown-instance-type"})},
+				Quantity:            1,
+			}}
+			Expect(cloudProvider.Create(ctx, nodeRequests, func(*cloudprovider.NodeRequest, *v1.Node) error {
+				Fail("should not launch nodes")
+				return nil
+			})).ToNot(Succeed())
+		})
+		It("should return errors from the callback", func() {
+			instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+			nodeRequests := []*cloudprovider.NodeRequest{{Constraints: constraints, InstanceTypeOptions: instanceTypes, Quantity: 1}}
+			Expect(cloudProvider.Create(ctx, nodeRequests, func(*cloudprovider.NodeRequest, *v1.Node) error {
+				return fmt.Errorf("binding failed")
+			})).ToNot(Succeed())
+		})
+	})
+	Context("Delete", func() {
+		It("should delete nodes", func() {
+			Expect(cloudProvider.Delete(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}})).To(Succeed())
+		})
+	})
+	Context("Serialization", func() {
+		It("should only serialize nonzero capacity", func() {
+			instanceType := external.NewInstanceType(fake.NewInstanceType(fake.InstanceTypeOptions{Name: "test-instance-type", AWSPodENI: resource.MustParse("1")}))
+			Expect(instanceType.Capacity).To(HaveKey(v1.ResourceName(resources.AWSPodENI)))
+			Expect(instanceType.Capacity).ToNot(HaveKey(v1.ResourceName(resources.NvidiaGPU)))
+		})
+	})
+})
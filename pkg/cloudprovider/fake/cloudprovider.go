@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/Pallinder/go-randomdata"
 	"go.uber.org/multierr"
@@ -29,52 +30,70 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 type CloudProvider struct {
 	InstanceTypes []cloudprovider.InstanceType
+	// InPlaceUpdateStatus is reported for every in-place update. In-place
+	// updates are unsupported if it's empty.
+	InPlaceUpdateStatus cloudprovider.InPlaceUpdateStatus
+	// InPlaceUpdates are the names of the nodes that were updated in place
+	InPlaceUpdates []string
+	// CleanedUp are the names of the provisioners whose resources were cleaned up
+	CleanedUp []string
+	// ManagedResources is true if resources are managed on behalf of every provisioner
+	ManagedResources bool
+	// CreateCalls is the number of calls to Create
+	CreateCalls int64
 }
 
-func (c *CloudProvider) Create(_ context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, bind func(*v1.Node) error) error {
+func (c *CloudProvider) Create(_ context.Context, nodeRequests []*cloudprovider.NodeRequest, bind func(*cloudprovider.NodeRequest, *v1.Node) error) error {
+	atomic.AddInt64(&c.CreateCalls, 1)
 	var err error
-	for i := 0; i < quantity; i++ {
-		name := strings.ToLower(randomdata.SillyName())
-		instance := instanceTypes[0]
-		var zone, capacityType string
-		for _, o := range instance.Offerings() {
-			if constraints.Requirements.CapacityTypes().Has(o.CapacityType) && constraints.Requirements.Zones().Has(o.Zone) {
-				zone = o.Zone
-				capacityType = o.CapacityType
-				break
-			}
+	for _, nodeRequest := range nodeRequests {
+		for i := 0; i < nodeRequest.Quantity; i++ {
+			err = multierr.Append(err, bind(nodeRequest, c.node(nodeRequest)))
 		}
+	}
+	return err
+}
 
-		err = multierr.Append(err, bind(&v1.Node{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: name,
-				Labels: map[string]string{
-					v1.LabelTopologyZone:       zone,
-					v1.LabelInstanceTypeStable: instance.Name(),
-					v1alpha5.LabelCapacityType: capacityType,
-				},
+func (c *CloudProvider) node(nodeRequest *cloudprovider.NodeRequest) *v1.Node {
+	name := strings.ToLower(randomdata.SillyName())
+	instance := nodeRequest.InstanceTypeOptions[0]
+	var zone, capacityType string
+	for _, o := range instance.Offerings() {
+		if nodeRequest.Constraints.Requirements.CapacityTypes().Has(o.CapacityType) && nodeRequest.Constraints.Requirements.Zones().Has(o.Zone) {
+			zone = o.Zone
+			capacityType = o.CapacityType
+			break
+		}
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				v1.LabelTopologyZone:       zone,
+				v1.LabelInstanceTypeStable: instance.Name(),
+				v1alpha5.LabelCapacityType: capacityType,
 			},
-			Spec: v1.NodeSpec{
-				ProviderID: fmt.Sprintf("fake:///%s/%s", name, zone),
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: fmt.Sprintf("fake:///%s/%s", name, zone),
+		},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{
+				Architecture:    instance.Architecture(),
+				OperatingSystem: v1alpha5.OperatingSystemLinux,
 			},
-			Status: v1.NodeStatus{
-				NodeInfo: v1.NodeSystemInfo{
-					Architecture:    instance.Architecture(),
-					OperatingSystem: v1alpha5.OperatingSystemLinux,
-				},
-				Allocatable: v1.ResourceList{
-					v1.ResourcePods:   *instance.Pods(),
-					v1.ResourceCPU:    *instance.CPU(),
-					v1.ResourceMemory: *instance.Memory(),
-				},
+			Allocatable: v1.ResourceList{
+				v1.ResourcePods:   *instance.Pods(),
+				v1.ResourceCPU:    *instance.CPU(),
+				v1.ResourceMemory: *instance.Memory(),
 			},
-		}))
+		},
 	}
-	return err
 }
 
 func (c *CloudProvider) GetInstanceTypes(_ context.Context, _ *v1alpha5.Provider) ([]cloudprovider.InstanceType, error) {
@@ -117,6 +136,37 @@ func (c *CloudProvider) Delete(context.Context, *v1.Node) error {
 	return nil
 }
 
+func (c *CloudProvider) Cleanup(_ context.Context, provisionerName string) error {
+	c.CleanedUp = append(c.CleanedUp, provisionerName)
+	return nil
+}
+
+func (c *CloudProvider) ManagesResources(context.Context, *v1alpha5.Constraints) bool {
+	return c.ManagedResources
+}
+
+func (c *CloudProvider) ValidateInPlaceUpdate(context.Context, *v1.Node) error {
+	if c.InPlaceUpdateStatus == "" {
+		return cloudprovider.ErrInPlaceUpdateUnsupported
+	}
+	return nil
+}
+
+func (c *CloudProvider) UpdateInPlace(_ context.Context, node *v1.Node) (string, error) {
+	if c.InPlaceUpdateStatus == "" {
+		return "", cloudprovider.ErrInPlaceUpdateUnsupported
+	}
+	c.InPlaceUpdates = append(c.InPlaceUpdates, node.Name)
+	return fmt.Sprintf("update-%s", node.Name), nil
+}
+
+func (c *CloudProvider) GetInPlaceUpdate(context.Context, *v1.Node, string) (cloudprovider.InPlaceUpdateStatus, error) {
+	if c.InPlaceUpdateStatus == "" {
+		return "", cloudprovider.ErrInPlaceUpdateUnsupported
+	}
+	return c.InPlaceUpdateStatus, nil
+}
+
 func (c *CloudProvider) Default(context.Context, *v1alpha5.Constraints) {
 }
 
@@ -124,6 +174,11 @@ func (c *CloudProvider) Validate(context.Context, *v1alpha5.Constraints) *apis.F
 	return nil
 }
 
+// Comparators returns the property comparators that can order the fake instance types
+func (c *CloudProvider) Comparators() sets.String {
+	return sets.NewString("price")
+}
+
 // Name returns the CloudProvider implementation name.
 func (c *CloudProvider) Name() string {
 	return "fake"
@@ -48,6 +48,9 @@ func NewInstanceType(options InstanceTypeOptions) *InstanceType {
 	if options.Pods.IsZero() {
 		options.Pods = resource.MustParse("5")
 	}
+	if options.EphemeralStorage.IsZero() {
+		options.EphemeralStorage = resource.MustParse("20Gi")
+	}
 	return &InstanceType{
 		options: InstanceTypeOptions{
 			Name:             options.Name,
@@ -61,6 +64,10 @@ func NewInstanceType(options InstanceTypeOptions) *InstanceType {
 			AMDGPUs:          options.AMDGPUs,
 			AWSNeurons:       options.AWSNeurons,
 			AWSPodENI:        options.AWSPodENI,
+			AWSEFAs:          options.AWSEFAs,
+			EphemeralStorage: options.EphemeralStorage,
+			Resources:        options.Resources,
+			Price:            options.Price,
 		},
 	}
 }
@@ -95,6 +102,13 @@ type InstanceTypeOptions struct {
 	AMDGPUs          resource.Quantity
 	AWSNeurons       resource.Quantity
 	AWSPodENI        resource.Quantity
+	AWSEFAs          resource.Quantity
+	EphemeralStorage resource.Quantity
+	// Resources are any other resources of the instance type, e.g. extended
+	// resources of device plugins
+	Resources v1.ResourceList
+	// Price is the hourly price of the instance type, which is unknown if nil
+	Price *float64
 }
 
 type InstanceType struct {
@@ -145,9 +159,34 @@ func (i *InstanceType) AWSPodENI() *resource.Quantity {
 	return &i.options.AWSPodENI
 }
 
-func (i *InstanceType) Overhead() v1.ResourceList {
-	return v1.ResourceList{
-		v1.ResourceCPU:    resource.MustParse("100m"),
-		v1.ResourceMemory: resource.MustParse("10Mi"),
+func (i *InstanceType) AWSEFAs() *resource.Quantity {
+	return &i.options.AWSEFAs
+}
+
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	return &i.options.EphemeralStorage
+}
+
+func (i *InstanceType) Resources() v1.ResourceList {
+	result := cloudprovider.KnownResources(i)
+	for resourceName, quantity := range i.options.Resources {
+		result[resourceName] = quantity
+	}
+	return result
+}
+
+func (i *InstanceType) Overhead() *cloudprovider.InstanceTypeOverhead {
+	return &cloudprovider.InstanceTypeOverhead{
+		KubeReserved: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("100m"),
+			v1.ResourceMemory: resource.MustParse("10Mi"),
+		},
+	}
+}
+
+func (i *InstanceType) Price() (float64, bool) {
+	if i.options.Price == nil {
+		return 0, false
 	}
+	return *i.options.Price, true
 }
@@ -19,6 +19,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/apis"
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
@@ -67,9 +68,9 @@ func Decorate(cloudProvider cloudprovider.CloudProvider) cloudprovider.CloudProv
 	return &decorator{cloudProvider}
 }
 
-func (d *decorator) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) error {
+func (d *decorator) Create(ctx context.Context, nodeRequests []*cloudprovider.NodeRequest, callback func(*cloudprovider.NodeRequest, *v1.Node) error) error {
 	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "Create", d.Name()))()
-	return d.CloudProvider.Create(ctx, constraints, instanceTypes, quantity, callback)
+	return d.CloudProvider.Create(ctx, nodeRequests, callback)
 }
 
 func (d *decorator) Delete(ctx context.Context, node *v1.Node) error {
@@ -77,11 +78,59 @@ func (d *decorator) Delete(ctx context.Context, node *v1.Node) error {
 	return d.CloudProvider.Delete(ctx, node)
 }
 
+func (d *decorator) ValidateInPlaceUpdate(ctx context.Context, node *v1.Node) error {
+	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "ValidateInPlaceUpdate", d.Name()))()
+	return d.CloudProvider.ValidateInPlaceUpdate(ctx, node)
+}
+
+func (d *decorator) UpdateInPlace(ctx context.Context, node *v1.Node) (string, error) {
+	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "UpdateInPlace", d.Name()))()
+	return d.CloudProvider.UpdateInPlace(ctx, node)
+}
+
+func (d *decorator) GetInPlaceUpdate(ctx context.Context, node *v1.Node, id string) (cloudprovider.InPlaceUpdateStatus, error) {
+	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "GetInPlaceUpdate", d.Name()))()
+	return d.CloudProvider.GetInPlaceUpdate(ctx, node, id)
+}
+
+func (d *decorator) Cleanup(ctx context.Context, provisionerName string) error {
+	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "Cleanup", d.Name()))()
+	return d.CloudProvider.Cleanup(ctx, provisionerName)
+}
+
 func (d *decorator) GetInstanceTypes(ctx context.Context, provider *v1alpha5.Provider) ([]cloudprovider.InstanceType, error) {
 	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "GetInstanceTypes", d.Name()))()
 	return d.CloudProvider.GetInstanceTypes(ctx, provider)
 }
 
+// Warm forwards to the decorated cloud provider, if it's a cloudprovider.Warmer
+func (d *decorator) Warm(ctx context.Context, constraints *v1alpha5.Constraints) error {
+	warmer, ok := d.CloudProvider.(cloudprovider.Warmer)
+	if !ok {
+		return nil
+	}
+	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "Warm", d.Name()))()
+	return warmer.Warm(ctx, constraints)
+}
+
+// ManagesResources forwards to the decorated cloud provider, if it's a cloudprovider.ResourceManager
+func (d *decorator) ManagesResources(ctx context.Context, constraints *v1alpha5.Constraints) bool {
+	manager, ok := d.CloudProvider.(cloudprovider.ResourceManager)
+	if !ok {
+		return false
+	}
+	return manager.ManagesResources(ctx, constraints)
+}
+
+// Comparators forwards to the decorated cloud provider, if it's a cloudprovider.ComparableCloudProvider
+func (d *decorator) Comparators() sets.String {
+	comparable, ok := d.CloudProvider.(cloudprovider.ComparableCloudProvider)
+	if !ok {
+		return sets.NewString()
+	}
+	return comparable.Comparators()
+}
+
 func (d *decorator) Default(ctx context.Context, constraints *v1alpha5.Constraints) {
 	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(injection.GetControllerName(ctx), "Default", d.Name()))()
 	d.CloudProvider.Default(ctx, constraints)
@@ -0,0 +1,210 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Comparator orders two instance types, returning a negative number if a is
+// preferred over b, a positive number if b is preferred over a, or zero if the
+// comparator doesn't distinguish between them.
+type Comparator func(a, b InstanceType) int
+
+// PricedInstanceType is implemented by instance types whose hourly price is known
+type PricedInstanceType interface {
+	// Price returns the hourly price of the instance type, if known
+	Price() (float64, bool)
+}
+
+// GenerationalInstanceType is implemented by instance types that belong to a
+// generation of hardware, where later generations are generally preferable
+type GenerationalInstanceType interface {
+	// Generation returns the generation of the instance type, if known
+	Generation() (int, bool)
+}
+
+// InterruptibleInstanceType is implemented by instance types whose rate of
+// interruption, e.g. of spot capacity, is known
+type InterruptibleInstanceType interface {
+	// InterruptionRate returns the fraction of instances that are interrupted, if known
+	InterruptionRate() (float64, bool)
+}
+
+// FamilyInstanceType is implemented by instance types that belong to a family
+// of similar hardware, whose capacity is likely to be interrupted together
+type FamilyInstanceType interface {
+	// Family returns the name of the instance type's family
+	Family() string
+}
+
+// Family returns the family of the instance type, defaulting to the part of
+// its name before the first ".", e.g. m5 for m5.large
+func Family(instanceType InstanceType) string {
+	if family, ok := instanceType.(FamilyInstanceType); ok {
+		return family.Family()
+	}
+	return strings.SplitN(instanceType.Name(), ".", 2)[0]
+}
+
+// comparators are the comparators that may be chained by name. They must
+// only be registered during initialization.
+var comparators = map[string]Comparator{
+	"size":              CompareSize,
+	"price":             ComparePrice,
+	"generation":        CompareGeneration,
+	"interruption-rate": CompareInterruptionRate,
+}
+
+// propertyComparators rank instance types by properties that not every cloud
+// provider knows, so they may only be chained for cloud providers that do
+var propertyComparators = sets.NewString("price", "generation", "interruption-rate")
+
+// ComparableCloudProvider is optionally implemented by cloud providers to name
+// the property comparators, e.g. price, that can order their instance types.
+// Other property comparators are rejected, since they'd rank every instance
+// type of the cloud provider equally.
+type ComparableCloudProvider interface {
+	Comparators() sets.String
+}
+
+// RegisterComparator registers a comparator that may be chained by name, so
+// that distributions can tune the selection of instance types without patching
+// the scheduler. It must be called during initialization, e.g. from init().
+func RegisterComparator(name string, comparator Comparator) {
+	comparators[name] = comparator
+}
+
+// ComparatorChain orders instance types by each of its comparators in turn
+type ComparatorChain []Comparator
+
+// NewComparatorChain returns the chain of the named comparators, e.g. from the
+// comma separated --instance-type-ordering
+func NewComparatorChain(names ...string) (ComparatorChain, error) {
+	chain := ComparatorChain{}
+	for _, name := range names {
+		comparator, ok := comparators[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown instance type comparator %q", name)
+		}
+		chain = append(chain, comparator)
+	}
+	return chain, nil
+}
+
+// NewComparatorChainFor returns the chain of the named comparators, rejecting
+// property comparators that the cloud provider doesn't support
+func NewComparatorChainFor(cloudProvider CloudProvider, names ...string) (ComparatorChain, error) {
+	supported := sets.NewString()
+	if comparable, ok := cloudProvider.(ComparableCloudProvider); ok {
+		supported = comparable.Comparators()
+	}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); propertyComparators.Has(name) && !supported.Has(name) {
+			return nil, fmt.Errorf("instance type comparator %q isn't supported by the %s cloud provider", name, cloudProvider.Name())
+		}
+	}
+	return NewComparatorChain(names...)
+}
+
+// Compare returns the first nonzero result of the chain's comparators, and
+// breaks ties by name so that the order is deterministic
+func (c ComparatorChain) Compare(a, b InstanceType) int {
+	for _, comparator := range c {
+		if result := comparator(a, b); result != 0 {
+			return result
+		}
+	}
+	return strings.Compare(a.Name(), b.Name())
+}
+
+// Sort sorts the instance types in order of preference
+func (c ComparatorChain) Sort(instanceTypes []InstanceType) {
+	sort.SliceStable(instanceTypes, func(i, j int) bool { return c.Compare(instanceTypes[i], instanceTypes[j]) < 0 })
+}
+
+// CompareSize prefers instance types with fewer accelerators, then fewer CPUs,
+// then less memory
+func CompareSize(a, b InstanceType) int {
+	if result := a.NvidiaGPUs().Cmp(*b.NvidiaGPUs()); result != 0 {
+		return result
+	}
+	if result := a.AMDGPUs().Cmp(*b.AMDGPUs()); result != 0 {
+		return result
+	}
+	if result := a.AWSNeurons().Cmp(*b.AWSNeurons()); result != 0 {
+		return result
+	}
+	if result := a.CPU().Cmp(*b.CPU()); result != 0 {
+		return result
+	}
+	return a.Memory().Cmp(*b.Memory())
+}
+
+// ComparePrice prefers cheaper instance types, and those with a known price
+func ComparePrice(a, b InstanceType) int {
+	return compareKnown(func(i InstanceType) (float64, bool) {
+		if priced, ok := i.(PricedInstanceType); ok {
+			return priced.Price()
+		}
+		return 0, false
+	}, a, b)
+}
+
+// CompareGeneration prefers instance types of later generations, and those with a known generation
+func CompareGeneration(a, b InstanceType) int {
+	return compareKnown(func(i InstanceType) (float64, bool) {
+		if generational, ok := i.(GenerationalInstanceType); ok {
+			generation, ok := generational.Generation()
+			return -float64(generation), ok
+		}
+		return 0, false
+	}, a, b)
+}
+
+// CompareInterruptionRate prefers instance types that are interrupted less
+// often, and those with a known interruption rate
+func CompareInterruptionRate(a, b InstanceType) int {
+	return compareKnown(func(i InstanceType) (float64, bool) {
+		if interruptible, ok := i.(InterruptibleInstanceType); ok {
+			return interruptible.InterruptionRate()
+		}
+		return 0, false
+	}, a, b)
+}
+
+// compareKnown prefers lower values, ranking unknown values last
+func compareKnown(value func(InstanceType) (float64, bool), a, b InstanceType) int {
+	valueA, knownA := value(a)
+	valueB, knownB := value(b)
+	switch {
+	case !knownA && !knownB:
+		return 0
+	case !knownA:
+		return 1
+	case !knownB:
+		return -1
+	case valueA < valueB:
+		return -1
+	case valueA > valueB:
+		return 1
+	default:
+		return 0
+	}
+}
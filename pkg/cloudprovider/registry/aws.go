@@ -23,6 +23,11 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider/aws"
 )
 
-func newCloudProvider(ctx context.Context, options cloudprovider.Options) cloudprovider.CloudProvider {
-	return aws.NewCloudProvider(ctx, options)
+// defaultCloudProvider is used if --cloud-provider is not set
+const defaultCloudProvider = "aws"
+
+func init() {
+	Register(defaultCloudProvider, func(ctx context.Context, options cloudprovider.Options) cloudprovider.CloudProvider {
+		return aws.NewCloudProvider(ctx, options)
+	})
 }
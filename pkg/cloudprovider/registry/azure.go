@@ -0,0 +1,33 @@
+//go:build azure
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/azure"
+)
+
+// defaultCloudProvider is used if --cloud-provider is not set
+const defaultCloudProvider = azure.Name
+
+func init() {
+	Register(defaultCloudProvider, func(ctx context.Context, options cloudprovider.Options) cloudprovider.CloudProvider {
+		return azure.NewCloudProvider(ctx, options)
+	})
+}
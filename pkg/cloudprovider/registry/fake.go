@@ -1,4 +1,4 @@
-//go:build !aws
+//go:build !aws && !azure
 
 /*
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -23,6 +23,11 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 )
 
-func newCloudProvider(context.Context, cloudprovider.Options) cloudprovider.CloudProvider {
-	return &fake.CloudProvider{}
+// defaultCloudProvider is used if --cloud-provider is not set
+const defaultCloudProvider = "fake"
+
+func init() {
+	Register(defaultCloudProvider, func(context.Context, cloudprovider.Options) cloudprovider.CloudProvider {
+		return &fake.CloudProvider{}
+	})
 }
@@ -16,13 +16,61 @@ package registry
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/injection"
 )
 
+// Factory constructs a cloud provider
+type Factory func(context.Context, cloudprovider.Options) cloudprovider.CloudProvider
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a cloud provider available by name, so that it can be
+// selected at startup with --cloud-provider. Register is typically called from
+// an init() function, and panics if the name is already registered.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("cloud provider %s is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// Names returns the names of the registered cloud providers
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := []string{}
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCloudProvider constructs the cloud provider selected by --cloud-provider,
+// defaulting to the cloud provider compiled into the binary.
 func NewCloudProvider(ctx context.Context, options cloudprovider.Options) cloudprovider.CloudProvider {
-	cloudProvider := newCloudProvider(ctx, options)
+	name := injection.GetOptions(ctx).CloudProvider
+	if name == "" {
+		name = defaultCloudProvider
+	}
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		panic(fmt.Sprintf("cloud provider %s is not registered, must be one of %v", name, Names()))
+	}
+	cloudProvider := factory(ctx, options)
 	RegisterOrDie(ctx, cloudProvider)
 	return cloudProvider
 }
@@ -16,6 +16,7 @@ package cloudprovider
 
 import (
 	"context"
+	"errors"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -24,17 +25,36 @@ import (
 	"knative.dev/pkg/apis"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
 // CloudProvider interface is implemented by cloud providers to support provisioning.
 type CloudProvider interface {
-	// Create a set of nodes for each of the given constraints. This API uses a
+	// Create nodes for each of the given node requests. This API uses a
 	// callback pattern to enable cloudproviders to batch capacity creation
-	// requests. The callback must be called with a theoretical node object that
-	// is fulfilled by the cloud providers capacity creation request.
-	Create(context.Context, *v1alpha5.Constraints, []InstanceType, int, func(*v1.Node) error) error
+	// requests, e.g. by fulfilling multiple node requests with a single call to
+	// the cloud provider's API. The callback must be called with the node
+	// request and a theoretical node object for each node that is fulfilled by
+	// the cloud providers capacity creation request.
+	Create(context.Context, []*NodeRequest, func(*NodeRequest, *v1.Node) error) error
 	// Delete node in cloudprovider
 	Delete(context.Context, *v1.Node) error
+	// Cleanup is a hook for deleting resources the cloudprovider manages on
+	// behalf of a provisioner, called once the named provisioner is being
+	// deleted and none of its nodes remain, or once its last orphaned node is
+	// terminated.
+	Cleanup(context.Context, string) error
+	// ValidateInPlaceUpdate returns ErrInPlaceUpdateUnsupported if the node
+	// can't be updated in place, so that it's replaced without being drained
+	// twice.
+	ValidateInPlaceUpdate(context.Context, *v1.Node) error
+	// UpdateInPlace starts patching the operating system of a drained node
+	// without replacing it, including any reboot, and returns an ID for the
+	// update. Returns ErrInPlaceUpdateUnsupported if the node can't be updated
+	// in place.
+	UpdateInPlace(context.Context, *v1.Node) (string, error)
+	// GetInPlaceUpdate returns the status of an update started by UpdateInPlace.
+	GetInPlaceUpdate(context.Context, *v1.Node, string) (InPlaceUpdateStatus, error)
 	// GetInstanceTypes returns instance types supported by the cloudprovider.
 	// Availability of types or zone may vary by provisioner or over time.
 	GetInstanceTypes(context.Context, *v1alpha5.Provider) ([]InstanceType, error)
@@ -46,6 +66,58 @@ type CloudProvider interface {
 	Name() string
 }
 
+// Warmer is optionally implemented by cloud providers that cache the responses
+// of their APIs. Warm is called in the background for each provisioner that is
+// started, e.g. when the controller restarts, to fill the caches that its
+// first provisioning round depends on.
+type Warmer interface {
+	Warm(context.Context, *v1alpha5.Constraints) error
+}
+
+// ResourceManager is optionally implemented by cloud providers that create
+// resources on behalf of provisioners, e.g. instance profiles. Only provisioners
+// that it manages resources for are given the cleanup finalizer, so that Cleanup
+// is called before they're removed.
+type ResourceManager interface {
+	// ManagesResources returns true if resources are created on behalf of
+	// provisioners with the constraints
+	ManagesResources(context.Context, *v1alpha5.Constraints) bool
+}
+
+// VolumeLimitedInstanceType is optionally implemented by instance types whose
+// attachable volume limits are known before any of their nodes register. The
+// limits are keyed by the resource names that the kubelet reports them with,
+// e.g. attachable-volumes-aws-ebs.
+type VolumeLimitedInstanceType interface {
+	VolumeLimits() v1.ResourceList
+}
+
+// ErrInPlaceUpdateUnsupported is returned by cloud providers that can't update
+// a node in place, so that it's replaced instead
+var ErrInPlaceUpdateUnsupported = errors.New("in-place updates are not supported")
+
+// InPlaceUpdateStatus is the status of an in-place update of a node
+type InPlaceUpdateStatus string
+
+const (
+	InPlaceUpdatePending   InPlaceUpdateStatus = "Pending"
+	InPlaceUpdateSucceeded InPlaceUpdateStatus = "Succeeded"
+	InPlaceUpdateFailed    InPlaceUpdateStatus = "Failed"
+)
+
+// NodeRequest is a request for a quantity of nodes that satisfy the
+// constraints, each using one of the instance type options
+type NodeRequest struct {
+	Constraints         *v1alpha5.Constraints
+	InstanceTypeOptions []InstanceType
+	Quantity            int
+	// PodRequests are the largest requests of the pods scheduled to any one of
+	// the nodes, for sizing resources that aren't fixed by the instance type
+	PodRequests v1.ResourceList
+	// DaemonRequests are the requests of the daemons that are scheduled to each of the nodes
+	DaemonRequests v1.ResourceList
+}
+
 // Options are injected into cloud providers' factories
 type Options struct {
 	ClientSet *kubernetes.Clientset
@@ -66,7 +138,52 @@ type InstanceType interface {
 	AMDGPUs() *resource.Quantity
 	AWSNeurons() *resource.Quantity
 	AWSPodENI() *resource.Quantity
-	Overhead() v1.ResourceList
+	// AWSEFAs returns the number of Elastic Fabric Adapter interfaces that can be attached to the instance type
+	AWSEFAs() *resource.Quantity
+	// EphemeralStorage returns the capacity of the filesystem that backs the kubelet's root directory
+	EphemeralStorage() *resource.Quantity
+	// Resources returns the capacity of every resource of the instance type that pods may request, including
+	// extended resources that are only advertised once device plugins register them on the running node
+	Resources() v1.ResourceList
+	// Overhead returns the resources reserved on the node that are not allocatable to pods
+	Overhead() *InstanceTypeOverhead
+}
+
+// KnownResources returns the capacity of the resources that the instance type
+// has accessors for, omitting those that it doesn't have
+func KnownResources(instanceType InstanceType) v1.ResourceList {
+	result := v1.ResourceList{}
+	for resourceName, quantity := range map[v1.ResourceName]*resource.Quantity{
+		v1.ResourceCPU:              instanceType.CPU(),
+		v1.ResourceMemory:           instanceType.Memory(),
+		v1.ResourcePods:             instanceType.Pods(),
+		resources.NvidiaGPU:         instanceType.NvidiaGPUs(),
+		resources.AMDGPU:            instanceType.AMDGPUs(),
+		resources.AWSNeuron:         instanceType.AWSNeurons(),
+		resources.AWSPodENI:         instanceType.AWSPodENI(),
+		resources.AWSEFA:            instanceType.AWSEFAs(),
+		v1.ResourceEphemeralStorage: instanceType.EphemeralStorage(),
+	} {
+		if quantity != nil && !quantity.IsZero() {
+			result[resourceName] = *quantity
+		}
+	}
+	return result
+}
+
+// InstanceTypeOverhead describes the resources reserved on a node for the kubelet,
+// system daemons, and eviction thresholds. Node allocatable is computed as the
+// instance type's capacity minus each of these components, see
+// https://kubernetes.io/docs/tasks/administer-cluster/reserve-compute-resources/#node-allocatable
+type InstanceTypeOverhead struct {
+	KubeReserved      v1.ResourceList
+	SystemReserved    v1.ResourceList
+	EvictionThreshold v1.ResourceList
+}
+
+// Total returns the sum of all overhead components
+func (o *InstanceTypeOverhead) Total() v1.ResourceList {
+	return resources.Merge(o.KubeReserved, o.SystemReserved, o.EvictionThreshold)
 }
 
 // An Offering describes where an InstanceType is available to be used, with the expectation that its properties
@@ -16,11 +16,14 @@ package counter
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -33,8 +36,22 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
+// wellKnownResources are counted in provisioner.status.resources
+var wellKnownResources = []v1.ResourceName{
+	v1.ResourceCPU,
+	v1.ResourceMemory,
+	v1.ResourcePods,
+	v1.ResourceEphemeralStorage,
+	resources.NvidiaGPU,
+	resources.AMDGPU,
+	resources.AWSNeuron,
+	resources.AWSPodENI,
+}
+
 // Controller for the resource
 type Controller struct {
 	kubeClient client.Client
@@ -57,33 +74,104 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, nil
 	}
 	persisted := provisioner.DeepCopy()
+	nodes := v1.NodeList{}
+	if err := c.kubeClient.List(ctx, &nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
 	// Determine resource usage and update provisioner.status.resources
-	resourceCounts, err := c.resourceCountsFor(ctx, provisioner.Name)
-	if err != nil {
-		return reconcile.Result{}, fmt.Errorf("computing resource usage, %w", err)
+	provisioner.Status.Resources = resourceCountsFor(nodes.Items)
+	provisioner.Status.ScopedResources = scopedResourceCountsFor(provisioner.Spec.Limits, nodes.Items)
+	// Record the last time the number of nodes changed
+	if nodeCount := int32(len(nodes.Items)); nodeCount != provisioner.Status.NodeCount {
+		provisioner.Status.NodeCount = nodeCount
+		provisioner.Status.LastScaleTime = &apis.VolatileTime{Inner: metav1.NewTime(injectabletime.Now())}
 	}
-	provisioner.Status.Resources = resourceCounts
+	c.updateConditions(ctx, provisioner)
 	if err := c.kubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
 		return reconcile.Result{}, fmt.Errorf("patching provisioner, %w", err)
 	}
 	return reconcile.Result{}, nil
 }
 
-func (c *Controller) resourceCountsFor(ctx context.Context, provisionerName string) (v1.ResourceList, error) {
-	nodes := v1.NodeList{}
-	if err := c.kubeClient.List(ctx, &nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisionerName}); err != nil {
-		return nil, err
+// updateConditions marks the provisioner as not ready if it's invalid or has
+// exceeded its limits, since it's unable to launch nodes in either case.
+func (c *Controller) updateConditions(ctx context.Context, provisioner *v1alpha5.Provisioner) {
+	conditions := provisioner.StatusConditions()
+	conditions.InitializeConditions()
+	validated := provisioner.DeepCopy()
+	validated.SetDefaults(ctx)
+	if err := validated.Validate(ctx); err != nil {
+		conditions.MarkFalse(v1alpha5.Active, "ValidationFailed", err.Error())
+	} else {
+		conditions.MarkTrue(v1alpha5.Active)
 	}
-	var cpu = resource.NewScaledQuantity(0, 0)
-	var memory = resource.NewScaledQuantity(0, resource.Giga)
-	for _, node := range nodes.Items {
-		cpu.Add(*node.Status.Capacity.Cpu())
-		memory.Add(*node.Status.Capacity.Memory())
+	if err := provisioner.Spec.Limits.ExceededBy(provisioner.Status.Resources); err != nil {
+		conditions.MarkFalse(v1alpha5.WithinLimits, "LimitsExceeded", err.Error())
+	} else if err := provisioner.Spec.Limits.ScopedExceededBy(provisioner.Status.ScopedResources, provisioner.Status.Resources); err != nil {
+		conditions.MarkFalse(v1alpha5.WithinLimits, "ScopedLimitsExceeded", err.Error())
+	} else {
+		conditions.MarkTrue(v1alpha5.WithinLimits)
+	}
+}
+
+// resourceCountsFor sums the capacity of well known resources across nodes
+func resourceCountsFor(nodes []v1.Node) v1.ResourceList {
+	counts := v1.ResourceList{
+		v1.ResourceCPU:    *resource.NewScaledQuantity(0, 0),
+		v1.ResourceMemory: *resource.NewScaledQuantity(0, resource.Giga),
+	}
+	for _, node := range nodes {
+		for _, resourceName := range wellKnownResources {
+			quantity, ok := node.Status.Capacity[resourceName]
+			if !ok {
+				continue
+			}
+			count, ok := counts[resourceName]
+			if !ok {
+				count = *resource.NewScaledQuantity(0, 0)
+			}
+			count.Add(quantity)
+			counts[resourceName] = count
+		}
 	}
-	return v1.ResourceList{
-		v1.ResourceCPU:    *cpu,
-		v1.ResourceMemory: *memory,
-	}, nil
+	return counts
+}
+
+// scopedResourceCountsFor sums the capacity of well known resources across the
+// nodes with each value of the labels that the scoped limits are scoped by.
+// The values that the limits name are counted even if no nodes have them.
+func scopedResourceCountsFor(limits *v1alpha5.Limits, nodes []v1.Node) []v1alpha5.ScopedResources {
+	if limits == nil || len(limits.Scoped) == 0 {
+		return nil
+	}
+	type scope struct{ key, value string }
+	scopes := map[scope][]v1.Node{}
+	for _, scoped := range limits.Scoped {
+		for _, value := range scoped.Values {
+			scopes[scope{scoped.Key, value}] = nil
+		}
+	}
+	for _, node := range nodes {
+		counted := map[scope]bool{}
+		for _, scoped := range limits.Scoped {
+			value, ok := node.Labels[scoped.Key]
+			if key := (scope{scoped.Key, value}); ok && scoped.Applies(value) && !counted[key] {
+				counted[key] = true
+				scopes[key] = append(scopes[key], node)
+			}
+		}
+	}
+	result := []v1alpha5.ScopedResources{}
+	for key, scopeNodes := range scopes {
+		result = append(result, v1alpha5.ScopedResources{Key: key.key, Value: key.value, Resources: resourceCountsFor(scopeNodes)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Key != result[j].Key {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
 }
 
 // Register the controller to the manager
@@ -95,7 +183,9 @@ func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
 		WithEventFilter(predicate.Funcs{
 			UpdateFunc: func(e event.UpdateEvent) bool {
 				// No need to reconcile the provisioner when nodes are updated since those don't affect the status of the provisioner.
-				return false
+				// Spec changes may affect the provisioner's conditions; status updates don't change its generation.
+				_, ok := e.ObjectNew.(*v1alpha5.Provisioner)
+				return ok && e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration()
 			},
 		}).
 		Watches(
@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counter_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/counter"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/resources"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *counter.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Counter")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		controller = counter.NewController(e.Client)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Counter", func() {
+	var provisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
+			Spec:       v1alpha5.ProvisionerSpec{},
+		}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	node := func(capacity v1.ResourceList) *v1.Node {
+		return test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}},
+			Capacity:   capacity,
+		})
+	}
+	reconcile := func() *v1alpha5.Provisioner {
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		return provisioner
+	}
+
+	It("should count nodes and resources", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client,
+			node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("4Gi"), v1.ResourcePods: resource.MustParse("10")}),
+			node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi"), v1.ResourcePods: resource.MustParse("20"), resources.NvidiaGPU: resource.MustParse("1")}),
+			test.Node(test.NodeOptions{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}),
+		)
+		provisioner = reconcile()
+		Expect(provisioner.Status.NodeCount).To(BeNumerically("==", 2))
+		Expect(provisioner.Status.LastScaleTime).ToNot(BeNil())
+		Expect(provisioner.Status.Resources.Cpu().String()).To(Equal("6"))
+		Expect(provisioner.Status.Resources.Memory().String()).To(Equal("12Gi"))
+		Expect(provisioner.Status.Resources.Pods().String()).To(Equal("30"))
+		gpus := provisioner.Status.Resources[resources.NvidiaGPU]
+		Expect(gpus.String()).To(Equal("1"))
+	})
+	It("should not update the last scale time if the node count is unchanged", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}))
+		lastScaleTime := reconcile().Status.LastScaleTime.DeepCopy()
+		Expect(reconcile().Status.LastScaleTime.Inner.Equal(&lastScaleTime.Inner)).To(BeTrue())
+	})
+	It("should be ready if the provisioner is valid and within its limits", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}))
+		provisioner = reconcile()
+		Expect(provisioner.StatusConditions().GetCondition(apis.ConditionReady).IsTrue()).To(BeTrue())
+		Expect(provisioner.StatusConditions().GetCondition(v1alpha5.Active).IsTrue()).To(BeTrue())
+		Expect(provisioner.StatusConditions().GetCondition(v1alpha5.WithinLimits).IsTrue()).To(BeTrue())
+	})
+	It("should not be ready if the provisioner has exceeded its limits", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}))
+		provisioner = reconcile()
+		Expect(provisioner.StatusConditions().GetCondition(apis.ConditionReady).IsFalse()).To(BeTrue())
+		Expect(provisioner.StatusConditions().GetCondition(v1alpha5.WithinLimits).Reason).To(Equal("LimitsExceeded"))
+	})
+	It("should count resources for each value of the scoped limits' labels", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Scoped: []v1alpha5.ScopedLimit{
+			{Key: v1.LabelTopologyZone, Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")}},
+			{Key: v1alpha5.LabelCapacityType, Values: []string{"spot"}, Percentages: map[v1.ResourceName]int32{v1.ResourceCPU: 60}},
+		}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		spot := node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+		spot.Labels[v1.LabelTopologyZone] = "test-zone-1"
+		spot.Labels[v1alpha5.LabelCapacityType] = "spot"
+		onDemand := node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+		onDemand.Labels[v1.LabelTopologyZone] = "test-zone-2"
+		onDemand.Labels[v1alpha5.LabelCapacityType] = "on-demand"
+		ExpectCreatedWithStatus(ctx, env.Client, spot, onDemand)
+		provisioner = reconcile()
+		Expect(provisioner.Status.ScopedResources).To(HaveLen(3))
+		for i, expected := range []struct{ key, value, cpu string }{
+			{v1alpha5.LabelCapacityType, "spot", "2"},
+			{v1.LabelTopologyZone, "test-zone-1", "2"},
+			{v1.LabelTopologyZone, "test-zone-2", "4"},
+		} {
+			Expect(provisioner.Status.ScopedResources[i].Key).To(Equal(expected.key))
+			Expect(provisioner.Status.ScopedResources[i].Value).To(Equal(expected.value))
+			Expect(provisioner.Status.ScopedResources[i].Resources.Cpu().String()).To(Equal(expected.cpu))
+		}
+		Expect(provisioner.StatusConditions().GetCondition(v1alpha5.WithinLimits).IsTrue()).To(BeTrue())
+	})
+	It("should not be ready if the provisioner has exceeded its scoped limits", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Scoped: []v1alpha5.ScopedLimit{
+			{Key: v1alpha5.LabelCapacityType, Values: []string{"spot"}, Percentages: map[v1.ResourceName]int32{v1.ResourceCPU: 60}},
+		}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		spot := node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+		spot.Labels[v1alpha5.LabelCapacityType] = "spot"
+		onDemand := node(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+		onDemand.Labels[v1alpha5.LabelCapacityType] = "on-demand"
+		ExpectCreatedWithStatus(ctx, env.Client, spot, onDemand)
+		provisioner = reconcile()
+		Expect(provisioner.StatusConditions().GetCondition(apis.ConditionReady).IsFalse()).To(BeTrue())
+		Expect(provisioner.StatusConditions().GetCondition(v1alpha5.WithinLimits).Reason).To(Equal("ScopedLimitsExceeded"))
+	})
+	It("should not be ready if the provisioner is invalid", func() {
+		provisioner.Spec.Labels = map[string]string{v1.LabelHostname: "restricted"}
+		ExpectApplied(ctx, env.Client, provisioner)
+		provisioner = reconcile()
+		Expect(provisioner.StatusConditions().GetCondition(apis.ConditionReady).IsFalse()).To(BeTrue())
+		Expect(provisioner.StatusConditions().GetCondition(v1alpha5.Active).Reason).To(Equal("ValidationFailed"))
+	})
+})
@@ -41,6 +41,21 @@ func NewManagerOrDie(ctx context.Context, config *rest.Config, options controlle
 	}); err != nil {
 		panic(fmt.Sprintf("Failed to setup pod indexer, %s", err))
 	}
+	// Events are listed by their source, e.g. by the dashboard
+	if err := newManager.GetFieldIndexer().IndexField(ctx, &v1.Event{}, "source", func(o client.Object) []string {
+		return []string{o.(*v1.Event).Source.Component}
+	}); err != nil {
+		panic(fmt.Sprintf("Failed to setup event indexer, %s", err))
+	}
+	// Persistent volumes are listed by the claims they were bound to, to predict the zones of StatefulSet volumes
+	if err := newManager.GetFieldIndexer().IndexField(ctx, &v1.PersistentVolume{}, "spec.claimRef", func(o client.Object) []string {
+		if claimRef := o.(*v1.PersistentVolume).Spec.ClaimRef; claimRef != nil {
+			return []string{claimRef.Namespace + "/" + claimRef.Name}
+		}
+		return nil
+	}); err != nil {
+		panic(fmt.Sprintf("Failed to setup persistent volume indexer, %s", err))
+	}
 	return &GenericControllerManager{Manager: newManager}
 }
 
@@ -237,6 +237,10 @@ func (c *Controller) record(ctx context.Context, node *v1.Node) error {
 	}
 	var daemons, pods []*v1.Pod
 	for index := range podlist.Items {
+		// Pods that have outlived their termination grace period no longer count toward the node's utilization
+		if podutil.IsPastTerminationGracePeriod(&podlist.Items[index]) {
+			continue
+		}
 		if podutil.IsOwnedByDaemonSet(&podlist.Items[index]) {
 			daemons = append(daemons, &podlist.Items[index])
 		} else {
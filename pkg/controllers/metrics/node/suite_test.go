@@ -16,18 +16,22 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/metrics/node"
 	"github.com/aws/karpenter/pkg/test"
 	. "github.com/aws/karpenter/pkg/test/expectations"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var controller *node.Controller
@@ -80,4 +84,50 @@ var _ = Describe("Node Metrics", func() {
 			}
 		}
 	})
+	It("should not count the requests of pods past their termination grace period", func() {
+		node := test.Node(test.NodeOptions{
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+		})
+		pod := test.Pod(test.PodOptions{
+			NodeName:             node.Name,
+			Phase:                v1.PodRunning,
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		})
+		ExpectCreated(ctx, env.Client, node)
+		ExpectCreatedWithStatus(ctx, env.Client, pod)
+		// The pod is bound to a node, so it's terminating until the kubelet confirms its deletion
+		Expect(env.Client.Delete(ctx, pod, &client.DeleteOptions{GracePeriodSeconds: ptr.Int64(30)})).To(Succeed())
+		defer func() { injectabletime.Now = time.Now }()
+
+		// Within the grace period, the pod's requests still count
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		Expect(ExpectNodeGauge("karpenter_nodes_total_pod_requests", node.Name, "cpu")).To(Equal(ptr.Float64(1)))
+
+		// Past the grace period, they're considered released
+		injectabletime.Now = func() time.Time { return time.Now().Add(time.Minute) }
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		Expect(ExpectNodeGauge("karpenter_nodes_total_pod_requests", node.Name, "cpu")).To(BeNil())
+		ExpectDeleted(ctx, env.Client, pod)
+	})
 })
+
+// ExpectNodeGauge returns the value of the metric for the node and resource type, or nil if it isn't set
+func ExpectNodeGauge(name string, nodeName string, resourceType string) *float64 {
+	families, err := crmetrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["node_name"] == nodeName && labels["resource_type"] == resourceType {
+				return ptr.Float64(metric.GetGauge().GetValue())
+			}
+		}
+	}
+	return nil
+}
@@ -23,6 +23,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"knative.dev/pkg/logging"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -33,18 +34,33 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/termination"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/result"
 )
 
 const controllerName = "node"
 
 // NewController constructs a controller instance
-func NewController(kubeClient client.Client) *Controller {
+func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, recorder events.Recorder) *Controller {
 	return &Controller{
 		kubeClient:     kubeClient,
 		initialization: &Initialization{kubeClient: kubeClient},
-		emptiness:      &Emptiness{kubeClient: kubeClient},
-		expiration:     &Expiration{kubeClient: kubeClient},
+		emptiness:      &Emptiness{kubeClient: kubeClient, recorder: recorder},
+		expiration:     &Expiration{kubeClient: kubeClient, recorder: recorder},
+		inPlaceUpdate: &InPlaceUpdate{
+			kubeClient:    kubeClient,
+			cloudProvider: cloudProvider,
+			terminator: &termination.Terminator{
+				KubeClient:    kubeClient,
+				CoreV1Client:  coreV1Client,
+				CloudProvider: cloudProvider,
+				EvictionQueue: termination.NewEvictionQueue(ctx, coreV1Client),
+				Recorder:      recorder,
+			},
+			recorder: recorder,
+		},
 	}
 }
 
@@ -55,6 +71,7 @@ type Controller struct {
 	initialization *Initialization
 	emptiness      *Emptiness
 	expiration     *Expiration
+	inPlaceUpdate  *InPlaceUpdate
 	finalizer      *Finalizer
 }
 
@@ -76,11 +93,11 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, nil
 	}
 
-	// 2. Retrieve Provisioner
+	// 2. Retrieve Provisioner, orphaning the node if it no longer exists
 	provisioner := &v1alpha5.Provisioner{}
 	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: stored.Labels[v1alpha5.ProvisionerNameLabelKey]}, provisioner); err != nil {
 		if errors.IsNotFound(err) {
-			return reconcile.Result{}, nil
+			return reconcile.Result{}, c.orphan(ctx, stored)
 		}
 		return reconcile.Result{}, err
 	}
@@ -94,6 +111,7 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	}{
 		c.initialization,
 		c.expiration,
+		c.inPlaceUpdate,
 		c.emptiness,
 		c.finalizer,
 	} {
@@ -102,7 +120,13 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		results = append(results, res)
 	}
 
-	// 4. Patch any changes, regardless of errors
+	// 4. Patch any changes, regardless of errors. The status is a subresource,
+	// so it's patched separately.
+	if !equality.Semantic.DeepEqual(node.Status, stored.Status) {
+		if err := c.kubeClient.Status().Patch(ctx, node.DeepCopy(), client.StrategicMergeFrom(stored)); err != nil {
+			return reconcile.Result{}, fmt.Errorf("patching node status, %w", err)
+		}
+	}
 	if !equality.Semantic.DeepEqual(node, stored) {
 		if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
 			return reconcile.Result{}, fmt.Errorf("patching node, %w", err)
@@ -115,6 +139,22 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	return result.Min(results...), nil
 }
 
+// orphan removes the provisioner label from a node whose provisioner was
+// deleted, leaving the node running. The termination finalizer is kept so
+// that the instance is still cleaned up if the node is deleted later, and the
+// provisioner's name is kept in another label so that the cloud provider's
+// resources for the provisioner are cleaned up after its last node.
+func (c *Controller) orphan(ctx context.Context, stored *v1.Node) error {
+	node := stored.DeepCopy()
+	node.Labels[v1alpha5.OrphanedProvisionerNameLabelKey] = node.Labels[v1alpha5.ProvisionerNameLabelKey]
+	delete(node.Labels, v1alpha5.ProvisionerNameLabelKey)
+	if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+		return fmt.Errorf("patching node, %w", err)
+	}
+	logging.FromContext(ctx).Infof("Orphaned node of deleted provisioner %s", stored.Labels[v1alpha5.ProvisionerNameLabelKey])
+	return nil
+}
+
 func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
 	return controllerruntime.
 		NewControllerManagedBy(m).
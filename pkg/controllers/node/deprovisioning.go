@@ -0,0 +1,114 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/pod"
+)
+
+const (
+	// DeprovisioningReasonExpired is recorded on nodes deprovisioned after ttlSecondsUntilExpired
+	DeprovisioningReasonExpired = "expired"
+	// DeprovisioningReasonEmpty is recorded on nodes deprovisioned after ttlSecondsAfterEmpty
+	DeprovisioningReasonEmpty = "empty"
+)
+
+// deprovision takes the deprovisioning action configured for the provisioner.
+// In Cordon mode, the node is cordoned and annotated with the reason in place,
+// and the changes are patched by the controller. Otherwise, the node is
+// deleted, which triggers the termination workflow. Nodes that opted out with
+// the do-not-consolidate annotation, or that are running a pod with the
+// do-not-evict annotation, are left alone until the annotation is removed or
+// the pod completes, which requeues the node.
+func deprovision(ctx context.Context, kubeClient client.Client, recorder events.Recorder, provisioner *v1alpha5.Provisioner, node *v1.Node, reason string) error {
+	mode := deprovisioningMode(ctx, provisioner)
+	if mode == v1alpha5.DeprovisioningModeCordon && isDeprovisioningCandidate(node) {
+		return nil
+	}
+	blocker, err := deprovisioningBlocker(ctx, kubeClient, node)
+	if err != nil {
+		return err
+	}
+	if blocker != "" {
+		logging.FromContext(ctx).Debugf("Unable to deprovision %s node, %s", reason, blocker)
+		recorder.DeprovisioningBlocked(node, reason, blocker)
+		return nil
+	}
+	if mode == v1alpha5.DeprovisioningModeCordon {
+		node.Spec.Unschedulable = true
+		node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha5.DeprovisioningCandidateAnnotationKey: reason})
+		logging.FromContext(ctx).Infof("Cordoned node, leaving termination to the cluster operator")
+		return nil
+	}
+	// Annotate the reason before deleting the node, so that it's included in
+	// the node's terminated record
+	annotated := node.DeepCopy()
+	annotated.Annotations = functional.UnionStringMaps(annotated.Annotations, map[string]string{v1alpha5.DeprovisioningReasonAnnotationKey: reason})
+	if err := kubeClient.Patch(ctx, annotated, client.MergeFrom(node)); err != nil {
+		return fmt.Errorf("annotating node, %w", err)
+	}
+	if err := kubeClient.Delete(ctx, annotated); err != nil {
+		return fmt.Errorf("deleting node, %w", err)
+	}
+	return nil
+}
+
+// deprovisioningBlocker returns why the node must not be deprovisioned, or an
+// empty string if it may be
+func deprovisioningBlocker(ctx context.Context, kubeClient client.Client, node *v1.Node) (string, error) {
+	if node.Annotations[v1alpha5.DoNotConsolidateNodeAnnotationKey] == "true" {
+		return fmt.Sprintf("node has the %s annotation", v1alpha5.DoNotConsolidateNodeAnnotationKey), nil
+	}
+	pods := &v1.PodList{}
+	if err := kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": node.Name}); err != nil {
+		return "", fmt.Errorf("listing pods for node, %w", err)
+	}
+	for i := range pods.Items {
+		if p := &pods.Items[i]; pod.HasDoNotEvict(p) && !pod.IsTerminal(p) {
+			return fmt.Sprintf("pod %s/%s has the %s annotation", p.Namespace, p.Name, v1alpha5.DoNotEvictPodAnnotationKey), nil
+		}
+	}
+	return "", nil
+}
+
+// isDeprovisioningCandidate returns true if the node has already been cordoned
+// and annotated for deprovisioning, in which case the first reason is kept.
+func isDeprovisioningCandidate(node *v1.Node) bool {
+	_, ok := node.Annotations[v1alpha5.DeprovisioningCandidateAnnotationKey]
+	return ok && node.Spec.Unschedulable
+}
+
+// deprovisioningMode returns the provisioner's deprovisioning mode, falling
+// back to the controller's --deprovisioning-mode.
+func deprovisioningMode(ctx context.Context, provisioner *v1alpha5.Provisioner) v1alpha5.DeprovisioningMode {
+	if provisioner.Spec.DeprovisioningMode != nil {
+		return *provisioner.Spec.DeprovisioningMode
+	}
+	if mode := injection.GetOptions(ctx).DeprovisioningMode; mode != "" {
+		return v1alpha5.DeprovisioningMode(mode)
+	}
+	return v1alpha5.DeprovisioningModeDelete
+}
@@ -26,15 +26,17 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
 	"github.com/aws/karpenter/pkg/utils/node"
 	"github.com/aws/karpenter/pkg/utils/pod"
 )
 
-// Emptiness is a subreconciler that deletes nodes that are empty after a ttl
+// Emptiness is a subreconciler that deprovisions nodes that are empty after a ttl
 type Emptiness struct {
 	kubeClient client.Client
+	recorder   events.Recorder
 }
 
 // Reconcile reconciles the node
@@ -43,7 +45,7 @@ func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisi
 	if provisioner.Spec.TTLSecondsAfterEmpty == nil {
 		return reconcile.Result{}, nil
 	}
-	if !node.IsReady(n) {
+	if !node.IsReady(n) || isUpdatingInPlace(n) {
 		return reconcile.Result{}, nil
 	}
 	// 2. Remove ttl if not empty
@@ -68,16 +70,16 @@ func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisi
 		logging.FromContext(ctx).Infof("Added TTL to empty node")
 		return reconcile.Result{RequeueAfter: ttl}, nil
 	}
-	// 4. Delete node if beyond TTL
+	// 4. Deprovision node if beyond TTL
 	emptinessTime, err := time.Parse(time.RFC3339, emptinessTimestamp)
 	if err != nil {
 		return reconcile.Result{}, fmt.Errorf("parsing emptiness timestamp, %s", emptinessTimestamp)
 	}
 	if injectabletime.Now().After(emptinessTime.Add(ttl)) {
-		logging.FromContext(ctx).Infof("Triggering termination after %s for empty node", ttl)
-		if err := r.kubeClient.Delete(ctx, n); err != nil {
-			return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+		if !isDeprovisioningCandidate(n) {
+			logging.FromContext(ctx).Infof("Deprovisioning node after %s for emptiness", ttl)
 		}
+		return reconcile.Result{}, deprovision(ctx, r.kubeClient, r.recorder, provisioner, n, DeprovisioningReasonEmpty)
 	}
 	return reconcile.Result{RequeueAfter: emptinessTime.Add(ttl).Sub(injectabletime.Now())}, nil
 }
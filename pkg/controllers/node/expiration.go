@@ -16,7 +16,6 @@ package node
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
@@ -26,29 +25,47 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/node"
 )
 
-// Expiration is a subreconciler that terminates nodes after a period of time.
+// Expiration is a subreconciler that deprovisions nodes after a period of time.
+// Expired nodes of provisioners with the InPlace update strategy are left to
+// the InPlaceUpdate subreconciler, unless they're only cordoned.
 type Expiration struct {
 	kubeClient client.Client
+	recorder   events.Recorder
 }
 
 // Reconcile reconciles the node
 func (r *Expiration) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node) (reconcile.Result, error) {
 	// 1. Ignore node if not applicable
-	if provisioner.Spec.TTLSecondsUntilExpired == nil {
+	if provisioner.Spec.TTLSecondsUntilExpired == nil || updatesInPlace(ctx, provisioner) {
 		return reconcile.Result{}, nil
 	}
-	// 2. Trigger termination workflow if expired
-	expirationTTL := time.Duration(ptr.Int64Value(provisioner.Spec.TTLSecondsUntilExpired)) * time.Second
-	expirationTime := node.CreationTimestamp.Add(expirationTTL)
-	if injectabletime.Now().After(expirationTime) {
-		logging.FromContext(ctx).Infof("Triggering termination for expired node after %s (+%s)", expirationTTL, time.Since(expirationTime))
-		if err := r.kubeClient.Delete(ctx, node); err != nil {
-			return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+	// 2. Deprovision node if expired
+	expiration := expirationTime(provisioner, node)
+	if injectabletime.Now().After(expiration) {
+		if !isDeprovisioningCandidate(node) {
+			logging.FromContext(ctx).Infof("Deprovisioning expired node after %s (+%s)", expirationTTL(provisioner), time.Since(expiration))
 		}
+		return reconcile.Result{}, deprovision(ctx, r.kubeClient, r.recorder, provisioner, node, DeprovisioningReasonExpired)
 	}
 	// 3. Backoff until expired
-	return reconcile.Result{RequeueAfter: time.Until(expirationTime)}, nil
+	return reconcile.Result{RequeueAfter: time.Until(expiration)}, nil
+}
+
+func expirationTTL(provisioner *v1alpha5.Provisioner) time.Duration {
+	return time.Duration(ptr.Int64Value(provisioner.Spec.TTLSecondsUntilExpired)) * time.Second
+}
+
+// expirationTime returns when the node expires, measured from when it was
+// created or last updated in place
+func expirationTime(provisioner *v1alpha5.Provisioner, n *v1.Node) time.Time {
+	start := n.CreationTimestamp.Time
+	if condition := node.GetCondition(n.Status.Conditions, v1alpha5.NodeOSUpdate); condition.Status == v1.ConditionTrue && condition.LastTransitionTime.After(start) {
+		start = condition.LastTransitionTime.Time
+	}
+	return start.Add(expirationTTL(provisioner))
 }
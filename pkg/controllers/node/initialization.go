@@ -17,6 +17,7 @@ package node
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
@@ -27,12 +28,16 @@ import (
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
 	"github.com/aws/karpenter/pkg/utils/node"
+	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
-const InitializationTimeout = 15 * time.Minute
+const (
+	InitializationTimeout         = 15 * time.Minute
+	extendedResourcesPollInterval = 5 * time.Second
+)
 
 // Initialization is a subreconciler that
-// 1. Removes the NotReady taint when the node is ready. This taint is originally applied on node creation.
+// 1. Removes the NotReady taint when the node is ready and its extended resources are registered. This taint is originally applied on node creation.
 // 2. Terminates nodes that don't transition to ready within InitializationTimeout
 type Initialization struct {
 	kubeClient client.Client
@@ -55,6 +60,15 @@ func (r *Initialization) Reconcile(ctx context.Context, _ *v1alpha5.Provisioner,
 		}
 		return reconcile.Result{}, nil
 	}
+	// Pods that request extended resources are nominated to the node rather than bound, and are scheduled by the
+	// kube-scheduler once the taint is removed, so wait for the device plugins to register the resources
+	if unregistered := unregisteredResources(n); len(unregistered) > 0 {
+		if age := injectabletime.Now().Sub(n.GetCreationTimestamp().Time); age < InitializationTimeout {
+			logging.FromContext(ctx).Debugf("Waiting for extended resources %s to be registered", unregistered)
+			return reconcile.Result{RequeueAfter: extendedResourcesPollInterval}, nil
+		}
+		logging.FromContext(ctx).Infof("Extended resources %s weren't registered within %s, initializing node without them", unregistered, InitializationTimeout)
+	}
 	taints := []v1.Taint{}
 	for _, taint := range n.Spec.Taints {
 		if taint.Key != v1alpha5.NotReadyTaintKey {
@@ -64,3 +78,21 @@ func (r *Initialization) Reconcile(ctx context.Context, _ *v1alpha5.Provisioner,
 	n.Spec.Taints = taints
 	return reconcile.Result{}, nil
 }
+
+// unregisteredResources returns the extended resources that the node was
+// launched with the capacity for, but that aren't allocatable yet. The kubelet
+// zeroes them when it registers the node, until they're registered by device
+// plugins.
+func unregisteredResources(n *v1.Node) []string {
+	unregistered := []string{}
+	for resourceName := range n.Status.Capacity {
+		if !resources.IsExtended(resourceName) {
+			continue
+		}
+		if quantity, ok := n.Status.Allocatable[resourceName]; !ok || quantity.IsZero() {
+			unregistered = append(unregistered, string(resourceName))
+		}
+	}
+	sort.Strings(unregistered)
+	return unregistered
+}
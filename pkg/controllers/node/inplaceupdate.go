@@ -0,0 +1,228 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/termination"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/node"
+)
+
+const (
+	// InPlaceUpdatePollInterval is how often in-place updates are checked for progress
+	InPlaceUpdatePollInterval = 15 * time.Second
+
+	inPlaceUpdateReasonDraining    = "Draining"
+	inPlaceUpdateReasonUpdating    = "Updating"
+	inPlaceUpdateReasonSucceeded   = "Succeeded"
+	inPlaceUpdateReasonFailed      = "Failed"
+	inPlaceUpdateReasonUnsupported = "Unsupported"
+)
+
+// InPlaceUpdate is a subreconciler that patches the operating system of
+// expired nodes without replacing them, for provisioners with the InPlace
+// update strategy. Nodes are cordoned and drained, respecting pod disruption
+// budgets, before the cloud provider updates them, and are uncordoned once
+// they're ready again. Progress is reported by the OSUpdate node condition.
+// Nodes that can't be updated in place, or whose update fails, are
+// deprovisioned so that they're replaced. In the Cordon deprovisioning mode,
+// expired nodes are left to the Expiration subreconciler instead, since the
+// cluster operator drains them.
+type InPlaceUpdate struct {
+	kubeClient    client.Client
+	cloudProvider cloudprovider.CloudProvider
+	terminator    *termination.Terminator
+	recorder      events.Recorder
+}
+
+// Reconcile reconciles the node
+func (r *InPlaceUpdate) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	// 1. Ignore node if not applicable
+	if !updatesInPlace(ctx, provisioner) || provisioner.Spec.TTLSecondsUntilExpired == nil {
+		return reconcile.Result{}, nil
+	}
+	// 2. Continue an update in progress
+	if condition := node.GetCondition(n.Status.Conditions, v1alpha5.NodeOSUpdate); condition.Status == v1.ConditionUnknown {
+		switch condition.Reason {
+		case inPlaceUpdateReasonDraining:
+			return r.drain(ctx, provisioner, n)
+		case inPlaceUpdateReasonUpdating:
+			return r.poll(ctx, provisioner, n)
+		}
+	}
+	// 3. Backoff until expired
+	expiration := expirationTime(provisioner, n)
+	if injectabletime.Now().Before(expiration) {
+		return reconcile.Result{RequeueAfter: time.Until(expiration)}, nil
+	}
+	// 4. Replace nodes that couldn't be updated
+	if node.GetCondition(n.Status.Conditions, v1alpha5.NodeOSUpdate).Status == v1.ConditionFalse {
+		return reconcile.Result{}, deprovision(ctx, r.kubeClient, r.recorder, provisioner, n, DeprovisioningReasonExpired)
+	}
+	return r.begin(ctx, provisioner, n)
+}
+
+// begin cordons the node and starts draining it, if the provisioner's budget
+// of nodes updating at once allows. Nodes that can't be updated in place are
+// replaced without being drained first.
+func (r *InPlaceUpdate) begin(ctx context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	if err := r.cloudProvider.ValidateInPlaceUpdate(ctx, n); errors.Is(err, cloudprovider.ErrInPlaceUpdateUnsupported) {
+		logging.FromContext(ctx).Infof("Replacing expired node, %s", err)
+		setOSUpdateCondition(n, v1.ConditionFalse, inPlaceUpdateReasonUnsupported, "The node can't be updated in place")
+		return reconcile.Result{}, deprovision(ctx, r.kubeClient, r.recorder, provisioner, n, DeprovisioningReasonExpired)
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("validating in-place update, %w", err)
+	}
+	blocker, err := deprovisioningBlocker(ctx, r.kubeClient, n)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if blocker != "" {
+		logging.FromContext(ctx).Debugf("Unable to update %s node in place, %s", DeprovisioningReasonExpired, blocker)
+		r.recorder.DeprovisioningBlocked(n, DeprovisioningReasonExpired, blocker)
+		return reconcile.Result{}, nil
+	}
+	updating, err := r.updating(ctx, provisioner)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if maxUnavailable := maxUnavailable(provisioner); updating >= maxUnavailable {
+		logging.FromContext(ctx).Debugf("Waiting to update expired node in place, %d of %d nodes are updating", updating, maxUnavailable)
+		return reconcile.Result{RequeueAfter: InPlaceUpdatePollInterval}, nil
+	}
+	logging.FromContext(ctx).Infof("Updating expired node in place")
+	n.Spec.Unschedulable = true
+	setOSUpdateCondition(n, v1.ConditionUnknown, inPlaceUpdateReasonDraining, "Draining the node before updating it in place")
+	return reconcile.Result{Requeue: true}, nil
+}
+
+// drain evicts the node's pods, and starts the update once they're gone
+func (r *InPlaceUpdate) drain(ctx context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	drained, err := r.terminator.Drain(ctx, n)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if !drained {
+		return reconcile.Result{RequeueAfter: InPlaceUpdatePollInterval}, nil
+	}
+	id, err := r.cloudProvider.UpdateInPlace(ctx, n)
+	if errors.Is(err, cloudprovider.ErrInPlaceUpdateUnsupported) {
+		logging.FromContext(ctx).Infof("Replacing expired node, %s", err)
+		setOSUpdateCondition(n, v1.ConditionFalse, inPlaceUpdateReasonUnsupported, "The node can't be updated in place")
+		return reconcile.Result{}, deprovision(ctx, r.kubeClient, r.recorder, provisioner, n, DeprovisioningReasonExpired)
+	}
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("updating node in place, %w", err)
+	}
+	n.Annotations = functional.UnionStringMaps(n.Annotations, map[string]string{v1alpha5.InPlaceUpdateAnnotationKey: id})
+	setOSUpdateCondition(n, v1.ConditionUnknown, inPlaceUpdateReasonUpdating, "Updating the operating system")
+	return reconcile.Result{RequeueAfter: InPlaceUpdatePollInterval}, nil
+}
+
+// poll checks on the update, and uncordons the node once it's ready again
+func (r *InPlaceUpdate) poll(ctx context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	status, err := r.cloudProvider.GetInPlaceUpdate(ctx, n, n.Annotations[v1alpha5.InPlaceUpdateAnnotationKey])
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("getting in-place update, %w", err)
+	}
+	switch {
+	case status == cloudprovider.InPlaceUpdateFailed:
+		logging.FromContext(ctx).Errorf("Failed to update node in place, replacing it")
+		delete(n.Annotations, v1alpha5.InPlaceUpdateAnnotationKey)
+		setOSUpdateCondition(n, v1.ConditionFalse, inPlaceUpdateReasonFailed, "Failed to update the operating system in place")
+		return reconcile.Result{}, deprovision(ctx, r.kubeClient, r.recorder, provisioner, n, DeprovisioningReasonExpired)
+	case status == cloudprovider.InPlaceUpdateSucceeded && node.IsReady(n):
+		logging.FromContext(ctx).Infof("Updated node in place")
+		delete(n.Annotations, v1alpha5.InPlaceUpdateAnnotationKey)
+		n.Spec.Unschedulable = false
+		setOSUpdateCondition(n, v1.ConditionTrue, inPlaceUpdateReasonSucceeded, "Updated the operating system in place")
+		return reconcile.Result{}, nil
+	default:
+		return reconcile.Result{RequeueAfter: InPlaceUpdatePollInterval}, nil
+	}
+}
+
+// updating returns the number of the provisioner's nodes that are updating in place
+func (r *InPlaceUpdate) updating(ctx context.Context, provisioner *v1alpha5.Provisioner) (int32, error) {
+	nodes := &v1.NodeList{}
+	if err := r.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return 0, fmt.Errorf("listing nodes, %w", err)
+	}
+	var updating int32
+	for i := range nodes.Items {
+		if isUpdatingInPlace(&nodes.Items[i]) {
+			updating++
+		}
+	}
+	return updating, nil
+}
+
+// isUpdatingInPlace returns true if the node is being drained or updated in place
+func isUpdatingInPlace(n *v1.Node) bool {
+	return node.GetCondition(n.Status.Conditions, v1alpha5.NodeOSUpdate).Status == v1.ConditionUnknown
+}
+
+// updatesInPlace returns true if the provisioner's expired nodes are updated in
+// place, which is never the case in the Cordon deprovisioning mode
+func updatesInPlace(ctx context.Context, provisioner *v1alpha5.Provisioner) bool {
+	return provisioner.Spec.UpdateStrategy != nil && provisioner.Spec.UpdateStrategy.Type == v1alpha5.UpdateStrategyInPlace &&
+		deprovisioningMode(ctx, provisioner) != v1alpha5.DeprovisioningModeCordon
+}
+
+func maxUnavailable(provisioner *v1alpha5.Provisioner) int32 {
+	if provisioner.Spec.UpdateStrategy.MaxUnavailable != nil {
+		return *provisioner.Spec.UpdateStrategy.MaxUnavailable
+	}
+	return 1
+}
+
+// setOSUpdateCondition sets the OSUpdate condition of the node, which is
+// patched to its status by the controller
+func setOSUpdateCondition(n *v1.Node, status v1.ConditionStatus, reason string, message string) {
+	now := metav1.NewTime(injectabletime.Now())
+	condition := v1.NodeCondition{
+		Type:               v1alpha5.NodeOSUpdate,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+	for i := range n.Status.Conditions {
+		if n.Status.Conditions[i].Type == v1alpha5.NodeOSUpdate {
+			if n.Status.Conditions[i].Status == status {
+				condition.LastTransitionTime = n.Status.Conditions[i].LastTransitionTime
+			}
+			n.Status.Conditions[i] = condition
+			return
+		}
+	}
+	n.Status.Conditions = append(n.Status.Conditions, condition)
+}
@@ -22,15 +22,24 @@ import (
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/controllers/node"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	nodeutil "github.com/aws/karpenter/pkg/utils/node"
+	"github.com/aws/karpenter/pkg/utils/options"
+	"github.com/aws/karpenter/pkg/utils/resources"
 
 	. "github.com/aws/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	. "knative.dev/pkg/logging/testing"
 	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -39,6 +48,8 @@ import (
 
 var ctx context.Context
 var controller *node.Controller
+var recorder *test.EventRecorder
+var cloudProvider *fake.CloudProvider
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -49,7 +60,9 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		controller = node.NewController(e.Client)
+		recorder = test.NewEventRecorder()
+		cloudProvider = &fake.CloudProvider{}
+		controller = node.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, events.NewRecorder(recorder))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -69,6 +82,7 @@ var _ = Describe("Controller", func() {
 
 	AfterEach(func() {
 		injectabletime.Now = time.Now
+		recorder.Reset()
 		ExpectCleanedUp(ctx, env.Client)
 	})
 
@@ -118,6 +132,8 @@ var _ = Describe("Controller", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
 			n = ExpectNodeExists(ctx, env.Client, n.Name)
 			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha5.DeprovisioningReasonAnnotationKey, node.DeprovisioningReasonExpired))
+			Expect(n.Annotations).ToNot(HaveKey(v1alpha5.DeprovisioningCandidateAnnotationKey))
 		})
 	})
 
@@ -210,6 +226,46 @@ var _ = Describe("Controller", func() {
 			n = ExpectNodeExists(ctx, env.Client, n.Name)
 			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
 		})
+		It("should not remove the readiness taint until extended resources are registered", func() {
+			n := test.Node(test.NodeOptions{
+				ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}},
+				ReadyStatus: v1.ConditionTrue,
+				Taints:      []v1.Taint{{Key: v1alpha5.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}},
+				Capacity:    v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+				Allocatable: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("0")},
+			})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(v1alpha5.Taints(n.Spec.Taints).HasKey(v1alpha5.NotReadyTaintKey)).To(BeTrue())
+
+			// Simulate the device plugin registering the resource
+			n.Status.Allocatable[resources.NvidiaGPU] = resource.MustParse("1")
+			Expect(env.Client.Status().Update(ctx, n)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(v1alpha5.Taints(n.Spec.Taints).HasKey(v1alpha5.NotReadyTaintKey)).To(BeFalse())
+		})
+		It("should remove the readiness taint if extended resources aren't registered within the Initialization timeout", func() {
+			n := test.Node(test.NodeOptions{
+				ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}},
+				ReadyStatus: v1.ConditionTrue,
+				Taints:      []v1.Taint{{Key: v1alpha5.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}},
+				Capacity:    v1.ResourceList{"example.com/device": resource.MustParse("1")},
+			})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.InitializationTimeout) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(v1alpha5.Taints(n.Spec.Taints).HasKey(v1alpha5.NotReadyTaintKey)).To(BeFalse())
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
 	})
 	Describe("Emptiness", func() {
 		It("should not TTL nodes that have ready status unknown", func() {
@@ -309,6 +365,290 @@ var _ = Describe("Controller", func() {
 			Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
 		})
 	})
+	Context("Deprovisioning Mode", func() {
+		cordon := v1alpha5.DeprovisioningModeCordon
+		del := v1alpha5.DeprovisioningModeDelete
+		var n *v1.Node
+		BeforeEach(func() {
+			n = test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			injectabletime.Now = func() time.Time { return time.Now().Add(time.Hour) }
+		})
+		It("should cordon and annotate expired nodes instead of deleting them", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.DeprovisioningMode = &cordon
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			result := ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			Expect(result).To(Equal(reconcile.Result{}))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha5.DeprovisioningCandidateAnnotationKey, node.DeprovisioningReasonExpired))
+		})
+		It("should cordon and annotate empty nodes past their TTL instead of deleting them", func() {
+			provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(30)
+			provisioner.Spec.DeprovisioningMode = &cordon
+			n.Annotations = map[string]string{v1alpha5.EmptinessTimestampAnnotationKey: time.Now().Format(time.RFC3339)}
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha5.DeprovisioningCandidateAnnotationKey, node.DeprovisioningReasonEmpty))
+		})
+		It("should keep the first deprovisioning reason", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(30)
+			provisioner.Spec.DeprovisioningMode = &cordon
+			n.Annotations = map[string]string{v1alpha5.EmptinessTimestampAnnotationKey: time.Now().Format(time.RFC3339)}
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha5.DeprovisioningCandidateAnnotationKey, node.DeprovisioningReasonExpired))
+		})
+		It("should use the controller's deprovisioning mode if the provisioner does not set one", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			cordonCtx := injection.WithOptions(ctx, options.Options{DeprovisioningMode: string(v1alpha5.DeprovisioningModeCordon)})
+			ExpectReconcileSucceeded(cordonCtx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+		})
+		It("should prefer the provisioner's deprovisioning mode over the controller's", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.DeprovisioningMode = &del
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			cordonCtx := injection.WithOptions(ctx, options.Options{DeprovisioningMode: string(v1alpha5.DeprovisioningModeCordon)})
+			ExpectReconcileSucceeded(cordonCtx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+	})
+	Context("Blocked Deprovisioning", func() {
+		var n *v1.Node
+		BeforeEach(func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			n = test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			injectabletime.Now = func() time.Time { return time.Now().Add(time.Hour) }
+		})
+		It("should not deprovision nodes with the do-not-consolidate annotation", func() {
+			n.Annotations = map[string]string{v1alpha5.DoNotConsolidateNodeAnnotationKey: "true"}
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+			Expect(recorder.For(n, events.DeprovisioningBlocked)).To(HaveLen(1))
+		})
+		It("should not deprovision nodes running a pod with the do-not-evict annotation until it completes", func() {
+			p := test.Pod(test.PodOptions{
+				NodeName:   n.Name,
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DoNotEvictPodAnnotationKey: "true"}},
+			})
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			ExpectCreatedWithStatus(ctx, env.Client, p)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(recorder.For(n, events.DeprovisioningBlocked)).To(HaveLen(1))
+
+			p = ExpectPodExists(ctx, env.Client, p.Name, p.Namespace)
+			p.Status.Phase = v1.PodSucceeded
+			ExpectStatusUpdated(ctx, env.Client, p)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should not cordon nodes running a pod with the do-not-evict annotation", func() {
+			cordon := v1alpha5.DeprovisioningModeCordon
+			provisioner.Spec.DeprovisioningMode = &cordon
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			ExpectCreatedWithStatus(ctx, env.Client, test.Pod(test.PodOptions{
+				NodeName:   n.Name,
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DoNotEvictPodAnnotationKey: "true"}},
+			}))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+			Expect(n.Annotations).ToNot(HaveKey(v1alpha5.DeprovisioningCandidateAnnotationKey))
+		})
+	})
+	Context("In-Place Update", func() {
+		BeforeEach(func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.UpdateStrategy = &v1alpha5.UpdateStrategy{Type: v1alpha5.UpdateStrategyInPlace}
+			injectabletime.Now = func() time.Time { return time.Now().Add(time.Hour) }
+		})
+		AfterEach(func() {
+			cloudProvider.InPlaceUpdateStatus = ""
+			cloudProvider.InPlaceUpdates = nil
+		})
+		It("should update expired nodes in place", func() {
+			cloudProvider.InPlaceUpdateStatus = cloudprovider.InPlaceUpdateSucceeded
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			// Cordons the node before draining it
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha5.NodeOSUpdate).Status).To(Equal(v1.ConditionUnknown))
+
+			// Updates the drained node
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(cloudProvider.InPlaceUpdates).To(ConsistOf(n.Name))
+			Expect(n.Annotations).To(HaveKey(v1alpha5.InPlaceUpdateAnnotationKey))
+
+			// Uncordons the updated node
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+			Expect(n.Annotations).ToNot(HaveKey(v1alpha5.InPlaceUpdateAnnotationKey))
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha5.NodeOSUpdate).Status).To(Equal(v1.ConditionTrue))
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should delete nodes that can't be updated in place without draining them first", func() {
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+			Expect(nodeutil.GetCondition(n.Status.Conditions, v1alpha5.NodeOSUpdate).Status).ToNot(Equal(v1.ConditionUnknown))
+			Expect(cloudProvider.InPlaceUpdates).To(BeEmpty())
+		})
+		It("should cordon and annotate expired nodes instead of updating them in the Cordon deprovisioning mode", func() {
+			cloudProvider.InPlaceUpdateStatus = cloudprovider.InPlaceUpdateSucceeded
+			cordon := v1alpha5.DeprovisioningModeCordon
+			provisioner.Spec.DeprovisioningMode = &cordon
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+			Expect(n.Annotations).To(HaveKeyWithValue(v1alpha5.DeprovisioningCandidateAnnotationKey, node.DeprovisioningReasonExpired))
+			Expect(cloudProvider.InPlaceUpdates).To(BeEmpty())
+		})
+		It("should delete nodes whose update fails", func() {
+			cloudProvider.InPlaceUpdateStatus = cloudprovider.InPlaceUpdateFailed
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(cloudProvider.InPlaceUpdates).To(ConsistOf(n.Name))
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should not update more than maxUnavailable nodes at once", func() {
+			cloudProvider.InPlaceUpdateStatus = cloudprovider.InPlaceUpdatePending
+			updating := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			updating.Status.Conditions = append(updating.Status.Conditions, v1.NodeCondition{
+				Type:   v1alpha5.NodeOSUpdate,
+				Status: v1.ConditionUnknown,
+				Reason: "Updating",
+			})
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, updating, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should measure expiration from the last in-place update", func() {
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			n.Status.Conditions = append(n.Status.Conditions, v1.NodeCondition{
+				Type:               v1alpha5.NodeOSUpdate,
+				Status:             v1.ConditionTrue,
+				Reason:             "Succeeded",
+				LastTransitionTime: metav1.Time{Time: injectabletime.Now()},
+			})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+			Expect(cloudProvider.InPlaceUpdates).To(BeEmpty())
+		})
+	})
+	Context("Orphaning", func() {
+		It("should orphan nodes of deleted provisioners", func() {
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+			}})
+			ExpectCreated(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Labels).ToNot(HaveKey(v1alpha5.ProvisionerNameLabelKey))
+			Expect(n.Labels).To(HaveKeyWithValue(v1alpha5.OrphanedProvisionerNameLabelKey, provisioner.Name))
+			Expect(n.Finalizers).To(ContainElement(v1alpha5.TerminationFinalizer))
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should not orphan nodes of existing provisioners", func() {
+			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+			}})
+			ExpectCreated(ctx, env.Client, provisioner, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.Labels).To(HaveKeyWithValue(v1alpha5.ProvisionerNameLabelKey, provisioner.Name))
+		})
+	})
+
 	Context("Finalizer", func() {
 		It("should add the termination finalizer if missing", func() {
 			n := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
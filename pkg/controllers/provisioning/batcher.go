@@ -18,45 +18,102 @@ import (
 	"context"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter/pkg/metrics"
 )
 
 var (
+	// MaxBatchDuration and BatchIdleDuration are used if the batcher's options
+	// don't configure a batching window
 	MaxBatchDuration  = time.Second * 10
 	BatchIdleDuration = time.Second * 1
 	// MaxItemsPerBatch limits the number of items we process at one time to avoid using too much memory
 	MaxItemsPerBatch = 2_000
+	// MaxBatchesInFlight limits the number of batches that may be processed concurrently
+	MaxBatchesInFlight = 1
 )
 
+// BatcherOptions configure a Batcher. Zero values fall back to the package defaults.
+type BatcherOptions struct {
+	// Name identifies the batcher in metrics, e.g. the provisioner name
+	Name string
+	// IdleDuration closes the window if no items are added for this duration
+	IdleDuration time.Duration
+	// MaxDuration closes the window this long after the first item is added
+	MaxDuration time.Duration
+	// MaxItems closes the window once this many items have been added
+	MaxItems int
+	// MaxInFlight is the number of batches that may be collected or processed at once
+	MaxInFlight int
+}
+
+func (o BatcherOptions) idleDuration() time.Duration {
+	if o.IdleDuration > 0 {
+		return o.IdleDuration
+	}
+	return BatchIdleDuration
+}
+
+func (o BatcherOptions) maxDuration() time.Duration {
+	if o.MaxDuration > 0 {
+		return o.MaxDuration
+	}
+	return MaxBatchDuration
+}
+
+func (o BatcherOptions) maxItems() int {
+	if o.MaxItems > 0 {
+		return o.MaxItems
+	}
+	return MaxItemsPerBatch
+}
+
+func (o BatcherOptions) maxInFlight() int {
+	if o.MaxInFlight > 0 {
+		return o.MaxInFlight
+	}
+	return MaxBatchesInFlight
+}
+
 // Batcher separates a stream of Add(item) calls into windowed slices. The
 // window is dynamic and will be extended if additional items are added up to a
-// maximum batch duration or maximum items per batch.
+// maximum batch duration or maximum items per batch. Each batch has its own
+// gate, so a slow batch doesn't hold up callers waiting on later batches, and
+// up to MaxInFlight batches may be processed concurrently.
 type Batcher struct {
 	sync.RWMutex
-	running context.Context
-	queue   chan interface{}
-	gate    context.Context
-	flush   context.CancelFunc
+	options  BatcherOptions
+	running  context.Context
+	queue    chan interface{}
+	gate     context.Context
+	flush    context.CancelFunc
+	inflight chan struct{}
 }
 
 // NewBatcher is a constructor
-func NewBatcher(running context.Context) *Batcher {
+func NewBatcher(running context.Context, options BatcherOptions) *Batcher {
 	gate, flush := context.WithCancel(running)
 	return &Batcher{
-		running: running,
-		queue:   make(chan interface{}),
-		gate:    gate,
-		flush:   flush,
+		options:  options,
+		running:  running,
+		queue:    make(chan interface{}),
+		gate:     gate,
+		flush:    flush,
+		inflight: make(chan struct{}, options.maxInFlight()),
 	}
 }
 
 // Add an item to the batch, returning the next gate which the caller may block
-// on. The gate is protected by a read-write mutex, and may be modified by
-// Flush(), which makes a new gate.
+// on. The gate is protected by a read-write mutex, and is replaced by Wait()
+// when a batch is closed.
 //
 // In rare scenarios, if a goroutine hangs after enqueueing but before acquiring
-// the gate lock, the batch could be flushed, resulting in the pod waiting on
+// the gate lock, the batch could be closed, resulting in the item waiting on
 // the next gate. This will be flushed on the next batch, and may result in
-// delayed retries for the individual pod if the provisioning loop fails. In
+// delayed retries for the individual item if the provisioning loop fails. In
 // practice, this won't be encountered because this time window is O(seconds).
 func (b *Batcher) Add(item interface{}) <-chan struct{} {
 	select {
@@ -68,36 +125,103 @@ func (b *Batcher) Add(item interface{}) <-chan struct{} {
 	return b.gate.Done()
 }
 
-// Flush all goroutines blocking on the current gate and create a new gate.
-func (b *Batcher) Flush() {
-	b.Lock()
-	defer b.Unlock()
-	b.flush()
-	b.gate, b.flush = context.WithCancel(b.running)
-}
-
-// Wait starts a batching window and returns a slice of items when closed.
-func (b *Batcher) Wait() (items []interface{}, window time.Duration) {
+// Wait blocks until fewer than MaxInFlight batches are unflushed, then starts a
+// batching window and returns a slice of items when closed. The caller must
+// call flush once the batch has been processed to release the goroutines
+// blocking on the batch's gate. Wait returns no items if the batcher is stopped.
+func (b *Batcher) Wait() (items []interface{}, window time.Duration, flush func()) {
+	select {
+	case b.inflight <- struct{}{}:
+	case <-b.running.Done():
+		return nil, 0, func() {}
+	}
+	batchesInFlightGauge.WithLabelValues(b.options.Name).Inc()
 	// Start the batching window after the first item is received
-	items = append(items, <-b.queue)
+	select {
+	case item := <-b.queue:
+		items = append(items, item)
+	case <-b.running.Done():
+		b.release()
+		return nil, 0, func() {}
+	}
 	start := time.Now()
-	defer func() {
-		window = time.Since(start)
-	}()
-	timeout := time.NewTimer(MaxBatchDuration)
-	idle := time.NewTimer(BatchIdleDuration)
-	for {
-		if len(items) >= MaxItemsPerBatch {
-			return
-		}
+	timeout := time.NewTimer(b.options.maxDuration())
+	defer timeout.Stop()
+	idle := time.NewTimer(b.options.idleDuration())
+	defer idle.Stop()
+collect:
+	for len(items) < b.options.maxItems() {
 		select {
 		case item := <-b.queue:
-			idle.Reset(BatchIdleDuration)
+			idle.Reset(b.options.idleDuration())
 			items = append(items, item)
 		case <-timeout.C:
-			return
+			break collect
 		case <-idle.C:
-			return
+			break collect
+		case <-b.running.Done():
+			break collect
 		}
 	}
+	window = time.Since(start)
+	batchSizeHistogram.WithLabelValues(b.options.Name).Observe(float64(len(items)))
+	batchWindowDurationHistogram.WithLabelValues(b.options.Name).Observe(window.Seconds())
+	return items, window, b.close()
+}
+
+// close replaces the current gate so that subsequent items are added to the
+// next batch, and returns a function that flushes the closed batch's gate.
+func (b *Batcher) close() func() {
+	b.Lock()
+	defer b.Unlock()
+	flush := b.flush
+	b.gate, b.flush = context.WithCancel(b.running)
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			flush()
+			b.release()
+		})
+	}
+}
+
+func (b *Batcher) release() {
+	<-b.inflight
+	batchesInFlightGauge.WithLabelValues(b.options.Name).Dec()
+}
+
+var batchSizeHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "allocation_controller",
+		Name:      "batch_size",
+		Help:      "Number of pods in each provisioning batch. Broken down by provisioner.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var batchWindowDurationHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "allocation_controller",
+		Name:      "batch_window_duration_seconds",
+		Help:      "Duration of each provisioning batching window in seconds. Broken down by provisioner.",
+		Buckets:   metrics.DurationBuckets(),
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var batchesInFlightGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "allocation_controller",
+		Name:      "batches_in_flight",
+		Help:      "Number of provisioning batches that are being collected or processed. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(batchSizeHistogram, batchWindowDurationHistogram, batchesInFlightGauge)
 }
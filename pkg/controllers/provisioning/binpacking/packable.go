@@ -16,25 +16,43 @@ package binpacking
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
 	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
+// defaultNodeFSEvictionThresholdPercentage is the kubelet's default hard
+// eviction threshold for nodefs.available, see
+// https://kubernetes.io/docs/concepts/scheduling-eviction/node-pressure-eviction/#hard-eviction-thresholds
+const defaultNodeFSEvictionThresholdPercentage = 10
+
 type Packable struct {
 	cloudprovider.InstanceType
 	reserved v1.ResourceList
 	total    v1.ResourceList
+	volumes  *scheduling.Volumes
+	// attached are the IDs of the volumes of the packed pods, so that volumes
+	// that are shared by pods are only counted once
+	attached sets.String
 }
 
+var (
+	errKubeletOverhead = errors.New("not enough resources for kubelet and system overhead")
+	errDaemonOverhead  = errors.New("not enough resources for daemons")
+)
+
 type Result struct {
 	packed   []*v1.Pod
 	unpacked []*v1.Pod
@@ -42,32 +60,14 @@ type Result struct {
 
 // PackablesFor creates viable packables for the provided constraints, excluding
 // those that can't fit resources or violate constraints.
-func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceType, constraints *v1alpha5.Constraints, pods []*v1.Pod, daemons []*v1.Pod) []*Packable {
+func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceType, constraints *v1alpha5.Constraints, pods []*v1.Pod, daemons []*v1.Pod, volumes *scheduling.Volumes) []*Packable {
 	packables := []*Packable{}
 	for _, instanceType := range instanceTypes {
-		packable := PackableFor(instanceType)
-		// First pass at filtering down to viable instance types;
-		// additional filtering will be done by later steps (such as
-		// removing instance types that obviously lack resources, such
-		// as GPUs, for the workload being presented).
-		if err := multierr.Combine(
-			packable.validateOfferings(constraints),
-			packable.validateInstanceType(constraints),
-			packable.validateArchitecture(constraints),
-			packable.validateOperatingSystems(constraints),
-			packable.validateAWSPodENI(pods),
-			packable.validateGPUs(pods),
-		); err != nil {
-			continue
-		}
-		// Calculate Kubelet Overhead
-		if ok := packable.reserve(instanceType.Overhead()); !ok {
-			logging.FromContext(ctx).Debugf("Excluding instance type %s because there are not enough resources for kubelet and system overhead", packable.Name())
-			continue
-		}
-		// Calculate Daemonset Overhead
-		if len(packable.Pack(daemons).unpacked) > 0 {
-			logging.FromContext(ctx).Debugf("Excluding instance type %s because there are not enough resources for daemons", packable.Name())
+		packable, err := viablePackableFor(instanceType, constraints, pods, daemons, volumes)
+		if err != nil {
+			if errors.Is(err, errKubeletOverhead) || errors.Is(err, errDaemonOverhead) {
+				logging.FromContext(ctx).Debugf("Excluding instance type %s because there are %s", instanceType.Name(), err)
+			}
 			continue
 		}
 		packables = append(packables, packable)
@@ -91,18 +91,114 @@ func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceTyp
 	return packables
 }
 
+// viablePackableFor returns a packable for the instance type with the kubelet
+// and daemon overhead reserved, or an error that explains why the instance
+// type isn't viable for the constraints and pods
+func viablePackableFor(instanceType cloudprovider.InstanceType, constraints *v1alpha5.Constraints, pods []*v1.Pod, daemons []*v1.Pod, volumes *scheduling.Volumes) (*Packable, error) {
+	packable := PackableFor(instanceType)
+	packable.limitPods(constraints.KubeletConfiguration)
+	packable.limitVolumes(volumes)
+	// First pass at filtering down to viable instance types;
+	// additional filtering will be done by later steps (such as
+	// removing instance types that obviously lack resources, such
+	// as GPUs, for the workload being presented).
+	if err := multierr.Combine(
+		packable.validateOfferings(constraints),
+		packable.validateInstanceType(constraints),
+		packable.validateArchitecture(constraints),
+		packable.validateOperatingSystems(constraints),
+		packable.validateResources(pods),
+	); err != nil {
+		return nil, err
+	}
+	// Calculate Kubelet Overhead
+	if ok := packable.reserve(overhead(instanceType, constraints.KubeletConfiguration)); !ok {
+		return nil, errKubeletOverhead
+	}
+	// Calculate Daemonset Overhead
+	if len(packable.Pack(daemons).unpacked) > 0 {
+		return nil, errDaemonOverhead
+	}
+	return packable, nil
+}
+
 func PackableFor(i cloudprovider.InstanceType) *Packable {
 	return &Packable{
 		InstanceType: i,
-		total: v1.ResourceList{
-			v1.ResourceCPU:      *i.CPU(),
-			v1.ResourceMemory:   *i.Memory(),
-			resources.NvidiaGPU: *i.NvidiaGPUs(),
-			resources.AMDGPU:    *i.AMDGPUs(),
-			resources.AWSNeuron: *i.AWSNeurons(),
-			resources.AWSPodENI: *i.AWSPodENI(),
-			v1.ResourcePods:     *i.Pods(),
-		},
+		total:        i.Resources().DeepCopy(),
+	}
+}
+
+// overhead returns the resources that are not allocatable to pods, such that
+// allocatable = capacity - kube-reserved - system-reserved - eviction threshold.
+// Capacity already excludes the VM memory overhead of the instance type. Values
+// specified by the kubelet configuration take precedence over the cloud
+// provider's defaults for the component and resource they apply to.
+func overhead(instanceType cloudprovider.InstanceType, kubeletConfig *v1alpha5.KubeletConfiguration) v1.ResourceList {
+	defaults := instanceType.Overhead()
+	evictionThreshold := override(v1.ResourceList{
+		v1.ResourceEphemeralStorage: percentageOf(*instanceType.EphemeralStorage(), defaultNodeFSEvictionThresholdPercentage),
+	}, defaults.EvictionThreshold)
+	if threshold, ok := kubeletConfig.EvictionThreshold("memory.available", *instanceType.Memory()); ok {
+		evictionThreshold = override(evictionThreshold, v1.ResourceList{v1.ResourceMemory: threshold})
+	}
+	if threshold, ok := kubeletConfig.EvictionThreshold("nodefs.available", *instanceType.EphemeralStorage()); ok {
+		evictionThreshold = override(evictionThreshold, v1.ResourceList{v1.ResourceEphemeralStorage: threshold})
+	}
+	if kubeletConfig == nil {
+		return resources.Merge(defaults.KubeReserved, defaults.SystemReserved, evictionThreshold)
+	}
+	return resources.Merge(
+		override(defaults.KubeReserved, kubeletConfig.KubeReserved),
+		override(defaults.SystemReserved, kubeletConfig.SystemReserved),
+		evictionThreshold,
+	)
+}
+
+// percentageOf returns the percentage of the quantity, rounded down
+func percentageOf(quantity resource.Quantity, percentage int64) resource.Quantity {
+	return *resource.NewQuantity(quantity.Value()*percentage/100, quantity.Format)
+}
+
+// override returns the defaults with any resources in overrides replaced
+func override(defaults v1.ResourceList, overrides v1.ResourceList) v1.ResourceList {
+	result := v1.ResourceList{}
+	for resourceName, quantity := range defaults {
+		result[resourceName] = quantity
+	}
+	for resourceName, quantity := range overrides {
+		result[resourceName] = quantity
+	}
+	return result
+}
+
+// limitPods caps the number of pods to the kubelet's maxPods and podsPerCore configuration
+func (p *Packable) limitPods(kubeletConfig *v1alpha5.KubeletConfiguration) {
+	if kubeletConfig == nil {
+		return
+	}
+	pods := p.total[v1.ResourcePods]
+	if kubeletConfig.MaxPods != nil {
+		if maxPods := resource.NewQuantity(int64(ptr.Int32Value(kubeletConfig.MaxPods)), resource.DecimalSI); maxPods.Cmp(pods) < 0 {
+			pods = *maxPods
+		}
+	}
+	if ptr.Int32Value(kubeletConfig.PodsPerCore) > 0 {
+		if podsPerCore := resource.NewQuantity(int64(ptr.Int32Value(kubeletConfig.PodsPerCore))*p.CPU().Value(), resource.DecimalSI); podsPerCore.Cmp(pods) < 0 {
+			pods = *podsPerCore
+		}
+	}
+	p.total[v1.ResourcePods] = pods
+}
+
+// limitVolumes caps the number of volumes per node to the attachable volume
+// limits of the instance type. Volumes of drivers without a known limit aren't
+// reserved, see requestsFor.
+func (p *Packable) limitVolumes(volumes *scheduling.Volumes) {
+	p.volumes = volumes
+	p.attached = sets.NewString()
+	for resourceName, quantity := range volumes.LimitsFor(p.InstanceType) {
+		p.total[resourceName] = quantity
 	}
 }
 
@@ -135,6 +231,8 @@ func (p *Packable) DeepCopy() *Packable {
 		InstanceType: p.InstanceType,
 		reserved:     p.reserved.DeepCopy(),
 		total:        p.total.DeepCopy(),
+		volumes:      p.volumes,
+		attached:     sets.NewString(p.attached.UnsortedList()...),
 	}
 }
 
@@ -144,7 +242,7 @@ func (p *Packable) DeepCopy() *Packable {
 // NvidiaGPUs and the instance type doesn't have any) will be
 // eliminated from consideration.
 func (p *Packable) fits(pod *v1.Pod) bool {
-	minResourceList := resources.RequestsForPods(pod)
+	minResourceList := p.requestsFor(pod)
 	for resourceName, totalQuantity := range p.total {
 		reservedQuantity := p.reserved[resourceName].DeepCopy()
 		reservedQuantity.Add(minResourceList[resourceName])
@@ -168,28 +266,50 @@ func (p *Packable) reserve(requests v1.ResourceList) bool {
 }
 
 func (p *Packable) reservePod(pod *v1.Pod) bool {
-	requests := resources.RequestsForPods(pod)
+	requests := p.requestsFor(pod)
 	requests[v1.ResourcePods] = *resource.NewQuantity(1, resource.BinarySI)
-	return p.reserve(requests)
+	if !p.reserve(requests) {
+		return false
+	}
+	for id := range p.volumes.For(pod) {
+		p.attached.Insert(id)
+	}
+	return true
+}
+
+// requestsFor returns the resources requested by the pod, including the volumes
+// it attaches that aren't already attached by packed pods, for drivers whose
+// limits are known for the instance type
+func (p *Packable) requestsFor(pod *v1.Pod) v1.ResourceList {
+	requests := resources.RequestsForPods(pod)
+	for id, resourceName := range p.volumes.For(pod) {
+		if _, ok := p.total[resourceName]; !ok || p.attached.Has(id) {
+			continue
+		}
+		quantity := requests[resourceName]
+		quantity.Add(*resource.NewQuantity(1, resource.DecimalSI))
+		requests[resourceName] = quantity
+	}
+	return requests
 }
 
 func (p *Packable) validateInstanceType(constraints *v1alpha5.Constraints) error {
 	if !constraints.Requirements.InstanceTypes().Has(p.Name()) {
-		return fmt.Errorf("instance type %s not in %s", p.Name(), constraints.Requirements.InstanceTypes())
+		return fmt.Errorf("instance type %s not in %s", p.Name(), constraints.Requirements.InstanceTypes().List())
 	}
 	return nil
 }
 
 func (p *Packable) validateArchitecture(constraints *v1alpha5.Constraints) error {
 	if !constraints.Requirements.Architectures().Has(p.Architecture()) {
-		return fmt.Errorf("architecture %s not in %s", p.Name(), constraints.Requirements.Architectures())
+		return fmt.Errorf("architecture %s not in %s", p.Architecture(), constraints.Requirements.Architectures().List())
 	}
 	return nil
 }
 
 func (p *Packable) validateOperatingSystems(constraints *v1alpha5.Constraints) error {
 	if constraints.Requirements.OperatingSystems().Intersection(p.OperatingSystems()).Len() == 0 {
-		return fmt.Errorf("operating systems %s not in %s", p.Name(), constraints.Requirements.OperatingSystems())
+		return fmt.Errorf("operating systems %s not in %s", p.OperatingSystems().List(), constraints.Requirements.OperatingSystems().List())
 	}
 	return nil
 }
@@ -200,51 +320,47 @@ func (p *Packable) validateOfferings(constraints *v1alpha5.Constraints) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("offerings %v are not available for capacity types %s and zones %s", p.Offerings(), constraints.Requirements.CapacityTypes(), constraints.Requirements.Zones())
+	return fmt.Errorf("offerings %v are not available for capacity types %s and zones %s", p.Offerings(), constraints.Requirements.CapacityTypes().List(), constraints.Requirements.Zones().List())
 }
 
-func (p *Packable) validateGPUs(pods []*v1.Pod) error {
-	gpuResources := map[v1.ResourceName]*resource.Quantity{
-		resources.NvidiaGPU: p.InstanceType.NvidiaGPUs(),
-		resources.AMDGPU:    p.InstanceType.AMDGPUs(),
-		resources.AWSNeuron: p.InstanceType.AWSNeurons(),
-	}
-	for resourceName, instanceTypeResourceQuantity := range gpuResources {
-		if p.requiresResource(pods, resourceName) && instanceTypeResourceQuantity.IsZero() {
+// validateResources excludes instance types that don't have the extended
+// resources that the pods require, e.g. GPUs or the resources of custom device
+// plugins, as well as instance types with accelerators that the pods don't
+// require, which would otherwise go unused
+func (p *Packable) validateResources(pods []*v1.Pod) error {
+	capacity := p.Resources()
+	required := requiredResources(pods)
+	for resourceName := range required {
+		if !resources.IsExtended(resourceName) {
+			continue
+		}
+		if quantity, ok := capacity[resourceName]; !ok || quantity.IsZero() {
 			return fmt.Errorf("%s is required", resourceName)
-		} else if !p.requiresResource(pods, resourceName) && !instanceTypeResourceQuantity.IsZero() {
+		}
+	}
+	for _, resourceName := range []v1.ResourceName{resources.NvidiaGPU, resources.AMDGPU, resources.AWSNeuron} {
+		if _, ok := required[resourceName]; !ok && !capacity.Name(resourceName, resource.DecimalSI).IsZero() {
 			return fmt.Errorf("%s is not required", resourceName)
 		}
 	}
 	return nil
 }
 
-func (p *Packable) requiresResource(pods []*v1.Pod, resource v1.ResourceName) bool {
+// requiredResources returns the names of the resources that the pods request
+// or limit
+func requiredResources(pods []*v1.Pod) map[v1.ResourceName]struct{} {
+	required := map[v1.ResourceName]struct{}{}
 	for _, pod := range pods {
 		for _, container := range pod.Spec.Containers {
-			if _, ok := container.Resources.Requests[resource]; ok {
-				return true
-			}
-			if _, ok := container.Resources.Limits[resource]; ok {
-				return true
+			for resourceName := range container.Resources.Requests {
+				required[resourceName] = struct{}{}
 			}
-		}
-	}
-	return false
-}
-
-func (p *Packable) validateAWSPodENI(pods []*v1.Pod) error {
-	for _, pod := range pods {
-		for _, container := range pod.Spec.Containers {
-			if _, ok := container.Resources.Requests[resources.AWSPodENI]; ok {
-				if p.InstanceType.AWSPodENI().IsZero() {
-					return fmt.Errorf("aws pod eni is required")
-				}
-				return nil
+			for resourceName := range container.Resources.Limits {
+				required[resourceName] = struct{}{}
 			}
 		}
 	}
-	return nil
+	return required
 }
 
 func packableNames(instanceTypes []*Packable) []string {
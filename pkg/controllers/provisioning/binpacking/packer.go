@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,6 +30,8 @@ import (
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/metrics"
 	"github.com/aws/karpenter/pkg/utils/apiobject"
 	"github.com/aws/karpenter/pkg/utils/injection"
@@ -55,10 +58,12 @@ func init() {
 	crmetrics.Registry.MustRegister(packDuration)
 }
 
-func NewPacker(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider) *Packer {
+func NewPacker(ctx context.Context, kubeClient client.Client, cloudProvider cloudprovider.CloudProvider, recorder events.Recorder) *Packer {
 	return &Packer{
 		kubeClient:    kubeClient,
 		cloudProvider: cloudProvider,
+		recorder:      recorder,
+		ordering:      orderingFor(ctx, cloudProvider),
 	}
 }
 
@@ -66,6 +71,24 @@ func NewPacker(kubeClient client.Client, cloudProvider cloudprovider.CloudProvid
 type Packer struct {
 	kubeClient    client.Client
 	cloudProvider cloudprovider.CloudProvider
+	recorder      events.Recorder
+	// ordering is the comparator chain of --instance-type-ordering
+	ordering cloudprovider.ComparatorChain
+}
+
+// orderingFor returns the comparator chain of --instance-type-ordering, which
+// is validated against the cloud provider when the controller starts
+func orderingFor(ctx context.Context, cloudProvider cloudprovider.CloudProvider) cloudprovider.ComparatorChain {
+	ordering := injection.GetOptions(ctx).InstanceTypeOrdering
+	if ordering == "" {
+		return nil
+	}
+	chain, err := cloudprovider.NewComparatorChainFor(cloudProvider, strings.Split(ordering, ",")...)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Ignoring instance type ordering, %s", err)
+		return nil
+	}
+	return chain
 }
 
 // Packing is a binpacking solution of equivalently schedulable pods to a set of
@@ -75,6 +98,8 @@ type Packing struct {
 	Pods                [][]*v1.Pod `hash:"ignore"`
 	NodeQuantity        int         `hash:"ignore"`
 	InstanceTypeOptions []cloudprovider.InstanceType
+	// DaemonRequests are the requests of the daemons that are scheduled to each node
+	DaemonRequests v1.ResourceList `hash:"ignore"`
 }
 
 // Pack returns the node packings for the provided pods. It computes a set of viable
@@ -83,8 +108,9 @@ type Packing struct {
 // Pods provided are all schedulable in the same zone as tightly as possible.
 // It follows the First Fit Decreasing bin packing technique, reference-
 // https://en.wikipedia.org/wiki/First-fit-decreasing_bin_packing
-func (p *Packer) Pack(ctx context.Context, constraints *v1alpha5.Constraints, pods []*v1.Pod, instanceTypes []cloudprovider.InstanceType) ([]*Packing, error) {
+func (p *Packer) Pack(ctx context.Context, schedule *scheduling.Schedule, instanceTypes []cloudprovider.InstanceType) ([]*Packing, error) {
 	defer metrics.Measure(packDuration.WithLabelValues(injection.GetNamespacedName(ctx).Name))()
+	constraints, pods := schedule.Constraints, schedule.Pods
 	// Get daemons for overhead calculations
 	daemons, err := p.getDaemons(ctx, constraints)
 	if err != nil {
@@ -101,11 +127,12 @@ func (p *Packer) Pack(ctx context.Context, constraints *v1alpha5.Constraints, po
 		}
 		return resourcePodA.Cpu().Cmp(*resourcePodB.Cpu()) == 1
 	})
+	strategy := v1alpha5.InstanceSelectionStrategyOrDefault(constraints.InstanceSelectionStrategy)
 	packs := map[uint64]*Packing{}
 	var packings []*Packing
 	var packing *Packing
 	remainingPods := pods
-	emptyPackables := PackablesFor(ctx, instanceTypes, constraints, pods, daemons)
+	emptyPackables := PackablesFor(ctx, instanceTypes, constraints, pods, daemons, schedule.Volumes)
 	for len(remainingPods) > 0 {
 		packables := []*Packable{}
 		for _, packable := range emptyPackables {
@@ -113,12 +140,16 @@ func (p *Packer) Pack(ctx context.Context, constraints *v1alpha5.Constraints, po
 		}
 		if len(packables) == 0 {
 			logging.FromContext(ctx).Errorf("Failed to find instance type option(s) for %v", apiobject.PodNamespacedNames(remainingPods))
+			for _, pod := range remainingPods {
+				p.recorder.PodDidNotFit(pod, injection.GetNamespacedName(ctx).Name, nil)
+			}
 			return packings, nil
 		}
-		packing, remainingPods = p.packWithLargestPod(remainingPods, packables)
+		packing, remainingPods = p.packWithLargestPod(remainingPods, packables, strategy)
 		// checked all instance types and found no packing option
 		if flattenedLen(packing.Pods...) == 0 {
 			logging.FromContext(ctx).Errorf("Failed to compute packing, pod(s) %s did not fit in instance type option(s) %v", apiobject.PodNamespacedNames(remainingPods), packableNames(packables))
+			p.recorder.PodDidNotFit(remainingPods[0], injection.GetNamespacedName(ctx).Name, packableNames(packables))
 			remainingPods = remainingPods[1:]
 			continue
 		}
@@ -131,15 +162,47 @@ func (p *Packer) Pack(ctx context.Context, constraints *v1alpha5.Constraints, po
 			mainPack.Pods = append(mainPack.Pods, packing.Pods...)
 			continue
 		}
+		packing.DaemonRequests = resources.RequestsForPods(daemons...)
 		packs[key] = packing
 		packings = append(packings, packing)
 	}
+	// Order each packing's instance type options by preference, since cloud providers may launch them in order.
+	// The lowest price strategy prefers cheaper instance types regardless of the configured ordering.
+	chain := p.ordering
+	if strategy == v1alpha5.InstanceSelectionStrategyLowestPrice {
+		chain = append(cloudprovider.ComparatorChain{cloudprovider.ComparePrice}, p.ordering...)
+	}
+	if len(chain) > 0 {
+		for _, pack := range packings {
+			chain.Sort(pack.InstanceTypeOptions)
+		}
+	}
 	for _, pack := range packings {
 		logging.FromContext(ctx).Infof("Computed packing of %d node(s) for %d pod(s) with instance type option(s) %s", pack.NodeQuantity, flattenedLen(pack.Pods...), instanceTypeNames(pack.InstanceTypeOptions))
 	}
 	return packings, nil
 }
 
+// Explain returns the reason that each instance type can't run the schedule's
+// pods, keyed by instance type name, or nil if it can
+func (p *Packer) Explain(ctx context.Context, schedule *scheduling.Schedule, instanceTypes []cloudprovider.InstanceType) (map[string]error, error) {
+	daemons, err := p.getDaemons(ctx, schedule.Constraints)
+	if err != nil {
+		return nil, fmt.Errorf("getting schedulable daemon pods, %w", err)
+	}
+	reasons := map[string]error{}
+	for _, instanceType := range instanceTypes {
+		packable, err := viablePackableFor(instanceType, schedule.Constraints, schedule.Pods, daemons, schedule.Volumes)
+		if err == nil {
+			if unpacked := packable.Pack(schedule.Pods).unpacked; len(unpacked) > 0 {
+				err = fmt.Errorf("not enough resources for %d/%d pods", len(unpacked), len(schedule.Pods))
+			}
+		}
+		reasons[instanceType.Name()] = err
+	}
+	return reasons, nil
+}
+
 func (p *Packer) getDaemons(ctx context.Context, constraints *v1alpha5.Constraints) ([]*v1.Pod, error) {
 	daemonSetList := &appsv1.DaemonSetList{}
 	if err := p.kubeClient.List(ctx, daemonSetList); err != nil {
@@ -148,6 +211,12 @@ func (p *Packer) getDaemons(ctx context.Context, constraints *v1alpha5.Constrain
 	// Include DaemonSets that will schedule on this node
 	pods := []*v1.Pod{}
 	for _, daemonSet := range daemonSetList.Items {
+		// DaemonSets that are being deleted won't schedule to new nodes
+		if daemonSet.DeletionTimestamp != nil {
+			continue
+		}
+		// The template is the DaemonSet's latest revision, which is the one that new nodes run, so pods of
+		// a revision that's being rolled out aren't counted alongside those of the revision they replace
 		pod := &v1.Pod{Spec: daemonSet.Spec.Template.Spec}
 		if err := constraints.ValidatePod(pod); err == nil {
 			pods = append(pods, pod)
@@ -159,7 +228,7 @@ func (p *Packer) getDaemons(ctx context.Context, constraints *v1alpha5.Constrain
 // packWithLargestPod will try to pack max number of pods with largest pod in
 // pods across all available node capacities. It returns Packing: max pod count
 // that fit; with their node capacities and list of leftover pods
-func (p *Packer) packWithLargestPod(unpackedPods []*v1.Pod, packables []*Packable) (*Packing, []*v1.Pod) {
+func (p *Packer) packWithLargestPod(unpackedPods []*v1.Pod, packables []*Packable, strategy v1alpha5.InstanceSelectionStrategy) (*Packing, []*v1.Pod) {
 	bestPackedPods := []*v1.Pod{}
 	bestInstances := []cloudprovider.InstanceType{}
 	remainingPods := unpackedPods
@@ -173,17 +242,7 @@ func (p *Packer) packWithLargestPod(unpackedPods []*v1.Pod, packables []*Packabl
 	for i, packable := range packables {
 		// check how many pods we can fit with the available capacity
 		if result := packable.Pack(unpackedPods); len(result.packed) == maxPodsPacked {
-			// Add all packable nodes that have more resources than this one
-			// Trim the bestInstances so that provisioning APIs in cloud providers are not overwhelmed by the number of instance type options
-			// For example, the AWS EC2 Fleet API only allows the request to be 145kb which equates to about 130 instance type options.
-			for j := i; j < len(packables) && j-i < MaxInstanceTypes; j++ {
-				// packable nodes are sorted lexicographically according to the order of [CPU, memory]
-				// It may result in cases where an instance type may have larger index value when it has more CPU but fewer memory
-				// Need to exclude instance type with smaller memory and fewer pods
-				if packables[i].Memory().Cmp(*packables[j].Memory()) <= 0 && packables[i].Pods().Cmp(*packables[j].Pods()) <= 0 {
-					bestInstances = append(bestInstances, packables[j])
-				}
-			}
+			bestInstances = instanceTypeOptionsFor(packables, i, strategy)
 			bestPackedPods = result.packed
 			remainingPods = result.unpacked
 			break
@@ -192,6 +251,65 @@ func (p *Packer) packWithLargestPod(unpackedPods []*v1.Pod, packables []*Packabl
 	return &Packing{Pods: [][]*v1.Pod{bestPackedPods}, InstanceTypeOptions: bestInstances, NodeQuantity: 1}, remainingPods
 }
 
+// instanceTypeOptionsFor returns the packables that have at least as many
+// resources as packables[i], narrowed according to the strategy. They're
+// trimmed so that provisioning APIs in cloud providers are not overwhelmed by
+// the number of instance type options. For example, the AWS EC2 Fleet API only
+// allows the request to be 145kb which equates to about 130 instance type options.
+func instanceTypeOptionsFor(packables []*Packable, i int, strategy v1alpha5.InstanceSelectionStrategy) []cloudprovider.InstanceType {
+	options := []cloudprovider.InstanceType{}
+	for j := i; j < len(packables); j++ {
+		// The capacity optimized strategy only considers the smallest packables,
+		// since they're likely to pack the pods most tightly
+		if strategy == v1alpha5.InstanceSelectionStrategyCapacityOptimized && j-i >= MaxInstanceTypes {
+			break
+		}
+		// packable nodes are sorted lexicographically according to the order of [CPU, memory]
+		// It may result in cases where an instance type may have larger index value when it has more CPU but fewer memory
+		// Need to exclude instance type with smaller memory and fewer pods
+		if packables[i].Memory().Cmp(*packables[j].Memory()) <= 0 && packables[i].Pods().Cmp(*packables[j].Pods()) <= 0 {
+			options = append(options, packables[j])
+		}
+	}
+	switch strategy {
+	case v1alpha5.InstanceSelectionStrategyLowestPrice:
+		// Instance types without a known price fall back to the smallest, and
+		// the cloud provider chooses the cheapest of them at launch
+		cloudprovider.ComparatorChain{cloudprovider.ComparePrice, cloudprovider.CompareSize}.Sort(options)
+	case v1alpha5.InstanceSelectionStrategyDiversified:
+		options = diversify(options)
+	}
+	if len(options) > MaxInstanceTypes {
+		options = options[:MaxInstanceTypes]
+	}
+	return options
+}
+
+// diversify interleaves the instance types of each family, so that trimming
+// them retains as many families as possible. The order of the instance types
+// within each family is preserved.
+func diversify(instanceTypes []cloudprovider.InstanceType) []cloudprovider.InstanceType {
+	families := []string{}
+	byFamily := map[string][]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		family := cloudprovider.Family(instanceType)
+		if _, ok := byFamily[family]; !ok {
+			families = append(families, family)
+		}
+		byFamily[family] = append(byFamily[family], instanceType)
+	}
+	diversified := []cloudprovider.InstanceType{}
+	for len(diversified) < len(instanceTypes) {
+		for _, family := range families {
+			if remaining := byFamily[family]; len(remaining) > 0 {
+				diversified = append(diversified, remaining[0])
+				byFamily[family] = remaining[1:]
+			}
+		}
+	}
+	return diversified
+}
+
 func instanceTypeNames(instanceTypes []cloudprovider.InstanceType) []string {
 	names := []string{}
 	for _, instanceType := range instanceTypes {
@@ -23,6 +23,7 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
@@ -41,7 +42,7 @@ func BenchmarkPacker(b *testing.B) {
 
 	kubeClient := testclient.NewClientBuilder().WithLists(&appsv1.DaemonSetList{}).Build()
 	fakeCloud := fake.CloudProvider{InstanceTypes: instanceTypes}
-	packer := binpacking.NewPacker(kubeClient, &fakeCloud)
+	packer := binpacking.NewPacker(ctx, kubeClient, &fakeCloud, events.NewRecorder(test.NewEventRecorder()))
 
 	pods := test.Pods(10_000, test.PodOptions{
 		ResourceRequirements: v1.ResourceRequirements{
@@ -67,7 +68,7 @@ func BenchmarkPacker(b *testing.B) {
 
 	// Pack benchmark
 	for i := 0; i < b.N; i++ {
-		if packings, err := packer.Pack(ctx, schedule.Constraints, pods, instanceTypes); err != nil || len(packings) == 0 {
+		if packings, err := packer.Pack(ctx, schedule, instanceTypes); err != nil || len(packings) == 0 {
 			b.FailNow()
 		}
 	}
@@ -0,0 +1,273 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/resources"
+)
+
+const (
+	// CheckpointConfigMapName is the ConfigMap that in-flight launches are persisted to
+	CheckpointConfigMapName = "karpenter-in-flight-launches"
+	checkpointLaunchesKey   = "launches"
+)
+
+// Launch is the bookkeeping of the nodes that a provisioning round launches
+type Launch struct {
+	Provisioner string `json:"provisioner"`
+	// Pods are the UIDs of the pods that the nodes are launched for
+	Pods []types.UID `json:"pods"`
+	// Nodes is the number of nodes that are launched
+	Nodes int `json:"nodes"`
+	// Capacity is the expected capacity of the nodes, in total
+	Capacity v1.ResourceList `json:"capacity,omitempty"`
+	// Expiration is when the nodes are expected to have started
+	Expiration time.Time `json:"expiration"`
+}
+
+// Checkpoint persists the launches of each provisioning round, from the moment
+// the round begins launching until it completes, once per round. If the
+// controller restarts in between, e.g. when Karpenter itself is deployed, the
+// restarted controller restores the checkpoint and, until the nodes have had
+// time to start, doesn't provision their pods again, since the kube-scheduler
+// schedules them to the nodes once they register, and counts the nodes'
+// capacity against their provisioner's limits, since they may not have
+// registered yet. Without it, the nodes that were launching would be
+// duplicated. A checkpoint without a namespace isn't persisted, so it only
+// tracks the launches of the running controller.
+type Checkpoint struct {
+	coreV1Client corev1.CoreV1Interface
+	namespace    string
+
+	mu       sync.Mutex
+	restored bool
+	// configMap is the ConfigMap as it was last read or written, which is
+	// updated without being read again
+	configMap *v1.ConfigMap
+	// launches are the in-flight launches by ID, and pods the IDs of the
+	// launches of the in-flight pods
+	launches map[string]*Launch
+	pods     map[types.UID]string
+	// previous are the IDs of the launches that were begun by a previous controller
+	previous map[string]bool
+	sequence int
+}
+
+// NewCheckpoint returns a checkpoint that's persisted to a ConfigMap in the
+// namespace, or that isn't persisted if the namespace is empty
+func NewCheckpoint(coreV1Client corev1.CoreV1Interface, namespace string) *Checkpoint {
+	return &Checkpoint{
+		coreV1Client: coreV1Client,
+		namespace:    namespace,
+		launches:     map[string]*Launch{},
+		pods:         map[types.UID]string{},
+		previous:     map[string]bool{},
+	}
+}
+
+// InFlight returns true if nodes were recently being launched for the pod
+func (c *Checkpoint) InFlight(ctx context.Context, pod *v1.Pod) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.restore(ctx); err != nil {
+		logging.FromContext(ctx).Errorf("Restoring in-flight launches, %s", err)
+	}
+	id, ok := c.pods[pod.UID]
+	return ok && injectabletime.Now().Before(c.launches[id].Expiration)
+}
+
+// PreviousCapacity returns the expected capacity of the provisioner's nodes
+// that a previous controller was launching, and that haven't had time to start
+func (c *Checkpoint) PreviousCapacity(ctx context.Context, provisioner string) v1.ResourceList {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.restore(ctx); err != nil {
+		logging.FromContext(ctx).Errorf("Restoring in-flight launches, %s", err)
+	}
+	now := injectabletime.Now()
+	capacity := v1.ResourceList{}
+	for id := range c.previous {
+		if launch := c.launches[id]; launch.Provisioner == provisioner && now.Before(launch.Expiration) {
+			capacity = resources.Merge(capacity, launch.Capacity)
+		}
+	}
+	return capacity
+}
+
+// Begin records that the round is launching nodes, which are considered in
+// flight until Complete is called with the returned ID, or until they'd be
+// expected to have started
+func (c *Checkpoint) Begin(ctx context.Context, launch *Launch) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.restore(ctx); err != nil {
+		return "", fmt.Errorf("restoring in-flight launches, %w", err)
+	}
+	now := injectabletime.Now()
+	c.sequence++
+	id := fmt.Sprintf("%s-%d-%d", launch.Provisioner, now.UnixNano(), c.sequence)
+	launch.Expiration = now.Add(injection.GetOptions(ctx).NodeStartupDuration)
+	c.add(id, launch)
+	return id, c.persist(ctx)
+}
+
+// Complete records that the round's launches have completed, whether or not
+// they succeeded, so that pods which weren't bound may be provisioned again
+func (c *Checkpoint) Complete(ctx context.Context, id string) error {
+	if c == nil || id == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(id)
+	return c.persist(ctx)
+}
+
+// add records the launch. It must be called with the lock held.
+func (c *Checkpoint) add(id string, launch *Launch) {
+	c.launches[id] = launch
+	for _, uid := range launch.Pods {
+		c.pods[uid] = id
+	}
+}
+
+// remove forgets the launch. It must be called with the lock held.
+func (c *Checkpoint) remove(id string) {
+	launch, ok := c.launches[id]
+	if !ok {
+		return
+	}
+	for _, uid := range launch.Pods {
+		if c.pods[uid] == id {
+			delete(c.pods, uid)
+		}
+	}
+	delete(c.launches, id)
+	delete(c.previous, id)
+}
+
+// restore reads the launches that were persisted by a previous controller,
+// once. It must be called with the lock held.
+func (c *Checkpoint) restore(ctx context.Context) error {
+	if c.restored || c.namespace == "" {
+		return nil
+	}
+	configMap, err := c.coreV1Client.ConfigMaps(c.namespace).Get(ctx, CheckpointConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.restored = true
+			return nil
+		}
+		return err
+	}
+	launches := map[string]*Launch{}
+	if data, ok := configMap.Data[checkpointLaunchesKey]; ok {
+		if err := json.Unmarshal([]byte(data), &launches); err != nil {
+			return fmt.Errorf("parsing configmap %s, %w", CheckpointConfigMapName, err)
+		}
+	}
+	for id, launch := range launches {
+		if _, ok := c.launches[id]; !ok {
+			c.add(id, launch)
+			c.previous[id] = true
+		}
+	}
+	c.configMap = configMap
+	c.restored = true
+	if len(launches) > 0 {
+		logging.FromContext(ctx).Infof("Restored %d in-flight launch(es)", len(launches))
+	}
+	return nil
+}
+
+// persist writes the unexpired launches to the ConfigMap with a single request,
+// unless a concurrent write, e.g. by a previous controller that's shutting
+// down, conflicts with it. It must be called with the lock held.
+func (c *Checkpoint) persist(ctx context.Context) error {
+	if c.namespace == "" {
+		return nil
+	}
+	now := injectabletime.Now()
+	for id, launch := range c.launches {
+		if !now.Before(launch.Expiration) {
+			c.remove(id)
+		}
+	}
+	raw, err := json.Marshal(c.launches)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{checkpointLaunchesKey: string(raw)}
+	if c.configMap == nil {
+		configMap, err := c.coreV1Client.ConfigMaps(c.namespace).Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: CheckpointConfigMapName, Namespace: c.namespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+		if err == nil {
+			c.configMap = configMap
+			return nil
+		}
+		if !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("creating configmap %s, %w", CheckpointConfigMapName, err)
+		}
+	}
+	if c.configMap != nil {
+		configMap := c.configMap.DeepCopy()
+		configMap.Data = data
+		updated, err := c.coreV1Client.ConfigMaps(c.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		if err == nil {
+			c.configMap = updated
+			return nil
+		}
+		if !errors.IsConflict(err) {
+			return fmt.Errorf("updating configmap %s, %w", CheckpointConfigMapName, err)
+		}
+	}
+	// The launches were restored from the ConfigMap before, so they replace its current data
+	configMap, err := c.coreV1Client.ConfigMaps(c.namespace).Get(ctx, CheckpointConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting configmap %s, %w", CheckpointConfigMapName, err)
+	}
+	configMap.Data = data
+	if c.configMap, err = c.coreV1Client.ConfigMaps(c.namespace).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		c.configMap = nil
+		return fmt.Errorf("updating configmap %s, %w", CheckpointConfigMapName, err)
+	}
+	return nil
+}
@@ -16,16 +16,20 @@ package provisioning
 import (
 	"context"
 	"fmt"
+	"os"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/mitchellh/hashstructure/v2"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -35,6 +39,7 @@ import (
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
 )
@@ -49,18 +54,41 @@ type Controller struct {
 	coreV1Client  corev1.CoreV1Interface
 	kubeClient    client.Client
 	cloudProvider cloudprovider.CloudProvider
+	recorder      events.Recorder
+	// launchLimiter limits the rate of node launches across all provisioners
+	launchLimiter *rate.Limiter
+	outcomes      *Outcomes
+	checkpoint    *Checkpoint
 }
 
 // NewController is a constructor
-func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider) *Controller {
-	return &Controller{
+func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, recorder events.Recorder) *Controller {
+	c := &Controller{
 		ctx:           ctx,
 		provisioners:  &sync.Map{},
 		kubeClient:    kubeClient,
 		coreV1Client:  coreV1Client,
 		cloudProvider: cloudProvider,
-		scheduler:     scheduling.NewScheduler(kubeClient),
+		recorder:      recorder,
+		scheduler:     scheduling.NewScheduler(kubeClient, recorder),
+		launchLimiter: newLaunchLimiter(injection.GetOptions(ctx).MaxNodesPerMinute),
+		outcomes:      &Outcomes{},
 	}
+	// Checkpoint in-flight launches to the controller's namespace
+	namespace := os.Getenv(system.NamespaceEnvKey)
+	if namespace == "" {
+		logging.FromContext(ctx).Errorf("Not persisting in-flight launches, since %s isn't set; nodes that are launching when the controller restarts may be launched again", system.NamespaceEnvKey)
+	}
+	c.checkpoint = NewCheckpoint(coreV1Client, namespace)
+	if opts := injection.GetOptions(ctx); opts.CostAnomalyThreshold > 0 {
+		c.Subscribe(NewCostAnalyzer(kubeClient, recorder, CostAnalyzerOptions{
+			Threshold:   opts.CostAnomalyThreshold,
+			Window:      opts.CostAnomalyWindow,
+			Baseline:    opts.CostAnomalyBaseline,
+			MinimumRate: opts.CostAnomalyMinimumRate,
+		}).Observe)
+	}
+	return c
 }
 
 // Reconcile a control loop for the resource
@@ -77,6 +105,13 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		}
 		return reconcile.Result{}, err
 	}
+	if !provisioner.DeletionTimestamp.IsZero() {
+		c.Delete(req.Name)
+		return c.finalize(ctx, provisioner)
+	}
+	if err := c.reconcileFinalizer(ctx, provisioner); err != nil {
+		return reconcile.Result{}, err
+	}
 	if err := c.Apply(ctx, provisioner); err != nil {
 		return reconcile.Result{}, err
 	}
@@ -84,6 +119,13 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
 }
 
+// Subscribe registers a hook that's called with the outcome of each provisioning
+// round of every provisioner, e.g. for controllers that embed the provisioning
+// controller and act on the nodes it launches.
+func (c *Controller) Subscribe(hook OutcomeHook) {
+	c.outcomes.Subscribe(hook)
+}
+
 // Delete stops and removes a provisioner. Enqueued pods will be provisioned.
 func (c *Controller) Delete(name string) {
 	if p, ok := c.provisioners.LoadAndDelete(name); ok {
@@ -91,30 +133,144 @@ func (c *Controller) Delete(name string) {
 	}
 }
 
+// reconcileFinalizer ensures that provisioners with the Delete deletion policy,
+// and only those, have the termination finalizer. Provisioners without it are
+// removed immediately and their nodes are orphaned by the node controller.
+// Provisioners that the cloud provider manages resources for also get the
+// cleanup finalizer, which is kept even if they no longer do, since the
+// resources may still exist.
+func (c *Controller) reconcileFinalizer(ctx context.Context, stored *v1alpha5.Provisioner) error {
+	provisioner := stored.DeepCopy()
+	if !functional.ContainsString(provisioner.Finalizers, v1alpha5.CleanupFinalizer) && c.managesResources(ctx, provisioner) {
+		provisioner.Finalizers = append(provisioner.Finalizers, v1alpha5.CleanupFinalizer)
+	}
+	if deletionPolicy(provisioner) == v1alpha5.DeletionPolicyDelete {
+		if !functional.ContainsString(provisioner.Finalizers, v1alpha5.TerminationFinalizer) {
+			provisioner.Finalizers = append(provisioner.Finalizers, v1alpha5.TerminationFinalizer)
+		}
+	} else {
+		provisioner.Finalizers = functional.StringSliceWithout(provisioner.Finalizers, v1alpha5.TerminationFinalizer)
+	}
+	if equality.Semantic.DeepEqual(provisioner.Finalizers, stored.Finalizers) {
+		return nil
+	}
+	if err := c.kubeClient.Patch(ctx, provisioner, client.MergeFrom(stored)); err != nil {
+		return fmt.Errorf("patching provisioner finalizers, %w", err)
+	}
+	return nil
+}
+
+// managesResources returns true if the cloud provider creates resources on
+// behalf of the provisioner
+func (c *Controller) managesResources(ctx context.Context, provisioner *v1alpha5.Provisioner) bool {
+	manager, ok := c.cloudProvider.(cloudprovider.ResourceManager)
+	return ok && manager.ManagesResources(ctx, &provisioner.Spec.Constraints)
+}
+
+// finalize terminates the nodes of a provisioner with the Delete deletion
+// policy, and removes the termination finalizer once they are all gone. Nodes
+// are deleted rather than terminated directly, so that the termination
+// controller cordons and drains them, respecting pod disruption budgets. The
+// cloud provider's resources for the provisioner are cleaned up before the
+// cleanup finalizer is removed, unless orphaned nodes still use them, in which
+// case the termination controller cleans them up after the last one.
+func (c *Controller) finalize(ctx context.Context, provisioner *v1alpha5.Provisioner) (reconcile.Result, error) {
+	if !functional.ContainsString(provisioner.Finalizers, v1alpha5.TerminationFinalizer) && !functional.ContainsString(provisioner.Finalizers, v1alpha5.CleanupFinalizer) {
+		return reconcile.Result{}, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	if functional.ContainsString(provisioner.Finalizers, v1alpha5.TerminationFinalizer) && len(nodes.Items) > 0 {
+		for i := range nodes.Items {
+			if !nodes.Items[i].DeletionTimestamp.IsZero() {
+				continue
+			}
+			if err := c.kubeClient.Delete(ctx, &nodes.Items[i]); err != nil && !errors.IsNotFound(err) {
+				return reconcile.Result{}, fmt.Errorf("deleting node %s, %w", nodes.Items[i].Name, err)
+			}
+		}
+		logging.FromContext(ctx).Infof("Waiting on %d node(s) to terminate before deleting provisioner", len(nodes.Items))
+		return reconcile.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+	if functional.ContainsString(provisioner.Finalizers, v1alpha5.CleanupFinalizer) && len(nodes.Items) == 0 {
+		if err := c.cloudProvider.Cleanup(ctx, provisioner.Name); err != nil {
+			return reconcile.Result{}, fmt.Errorf("cleaning up cloudprovider resources, %w", err)
+		}
+	}
+	persisted := provisioner.DeepCopy()
+	provisioner.Finalizers = functional.StringSliceWithout(provisioner.Finalizers, v1alpha5.TerminationFinalizer)
+	provisioner.Finalizers = functional.StringSliceWithout(provisioner.Finalizers, v1alpha5.CleanupFinalizer)
+	if err := c.kubeClient.Patch(ctx, provisioner, client.MergeFrom(persisted)); client.IgnoreNotFound(err) != nil {
+		return reconcile.Result{}, fmt.Errorf("removing provisioner finalizer, %w", err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// deletionPolicy returns the provisioner's deletion policy, defaulting to Orphan
+func deletionPolicy(provisioner *v1alpha5.Provisioner) v1alpha5.DeletionPolicy {
+	if provisioner.Spec.DeletionPolicy != nil {
+		return *provisioner.Spec.DeletionPolicy
+	}
+	return v1alpha5.DeletionPolicyOrphan
+}
+
 // Apply creates or updates the provisioner to the latest configuration
 func (c *Controller) Apply(ctx context.Context, provisioner *v1alpha5.Provisioner) error {
+	if _, err := Resolve(ctx, c.cloudProvider, provisioner); err != nil {
+		return err
+	}
+	// Update the provisioner if anything has changed
+	if c.hasChanged(ctx, provisioner) {
+		// Keep the launch rate limiter, so that changing the spec doesn't reset its burst
+		var limiter *rate.Limiter
+		if old, ok := c.provisioners.Load(provisioner.Name); ok {
+			limiter = old.(*Provisioner).limiter
+		}
+		c.Delete(provisioner.Name)
+		c.provisioners.Store(provisioner.Name, NewProvisioner(ctx, provisioner, c.kubeClient, c.coreV1Client, c.cloudProvider, c.recorder, c.launchLimiter, limiter, c.outcomes, c.checkpoint))
+		c.warm(ctx, provisioner)
+	}
+	return nil
+}
+
+// Resolve defaults and validates the provisioner, and layers the requirements
+// of the cloud provider's instance types onto it, as they are when the
+// provisioner is started. It returns the instance types.
+func Resolve(ctx context.Context, cloudProvider cloudprovider.CloudProvider, provisioner *v1alpha5.Provisioner) ([]cloudprovider.InstanceType, error) {
 	provisioner.SetDefaults(ctx)
 	if err := provisioner.Validate(ctx); err != nil {
-		return err
+		return nil, err
 	}
 	// Refresh global requirements using instance type availability
-	instanceTypes, err := c.cloudProvider.GetInstanceTypes(ctx, provisioner.Spec.Provider)
+	instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, provisioner.Spec.Provider)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	provisioner.Spec.Labels = functional.UnionStringMaps(provisioner.Spec.Labels, map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name})
 	provisioner.Spec.Requirements = provisioner.Spec.Requirements.
 		Add(requirements(instanceTypes)...).
 		Add(v1alpha5.NewLabelRequirements(provisioner.Spec.Labels).Requirements...)
 	if err := provisioner.Spec.Requirements.Validate(); err != nil {
-		return fmt.Errorf("requirements are not compatible with cloud provider, %w", err)
+		return nil, fmt.Errorf("requirements are not compatible with cloud provider, %w", err)
 	}
-	// Update the provisioner if anything has changed
-	if c.hasChanged(ctx, provisioner) {
-		c.Delete(provisioner.Name)
-		c.provisioners.Store(provisioner.Name, NewProvisioner(ctx, provisioner, c.kubeClient, c.coreV1Client, c.cloudProvider))
+	return instanceTypes, nil
+}
+
+// warm fills the cloud provider's caches for the provisioner in the background,
+// if the cloud provider caches its APIs
+func (c *Controller) warm(ctx context.Context, provisioner *v1alpha5.Provisioner) {
+	warmer, ok := c.cloudProvider.(cloudprovider.Warmer)
+	if !ok {
+		return
 	}
-	return nil
+	constraints := provisioner.Spec.Constraints.DeepCopy()
+	go func() {
+		if err := warmer.Warm(ctx, constraints); err != nil {
+			logging.FromContext(ctx).Errorf("Warming cloud provider caches for provisioner %s, %s", provisioner.Name, err)
+		}
+	}()
 }
 
 // Returns true if the new candidate provisioner is different than the provisioner in memory.
@@ -169,8 +325,25 @@ func requirements(instanceTypes []cloudprovider.InstanceType) []v1.NodeSelectorR
 	return requirements
 }
 
+// Start blocks until the manager stops or loses leadership, and then stops
+// every provisioner, so that only the leader batches pods and launches nodes.
+// The manager only reconciles provisioners, and so creates provisioners, while
+// it's the leader.
+func (c *Controller) Start(ctx context.Context) error {
+	<-ctx.Done()
+	c.provisioners.Range(func(key, _ interface{}) bool {
+		c.Delete(key.(string))
+		return true
+	})
+	return nil
+}
+
 // Register the controller to the manager
 func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	// Runnables participate in leader election unless they opt out
+	if err := m.Add(c); err != nil {
+		return fmt.Errorf("adding provisioning controller runnable, %w", err)
+	}
 	return controllerruntime.
 		NewControllerManagedBy(m).
 		Named(controllerName).
@@ -0,0 +1,226 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+)
+
+var (
+	launchCostRateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "allocation_controller",
+			Name:      "launch_cost_rate",
+			Help:      "Hourly cost of the nodes launched per hour over the cost anomaly window. Costs are hourly prices if the cloud provider reports them, and vCPUs otherwise. Broken down by provisioner.",
+		},
+		[]string{metrics.ProvisionerLabel},
+	)
+	launchCostBaselineRateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "allocation_controller",
+			Name:      "launch_cost_baseline_rate",
+			Help:      "Hourly cost of the nodes launched per hour over the trailing cost anomaly baseline. Broken down by provisioner.",
+		},
+		[]string{metrics.ProvisionerLabel},
+	)
+	costAnomaliesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "allocation_controller",
+			Name:      "cost_anomalies_total",
+			Help:      "Number of times the launch cost rate exceeded the cost anomaly threshold times its baseline. Broken down by provisioner.",
+		},
+		[]string{metrics.ProvisionerLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(launchCostRateGauge, launchCostBaselineRateGauge, costAnomaliesCounter)
+}
+
+// CostAnalyzerOptions configure the detection of cost anomalies
+type CostAnalyzerOptions struct {
+	// Threshold is the ratio of the launch cost rate to its baseline that's anomalous
+	Threshold float64
+	// Window is the period over which the launch cost rate is measured
+	Window time.Duration
+	// Baseline is the trailing period, before the window, that the launch cost rate is compared to
+	Baseline time.Duration
+	// MinimumRate is the launch cost rate that's anomalous if nothing was launched during the baseline
+	MinimumRate float64
+}
+
+// CostAnalyzer detects sharp increases in the cost of the nodes that each
+// provisioner launches, e.g. runaway scale up due to a misconfigured
+// autoscaler. A provisioner's launch cost rate is the cost of the nodes it
+// launched during the window, per hour. It's anomalous if it exceeds the
+// threshold times the rate over the trailing baseline, or the minimum rate if
+// the provisioner launched nothing during the baseline. Anomalies are reported
+// at most once per window, and not until the analyzer has observed a baseline.
+type CostAnalyzer struct {
+	kubeClient client.Client
+	recorder   events.Recorder
+	options    CostAnalyzerOptions
+	started    time.Time
+
+	mu       sync.Mutex
+	launches map[string][]launchCost
+	reported map[string]time.Time
+}
+
+type launchCost struct {
+	time time.Time
+	cost float64
+}
+
+// NewCostAnalyzer is a constructor
+func NewCostAnalyzer(kubeClient client.Client, recorder events.Recorder, options CostAnalyzerOptions) *CostAnalyzer {
+	return &CostAnalyzer{
+		kubeClient: kubeClient,
+		recorder:   recorder,
+		options:    options,
+		started:    injectabletime.Now(),
+		launches:   map[string][]launchCost{},
+		reported:   map[string]time.Time{},
+	}
+}
+
+// Observe records the cost of the nodes launched in a provisioning round, and
+// reports an anomaly if the provisioner's launch cost rate has spiked. It's an
+// OutcomeHook.
+func (a *CostAnalyzer) Observe(ctx context.Context, outcome Outcome) {
+	now := injectabletime.Now()
+	anomaly, ok := a.record(outcome, now)
+	if !ok {
+		return
+	}
+	costAnomaliesCounter.WithLabelValues(outcome.Provisioner).Inc()
+	logging.FromContext(ctx).Warnf("Launched %d node(s) at a cost rate of %.2f/h over the last %s, exceeding %.2f times the baseline of %.2f/h",
+		anomaly.nodes, anomaly.rate, a.options.Window, a.options.Threshold, anomaly.baseline)
+	provisioner := &v1alpha5.Provisioner{}
+	if err := a.kubeClient.Get(ctx, types.NamespacedName{Name: outcome.Provisioner}, provisioner); err != nil {
+		logging.FromContext(ctx).Errorf("Getting provisioner to report cost anomaly, %s", err)
+		return
+	}
+	a.recorder.CostAnomaly(provisioner, anomaly.nodes, a.options.Window, anomaly.rate, anomaly.baseline)
+}
+
+type costAnomaly struct {
+	nodes    int
+	rate     float64
+	baseline float64
+}
+
+// record adds the round's launches to the provisioner's history, and returns
+// the anomaly, if any, that should be reported
+func (a *CostAnalyzer) record(outcome Outcome, now time.Time) (costAnomaly, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Forget launches that are older than the baseline
+	launches := []launchCost{}
+	for _, launch := range a.launches[outcome.Provisioner] {
+		if now.Sub(launch.time) <= a.options.Window+a.options.Baseline {
+			launches = append(launches, launch)
+		}
+	}
+	for _, node := range outcome.Nodes {
+		if node.Node != nil {
+			launches = append(launches, launchCost{time: now, cost: cost(node.InstanceType)})
+		}
+	}
+	a.launches[outcome.Provisioner] = launches
+	// Once the provisioner's launches have aged out, its rates are no longer exported
+	if len(launches) == 0 {
+		delete(a.launches, outcome.Provisioner)
+		delete(a.reported, outcome.Provisioner)
+		launchCostRateGauge.DeleteLabelValues(outcome.Provisioner)
+		launchCostBaselineRateGauge.DeleteLabelValues(outcome.Provisioner)
+		return costAnomaly{}, false
+	}
+
+	anomaly := costAnomaly{}
+	windowStart := now.Add(-a.options.Window)
+	baselineStart := windowStart.Add(-a.options.Baseline)
+	if baselineStart.Before(a.started) {
+		baselineStart = a.started
+	}
+	var windowCost, baselineCost float64
+	for _, launch := range launches {
+		if launch.time.After(windowStart) {
+			windowCost += launch.cost
+			anomaly.nodes++
+		} else if !launch.time.Before(baselineStart) {
+			baselineCost += launch.cost
+		}
+	}
+	anomaly.rate = windowCost / a.options.Window.Hours()
+	launchCostRateGauge.WithLabelValues(outcome.Provisioner).Set(anomaly.rate)
+	// Without a baseline, there's nothing to compare the rate to
+	baselineDuration := windowStart.Sub(baselineStart)
+	if baselineDuration <= 0 {
+		return anomaly, false
+	}
+	anomaly.baseline = baselineCost / baselineDuration.Hours()
+	launchCostBaselineRateGauge.WithLabelValues(outcome.Provisioner).Set(anomaly.baseline)
+	if !a.anomalous(anomaly) {
+		// The anomaly has cleared, so the next one is reported as soon as it's observed
+		delete(a.reported, outcome.Provisioner)
+		return anomaly, false
+	}
+	if reported, ok := a.reported[outcome.Provisioner]; ok && now.Sub(reported) < a.options.Window {
+		return anomaly, false
+	}
+	a.reported[outcome.Provisioner] = now
+	return anomaly, true
+}
+
+// anomalous returns true if the rate exceeds the threshold times the baseline,
+// or the minimum rate if there's no baseline to compare it to
+func (a *CostAnalyzer) anomalous(anomaly costAnomaly) bool {
+	if anomaly.baseline == 0 {
+		return anomaly.rate > a.options.MinimumRate
+	}
+	return anomaly.rate > a.options.Threshold*anomaly.baseline
+}
+
+// cost returns the hourly price of the instance type, if the cloud provider
+// reports it, and otherwise its vCPUs as a proxy
+func cost(instanceType cloudprovider.InstanceType) float64 {
+	if instanceType == nil {
+		return 0
+	}
+	if priced, ok := instanceType.(cloudprovider.PricedInstanceType); ok {
+		if price, ok := priced.Price(); ok {
+			return price
+		}
+	}
+	return float64(instanceType.CPU().MilliValue()) / 1000
+}
@@ -0,0 +1,141 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/clock"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter/pkg/metrics"
+)
+
+var throttledLaunchesCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "allocation_controller",
+		Name:      "throttled_launches_total",
+		Help:      "Number of node launches that were delayed by the global or provisioner launch rate limits. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+// LaunchClock is the clock that launches wait on, which may be mocked by tests.
+var LaunchClock clock.Clock = clock.RealClock{}
+
+func init() {
+	crmetrics.Registry.MustRegister(throttledLaunchesCounter)
+}
+
+// newLaunchLimiter returns a limiter that allows the given number of node
+// launches per minute, in bursts of up to a minute's worth of launches, or nil
+// if launches aren't limited.
+func newLaunchLimiter(nodesPerMinute int) *rate.Limiter {
+	if nodesPerMinute <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(nodesPerMinute)), nodesPerMinute)
+}
+
+// updateLaunchLimiter returns the limiter of a provisioner whose spec changed,
+// updated to the given rate. The limiter is reused, so that the launches it
+// has already allowed still count against the burst.
+func updateLaunchLimiter(limiter *rate.Limiter, nodesPerMinute int) *rate.Limiter {
+	if nodesPerMinute <= 0 || limiter == nil {
+		return newLaunchLimiter(nodesPerMinute)
+	}
+	now := LaunchClock.Now()
+	limiter.SetLimitAt(now, rate.Every(time.Minute/time.Duration(nodesPerMinute)))
+	limiter.SetBurstAt(now, nodesPerMinute)
+	return limiter
+}
+
+// waitToLaunch blocks until a node may be launched within all of the
+// provisioner's launch rate limits, and reserves its launch. Launches are
+// queued in the order they arrive, rather than dropped. If the context is
+// cancelled while waiting, the launch is returned to every limiter.
+func (p *Provisioner) waitToLaunch(ctx context.Context) error {
+	now := LaunchClock.Now()
+	reservations := []*rate.Reservation{}
+	delay := time.Duration(0)
+	for _, limiter := range p.limiters() {
+		reservation := limiter.ReserveN(now, 1)
+		reservations = append(reservations, reservation)
+		if reservation.DelayFrom(now) > delay {
+			delay = reservation.DelayFrom(now)
+		}
+	}
+	if delay == 0 {
+		return nil
+	}
+	select {
+	case <-LaunchClock.After(delay):
+	case <-ctx.Done():
+		for _, reservation := range reservations {
+			reservation.CancelAt(LaunchClock.Now())
+		}
+		return ctx.Err()
+	}
+	throttledLaunchesCounter.WithLabelValues(p.Name).Inc()
+	return nil
+}
+
+// reserveLaunches returns how many of n nodes may be launched now, including
+// the node whose launch was reserved by waitToLaunch. The launches of the
+// others are reserved as long as every limiter allows them without waiting.
+func (p *Provisioner) reserveLaunches(n int) int {
+	limiters := p.limiters()
+	if len(limiters) == 0 {
+		return n
+	}
+	now := LaunchClock.Now()
+	reserved := 1
+	for reserved < n && reserveLaunch(limiters, now) {
+		reserved++
+	}
+	return reserved
+}
+
+// reserveLaunch reserves a launch from every limiter if all of them allow it
+// now, or leaves the limiters unchanged otherwise
+func reserveLaunch(limiters []*rate.Limiter, now time.Time) bool {
+	reservations := []*rate.Reservation{}
+	for _, limiter := range limiters {
+		reservation := limiter.ReserveN(now, 1)
+		reservations = append(reservations, reservation)
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			for _, reservation := range reservations {
+				reservation.CancelAt(now)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// limiters returns the global and provisioner launch rate limiters that are set
+func (p *Provisioner) limiters() []*rate.Limiter {
+	limiters := []*rate.Limiter{}
+	for _, limiter := range []*rate.Limiter{p.globalLimiter, p.limiter} {
+		if limiter != nil {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return limiters
+}
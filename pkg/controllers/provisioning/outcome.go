@@ -0,0 +1,158 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+// Outcome reports what happened to a batch of pods in a provisioning round
+type Outcome struct {
+	// Provisioner is the name of the provisioner that provisioned the batch
+	Provisioner string
+	// Pods are the batch's pods that were still provisionable, and were considered
+	Pods []*v1.Pod
+	// Nodes are the nodes that were planned for the pods
+	Nodes []*NodeOutcome
+	// Err is the reason that no nodes were planned, e.g. if the pods couldn't be solved
+	Err error
+}
+
+// NodeOutcome reports what happened to a node that was planned in a provisioning round
+type NodeOutcome struct {
+	// InstanceTypeOptions are the names of the instance types that the node could be launched as
+	InstanceTypeOptions []string
+	// Pods are the pods that were packed onto the node
+	Pods []*v1.Pod
+	// Node is the node that was launched, or nil if it wasn't launched
+	Node *v1.Node
+	// InstanceType is the instance type that the node was launched as, or nil if it's unknown
+	InstanceType cloudprovider.InstanceType
+	// Err is the reason that the node wasn't launched, or that its pods weren't bound
+	Err error
+
+	instanceTypeOptions []cloudprovider.InstanceType
+}
+
+// Launched returns the nodes that were launched in the round
+func (o Outcome) Launched() []*v1.Node {
+	nodes := []*v1.Node{}
+	for _, node := range o.Nodes {
+		if node.Node != nil {
+			nodes = append(nodes, node.Node)
+		}
+	}
+	return nodes
+}
+
+// OutcomeHook is called with the outcome of each provisioning round. Hooks are
+// called synchronously once the round completes, and shouldn't block.
+type OutcomeHook func(context.Context, Outcome)
+
+// Outcomes is a registry of the hooks that are called with the outcome of each
+// provisioning round. It's shared by the controller and its provisioners, so
+// that hooks outlive provisioners that are recreated as their specs change.
+type Outcomes struct {
+	mu    sync.RWMutex
+	hooks []OutcomeHook
+}
+
+// Subscribe registers a hook for the outcomes of future provisioning rounds
+func (o *Outcomes) Subscribe(hook OutcomeHook) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.hooks = append(o.hooks, hook)
+}
+
+func (o *Outcomes) notify(ctx context.Context, outcome Outcome) {
+	if o == nil {
+		return
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for _, hook := range o.hooks {
+		hook(ctx, outcome)
+	}
+}
+
+// round accumulates the outcome of a provisioning round. Nodes are identified
+// by their first pod, since each pod is packed onto at most one node.
+type round struct {
+	mu      sync.Mutex
+	outcome Outcome
+	nodes   map[*v1.Pod]*NodeOutcome
+}
+
+func newRound(provisioner string) *round {
+	return &round{outcome: Outcome{Provisioner: provisioner}, nodes: map[*v1.Pod]*NodeOutcome{}}
+}
+
+// plan records the nodes that will be launched for the node requests
+func (r *round) plan(nodeRequests []*nodeRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, nodeRequest := range nodeRequests {
+		instanceTypeOptions := []string{}
+		for _, instanceType := range nodeRequest.InstanceTypeOptions {
+			instanceTypeOptions = append(instanceTypeOptions, instanceType.Name())
+		}
+		for _, pods := range nodeRequest.pods {
+			node := &NodeOutcome{InstanceTypeOptions: instanceTypeOptions, Pods: pods, instanceTypeOptions: nodeRequest.InstanceTypeOptions}
+			r.outcome.Nodes = append(r.outcome.Nodes, node)
+			if len(pods) > 0 {
+				r.nodes[pods[0]] = node
+			}
+		}
+	}
+}
+
+// launched records the node that was launched for the pods
+func (r *round) launched(pods []*v1.Pod, node *v1.Node, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(pods) == 0 {
+		return
+	}
+	if outcome, ok := r.nodes[pods[0]]; ok {
+		outcome.Node = node
+		outcome.Err = err
+		for _, instanceType := range outcome.instanceTypeOptions {
+			if instanceType.Name() == node.Labels[v1.LabelInstanceTypeStable] {
+				outcome.InstanceType = instanceType
+			}
+		}
+	}
+}
+
+// failed records the error for the node requests' nodes that weren't launched
+func (r *round) failed(nodeRequests []*nodeRequest, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, nodeRequest := range nodeRequests {
+		for _, pods := range nodeRequest.pods {
+			if len(pods) == 0 {
+				continue
+			}
+			if outcome, ok := r.nodes[pods[0]]; ok && outcome.Node == nil && outcome.Err == nil {
+				outcome.Err = err
+			}
+		}
+	}
+}
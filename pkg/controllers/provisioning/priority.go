@@ -0,0 +1,307 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	stringsets "k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	"github.com/aws/karpenter/pkg/utils/sets"
+)
+
+// priority returns the highest priority of the pods
+func priority(pods []*v1.Pod) (result int32) {
+	for i, pod := range pods {
+		if podPriority := ptr.Int32Value(pod.Spec.Priority); i == 0 || podPriority > result {
+			result = podPriority
+		}
+	}
+	return result
+}
+
+// withPods returns a copy of the node request for a subset of its nodes
+func (n *nodeRequest) withPods(pods [][]*v1.Pod) *nodeRequest {
+	request := *n.NodeRequest
+	request.Quantity = len(pods)
+	return &nodeRequest{NodeRequest: &request, pods: pods}
+}
+
+// plannedNode is one of the nodes of a node request
+type plannedNode struct {
+	request *nodeRequest
+	pods    []*v1.Pod
+}
+
+// byPriority returns the nodes of the node requests ordered by the highest
+// priority of their pods, so that nodes for higher priority pods are launched
+// first when launches are rate limited or the provisioner's limits are nearly
+// exhausted
+func byPriority(nodeRequests []*nodeRequest) []plannedNode {
+	nodes := []plannedNode{}
+	for _, request := range nodeRequests {
+		for _, pods := range request.pods {
+			nodes = append(nodes, plannedNode{request: request, pods: pods})
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return priority(nodes[i].pods) > priority(nodes[j].pods)
+	})
+	return nodes
+}
+
+// regroup returns node requests for the nodes, in order of their first node
+func regroup(nodes []plannedNode) []*nodeRequest {
+	order := []*nodeRequest{}
+	pods := map[*nodeRequest][][]*v1.Pod{}
+	for _, node := range nodes {
+		if _, ok := pods[node.request]; !ok {
+			order = append(order, node.request)
+		}
+		pods[node.request] = append(pods[node.request], node.pods)
+	}
+	result := []*nodeRequest{}
+	for _, request := range order {
+		result = append(result, request.withPods(pods[request]))
+	}
+	return result
+}
+
+// withinLimits separates the nodes, in priority order, that fit within the
+// provisioner's remaining limits from those that don't, so that a flood of low
+// priority pods can't use up the limits before higher priority pods are
+// provisioned. Each node is estimated at the capacity of the instance type that
+// the cloud provider is expected to launch it as. Nodes that would exceed a
+// scoped limit, e.g. on spot capacity, are restricted to the label's other
+// values if their requirements allow any.
+func withinLimits(limits *v1alpha5.Limits, status v1alpha5.ProvisionerStatus, nodeRequests []*nodeRequest) (within []*nodeRequest, exceeding []*nodeRequest) {
+	nodes := byPriority(nodeRequests)
+	if limits == nil || (len(limits.Resources) == 0 && len(limits.Scoped) == 0) {
+		return regroup(nodes), nil
+	}
+	projected := newProjection(status)
+	restricted := map[restriction]*nodeRequest{}
+	capacities := map[*nodeRequest]v1.ResourceList{}
+	fit, unfit := []plannedNode{}, []plannedNode{}
+	for _, node := range nodes {
+		if len(node.request.InstanceTypeOptions) == 0 {
+			fit = append(fit, node)
+			continue
+		}
+		capacity, ok := capacities[node.request]
+		if !ok {
+			capacity = expectedCapacity(node.request.InstanceTypeOptions)
+			capacities[node.request] = capacity
+		}
+		total := resources.Merge(projected.total, capacity)
+		if exceeds(limits, total) {
+			unfit = append(unfit, node)
+			continue
+		}
+		requirements, excluded, ok := projected.restrict(limits, node.request.Constraints.Requirements, capacity, total)
+		if !ok {
+			unfit = append(unfit, node)
+			continue
+		}
+		if len(excluded) > 0 {
+			key := restriction{request: node.request, excluded: fmt.Sprint(excluded)}
+			if _, ok := restricted[key]; !ok {
+				restricted[key] = node.request.withRequirements(requirements)
+			}
+			node.request = restricted[key]
+		}
+		projected.add(limits, requirements, capacity)
+		fit = append(fit, node)
+	}
+	return regroup(fit), regroup(unfit)
+}
+
+// expectedCapacity returns the capacity of the instance type that the cloud
+// provider is expected to launch: the cheapest of the options, or the smallest
+// if their prices aren't known. Every option fits the node's pods, so the cloud
+// provider may launch a larger one, e.g. for spot capacity; the provisioner's
+// status is updated with the node's actual capacity once it's launched.
+func expectedCapacity(instanceTypes []cloudprovider.InstanceType) v1.ResourceList {
+	options := append([]cloudprovider.InstanceType{}, instanceTypes...)
+	cloudprovider.ComparatorChain{cloudprovider.ComparePrice, cloudprovider.CompareSize}.Sort(options)
+	return options[0].Resources()
+}
+
+// restriction identifies the copy of a node request whose nodes are excluded
+// from some values of the scoped limits' labels
+type restriction struct {
+	request  *nodeRequest
+	excluded string
+}
+
+// withRequirements returns a copy of the node request, without its nodes, whose
+// constraints have the requirements
+func (n *nodeRequest) withRequirements(requirements v1alpha5.Requirements) *nodeRequest {
+	request := *n.NodeRequest
+	request.Constraints = n.Constraints.DeepCopy()
+	request.Constraints.Requirements = requirements
+	return &nodeRequest{NodeRequest: &request}
+}
+
+// scope is a value of the label that a scoped limit is scoped by
+type scope struct {
+	key   string
+	value string
+}
+
+// projection is the provisioner's resource usage once the nodes that fit
+// within its limits so far are launched
+type projection struct {
+	total  v1.ResourceList
+	scoped map[scope]v1.ResourceList
+}
+
+func newProjection(status v1alpha5.ProvisionerStatus) *projection {
+	p := &projection{total: resources.Merge(status.Resources), scoped: map[scope]v1.ResourceList{}}
+	for _, scoped := range status.ScopedResources {
+		p.scoped[scope{key: scoped.Key, value: scoped.Value}] = resources.Merge(scoped.Resources)
+	}
+	return p
+}
+
+// restrict excludes the label values that a node with the requirements would
+// exceed a scoped limit with. Percentages only steer nodes to the label's other
+// values, so values that are capped by percentages are allowed if the node
+// can't launch with any other value; otherwise a workload that requires a
+// capped value, e.g. spot, could never launch its first node. It returns false
+// if the node can't launch with any of the remaining values.
+func (p *projection) restrict(limits *v1alpha5.Limits, requirements v1alpha5.Requirements, capacity v1.ResourceList, total v1.ResourceList) (v1alpha5.Requirements, []v1.NodeSelectorRequirement, bool) {
+	excluded := []v1.NodeSelectorRequirement{}
+	for i := range limits.Scoped {
+		limit := &limits.Scoped[i]
+		blocked, capped := []string{}, []string{}
+		for _, value := range p.values(limit, requirements.Get(limit.Key)) {
+			usage := resources.Merge(p.scoped[scope{key: limit.Key, value: value}], capacity)
+			if limit.ResourcesExceededBy(value, usage) != nil {
+				blocked = append(blocked, value)
+			} else if limit.PercentagesExceededBy(value, usage, total) != nil {
+				capped = append(capped, value)
+			}
+		}
+		if len(capped) > 0 {
+			if all := append(append([]string{}, blocked...), capped...); requirements.Add(v1.NodeSelectorRequirement{Key: limit.Key, Operator: v1.NodeSelectorOpNotIn, Values: all}).Get(limit.Key).Len() > 0 {
+				blocked = all
+			}
+		}
+		if len(blocked) == 0 {
+			continue
+		}
+		requirement := v1.NodeSelectorRequirement{Key: limit.Key, Operator: v1.NodeSelectorOpNotIn, Values: blocked}
+		requirements = requirements.Add(requirement)
+		excluded = append(excluded, requirement)
+		if requirements.Get(limit.Key).Len() == 0 {
+			return requirements, excluded, false
+		}
+	}
+	return requirements, excluded, true
+}
+
+// add projects the capacity of a node with the requirements. Since the cloud
+// provider chooses between the values that the node may launch with, it's
+// counted against each of them.
+func (p *projection) add(limits *v1alpha5.Limits, requirements v1alpha5.Requirements, capacity v1.ResourceList) {
+	p.total = resources.Merge(p.total, capacity)
+	counted := map[scope]bool{}
+	for i := range limits.Scoped {
+		limit := &limits.Scoped[i]
+		for _, value := range p.values(limit, requirements.Get(limit.Key)) {
+			if key := (scope{key: limit.Key, value: value}); !counted[key] {
+				counted[key] = true
+				p.scoped[key] = resources.Merge(p.scoped[key], capacity)
+			}
+		}
+	}
+}
+
+// values returns the values of the limit's label, in order, that the limit
+// applies to and that a node may launch with. If any value is allowed, only
+// the values that are named by the limit or already in use are considered.
+func (p *projection) values(limit *v1alpha5.ScopedLimit, allowed sets.Set) []string {
+	candidates := stringsets.NewString(limit.Values...)
+	if allowed.IsComplement() {
+		for key := range p.scoped {
+			if key.key == limit.Key {
+				candidates.Insert(key.value)
+			}
+		}
+	} else {
+		candidates = allowed.Values()
+	}
+	values := []string{}
+	for _, value := range candidates.List() {
+		if allowed.Has(value) && limit.Applies(value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// exceeds returns true if the usage is greater than any of the limits
+func exceeds(limits *v1alpha5.Limits, usage v1.ResourceList) bool {
+	for resourceName, limit := range limits.Resources {
+		if quantity, ok := usage[resourceName]; ok && quantity.Cmp(limit) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// prioritize orders the node requests by priority and drops the nodes that
+// would exceed the provisioner's limits, recording them as failed in the round
+func (p *Provisioner) prioritize(ctx context.Context, nodeRequests []*nodeRequest, r *round) ([]*nodeRequest, error) {
+	latest := &v1alpha5.Provisioner{}
+	if err := p.kubeClient.Get(ctx, client.ObjectKeyFromObject(p.Provisioner), latest); err != nil {
+		return nil, fmt.Errorf("getting current resource usage, %w", err)
+	}
+	// Nodes that a previous controller was launching may not have registered yet, so they're counted too
+	status := latest.Status
+	status.Resources = resources.Merge(status.Resources, p.checkpoint.PreviousCapacity(ctx, p.Name))
+	within, exceeding := withinLimits(p.Spec.Limits, status, nodeRequests)
+	if len(exceeding) > 0 {
+		err := fmt.Errorf("launching %d nodes would exceed the provisioner's limits", nodeCount(exceeding))
+		r.failed(exceeding, err)
+		for _, request := range exceeding {
+			for _, pods := range request.pods {
+				for _, pod := range pods {
+					p.recorder.PodExceededLimits(pod, p.Name, err)
+				}
+			}
+		}
+		logging.FromContext(ctx).Infof("Skipping lower priority pods, %s", err)
+	}
+	return within, nil
+}
+
+// nodeCount returns the number of nodes across the node requests
+func nodeCount(nodeRequests []*nodeRequest) (count int) {
+	for _, request := range nodeRequests {
+		count += len(request.pods)
+	}
+	return count
+}
@@ -17,15 +17,20 @@ package provisioning
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/util/workqueue"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
@@ -33,29 +38,49 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/metrics"
 	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/pod"
+	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
-func NewProvisioner(ctx context.Context, provisioner *v1alpha5.Provisioner, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider) *Provisioner {
+func NewProvisioner(ctx context.Context, provisioner *v1alpha5.Provisioner, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, recorder events.Recorder, globalLimiter *rate.Limiter, limiter *rate.Limiter, outcomes *Outcomes, checkpoint *Checkpoint) *Provisioner {
 	running, stop := context.WithCancel(ctx)
 	p := &Provisioner{
 		Provisioner:   provisioner,
-		batcher:       NewBatcher(running),
+		batcher:       NewBatcher(running, batcherOptions(ctx, provisioner)),
 		Stop:          stop,
 		cloudProvider: cloudProvider,
 		kubeClient:    kubeClient,
 		coreV1Client:  coreV1Client,
-		scheduler:     scheduling.NewScheduler(kubeClient),
-		packer:        binpacking.NewPacker(kubeClient, cloudProvider),
+		recorder:      recorder,
+		scheduler:     scheduling.NewScheduler(kubeClient, recorder),
+		packer:        binpacking.NewPacker(ctx, kubeClient, cloudProvider, recorder),
+		solutions:     newSolutions(),
+		globalLimiter: globalLimiter,
+		limiter:       updateLaunchLimiter(limiter, int(ptr.Int32Value(provisioner.Spec.MaxNodesPerMinute))),
+		outcomes:      outcomes,
+		checkpoint:    checkpoint,
 	}
 	go func() {
 		for running.Err() == nil {
-			if err := p.provision(running); err != nil {
-				logging.FromContext(running).Errorf("Provisioning failed, %s", err)
+			// Batch pods
+			logging.FromContext(running).Infof("Waiting for unschedulable pods")
+			items, window, flush := p.batcher.Wait()
+			if len(items) == 0 {
+				flush()
+				continue
 			}
+			logging.FromContext(running).Infof("Batched %d pods in %s", len(items), window)
+			go func() {
+				defer flush()
+				if err := p.provision(running, items); err != nil {
+					logging.FromContext(running).Errorf("Provisioning failed, %s", err)
+				}
+			}()
 		}
 		logging.FromContext(running).Info("Stopped provisioner")
 	}()
@@ -72,8 +97,19 @@ type Provisioner struct {
 	cloudProvider cloudprovider.CloudProvider
 	kubeClient    client.Client
 	coreV1Client  corev1.CoreV1Interface
+	recorder      events.Recorder
 	scheduler     *scheduling.Scheduler
 	packer        *binpacking.Packer
+	solutions     *solutions
+	// globalLimiter limits the rate of node launches across all provisioners, and
+	// limiter the rate of this provisioner's, if set
+	globalLimiter *rate.Limiter
+	limiter       *rate.Limiter
+	outcomes      *Outcomes
+	checkpoint    *Checkpoint
+	// launching serializes the limit checks and launches of batches that are
+	// in flight concurrently, so that they can't exceed the limits together
+	launching sync.Mutex
 }
 
 // Add a pod to the provisioner and return a channel to block on. The caller is
@@ -82,12 +118,12 @@ func (p *Provisioner) Add(pod *v1.Pod) <-chan struct{} {
 	return p.batcher.Add(pod)
 }
 
-func (p *Provisioner) provision(ctx context.Context) error {
-	// Batch pods
-	logging.FromContext(ctx).Infof("Waiting for unschedulable pods")
-	items, window := p.batcher.Wait()
-	defer p.batcher.Flush()
-	logging.FromContext(ctx).Infof("Batched %d pods in %s", len(items), window)
+func (p *Provisioner) provision(ctx context.Context, items []interface{}) (err error) {
+	r := newRound(p.Name)
+	defer func() {
+		r.outcome.Err = err
+		p.outcomes.notify(ctx, r.outcome)
+	}()
 	// Filter pods
 	pods := []*v1.Pod{}
 	for _, item := range items {
@@ -99,37 +135,158 @@ func (p *Provisioner) provision(ctx context.Context) error {
 			pods = append(pods, item.(*v1.Pod))
 		}
 	}
+	r.outcome.Pods = pods
+	// Reuse the solution of an identical batch, if any
+	key, shapes, cacheable := solutionKey(ctx, pods)
+	if cacheable {
+		if nodeRequests, ok := p.solutions.Get(key, pods, shapes); ok {
+			logging.FromContext(ctx).Debugf("Reusing the solution of an identical batch of %d pods", len(pods))
+			return p.launchNodeRequests(ctx, nodeRequests, r)
+		}
+	}
+	nodeRequests, err := p.solve(ctx, pods)
+	if err != nil {
+		return err
+	}
+	if cacheable {
+		p.solutions.Set(key, pods, shapes, nodeRequests)
+	}
+	return p.launchNodeRequests(ctx, nodeRequests, r)
+}
+
+// solve separates pods by scheduling constraints, and packs them into node requests
+func (p *Provisioner) solve(ctx context.Context, pods []*v1.Pod) ([]*nodeRequest, error) {
 	// Separate pods by scheduling constraints
 	schedules, err := p.scheduler.Solve(ctx, p.Provisioner, pods)
 	if err != nil {
-		return fmt.Errorf("solving scheduling constraints, %w", err)
+		return nil, fmt.Errorf("solving scheduling constraints, %w", err)
 	}
 	// Get instance type options
 	instanceTypes, err := p.cloudProvider.GetInstanceTypes(ctx, p.Spec.Provider)
 	if err != nil {
-		return fmt.Errorf("getting instance types, %w", err)
+		return nil, fmt.Errorf("getting instance types, %w", err)
 	}
-	// Launch capacity and bind pods
+	// Pack pods for each schedule
+	packings := make([][]*binpacking.Packing, len(schedules))
 	workqueue.ParallelizeUntil(ctx, len(schedules), len(schedules), func(i int) {
-		packings, err := p.packer.Pack(ctx, schedules[i].Constraints, schedules[i].Pods, instanceTypes)
+		packing, err := p.packer.Pack(ctx, schedules[i], instanceTypes)
 		if err != nil {
 			logging.FromContext(ctx).Errorf("Could not pack pods, %s", err)
 			return
 		}
-		workqueue.ParallelizeUntil(ctx, len(packings), len(packings), func(j int) {
-			if err := p.launch(ctx, schedules[i].Constraints, packings[j]); err != nil {
-				logging.FromContext(ctx).Errorf("Could not launch node, %s", err)
-				return
-			}
-		})
+		packings[i] = packing
 	})
+	nodeRequests := []*nodeRequest{}
+	for i := range schedules {
+		for _, packing := range packings[i] {
+			nodeRequests = append(nodeRequests, &nodeRequest{
+				NodeRequest: &cloudprovider.NodeRequest{
+					Constraints:         schedules[i].Constraints,
+					InstanceTypeOptions: packing.InstanceTypeOptions,
+					Quantity:            packing.NodeQuantity,
+					PodRequests:         podRequests(packing.Pods),
+					DaemonRequests:      packing.DaemonRequests,
+				},
+				pods: packing.Pods,
+			})
+		}
+	}
+	return nodeRequests, nil
+}
+
+// launchNodeRequests launches capacity for the node requests and binds pods,
+// recording the outcome of each node in the round
+func (p *Provisioner) launchNodeRequests(ctx context.Context, nodeRequests []*nodeRequest, r *round) error {
+	if len(nodeRequests) == 0 {
+		return nil
+	}
+	r.plan(nodeRequests)
+	// Checkpoint the round's launches until they complete, so that a restarted controller doesn't launch
+	// duplicate nodes for their pods
+	id, err := p.checkpoint.Begin(ctx, p.newLaunch(nodeRequests))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Checkpointing in-flight launches, %s", err)
+	}
+	defer func() {
+		if err := p.checkpoint.Complete(ctx, id); err != nil {
+			logging.FromContext(ctx).Errorf("Checkpointing completed launches, %s", err)
+		}
+	}()
+	if err := p.launch(ctx, nodeRequests, r); err != nil {
+		r.failed(nodeRequests, err)
+		logging.FromContext(ctx).Errorf("Could not launch node, %s", err)
+		p.recorder.LaunchFailed(p.Provisioner, err)
+		// The solution may have been stale, e.g. if its instance types are unavailable
+		p.solutions.Invalidate()
+	}
 	return nil
 }
 
+// newLaunch returns the bookkeeping of the node requests' launches, whose
+// capacity is estimated as it is for the provisioner's limits
+func (p *Provisioner) newLaunch(nodeRequests []*nodeRequest) *Launch {
+	launch := &Launch{Provisioner: p.Name, Capacity: v1.ResourceList{}}
+	for _, request := range nodeRequests {
+		for _, pods := range request.pods {
+			for _, pod := range pods {
+				launch.Pods = append(launch.Pods, pod.UID)
+			}
+			launch.Nodes++
+			if len(request.InstanceTypeOptions) > 0 {
+				launch.Capacity = resources.Merge(launch.Capacity, expectedCapacity(request.InstanceTypeOptions))
+			}
+		}
+	}
+	return launch
+}
+
+// nodeRequest pairs a request for nodes with the pods to bind to each node
+type nodeRequest struct {
+	*cloudprovider.NodeRequest
+	pods [][]*v1.Pod
+}
+
+// podRequests returns the largest requests of each resource across the nodes' pods
+func podRequests(pods [][]*v1.Pod) v1.ResourceList {
+	result := v1.ResourceList{}
+	for _, nodePods := range pods {
+		for resourceName, quantity := range resources.RequestsForPods(nodePods...) {
+			if current, ok := result[resourceName]; !ok || quantity.Cmp(current) > 0 {
+				result[resourceName] = quantity
+			}
+		}
+	}
+	return result
+}
+
+// batcherOptions returns the batching configuration for the provisioner,
+// preferring the provisioner's spec over the controller's options.
+func batcherOptions(ctx context.Context, provisioner *v1alpha5.Provisioner) BatcherOptions {
+	opts := injection.GetOptions(ctx)
+	batcherOptions := BatcherOptions{
+		Name:         provisioner.Name,
+		IdleDuration: opts.BatchIdleDuration,
+		MaxDuration:  opts.BatchMaxDuration,
+		MaxItems:     opts.BatchMaxItems,
+		MaxInFlight:  opts.BatchMaxInFlight,
+	}
+	if provisioner.Spec.BatchIdleDuration != nil {
+		batcherOptions.IdleDuration = provisioner.Spec.BatchIdleDuration.Duration
+	}
+	if provisioner.Spec.BatchMaxDuration != nil {
+		batcherOptions.MaxDuration = provisioner.Spec.BatchMaxDuration.Duration
+	}
+	return batcherOptions
+}
+
 // isProvisionable ensure that the pod can still be provisioned.
 // This check is needed to prevent duplicate binds when a pod is scheduled to a node
 // between the time it was ingested into the scheduler and the time it is included
-// in a provisioner batch.
+// in a provisioner batch, or deleted in the meantime. Pods that nodes are being
+// launched for, e.g. by the controller before it restarted, are provisioned once
+// their launches expire.
+// Pods that are waiting for the node they were nominated to, see bind, aren't
+// provisioned until the node is initialized.
 func (p *Provisioner) isProvisionable(ctx context.Context, candidate *v1.Pod) (bool, error) {
 	stored := &v1.Pod{}
 	if err := p.kubeClient.Get(ctx, client.ObjectKeyFromObject(candidate), stored); err != nil {
@@ -138,10 +295,64 @@ func (p *Provisioner) isProvisionable(ctx context.Context, candidate *v1.Pod) (b
 		}
 		return false, err
 	}
-	return !pod.IsScheduled(stored), nil
+	if pod.IsScheduled(stored) || pod.IsTerminating(stored) || p.checkpoint.InFlight(ctx, stored) {
+		return false, nil
+	}
+	initializing, err := p.isNominatedToInitializingNode(ctx, stored)
+	return !initializing, err
+}
+
+// isNominatedToInitializingNode returns true if the pod was nominated to a node
+// that still has the not-ready taint, e.g. because the node's device plugins
+// haven't registered the extended resources that the pod requests
+func (p *Provisioner) isNominatedToInitializingNode(ctx context.Context, stored *v1.Pod) (bool, error) {
+	name, ok := stored.Annotations[v1alpha5.NominatedNodeAnnotationKey]
+	if !ok {
+		return false, nil
+	}
+	node := &v1.Node{}
+	if err := p.kubeClient.Get(ctx, types.NamespacedName{Name: name}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return node.DeletionTimestamp.IsZero() && v1alpha5.Taints(node.Spec.Taints).HasKey(v1alpha5.NotReadyTaintKey), nil
 }
 
-func (p *Provisioner) launch(ctx context.Context, constraints *v1alpha5.Constraints, packing *binpacking.Packing) error {
+// launch launches the nodes in priority order, as many at a time as the launch
+// rate limits allow. Waiting for the launch rate limits doesn't block the
+// provisioner's other batches, but each launch, and the limit check before it,
+// is serialized with theirs, so that they can't exceed the limits together.
+func (p *Provisioner) launch(ctx context.Context, nodeRequests []*nodeRequest, r *round) error {
+	for remaining := nodeRequests; len(remaining) > 0; {
+		if err := p.waitToLaunch(ctx); err != nil {
+			return err
+		}
+		var err error
+		if remaining, err = p.launchAvailable(ctx, remaining, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// launchAvailable launches the highest priority nodes that are within the
+// provisioner's limits, as many as the launch rate limits allow now, with a
+// single create call, and returns the nodes that remain to be launched
+func (p *Provisioner) launchAvailable(ctx context.Context, nodeRequests []*nodeRequest, r *round) ([]*nodeRequest, error) {
+	p.launching.Lock()
+	defer p.launching.Unlock()
+	prioritized, err := p.prioritize(ctx, nodeRequests, r)
+	if err != nil || len(prioritized) == 0 {
+		return nil, err
+	}
+	nodes := byPriority(prioritized)
+	available := p.reserveLaunches(len(nodes))
+	return regroup(nodes[available:]), p.create(ctx, regroup(nodes[:available]), r)
+}
+
+func (p *Provisioner) create(ctx context.Context, nodeRequests []*nodeRequest, r *round) error {
 	// Check limits
 	latest := &v1alpha5.Provisioner{}
 	if err := p.kubeClient.Get(ctx, client.ObjectKeyFromObject(p.Provisioner), latest); err != nil {
@@ -151,15 +362,24 @@ func (p *Provisioner) launch(ctx context.Context, constraints *v1alpha5.Constrai
 		return err
 	}
 	// Create and Bind
-	pods := make(chan []*v1.Pod, len(packing.Pods))
-	defer close(pods)
-	for _, ps := range packing.Pods {
-		pods <- ps
-	}
-	return p.cloudProvider.Create(ctx, constraints, packing.InstanceTypeOptions, packing.NodeQuantity, func(node *v1.Node) error {
-		node.Labels = functional.UnionStringMaps(node.Labels, constraints.Labels)
-		node.Spec.Taints = append(node.Spec.Taints, constraints.Taints...)
-		return p.bind(ctx, node, <-pods)
+	requests := []*cloudprovider.NodeRequest{}
+	pods := map[*cloudprovider.NodeRequest]chan []*v1.Pod{}
+	for _, nodeRequest := range nodeRequests {
+		requests = append(requests, nodeRequest.NodeRequest)
+		pods[nodeRequest.NodeRequest] = make(chan []*v1.Pod, len(nodeRequest.pods))
+		for _, ps := range nodeRequest.pods {
+			pods[nodeRequest.NodeRequest] <- ps
+		}
+		defer close(pods[nodeRequest.NodeRequest])
+	}
+	return p.cloudProvider.Create(ctx, requests, func(nodeRequest *cloudprovider.NodeRequest, node *v1.Node) error {
+		node.Labels = functional.UnionStringMaps(node.Labels, nodeRequest.Constraints.Labels)
+		node.Spec.Taints = append(node.Spec.Taints, nodeRequest.Constraints.Taints...)
+		bound := <-pods[nodeRequest]
+		p.scheduler.Stickiness.Record(node, bound)
+		err := p.bind(ctx, node, bound)
+		r.launched(bound, node, err)
+		return err
 	})
 }
 
@@ -191,19 +411,72 @@ func (p *Provisioner) bind(ctx context.Context, node *v1.Node, pods []*v1.Pod) (
 			return fmt.Errorf("creating node %s, %w", node.Name, err)
 		}
 	}
-	// Bind pods
+	// Bind pods. The API server copies the binding's annotations onto the pod,
+	// which explains to users why the pod is pending until the node is ready.
+	nominated := injectabletime.Now()
+	expectedReady := nominated.Add(injection.GetOptions(ctx).NodeStartupDuration)
+	nomination := map[string]string{
+		v1alpha5.NominatedNodeAnnotationKey:          node.Name,
+		v1alpha5.NominatedProvisionerAnnotationKey:   p.Name,
+		v1alpha5.NominatedTimestampAnnotationKey:     nominated.Format(time.RFC3339),
+		v1alpha5.ExpectedReadyTimestampAnnotationKey: expectedReady.Format(time.RFC3339),
+	}
+	// Pods that request extended resources, e.g. GPUs, aren't bound until the
+	// node's device plugins register the resources, since the kubelet rejects
+	// pods whose extended resources aren't allocatable yet, and their
+	// controllers would create replacements that launch yet another node. They
+	// are nominated to the node instead, and the kube-scheduler binds them once
+	// the node controller removes the not-ready taint.
+	pods, extended := partitionExtended(pods)
+	workqueue.ParallelizeUntil(ctx, len(extended), len(extended), func(i int) {
+		patched := extended[i].DeepCopy()
+		patched.Annotations = functional.UnionStringMaps(patched.Annotations, nomination)
+		if err := p.kubeClient.Patch(ctx, patched, client.MergeFrom(extended[i])); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to nominate %s/%s to %s, %s", extended[i].Namespace, extended[i].Name, node.Name, err)
+		} else {
+			p.recorder.PodNominated(extended[i], node.Name, p.Name, expectedReady)
+		}
+	})
 	var bound int64
 	workqueue.ParallelizeUntil(ctx, len(pods), len(pods), func(i int) {
-		if err := p.coreV1Client.Pods(pods[i].Namespace).Bind(ctx, &v1.Binding{TypeMeta: pods[i].TypeMeta, ObjectMeta: pods[i].ObjectMeta, Target: v1.ObjectReference{Name: node.Name}}, metav1.CreateOptions{}); err != nil {
+		binding := &v1.Binding{TypeMeta: pods[i].TypeMeta, ObjectMeta: pods[i].ObjectMeta, Target: v1.ObjectReference{Name: node.Name}}
+		binding.Annotations = nomination
+		if err := p.coreV1Client.Pods(pods[i].Namespace).Bind(ctx, binding, metav1.CreateOptions{}); err != nil {
 			logging.FromContext(ctx).Errorf("Failed to bind %s/%s to %s, %s", pods[i].Namespace, pods[i].Name, node.Name, err)
 		} else {
+			p.recorder.PodNominated(pods[i], node.Name, p.Name, expectedReady)
 			atomic.AddInt64(&bound, 1)
 		}
 	})
 	logging.FromContext(ctx).Infof("Bound %d pod(s) to node %s", bound, node.Name)
+	if len(extended) > 0 {
+		logging.FromContext(ctx).Infof("Nominated %d pod(s) that request extended resources to node %s", len(extended), node.Name)
+	}
 	return nil
 }
 
+// partitionExtended separates the pods that request extended resources from
+// the rest
+func partitionExtended(pods []*v1.Pod) (rest []*v1.Pod, extended []*v1.Pod) {
+	for _, candidate := range pods {
+		if requestsExtended(candidate) {
+			extended = append(extended, candidate)
+		} else {
+			rest = append(rest, candidate)
+		}
+	}
+	return rest, extended
+}
+
+func requestsExtended(pod *v1.Pod) bool {
+	for resourceName := range resources.RequestsForPods(pod) {
+		if resources.IsExtended(resourceName) {
+			return true
+		}
+	}
+	return false
+}
+
 var bindTimeHistogram = prometheus.NewHistogramVec(
 	prometheus.HistogramOpts{
 		Namespace: metrics.Namespace,
@@ -0,0 +1,111 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/resources"
+)
+
+// Intersections caches the intersection of the provisioner's constraints with
+// each distinct set of pod scheduling constraints. Replicas of a workload share
+// their scheduling constraints, so the provisioner's requirements, which list
+// every viable instance type and zone, are copied, intersected, and hashed once
+// per workload rather than once per pod. Intersections are only valid for the
+// constraints that they're created for, which must not be modified.
+type Intersections struct {
+	constraints *v1alpha5.Constraints
+	cache       map[uint64]*Intersection
+}
+
+// Intersection of the provisioner's constraints with a pod's
+type Intersection struct {
+	// Err is the reason that the pod is incompatible with the constraints, if any
+	Err error
+	// Tightened are the constraints that the pod's node must meet
+	Tightened *v1alpha5.Constraints
+	// ScheduleKey identifies the schedule of pods with isomorphic constraints
+	ScheduleKey uint64
+}
+
+func NewIntersections(constraints *v1alpha5.Constraints) *Intersections {
+	return &Intersections{
+		constraints: constraints,
+		cache:       map[uint64]*Intersection{},
+	}
+}
+
+// For returns the intersection of the constraints with the pod's
+func (i *Intersections) For(pod *v1.Pod) (*Intersection, error) {
+	key, err := podSchedulingKey(pod)
+	if err != nil {
+		return nil, fmt.Errorf("hashing pod scheduling constraints, %w", err)
+	}
+	if intersection, ok := i.cache[key]; ok {
+		return intersection, nil
+	}
+	intersection := &Intersection{}
+	if err := i.constraints.ValidatePod(pod); err != nil {
+		intersection.Err = err
+		i.cache[key] = intersection
+		return intersection, nil
+	}
+	intersection.Tightened = i.constraints.Tighten(pod)
+	// schedulingConstraints applies the provisioner constraints
+	// and any inferred constraints such as GPU resource requests from the pods
+	// and is then hashed to compute the schedules
+	schedulingConstraints := struct {
+		*v1alpha5.Constraints
+		GPURequests v1.ResourceList
+	}{
+		Constraints: intersection.Tightened,
+		GPURequests: resources.GPULimitsFor(pod),
+	}
+	if intersection.ScheduleKey, err = hashstructure.Hash(schedulingConstraints, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true}); err != nil {
+		return nil, fmt.Errorf("hashing constraints, %w", err)
+	}
+	i.cache[key] = intersection
+	return intersection, nil
+}
+
+// podSchedulingKey hashes the fields of the pod that its requirements,
+// tolerations, and inferred constraints are derived from. Slices are ordered,
+// since the first node selector term of a pod's affinity is preferred.
+func podSchedulingKey(pod *v1.Pod) (uint64, error) {
+	gpuRequests := map[v1.ResourceName]string{}
+	for resourceName, quantity := range resources.GPULimitsFor(pod) {
+		gpuRequests[resourceName] = quantity.String()
+	}
+	var nodeAffinity *v1.NodeAffinity
+	if pod.Spec.Affinity != nil {
+		nodeAffinity = pod.Spec.Affinity.NodeAffinity
+	}
+	return hashstructure.Hash(struct {
+		NodeSelector map[string]string
+		NodeAffinity *v1.NodeAffinity
+		Tolerations  []v1.Toleration
+		GPURequests  map[v1.ResourceName]string
+	}{
+		NodeSelector: pod.Spec.NodeSelector,
+		NodeAffinity: nodeAffinity,
+		Tolerations:  pod.Spec.Tolerations,
+		GPURequests:  gpuRequests,
+	}, hashstructure.FormatV2, nil)
+}
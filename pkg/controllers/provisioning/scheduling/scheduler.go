@@ -18,7 +18,6 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/mitchellh/hashstructure/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/logging"
@@ -26,9 +25,9 @@ import (
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/metrics"
 	"github.com/aws/karpenter/pkg/utils/injection"
-	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
 var schedulingDuration = prometheus.NewHistogramVec(
@@ -47,20 +46,28 @@ func init() {
 }
 
 type Scheduler struct {
-	KubeClient client.Client
-	Topology   *Topology
+	KubeClient   client.Client
+	Topology     *Topology
+	Stickiness   *Stickiness
+	VolumeLimits *VolumeLimits
+	recorder     events.Recorder
 }
 
 type Schedule struct {
 	*v1alpha5.Constraints
 	// Pods is a set of pods that may schedule to the node; used for binpacking.
 	Pods []*v1.Pod
+	// Volumes are the attachable volumes of the pods and the limits of instance types; used for binpacking.
+	Volumes *Volumes
 }
 
-func NewScheduler(kubeClient client.Client) *Scheduler {
+func NewScheduler(kubeClient client.Client, recorder events.Recorder) *Scheduler {
 	return &Scheduler{
-		KubeClient: kubeClient,
-		Topology:   &Topology{kubeClient: kubeClient},
+		KubeClient:   kubeClient,
+		Topology:     &Topology{kubeClient: kubeClient},
+		Stickiness:   NewStickiness(),
+		VolumeLimits: NewVolumeLimits(kubeClient),
+		recorder:     recorder,
 	}
 }
 
@@ -74,48 +81,53 @@ func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha5.Provisioner
 	if err := s.Topology.Inject(ctx, constraints, pods); err != nil {
 		return nil, fmt.Errorf("injecting topology, %w", err)
 	}
+	// Pods are intersected with the constraints once they're final, per distinct set of scheduling constraints
+	intersections := NewIntersections(constraints)
+	// Prefer the zone and instance type of previously launched replicas, unless
+	// the provisioner diversifies them, which stickiness would defeat
+	if injection.GetOptions(ctx).WorkloadStickiness && v1alpha5.InstanceSelectionStrategyOrDefault(constraints.InstanceSelectionStrategy) != v1alpha5.InstanceSelectionStrategyDiversified {
+		if err := s.Stickiness.Inject(intersections, pods); err != nil {
+			return nil, fmt.Errorf("injecting stickiness, %w", err)
+		}
+	}
+	// Count the volumes that pods attach, so nodes aren't packed beyond their attachment limits
+	volumes, err := s.VolumeLimits.Get(ctx, pods)
+	if err != nil {
+		return nil, fmt.Errorf("getting volume limits, %w", err)
+	}
 	// Separate pods into schedules of isomorphic scheduling constraints.
-	schedules, err := s.getSchedules(ctx, constraints, pods)
+	schedules, err := s.getSchedules(ctx, provisioner, intersections, pods)
 	if err != nil {
 		return nil, fmt.Errorf("getting schedules, %w", err)
 	}
+	for _, schedule := range schedules {
+		schedule.Volumes = volumes
+	}
 	return schedules, nil
 }
 
 // getSchedules separates pods into a set of schedules. All pods in each group
 // contain isomorphic scheduling constraints and can be deployed together on the
 // same node, or multiple similar nodes if the pods exceed one node's capacity.
-func (s *Scheduler) getSchedules(ctx context.Context, constraints *v1alpha5.Constraints, pods []*v1.Pod) ([]*Schedule, error) {
+func (s *Scheduler) getSchedules(ctx context.Context, provisioner *v1alpha5.Provisioner, intersections *Intersections, pods []*v1.Pod) ([]*Schedule, error) {
 	// schedule uniqueness is tracked by hash(Constraints)
 	schedules := map[uint64]*Schedule{}
 	for _, pod := range pods {
-		if err := constraints.ValidatePod(pod); err != nil {
-			logging.FromContext(ctx).Infof("Unable to schedule pod %s/%s, %s", pod.Namespace, pod.Name, err)
-			continue
-		}
-		tightened := constraints.Tighten(pod)
-
-		// schedulingConstraints applies the provisioner constraints
-		// and any inferred constraints such as GPU resource requests from the pods
-		// and is then hashed to compute the schedules
-		schedulingConstraints := struct {
-			*v1alpha5.Constraints
-			GPURequests v1.ResourceList
-		}{
-			Constraints: tightened,
-			GPURequests: resources.GPULimitsFor(pod),
-		}
-
-		key, err := hashstructure.Hash(schedulingConstraints, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+		intersection, err := intersections.For(pod)
 		if err != nil {
-			return nil, fmt.Errorf("hashing constraints, %w", err)
+			return nil, err
+		}
+		if intersection.Err != nil {
+			logging.FromContext(ctx).Infof("Unable to schedule pod %s/%s, %s", pod.Namespace, pod.Name, intersection.Err)
+			s.recorder.PodIncompatible(pod, provisioner, intersection.Err)
+			continue
 		}
 		// Create new schedule if one doesn't exist
-		if _, ok := schedules[key]; !ok {
-			schedules[key] = &Schedule{Constraints: tightened, Pods: []*v1.Pod{}}
+		if _, ok := schedules[intersection.ScheduleKey]; !ok {
+			schedules[intersection.ScheduleKey] = &Schedule{Constraints: intersection.Tightened, Pods: []*v1.Pod{}}
 		}
 		// Append pod to schedule, guaranteed to exist
-		schedules[key].Pods = append(schedules[key].Pods, pod)
+		schedules[intersection.ScheduleKey].Pods = append(schedules[intersection.ScheduleKey].Pods, pod)
 	}
 
 	result := []*Schedule{}
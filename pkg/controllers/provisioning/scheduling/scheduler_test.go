@@ -0,0 +1,101 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/test"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func BenchmarkScheduler(b *testing.B) {
+	// Pods of 10 workloads, each with their own node selector
+	options := []test.PodOptions{}
+	for i := 0; i < 10; i++ {
+		options = append(options, test.PodOptions{
+			NodeSelector:         map[string]string{v1.LabelTopologyZone: fmt.Sprintf("test-zone-%d", i%3+1)},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		})
+	}
+	benchmarkScheduler(b, test.Pods(5_000, options...))
+}
+
+func BenchmarkSchedulerTopology(b *testing.B) {
+	labels := map[string]string{"app": "benchmark"}
+	benchmarkScheduler(b, test.Pods(5_000, test.PodOptions{
+		ObjectMeta:           metav1.ObjectMeta{Labels: labels},
+		ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+			TopologyKey:       v1.LabelTopologyZone,
+			WhenUnsatisfiable: v1.DoNotSchedule,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+			MaxSkew:           1,
+		}, {
+			TopologyKey:       v1.LabelHostname,
+			WhenUnsatisfiable: v1.DoNotSchedule,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+			MaxSkew:           1,
+		}},
+	}))
+}
+
+func benchmarkScheduler(b *testing.B, pods []*v1.Pod) {
+	// Setup Mocks
+	ctx := context.Background()
+	instanceTypes := fake.InstanceTypes(400)
+	instanceTypeNames := []string{}
+	for _, it := range instanceTypes {
+		instanceTypeNames = append(instanceTypeNames, it.Name())
+	}
+	kubeClient := testclient.NewClientBuilder().Build()
+	scheduler := scheduling.NewScheduler(kubeClient, events.NewRecorder(test.NewEventRecorder()))
+	provisioner := &v1alpha5.Provisioner{
+		ObjectMeta: metav1.ObjectMeta{Name: "benchmark"},
+		Spec: v1alpha5.ProvisionerSpec{Constraints: v1alpha5.Constraints{
+			Requirements: v1alpha5.NewRequirements([]v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}},
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: instanceTypeNames},
+				{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"spot", "on-demand"}},
+				{Key: v1.LabelOSStable, Operator: v1.NodeSelectorOpIn, Values: []string{"linux"}},
+			}...),
+		}},
+	}
+
+	// Solve benchmark
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Solve injects node selectors into the pods, so each iteration starts from a copy
+		b.StopTimer()
+		copies := []*v1.Pod{}
+		for _, pod := range pods {
+			copies = append(copies, pod.DeepCopy())
+		}
+		b.StartTimer()
+		if schedules, err := scheduler.Solve(ctx, provisioner, copies); err != nil || len(schedules) == 0 {
+			b.FailNow()
+		}
+	}
+}
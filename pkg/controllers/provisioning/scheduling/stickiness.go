@@ -0,0 +1,146 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	StickinessTTL             = 10 * time.Minute
+	StickinessCleanupInterval = 1 * time.Minute
+)
+
+// stickyLabels are the node labels remembered for each workload
+var stickyLabels = []string{v1.LabelTopologyZone, v1.LabelInstanceTypeStable}
+
+// Stickiness remembers the zone and instance type that replicas of a workload
+// were most recently launched to, so that replicas in subsequent batches can
+// be steered to the same pattern. This keeps performance characteristics
+// consistent for latency-sensitive workloads. Decisions are forgotten after
+// StickinessTTL so that workloads are eventually free to move.
+type Stickiness struct {
+	cache *cache.Cache
+	// steered are the UIDs of the pods that were steered to a previous
+	// decision, which are scheduled without it if they come back unscheduled
+	steered *cache.Cache
+}
+
+func NewStickiness() *Stickiness {
+	return &Stickiness{
+		cache:   cache.New(StickinessTTL, StickinessCleanupInterval),
+		steered: cache.New(StickinessTTL, StickinessCleanupInterval),
+	}
+}
+
+// Record remembers the node's zone and instance type for the workloads of the pods bound to it.
+func (s *Stickiness) Record(node *v1.Node, pods []*v1.Pod) {
+	labels := map[string]string{}
+	for _, key := range stickyLabels {
+		if value, ok := node.Labels[key]; ok && value != "" {
+			labels[key] = value
+		}
+	}
+	if len(labels) == 0 {
+		return
+	}
+	for _, pod := range pods {
+		if key, ok := workloadKey(pod); ok {
+			s.cache.SetDefault(key, labels)
+		}
+	}
+}
+
+// Inject adds a preferred node affinity term for the zone and instance type
+// previously chosen for the pod's workload, which the scheduling logic treats
+// as a requirement. Pods with topology spread constraints are skipped, since
+// the spread must take precedence, as are pods with their own preferred node
+// affinity, since their preferences take precedence. Labels that the
+// provisioner or the pod do not allow are ignored. If a steered pod comes back
+// unscheduled, e.g. because the instance type can't fit it or has no capacity
+// in the zone, it's scheduled without the preference, so stickiness never
+// makes a pod unschedulable.
+func (s *Stickiness) Inject(intersections *Intersections, pods []*v1.Pod) error {
+	for _, pod := range pods {
+		if len(pod.Spec.TopologySpreadConstraints) > 0 {
+			continue
+		}
+		if pod.Spec.Affinity != nil && pod.Spec.Affinity.NodeAffinity != nil && len(pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) > 0 {
+			continue
+		}
+		if _, ok := s.steered.Get(string(pod.UID)); ok {
+			continue
+		}
+		key, ok := workloadKey(pod)
+		if !ok {
+			continue
+		}
+		cached, ok := s.cache.Get(key)
+		if !ok {
+			continue
+		}
+		intersection, err := intersections.For(pod)
+		if err != nil {
+			return err
+		}
+		// Incompatible pods aren't scheduled regardless
+		if intersection.Err != nil {
+			continue
+		}
+		requirements := []v1.NodeSelectorRequirement{}
+		for label, value := range cached.(map[string]string) {
+			if _, ok := pod.Spec.NodeSelector[label]; ok {
+				continue
+			}
+			if intersection.Tightened.Requirements.Get(label).Has(value) {
+				requirements = append(requirements, v1.NodeSelectorRequirement{Key: label, Operator: v1.NodeSelectorOpIn, Values: []string{value}})
+			}
+		}
+		if len(requirements) == 0 {
+			continue
+		}
+		sort.Slice(requirements, func(i, j int) bool { return requirements[i].Key < requirements[j].Key })
+		// The affinity may be shared with the cached specs of relaxed pods
+		affinity := &v1.Affinity{}
+		if pod.Spec.Affinity != nil {
+			affinity = pod.Spec.Affinity.DeepCopy()
+		}
+		if affinity.NodeAffinity == nil {
+			affinity.NodeAffinity = &v1.NodeAffinity{}
+		}
+		affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []v1.PreferredSchedulingTerm{{
+			Weight:     1,
+			Preference: v1.NodeSelectorTerm{MatchExpressions: requirements},
+		}}
+		pod.Spec.Affinity = affinity
+		s.steered.SetDefault(string(pod.UID), true)
+	}
+	return nil
+}
+
+// workloadKey identifies the pod's workload by its controller reference
+func workloadKey(pod *v1.Pod) (string, bool) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s/%s", pod.Namespace, owner.Kind, owner.Name), true
+}
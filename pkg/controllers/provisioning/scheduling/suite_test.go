@@ -23,16 +23,26 @@ import (
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	. "github.com/aws/karpenter/pkg/test/expectations"
@@ -42,6 +52,7 @@ import (
 )
 
 var ctx context.Context
+var cloudProvider *fake.CloudProvider
 var provisioner *v1alpha5.Provisioner
 var provisioners *provisioning.Controller
 var selectionController *selection.Controller
@@ -55,10 +66,10 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		cloudProvider := &fake.CloudProvider{}
+		cloudProvider = &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioners)
+		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, events.NewRecorder(test.NewEventRecorder()))
+		selectionController = selection.NewController(e.Client, provisioners, events.NewRecorder(test.NewEventRecorder()))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -68,6 +79,7 @@ var _ = AfterSuite(func() {
 })
 
 var _ = BeforeEach(func() {
+	cloudProvider.InstanceTypes = nil
 	provisioner = &v1alpha5.Provisioner{
 		ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
 		Spec:       v1alpha5.ProvisionerSpec{},
@@ -653,6 +665,27 @@ var _ = Describe("Topology", func() {
 			)
 			ExpectSkew(ctx, env.Client, "default", &topology[0]).To(ConsistOf(4))
 		})
+		It("should schedule multiple deployments with hostname topology spread", func() {
+			spreadPod := func(appName string) test.PodOptions {
+				return test.PodOptions{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": appName}},
+					TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+						MaxSkew:           1,
+						TopologyKey:       v1.LabelHostname,
+						WhenUnsatisfiable: v1.DoNotSchedule,
+						LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": appName}},
+					}},
+				}
+			}
+			pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+				test.UnschedulablePod(spreadPod("app1")), test.UnschedulablePod(spreadPod("app1")),
+				test.UnschedulablePod(spreadPod("app2")), test.UnschedulablePod(spreadPod("app2")))
+			nodeNames := sets.NewString()
+			for _, pod := range pods {
+				nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+			}
+			Expect(nodeNames).To(HaveLen(4))
+		})
 		It("balance multiple deployments with hostname topology spread", func() {
 			Skip("enable after scheduler doesn't fail when scheduling disparate workloads")
 			// Issue #1425
@@ -1437,3 +1470,204 @@ func ExpectSkew(ctx context.Context, c client.Client, namespace string, constrai
 	}
 	return Expect(skew)
 }
+
+var _ = Describe("Workload Stickiness", func() {
+	var stickyCtx context.Context
+	var owner []metav1.OwnerReference
+	BeforeEach(func() {
+		stickyCtx = injection.WithOptions(ctx, options.Options{WorkloadStickiness: true})
+		owner = []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "ReplicaSet",
+			Name:       strings.ToLower(randomdata.SillyName()),
+			UID:        types.UID(randomdata.Alphanumeric(10)),
+			Controller: ptr.Bool(true),
+		}}
+	})
+	It("should schedule replicas to the zone and instance type of previous replicas", func() {
+		pod := ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{OwnerReferences: owner},
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2", v1.LabelInstanceTypeStable: "default-instance-type"},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		pod = ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{OwnerReferences: owner},
+		}))[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "default-instance-type"))
+	})
+	It("should schedule replicas without stickiness if they can't be scheduled with it", func() {
+		pod := ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{OwnerReferences: owner},
+			NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "default-instance-type"},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		// The previous replica's instance type has no GPUs
+		pod = ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{OwnerReferences: owner},
+			ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(pod.Spec.Affinity).To(BeNil())
+
+		_, err := selectionController.Reconcile(stickyCtx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).ToNot(HaveOccurred())
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "nvidia-gpu-instance-type"))
+	})
+	It("should not apply stickiness to pods that prefer their own node affinity", func() {
+		pod := ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{OwnerReferences: owner},
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		pod = ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{OwnerReferences: owner},
+			NodePreferences: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}},
+			},
+		}))[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
+	})
+	It("should not apply stickiness to pods with topology spread constraints", func() {
+		pod := ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{OwnerReferences: owner},
+			NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "default-instance-type"},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		pod = ExpectProvisioned(stickyCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{OwnerReferences: owner},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				TopologyKey:       v1.LabelHostname,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				MaxSkew:           1,
+			}},
+		}))[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small-instance-type"))
+	})
+	It("should ignore previous replicas when disabled", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{OwnerReferences: owner},
+			NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "default-instance-type"},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{OwnerReferences: owner},
+		}))[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small-instance-type"))
+	})
+})
+
+var _ = Describe("Volume Limits", func() {
+	var storageClass *storagev1.StorageClass
+	BeforeEach(func() {
+		storageClass = test.StorageClass()
+		node := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1.LabelInstanceTypeStable: "small-instance-type"}}})
+		ExpectCreated(ctx, env.Client, storageClass, node)
+		ExpectCreated(ctx, env.Client, &storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{Name: node.Name},
+			Spec: storagev1.CSINodeSpec{Drivers: []storagev1.CSINodeDriver{
+				{Name: storageClass.Provisioner, NodeID: node.Name, Allocatable: &storagev1.VolumeNodeResources{Count: ptr.Int32(1)}},
+				{Name: "test-driver", NodeID: node.Name, Allocatable: &storagev1.VolumeNodeResources{Count: ptr.Int32(1)}},
+			}},
+		})
+	})
+	It("should not pack more volumes onto a node than its instance type can attach", func() {
+		pods := []*v1.Pod{}
+		for i := 0; i < 2; i++ {
+			pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &storageClass.Name})
+			ExpectCreated(ctx, env.Client, pvc)
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				NodeSelector:           map[string]string{v1.LabelInstanceTypeStable: "small-instance-type"},
+				PersistentVolumeClaims: []string{pvc.Name},
+			}))
+		}
+		nodes := sets.NewString()
+		for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pods...) {
+			nodes.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodes.Len()).To(Equal(2))
+	})
+	It("should count bound volumes against the limits of their driver", func() {
+		pods := []*v1.Pod{}
+		for i := 0; i < 2; i++ {
+			pv := test.PersistentVolume()
+			pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: pv.Name})
+			ExpectCreated(ctx, env.Client, pv, pvc)
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				NodeSelector:           map[string]string{v1.LabelInstanceTypeStable: "small-instance-type"},
+				PersistentVolumeClaims: []string{pvc.Name},
+			}))
+		}
+		nodes := sets.NewString()
+		for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pods...) {
+			nodes.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodes.Len()).To(Equal(2))
+	})
+	It("should not limit volumes of instance types without observed limits", func() {
+		pods := []*v1.Pod{}
+		for i := 0; i < 2; i++ {
+			pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &storageClass.Name})
+			ExpectCreated(ctx, env.Client, pvc)
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				NodeSelector:           map[string]string{v1.LabelInstanceTypeStable: "default-instance-type"},
+				PersistentVolumeClaims: []string{pvc.Name},
+			}))
+		}
+		nodes := sets.NewString()
+		for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pods...) {
+			nodes.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodes.Len()).To(Equal(1))
+	})
+	It("should limit volumes of instance types whose limits are known before their nodes register", func() {
+		cloudProvider.InstanceTypes = []cloudprovider.InstanceType{fake.NewInstanceType(fake.InstanceTypeOptions{
+			Name:      "ebs-instance-type",
+			Resources: v1.ResourceList{"attachable-volumes-aws-ebs": resource.MustParse("1")},
+		})}
+		ebsStorageClass := test.StorageClass(test.StorageClassOptions{Provisioner: "kubernetes.io/aws-ebs"})
+		ExpectCreated(ctx, env.Client, ebsStorageClass)
+		pods := []*v1.Pod{}
+		for i := 0; i < 2; i++ {
+			pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &ebsStorageClass.Name})
+			ExpectCreated(ctx, env.Client, pvc)
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{pvc.Name}}))
+		}
+		nodes := sets.NewString()
+		for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pods...) {
+			nodes.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodes.Len()).To(Equal(2))
+	})
+	It("should count volumes that are shared by pods once", func() {
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &storageClass.Name})
+		ExpectCreated(ctx, env.Client, pvc)
+		pods := []*v1.Pod{}
+		for i := 0; i < 2; i++ {
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				NodeSelector:           map[string]string{v1.LabelInstanceTypeStable: "small-instance-type"},
+				PersistentVolumeClaims: []string{pvc.Name},
+			}))
+		}
+		nodes := sets.NewString()
+		for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pods...) {
+			nodes.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodes.Len()).To(Equal(1))
+	})
+	It("should ignore volumes whose claims don't exist", func() {
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &storageClass.Name})
+		ExpectCreated(ctx, env.Client, pvc)
+		missing := test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{"missing-claim"}})
+		pod := test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: []string{pvc.Name}})
+		volumes, err := scheduling.NewVolumeLimits(env.Client).Get(ctx, []*v1.Pod{missing, pod})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(volumes.For(missing)).To(BeEmpty())
+		Expect(volumes.For(pod)).To(HaveLen(1))
+	})
+})
@@ -46,10 +46,10 @@ func (t *Topology) Inject(ctx context.Context, constraints *v1alpha5.Constraints
 		if err := t.computeCurrentTopology(ctx, constraints, topologyGroup); err != nil {
 			return fmt.Errorf("computing topology, %w", err)
 		}
+		// Domains must be allowed by both the provisioner's and the pod's requirements for the topology key
+		viable := constraints.Requirements.Get(topologyGroup.Constraint.TopologyKey)
 		for _, pod := range topologyGroup.Pods {
-			domain := topologyGroup.NextDomain(constraints.Requirements.Add(v1alpha5.NewPodRequirements(pod).Requirements...).
-				Get(topologyGroup.Constraint.TopologyKey).
-				Values())
+			domain := topologyGroup.NextDomain(viable, v1alpha5.NewPodRequirements(pod).Get(topologyGroup.Constraint.TopologyKey))
 			pod.Spec.NodeSelector = functional.UnionStringMaps(pod.Spec.NodeSelector, map[string]string{topologyGroup.Constraint.TopologyKey: domain})
 		}
 	}
@@ -101,8 +101,12 @@ func (t *Topology) computeHostnameTopology(topologyGroup *TopologyGroup, constra
 		domains = append(domains, strings.ToLower(randomdata.Alphanumeric(8)))
 	}
 	topologyGroup.Register(domains...)
-	// This is a bit of a hack that allows the constraints to recognize viable hostname topologies
-	constraints.Requirements = constraints.Requirements.Add(v1.NodeSelectorRequirement{Key: topologyGroup.Constraint.TopologyKey, Operator: v1.NodeSelectorOpIn, Values: domains})
+	// This is a bit of a hack that allows the constraints to recognize viable hostname topologies. Any hostname
+	// is allowed rather than the generated domains, which would make the requirements of every pod as large as
+	// the batch, and would leave no viable hostname for a second topology group.
+	if !constraints.Requirements.Keys().Has(topologyGroup.Constraint.TopologyKey) {
+		constraints.Requirements = constraints.Requirements.Add(v1.NodeSelectorRequirement{Key: topologyGroup.Constraint.TopologyKey, Operator: v1.NodeSelectorOpNotIn})
+	}
 	return nil
 }
 
@@ -124,19 +128,27 @@ func (t *Topology) countMatchingPods(ctx context.Context, topologyGroup *Topolog
 	if err := t.kubeClient.List(ctx, pods, TopologyListOptions(topologyGroup.Pods[0].Namespace, &topologyGroup.Constraint)); err != nil {
 		return fmt.Errorf("listing pods, %w", err)
 	}
+	// Look up each node's domain once, since many pods may be bound to the same node
+	domains := map[string]*string{}
 	for i, p := range pods.Items {
 		if IgnoredForTopology(&pods.Items[i]) {
 			continue
 		}
-		node := &v1.Node{}
-		if err := t.kubeClient.Get(ctx, types.NamespacedName{Name: p.Spec.NodeName}, node); err != nil {
-			return fmt.Errorf("getting node %s, %w", p.Spec.NodeName, err)
-		}
-		domain, ok := node.Labels[topologyGroup.Constraint.TopologyKey]
+		domain, ok := domains[p.Spec.NodeName]
 		if !ok {
+			node := &v1.Node{}
+			if err := t.kubeClient.Get(ctx, types.NamespacedName{Name: p.Spec.NodeName}, node); err != nil {
+				return fmt.Errorf("getting node %s, %w", p.Spec.NodeName, err)
+			}
+			if value, ok := node.Labels[topologyGroup.Constraint.TopologyKey]; ok {
+				domain = &value
+			}
+			domains[p.Spec.NodeName] = domain
+		}
+		if domain == nil {
 			continue // Don't include pods if node doesn't contain domain https://kubernetes.io/docs/concepts/workloads/pods/pod-topology-spread-constraints/#conventions
 		}
-		topologyGroup.Increment(domain)
+		topologyGroup.Increment(*domain)
 	}
 	return nil
 }
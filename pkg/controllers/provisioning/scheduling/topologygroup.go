@@ -15,17 +15,18 @@ limitations under the License.
 package scheduling
 
 import (
-	"math"
+	"container/heap"
 
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter/pkg/utils/sets"
 )
 
 func NewTopologyGroup(pod *v1.Pod, constraint v1.TopologySpreadConstraint) *TopologyGroup {
 	return &TopologyGroup{
 		Constraint: constraint,
 		Pods:       []*v1.Pod{pod},
-		spread:     map[string]int{},
+		spread:     map[string]*domainCount{},
 	}
 }
 
@@ -33,36 +34,105 @@ func NewTopologyGroup(pod *v1.Pod, constraint v1.TopologySpreadConstraint) *Topo
 type TopologyGroup struct {
 	Constraint v1.TopologySpreadConstraint
 	Pods       []*v1.Pod
-	// spread is an internal field used to track current spread
-	spread map[string]int // TODO(ellistarn) explore replacing with a minheap
+	// spread is an internal field used to track current spread, indexed by
+	// domain and ordered by count so that the next domain is found without
+	// scanning every domain, e.g. one per node for hostname topologies
+	spread  map[string]*domainCount
+	byCount domainHeap
 }
 
 func (t *TopologyGroup) Register(domains ...string) {
 	for _, domain := range domains {
-		t.spread[domain] = 0
+		if count, ok := t.spread[domain]; ok {
+			count.count = 0
+			heap.Fix(&t.byCount, count.index)
+			continue
+		}
+		count := &domainCount{domain: domain}
+		t.spread[domain] = count
+		heap.Push(&t.byCount, count)
 	}
 }
 
 // Increment increments the spread of a registered domain
 func (t *TopologyGroup) Increment(domain string) {
-	if _, ok := t.spread[domain]; ok {
-		t.spread[domain]++
+	if count, ok := t.spread[domain]; ok {
+		count.count++
+		heap.Fix(&t.byCount, count.index)
 	}
 }
 
-// NextDomain chooses a domain within the constraints that minimizes skew
-func (t *TopologyGroup) NextDomain(requirement sets.String) string {
-	minDomain := ""
-	minCount := math.MaxInt32
-	for domain, count := range t.spread {
-		if !requirement.Has(domain) {
-			continue
+// NextDomain chooses a domain within the requirements that minimizes skew. The
+// domain must be in all of the requirements, which avoids intersecting them for
+// every pod. It returns an empty string if no registered domain is viable.
+func (t *TopologyGroup) NextDomain(requirements ...sets.Set) string {
+	// Domains are popped in order of count until one is viable, and the rest
+	// are restored; typically the first domain is viable
+	skipped := []*domainCount{}
+	defer func() {
+		for _, count := range skipped {
+			heap.Push(&t.byCount, count)
+		}
+	}()
+	for t.byCount.Len() > 0 {
+		count := heap.Pop(&t.byCount).(*domainCount)
+		if viable(count.domain, requirements) {
+			count.count++
+			heap.Push(&t.byCount, count)
+			return count.domain
 		}
-		if count <= minCount {
-			minDomain = domain
-			minCount = count
+		skipped = append(skipped, count)
+	}
+	return ""
+}
+
+// viable returns true if the domain is in all of the requirements
+func viable(domain string, requirements []sets.Set) bool {
+	for _, requirement := range requirements {
+		if !requirement.Has(domain) {
+			return false
 		}
 	}
-	t.spread[minDomain]++
-	return minDomain
+	return true
+}
+
+// domainCount is the number of pods in a topology domain
+type domainCount struct {
+	domain string
+	count  int
+	// index of the domain in the heap, maintained by the heap
+	index int
+}
+
+// domainHeap is a min heap of domains by count, see container/heap. Ties are
+// broken by domain so that the next domain is deterministic.
+type domainHeap []*domainCount
+
+func (h domainHeap) Len() int { return len(h) }
+
+func (h domainHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].domain < h[j].domain
+}
+
+func (h domainHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *domainHeap) Push(x interface{}) {
+	count := x.(*domainCount)
+	count.index = len(*h)
+	*h = append(*h, count)
+}
+
+func (h *domainHeap) Pop() interface{} {
+	old := *h
+	count := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return count
 }
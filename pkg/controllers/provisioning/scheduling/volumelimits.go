@@ -0,0 +1,283 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+const (
+	// attachableVolumesPrefix prefixes the resource names that the kubelet
+	// reports attachable volume limits with
+	attachableVolumesPrefix = "attachable-volumes-"
+	// inTreeEBSProvisioner is the in-tree plugin for EBS volumes, which is
+	// migrated to the EBS CSI driver and counts against its limits
+	inTreeEBSProvisioner = "kubernetes.io/aws-ebs"
+	// inTreeEBSLimitKey is the resource name that the kubelet reports the
+	// in-tree plugin's attachable volume limit with
+	inTreeEBSLimitKey = attachableVolumesPrefix + "aws-ebs"
+	ebsCSIDriver      = "ebs.csi.aws.com"
+)
+
+// VolumeLimits counts the volumes that pods attach to nodes, and gets the
+// number of volumes that nodes of each instance type can attach. Limits that
+// the cloud provider knows are lowered by those that existing nodes report.
+type VolumeLimits struct {
+	kubeClient client.Client
+}
+
+func NewVolumeLimits(kubeClient client.Client) *VolumeLimits {
+	return &VolumeLimits{kubeClient: kubeClient}
+}
+
+// Volumes are the attachable volumes of pods and the attachable volume limits
+// of instance types, keyed by the resource names that the kubelet reports
+// attachable volume limits with, e.g. attachable-volumes-csi-ebs.csi.aws.com.
+type Volumes struct {
+	pods   map[*v1.Pod]map[string]v1.ResourceName
+	limits map[string]v1.ResourceList
+}
+
+// For returns the attachable volumes of the pod by the resource name of their
+// driver's limit, keyed by an ID that's shared by pods that use the same volume
+func (v *Volumes) For(pod *v1.Pod) map[string]v1.ResourceName {
+	if v == nil {
+		return nil
+	}
+	return v.pods[pod]
+}
+
+// LimitsFor returns the attachable volume limits of the instance type, which
+// are the smaller of those the cloud provider knows and those nodes reported
+func (v *Volumes) LimitsFor(instanceType cloudprovider.InstanceType) v1.ResourceList {
+	if v == nil || len(v.pods) == 0 {
+		return nil
+	}
+	limits := v1.ResourceList{}
+	for resourceName, quantity := range knownLimitsFor(instanceType) {
+		limits[resourceName] = quantity
+	}
+	for resourceName, quantity := range v.limits[instanceType.Name()] {
+		if current, ok := limits[resourceName]; ok && current.Cmp(quantity) <= 0 {
+			continue
+		}
+		limits[resourceName] = quantity
+	}
+	return limits
+}
+
+// knownLimitsFor returns the attachable volume limits that the cloud provider
+// knows for the instance type before any of its nodes register
+func knownLimitsFor(instanceType cloudprovider.InstanceType) v1.ResourceList {
+	limits := v1.ResourceList{}
+	add := func(resources v1.ResourceList) {
+		for resourceName, quantity := range resources {
+			if strings.HasPrefix(string(resourceName), attachableVolumesPrefix) {
+				limits[normalize(resourceName)] = quantity
+			}
+		}
+	}
+	add(instanceType.Resources())
+	if limited, ok := instanceType.(cloudprovider.VolumeLimitedInstanceType); ok {
+		add(limited.VolumeLimits())
+	}
+	return limits
+}
+
+// Get counts the attachable volumes of the pods and the limits of instance
+// types. Volumes whose driver can't be resolved, e.g. because their claim
+// doesn't exist yet, aren't counted rather than failing the whole batch.
+func (v *VolumeLimits) Get(ctx context.Context, pods []*v1.Pod) (*Volumes, error) {
+	volumes := &Volumes{pods: map[*v1.Pod]map[string]v1.ResourceName{}}
+	for _, pod := range pods {
+		if requests := v.volumesFor(ctx, pod); len(requests) > 0 {
+			volumes.pods[pod] = requests
+		}
+	}
+	// Limits only matter if pods attach volumes
+	if len(volumes.pods) == 0 {
+		return volumes, nil
+	}
+	limits, err := v.getLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volumes.limits = limits
+	return volumes, nil
+}
+
+// volumesFor returns the attachable volumes of the pod by the resource name of
+// the limit of the driver that attaches them
+func (v *VolumeLimits) volumesFor(ctx context.Context, pod *v1.Pod) map[string]v1.ResourceName {
+	volumes := map[string]v1.ResourceName{}
+	for _, volume := range pod.Spec.Volumes {
+		driver, err := v.getDriver(ctx, pod, volume)
+		if err != nil {
+			logging.FromContext(ctx).Debugf("Ignoring volume %s of pod %s/%s for attachable volume limits, %s", volume.Name, pod.Namespace, pod.Name, err)
+			continue
+		}
+		if driver != "" {
+			volumes[volumeID(pod, volume)] = attachLimitKey(driver)
+		}
+	}
+	return volumes
+}
+
+// volumeID identifies the volume across pods, so that a claim that's used by
+// several pods on the same node is only attached once
+func volumeID(pod *v1.Pod, volume v1.Volume) string {
+	switch {
+	case volume.AWSElasticBlockStore != nil:
+		return volume.AWSElasticBlockStore.VolumeID
+	case volume.PersistentVolumeClaim != nil:
+		return pod.Namespace + "/" + volume.PersistentVolumeClaim.ClaimName
+	}
+	// Ephemeral volumes are claimed by a single pod, named after the pod and volume
+	return pod.Namespace + "/" + pod.Name + "-" + volume.Name
+}
+
+// getDriver returns the driver that attaches the volume, or "" if the volume isn't attached
+func (v *VolumeLimits) getDriver(ctx context.Context, pod *v1.Pod, volume v1.Volume) (string, error) {
+	switch {
+	case volume.AWSElasticBlockStore != nil:
+		return ebsCSIDriver, nil
+	case volume.Ephemeral != nil && volume.Ephemeral.VolumeClaimTemplate != nil:
+		return v.getStorageClassDriver(ctx, ptr.StringValue(volume.Ephemeral.VolumeClaimTemplate.Spec.StorageClassName))
+	case volume.PersistentVolumeClaim != nil:
+		pvc := &v1.PersistentVolumeClaim{}
+		if err := v.kubeClient.Get(ctx, types.NamespacedName{Name: volume.PersistentVolumeClaim.ClaimName, Namespace: pod.Namespace}, pvc); err != nil {
+			return "", fmt.Errorf("getting persistent volume claim %s, %w", volume.PersistentVolumeClaim.ClaimName, err)
+		}
+		if pvc.Spec.VolumeName != "" {
+			return v.getPersistentVolumeDriver(ctx, pvc.Spec.VolumeName)
+		}
+		return v.getStorageClassDriver(ctx, ptr.StringValue(pvc.Spec.StorageClassName))
+	}
+	return "", nil
+}
+
+func (v *VolumeLimits) getPersistentVolumeDriver(ctx context.Context, name string) (string, error) {
+	pv := &v1.PersistentVolume{}
+	if err := v.kubeClient.Get(ctx, types.NamespacedName{Name: name}, pv); err != nil {
+		return "", fmt.Errorf("getting persistent volume %q, %w", name, err)
+	}
+	switch {
+	case pv.Spec.CSI != nil:
+		return pv.Spec.CSI.Driver, nil
+	case pv.Spec.AWSElasticBlockStore != nil:
+		return ebsCSIDriver, nil
+	}
+	return "", nil
+}
+
+func (v *VolumeLimits) getStorageClassDriver(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	storageClass := &storagev1.StorageClass{}
+	if err := v.kubeClient.Get(ctx, types.NamespacedName{Name: name}, storageClass); err != nil {
+		return "", fmt.Errorf("getting storage class %q, %w", name, err)
+	}
+	if storageClass.Provisioner == inTreeEBSProvisioner {
+		return ebsCSIDriver, nil
+	}
+	return storageClass.Provisioner, nil
+}
+
+// getLimits returns the smallest attachable volume limits that nodes of each
+// instance type report, either in their CSINode objects or, for in-tree
+// plugins, in their allocatable resources
+func (v *VolumeLimits) getLimits(ctx context.Context) (map[string]v1.ResourceList, error) {
+	nodes := &v1.NodeList{}
+	if err := v.kubeClient.List(ctx, nodes); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	csiNodes := &storagev1.CSINodeList{}
+	if err := v.kubeClient.List(ctx, csiNodes); err != nil {
+		return nil, fmt.Errorf("listing csi nodes, %w", err)
+	}
+	instanceTypes := map[string]string{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		instanceType, ok := node.Labels[v1.LabelInstanceTypeStable]
+		if !ok {
+			continue
+		}
+		instanceTypes[node.Name] = instanceType
+	}
+	limits := map[string]v1.ResourceList{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		instanceType, ok := instanceTypes[node.Name]
+		if !ok {
+			continue
+		}
+		for resourceName, quantity := range node.Status.Allocatable {
+			if strings.HasPrefix(string(resourceName), attachableVolumesPrefix) {
+				limit(limits, instanceType, normalize(resourceName), quantity.Value())
+			}
+		}
+	}
+	for _, csiNode := range csiNodes.Items {
+		instanceType, ok := instanceTypes[csiNode.Name]
+		if !ok {
+			continue
+		}
+		for _, driver := range csiNode.Spec.Drivers {
+			if driver.Allocatable != nil && driver.Allocatable.Count != nil {
+				limit(limits, instanceType, attachLimitKey(driver.Name), int64(*driver.Allocatable.Count))
+			}
+		}
+	}
+	return limits, nil
+}
+
+// limit lowers the instance type's limit for the resource to the value
+func limit(limits map[string]v1.ResourceList, instanceType string, resourceName v1.ResourceName, value int64) {
+	if _, ok := limits[instanceType]; !ok {
+		limits[instanceType] = v1.ResourceList{}
+	}
+	if current, ok := limits[instanceType][resourceName]; ok && current.Value() <= value {
+		return
+	}
+	limits[instanceType][resourceName] = *resource.NewQuantity(value, resource.DecimalSI)
+}
+
+// attachLimitKey returns the resource name that the kubelet reports the
+// driver's attachable volume limit with
+func attachLimitKey(driver string) v1.ResourceName {
+	return v1.ResourceName(attachableVolumesPrefix + "csi-" + driver)
+}
+
+// normalize maps the in-tree EBS plugin's limit to the EBS CSI driver's, since
+// in-tree EBS volumes are migrated to and counted against the CSI driver
+func normalize(resourceName v1.ResourceName) v1.ResourceName {
+	if resourceName == inTreeEBSLimitKey {
+		return attachLimitKey(ebsCSIDriver)
+	}
+	return resourceName
+}
@@ -0,0 +1,228 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/pod"
+	"github.com/aws/karpenter/pkg/utils/resources"
+)
+
+const (
+	// SolutionsCacheTTL bounds how long a solution is reused, since it doesn't
+	// observe changes to instance type availability or daemonset overhead
+	SolutionsCacheTTL             = 1 * time.Minute
+	SolutionsCacheCleanupInterval = 5 * time.Minute
+)
+
+// solutions remembers how recent batches of pods were scheduled and packed, so
+// that a successive batch of identical pods, which is common during large
+// rollouts, reuses the solution instead of re-running the scheduler and packer.
+// Pods are identical if they have the same scheduling constraints, requests,
+// volumes and priority.
+// Batches that include pods with topology spread constraints or pod affinity
+// are never cached, since their solution depends on the pods in the cluster.
+type solutions struct {
+	cache *cache.Cache
+}
+
+func newSolutions() *solutions {
+	return &solutions{cache: cache.New(SolutionsCacheTTL, SolutionsCacheCleanupInterval)}
+}
+
+// solution is a template of node requests, where each node's pods are
+// recorded by their shape so that any identical pods can be substituted
+type solution struct {
+	nodeRequests []*nodeRequestTemplate
+}
+
+type nodeRequestTemplate struct {
+	constraints         *v1alpha5.Constraints
+	instanceTypeOptions []cloudprovider.InstanceType
+	daemonRequests      v1.ResourceList
+	shapes              [][]uint64
+}
+
+// Get returns node requests for the pods from the solution of an identical batch
+func (s *solutions) Get(key string, pods []*v1.Pod, shapes []uint64) ([]*nodeRequest, bool) {
+	cached, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	// Substitute the batch's pods for the identical pods in the solution
+	podsByShape := map[uint64][]*v1.Pod{}
+	for i, p := range pods {
+		podsByShape[shapes[i]] = append(podsByShape[shapes[i]], p)
+	}
+	nodeRequests := []*nodeRequest{}
+	for _, template := range cached.(*solution).nodeRequests {
+		nodeRequest := &nodeRequest{NodeRequest: &cloudprovider.NodeRequest{
+			Constraints:         template.constraints,
+			InstanceTypeOptions: template.instanceTypeOptions,
+			Quantity:            len(template.shapes),
+			DaemonRequests:      template.daemonRequests,
+		}}
+		for _, nodeShapes := range template.shapes {
+			nodePods := []*v1.Pod{}
+			for _, shape := range nodeShapes {
+				nodePods = append(nodePods, podsByShape[shape][0])
+				podsByShape[shape] = podsByShape[shape][1:]
+			}
+			nodeRequest.pods = append(nodeRequest.pods, nodePods)
+		}
+		nodeRequest.PodRequests = podRequests(nodeRequest.pods)
+		nodeRequests = append(nodeRequests, nodeRequest)
+	}
+	return nodeRequests, true
+}
+
+// Set caches the node requests that were computed for the pods. Solutions that
+// leave pods unscheduled aren't cached, so that the pods are solved again.
+func (s *solutions) Set(key string, pods []*v1.Pod, shapes []uint64, nodeRequests []*nodeRequest) {
+	scheduled := 0
+	for _, nodeRequest := range nodeRequests {
+		for _, nodePods := range nodeRequest.pods {
+			scheduled += len(nodePods)
+		}
+	}
+	if scheduled != len(pods) {
+		return
+	}
+	shapesByPod := map[*v1.Pod]uint64{}
+	for i, p := range pods {
+		shapesByPod[p] = shapes[i]
+	}
+	cached := &solution{}
+	for _, nodeRequest := range nodeRequests {
+		template := &nodeRequestTemplate{
+			constraints:         nodeRequest.Constraints,
+			instanceTypeOptions: nodeRequest.InstanceTypeOptions,
+			daemonRequests:      nodeRequest.DaemonRequests,
+		}
+		for _, nodePods := range nodeRequest.pods {
+			nodeShapes := []uint64{}
+			for _, p := range nodePods {
+				nodeShapes = append(nodeShapes, shapesByPod[p])
+			}
+			template.shapes = append(template.shapes, nodeShapes)
+		}
+		cached.nodeRequests = append(cached.nodeRequests, template)
+	}
+	s.cache.SetDefault(key, cached)
+}
+
+// Invalidate forgets all cached solutions, e.g. after a launch failure
+func (s *solutions) Invalidate() {
+	s.cache.Flush()
+}
+
+// solutionKey returns a key that's identical for batches of identical pods,
+// and the shape of each pod, or false if the batch can't be cached. It must
+// be computed before solving, which injects node selectors into the pods.
+func solutionKey(ctx context.Context, pods []*v1.Pod) (string, []uint64, bool) {
+	// Stickiness steers pods to decisions learned from previous launches, so
+	// solutions computed before those launches would be stale
+	if len(pods) == 0 || injection.GetOptions(ctx).WorkloadStickiness {
+		return "", nil, false
+	}
+	shapes := make([]uint64, len(pods))
+	for i, p := range pods {
+		if len(p.Spec.TopologySpreadConstraints) > 0 || (p.Spec.Affinity != nil && (pod.HasPodAffinity(p) || pod.HasPodAntiAffinity(p))) {
+			return "", nil, false
+		}
+		shape, err := podShape(p)
+		if err != nil {
+			return "", nil, false
+		}
+		shapes[i] = shape
+	}
+	sorted := append([]uint64{}, shapes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return fmt.Sprint(sorted), shapes, true
+}
+
+// podShape hashes the parts of the pod that are considered by the scheduler and
+// packer. Volumes are identified by their claims, since pods that share a claim
+// attach its volume once, and priority decides which pods are within limits.
+func podShape(p *v1.Pod) (uint64, error) {
+	claims := []string{}
+	ephemeral := []ephemeralVolumeShape{}
+	for _, volume := range p.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims = append(claims, fmt.Sprintf("%s/%s", p.Namespace, volume.PersistentVolumeClaim.ClaimName))
+		}
+		if volume.Ephemeral != nil && volume.Ephemeral.VolumeClaimTemplate != nil {
+			spec := volume.Ephemeral.VolumeClaimTemplate.Spec
+			ephemeral = append(ephemeral, ephemeralVolumeShape{
+				StorageClassName: spec.StorageClassName,
+				AccessModes:      spec.AccessModes,
+				Selector:         spec.Selector,
+				Requests:         quantities(spec.Resources.Requests),
+			})
+		}
+	}
+	return hashstructure.Hash(struct {
+		NodeSelector      map[string]string
+		Affinity          *v1.Affinity
+		Tolerations       []v1.Toleration
+		Requests          map[v1.ResourceName]string
+		GPULimits         map[v1.ResourceName]string
+		Claims            []string
+		EphemeralVolumes  []ephemeralVolumeShape
+		Priority          *int32
+		PriorityClassName string
+	}{
+		NodeSelector:      p.Spec.NodeSelector,
+		Affinity:          p.Spec.Affinity,
+		Tolerations:       p.Spec.Tolerations,
+		Requests:          quantities(resources.RequestsForPods(p)),
+		GPULimits:         quantities(resources.GPULimitsFor(p)),
+		Claims:            claims,
+		EphemeralVolumes:  ephemeral,
+		Priority:          p.Spec.Priority,
+		PriorityClassName: p.Spec.PriorityClassName,
+	}, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+}
+
+// ephemeralVolumeShape is the part of an ephemeral volume's claim template
+// that decides where its volume can be provisioned and attached
+type ephemeralVolumeShape struct {
+	StorageClassName *string
+	AccessModes      []v1.PersistentVolumeAccessMode
+	Selector         *metav1.LabelSelector
+	Requests         map[v1.ResourceName]string
+}
+
+// quantities returns the canonical string of each quantity, since quantities
+// keep their values in unexported fields, which aren't hashed
+func quantities(resourceList v1.ResourceList) map[v1.ResourceName]string {
+	result := map[v1.ResourceName]string{}
+	for resourceName, quantity := range resourceList {
+		result[resourceName] = quantity.String()
+	}
+	return result
+}
@@ -16,22 +16,40 @@ package provisioning_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
 	"github.com/aws/karpenter/pkg/utils/resources"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"knative.dev/pkg/ptr"
+	"knative.dev/pkg/system"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	. "github.com/aws/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
@@ -43,6 +61,9 @@ var ctx context.Context
 var provisioningController *provisioning.Controller
 var selectionController *selection.Controller
 var env *test.Environment
+var recorder *test.EventRecorder
+var cloudProvider *fake.CloudProvider
+var coreV1Client corev1.CoreV1Interface
 
 func TestAPIs(t *testing.T) {
 	ctx = TestContextWithLogger(t)
@@ -52,10 +73,14 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		cloudProvider := &fake.CloudProvider{}
+		ctx = injection.WithOptions(ctx, options.Options{NodeStartupDuration: 2 * time.Minute})
+		cloudProvider = &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioningController = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioningController)
+		recorder = test.NewEventRecorder()
+		coreV1Client = corev1.NewForConfigOrDie(e.Config)
+		Expect(os.Setenv(system.NamespaceEnvKey, "default")).To(Succeed())
+		provisioningController = provisioning.NewController(ctx, e.Client, coreV1Client, cloudProvider, events.NewRecorder(recorder))
+		selectionController = selection.NewController(e.Client, provisioningController, events.NewRecorder(test.NewEventRecorder()))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -80,6 +105,8 @@ var _ = Describe("Provisioning", func() {
 	})
 
 	AfterEach(func() {
+		cloudProvider.InstanceTypes = nil
+		cloudProvider.ManagedResources = false
 		ExpectProvisioningCleanedUp(ctx, env.Client, provisioningController)
 	})
 
@@ -141,8 +168,41 @@ var _ = Describe("Provisioning", func() {
 					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.AWSNeuron: resource.MustParse("1")}},
 				}),
 			) {
-				ExpectScheduled(ctx, env.Client, pod)
+				ExpectNominated(ctx, env.Client, pod)
+			}
+		})
+		It("should provision nodes for extended resources of custom device plugins", func() {
+			cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+				fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default-instance-type"}),
+				fake.NewInstanceType(fake.InstanceTypeOptions{
+					Name:      "device-instance-type",
+					Resources: v1.ResourceList{"example.com/device": resource.MustParse("2")},
+				}),
 			}
+			pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{"example.com/device": resource.MustParse("1")}},
+				}),
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{"example.com/other-device": resource.MustParse("1")}},
+				}),
+			)
+			node := ExpectNominated(ctx, env.Client, pods[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "device-instance-type"))
+			ExpectNotScheduled(ctx, env.Client, pods[1])
+			Expect(pods[1].Annotations).ToNot(HaveKey(v1alpha5.NominatedNodeAnnotationKey))
+		})
+		It("should not provision nodes again for pods waiting for extended resources", func() {
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")}},
+			}))[0]
+			node := ExpectNominated(ctx, env.Client, pod)
+			// The node hasn't registered the GPUs yet, so it still has the not-ready taint
+			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pod)[0]
+			Expect(ExpectNominated(ctx, env.Client, pod).Name).To(Equal(node.Name))
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(HaveLen(1))
 		})
 		Context("Resource Limits", func() {
 			It("should not schedule when limits are exceeded", func() {
@@ -155,6 +215,124 @@ var _ = Describe("Provisioning", func() {
 				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
 				ExpectNotScheduled(ctx, env.Client, pod)
 			})
+			It("should provision for higher priority pods first when limits are nearly exhausted", func() {
+				cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default-instance-type", CPU: resource.MustParse("4")}),
+				}
+				high := test.PriorityClass(test.PriorityClassOptions{Value: 1000})
+				ExpectCreated(ctx, env.Client, high)
+				// Each pod fills a node, and only two nodes fit within the limits
+				options := test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options),
+					test.UnschedulablePod(options),
+					test.UnschedulablePod(options),
+					test.UnschedulablePod(options, test.PodOptions{PriorityClassName: high.Name}),
+				)
+				ExpectScheduled(ctx, env.Client, pods[3])
+				nodes := &v1.NodeList{}
+				Expect(env.Client.List(ctx, nodes)).To(Succeed())
+				Expect(nodes.Items).To(HaveLen(2))
+				// One of the low priority pods fits within the limits, and the others fail with an event
+				unscheduled := 0
+				for _, pod := range pods[:3] {
+					if pod.Spec.NodeName == "" {
+						unscheduled++
+						podEvents := recorder.For(pod, events.ExceededLimits)
+						Expect(podEvents).To(HaveLen(1))
+						Expect(podEvents[0].Message).To(ContainSubstring("would exceed the provisioner's limits"))
+					} else {
+						Expect(recorder.For(pod, events.ExceededLimits)).To(BeEmpty())
+					}
+				}
+				Expect(unscheduled).To(Equal(2))
+			})
+			It("should estimate nodes at the smallest of their instance type options", func() {
+				cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-instance-type", CPU: resource.MustParse("4")}),
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-instance-type", CPU: resource.MustParse("16")}),
+				}
+				// The large instance type would exceed the limits, but the small one is expected to be launched
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(recorder.For(pod, events.ExceededLimits)).To(BeEmpty())
+			})
+			It("should estimate nodes at the cheapest of their instance type options", func() {
+				cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-instance-type", CPU: resource.MustParse("4"), Price: ptr.Float64(2)}),
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-instance-type", CPU: resource.MustParse("16"), Price: ptr.Float64(1)}),
+				}
+				// The large instance type is cheaper, so it's expected to be launched, which exceeds the limits
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+				Expect(recorder.For(pod, events.ExceededLimits)).To(HaveLen(1))
+			})
+		})
+		Context("Scoped Limits", func() {
+			// Each pod fills a node
+			options := test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}
+			BeforeEach(func() {
+				cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default-instance-type", CPU: resource.MustParse("4")}),
+				}
+				provisioner.Spec.Limits.Resources[v1.ResourceCPU] = resource.MustParse("100")
+			})
+			It("should launch on-demand nodes when spot nodes would exceed their share", func() {
+				provisioner.Spec.Limits.Scoped = []v1alpha5.ScopedLimit{
+					{Key: v1alpha5.LabelCapacityType, Values: []string{"spot"}, Percentages: map[v1.ResourceName]int32{v1.ResourceCPU: 60}},
+				}
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options), test.UnschedulablePod(options), test.UnschedulablePod(options),
+				)
+				capacityTypes := map[string]int{}
+				for _, pod := range pods {
+					capacityTypes[ExpectScheduled(ctx, env.Client, pod).Labels[v1alpha5.LabelCapacityType]]++
+				}
+				Expect(capacityTypes).To(Equal(map[string]int{"spot": 1, "on-demand": 2}))
+			})
+			It("should launch nodes in other zones when a zone would exceed its limit", func() {
+				provisioner.Spec.Limits.Scoped = []v1alpha5.ScopedLimit{
+					{Key: v1.LabelTopologyZone, Values: []string{"test-zone-1"}, Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}},
+				}
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options), test.UnschedulablePod(options),
+				)
+				zones := map[string]int{}
+				for _, pod := range pods {
+					zones[ExpectScheduled(ctx, env.Client, pod).Labels[v1.LabelTopologyZone]]++
+				}
+				Expect(zones).To(Equal(map[string]int{"test-zone-1": 1, "test-zone-2": 1}))
+			})
+			It("should launch nodes that can only exceed a scoped percentage", func() {
+				provisioner.Spec.Limits.Scoped = []v1alpha5.ScopedLimit{
+					{Key: v1alpha5.LabelCapacityType, Values: []string{"spot"}, Percentages: map[v1.ResourceName]int32{v1.ResourceCPU: 60}},
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options, test.PodOptions{NodeSelector: map[string]string{v1alpha5.LabelCapacityType: "spot"}}),
+				)[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, "spot"))
+			})
+			It("should not launch nodes that can only exceed a scoped limit's resources", func() {
+				provisioner.Spec.Limits.Scoped = []v1alpha5.ScopedLimit{
+					{Key: v1alpha5.LabelCapacityType, Values: []string{"spot"}, Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}},
+				}
+				spot := test.PodOptions{NodeSelector: map[string]string{v1alpha5.LabelCapacityType: "spot"}}
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options, spot), test.UnschedulablePod(options, spot),
+				)
+				scheduled := 0
+				for _, pod := range pods {
+					if pod.Spec.NodeName != "" {
+						scheduled++
+					}
+				}
+				Expect(scheduled).To(Equal(1))
+			})
 		})
 		Context("Daemonsets and Node Overhead", func() {
 			It("should account for overhead", func() {
@@ -198,6 +376,48 @@ var _ = Describe("Provisioning", func() {
 				Expect(*node.Status.Allocatable.Cpu()).To(Equal(resource.MustParse("2")))
 				Expect(*node.Status.Allocatable.Memory()).To(Equal(resource.MustParse("2Gi")))
 			})
+			It("should ignore daemonsets that are being deleted", func() {
+				daemonSet := test.DaemonSet(
+					test.DaemonSetOptions{PodOptions: test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("1Gi")}},
+					}},
+				)
+				daemonSet.Finalizers = []string{"example.com/finalizer"}
+				ExpectCreated(ctx, env.Client, daemonSet)
+				Expect(env.Client.Delete(ctx, daemonSet)).To(Succeed())
+				// The pod only fits on the small instance type without the daemonset's overhead
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("2Gi")}},
+					},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(*node.Status.Allocatable.Cpu()).To(Equal(resource.MustParse("2")))
+				Expect(*node.Status.Allocatable.Memory()).To(Equal(resource.MustParse("2Gi")))
+
+				Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(daemonSet), daemonSet)).To(Succeed())
+				daemonSet.SetFinalizers([]string{})
+				Expect(env.Client.Update(ctx, daemonSet)).To(Succeed())
+			})
+			It("should account for the latest revision of daemonsets that are being rolled out", func() {
+				daemonSet := test.DaemonSet(
+					test.DaemonSetOptions{PodOptions: test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3"), v1.ResourceMemory: resource.MustParse("3Gi")}},
+					}},
+				)
+				ExpectCreated(ctx, env.Client, daemonSet)
+				// The rollout doesn't progress, since the daemonset controller doesn't run in the test environment
+				daemonSet.Spec.Template.Spec.Containers[0].Resources.Requests = v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("1Gi")}
+				Expect(env.Client.Update(ctx, daemonSet)).To(Succeed())
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("1Gi")}},
+					},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(*node.Status.Allocatable.Cpu()).To(Equal(resource.MustParse("2")))
+				Expect(*node.Status.Allocatable.Memory()).To(Equal(resource.MustParse("2Gi")))
+			})
 			It("should ignore daemonsets with an invalid selector", func() {
 				ExpectCreated(ctx, env.Client, test.DaemonSet(
 					test.DaemonSetOptions{PodOptions: test.PodOptions{
@@ -249,6 +469,70 @@ var _ = Describe("Provisioning", func() {
 				Expect(*node.Status.Allocatable.Memory()).To(Equal(resource.MustParse("4Gi")))
 			})
 		})
+		Context("Kubelet Configuration", func() {
+			It("should not schedule if kubelet reserved resources are too large", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha5.KubeletConfiguration{
+					KubeReserved: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3500m")},
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should not schedule if the hard eviction threshold is too large", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha5.KubeletConfiguration{
+					EvictionHard: map[string]string{"memory.available": "90%"},
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should limit pods per node to maxPods", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha5.KubeletConfiguration{MaxPods: ptr.Int32(1)}
+				nodes := map[string]struct{}{}
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(), test.UnschedulablePod()) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					nodes[node.Name] = struct{}{}
+				}
+				Expect(nodes).To(HaveLen(2))
+			})
+		})
+		Context("Ephemeral Storage", func() {
+			It("should schedule pods whose ephemeral storage requests fit", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("10Gi")}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+			})
+			It("should not schedule pods whose ephemeral storage requests exceed the eviction threshold", func() {
+				// 10% of the 20Gi of ephemeral storage is held back by the default nodefs.available eviction threshold
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("19Gi")}}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should respect the kubelet's nodefs.available eviction threshold", func() {
+				provisioner.Spec.KubeletConfiguration = &v1alpha5.KubeletConfiguration{
+					EvictionHard: map[string]string{"nodefs.available": "50%"},
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("11Gi")}}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should pack pods by their ephemeral storage requests", func() {
+				nodes := map[string]struct{}{}
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("10Gi")}}}),
+					test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("10Gi")}}}),
+				) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					nodes[node.Name] = struct{}{}
+				}
+				Expect(nodes).To(HaveLen(2))
+			})
+		})
 		Context("Labels", func() {
 			It("should label nodes", func() {
 				provisioner.Spec.Labels = map[string]string{"test-key": "test-value", "test-key-2": "test-value-2"}
@@ -271,5 +555,657 @@ var _ = Describe("Provisioning", func() {
 				}
 			})
 		})
+		Context("Nomination", func() {
+			BeforeEach(func() {
+				recorder.Reset()
+			})
+			It("should annotate pods with the node they're nominated to", func() {
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod()) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha5.NominatedNodeAnnotationKey, node.Name))
+					Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha5.NominatedProvisionerAnnotationKey, provisioner.Name))
+					nominated, err := time.Parse(time.RFC3339, pod.Annotations[v1alpha5.NominatedTimestampAnnotationKey])
+					Expect(err).ToNot(HaveOccurred())
+					expectedReady, err := time.Parse(time.RFC3339, pod.Annotations[v1alpha5.ExpectedReadyTimestampAnnotationKey])
+					Expect(err).ToNot(HaveOccurred())
+					Expect(expectedReady.Sub(nominated)).To(Equal(2 * time.Minute))
+				}
+			})
+			It("should preserve existing pod annotations", func() {
+				pod := test.UnschedulablePod()
+				pod.Annotations = map[string]string{"test-key": "test-value"}
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pod) {
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(pod.Annotations).To(HaveKeyWithValue("test-key", "test-value"))
+					Expect(pod.Annotations).To(HaveKey(v1alpha5.NominatedNodeAnnotationKey))
+				}
+			})
+			It("should emit an event to nominated pods", func() {
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod()) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					nominations := recorder.For(pod, events.Nominated)
+					Expect(nominations).To(HaveLen(1))
+					Expect(nominations[0].Message).To(ContainSubstring(node.Name))
+					Expect(nominations[0].Message).To(ContainSubstring("provisioner/" + provisioner.Name))
+				}
+			})
+			It("should not annotate pods that weren't provisioned", func() {
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown"}})) {
+					ExpectNotScheduled(ctx, env.Client, pod)
+					Expect(pod.Annotations).ToNot(HaveKey(v1alpha5.NominatedNodeAnnotationKey))
+					Expect(recorder.For(pod, events.Nominated)).To(BeEmpty())
+				}
+			})
+		})
+		Context("Solution Reuse", func() {
+			podOptions := func() test.PodOptions {
+				return test.PodOptions{
+					NodeSelector:         map[string]string{v1.LabelTopologyZone: "test-zone-1"},
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+				}
+			}
+			It("should bind each batch of identical pods to its own nodes", func() {
+				first := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(podOptions()), test.UnschedulablePod(podOptions()))
+				second := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(podOptions()), test.UnschedulablePod(podOptions()))
+				firstNodes := map[string]bool{}
+				for _, pod := range first {
+					firstNodes[ExpectScheduled(ctx, env.Client, pod).Name] = true
+				}
+				for _, pod := range second {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(firstNodes).ToNot(HaveKey(node.Name))
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
+				}
+			})
+			It("should reuse the solution of an identical batch", func() {
+				solved := ExpectSolveCount()
+				ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(podOptions()), test.UnschedulablePod(podOptions()))
+				Expect(ExpectSolveCount()).To(Equal(solved + 1))
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(podOptions()), test.UnschedulablePod(podOptions())) {
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+				Expect(ExpectSolveCount()).To(Equal(solved + 1))
+			})
+			It("should solve batches of pods with different priorities independently", func() {
+				solved := ExpectSolveCount()
+				for _, priority := range []int32{1, 2} {
+					pods := []*v1.Pod{test.UnschedulablePod(podOptions()), test.UnschedulablePod(podOptions())}
+					for _, pod := range pods {
+						pod.Spec.Priority = ptr.Int32(priority)
+					}
+					ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pods...)
+				}
+				Expect(ExpectSolveCount()).To(Equal(solved + 2))
+			})
+			It("should solve batches of pods with different volumes independently", func() {
+				storageClass := test.StorageClass()
+				ExpectCreated(ctx, env.Client, storageClass)
+				solved := ExpectSolveCount()
+				for i := 0; i < 2; i++ {
+					persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{StorageClassName: &storageClass.Name})
+					ExpectCreated(ctx, env.Client, persistentVolumeClaim)
+					options := podOptions()
+					options.PersistentVolumeClaims = []string{persistentVolumeClaim.Name}
+					ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+						test.UnschedulablePod(options), test.UnschedulablePod(options))
+				}
+				Expect(ExpectSolveCount()).To(Equal(solved + 2))
+			})
+			It("should solve batches of pods with different requests independently", func() {
+				cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-instance-type", CPU: resource.MustParse("2")}),
+					fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-instance-type", CPU: resource.MustParse("16")}),
+				}
+				solved := ExpectSolveCount()
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(podOptions()), test.UnschedulablePod(podOptions())) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small-instance-type"))
+				}
+				options := podOptions()
+				options.ResourceRequirements.Requests[v1.ResourceCPU] = resource.MustParse("4")
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options), test.UnschedulablePod(options)) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "large-instance-type"))
+				}
+				Expect(ExpectSolveCount()).To(Equal(solved + 2))
+			})
+			It("should solve batches of different pods independently", func() {
+				ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(podOptions()), test.UnschedulablePod(podOptions()))
+				options := podOptions()
+				options.NodeSelector[v1.LabelTopologyZone] = "test-zone-2"
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options), test.UnschedulablePod(options)) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+				}
+			})
+		})
+	})
+	Context("Instance Type Ordering", func() {
+		BeforeEach(func() {
+			cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+				fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-expensive-instance-type", CPU: resource.MustParse("2"), Price: ptr.Float64(1)}),
+				fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-cheap-instance-type", CPU: resource.MustParse("8"), Price: ptr.Float64(0.5)}),
+			}
+		})
+		It("should prefer smaller instance types by default", func() {
+			orderingCtx := injection.WithOptions(ctx, options.Options{NodeStartupDuration: 2 * time.Minute, InstanceTypeOrdering: "size"})
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(orderingCtx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small-expensive-instance-type"))
+		})
+		It("should prefer instance types by the configured comparators", func() {
+			orderingCtx := injection.WithOptions(ctx, options.Options{NodeStartupDuration: 2 * time.Minute, InstanceTypeOrdering: "price,size"})
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(orderingCtx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "large-cheap-instance-type"))
+		})
+		It("should ignore an ordering with comparators that the cloud provider doesn't support", func() {
+			orderingCtx := injection.WithOptions(ctx, options.Options{NodeStartupDuration: 2 * time.Minute, InstanceTypeOrdering: "generation,price"})
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(orderingCtx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small-expensive-instance-type"))
+		})
+	})
+	Context("Instance Selection Strategy", func() {
+		BeforeEach(func() {
+			provisioner.Spec.Limits = nil
+			// The cheapest instance type is larger than the smallest instance types that fit
+			cloudProvider.InstanceTypes = []cloudprovider.InstanceType{}
+			for i := 0; i <= binpacking.MaxInstanceTypes; i++ {
+				price := 1.0
+				if i == binpacking.MaxInstanceTypes {
+					price = 0.5
+				}
+				cloudProvider.InstanceTypes = append(cloudProvider.InstanceTypes, fake.NewInstanceType(fake.InstanceTypeOptions{
+					Name:  fmt.Sprintf("instance-type-%d", i),
+					CPU:   resource.MustParse(fmt.Sprint(i + 1)),
+					Price: ptr.Float64(price),
+				}))
+			}
+		})
+		It("should prefer the smallest instance types with the capacity optimized strategy", func() {
+			orderingCtx := injection.WithOptions(ctx, options.Options{NodeStartupDuration: 2 * time.Minute, InstanceTypeOrdering: "price,size"})
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(orderingCtx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(node.Labels).ToNot(HaveKeyWithValue(v1.LabelInstanceTypeStable, fmt.Sprintf("instance-type-%d", binpacking.MaxInstanceTypes)))
+		})
+		It("should prefer the cheapest instance type with the lowest price strategy", func() {
+			strategy := v1alpha5.InstanceSelectionStrategyLowestPrice
+			provisioner.Spec.InstanceSelectionStrategy = &strategy
+			orderingCtx := injection.WithOptions(ctx, options.Options{NodeStartupDuration: 2 * time.Minute, InstanceTypeOrdering: "size"})
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(orderingCtx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, fmt.Sprintf("instance-type-%d", binpacking.MaxInstanceTypes)))
+		})
+	})
+	Context("Outcomes", func() {
+		var mu sync.Mutex
+		var outcomes []provisioning.Outcome
+		BeforeEach(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes = nil
+			name := provisioner.Name
+			provisioningController.Subscribe(func(_ context.Context, outcome provisioning.Outcome) {
+				if outcome.Provisioner != name {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				outcomes = append(outcomes, outcome)
+			})
+		})
+		It("should report the nodes launched for the pods", func() {
+			pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(), test.UnschedulablePod())
+			node := ExpectScheduled(ctx, env.Client, pods[0])
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(outcomes).To(HaveLen(1))
+			Expect(outcomes[0].Err).ToNot(HaveOccurred())
+			Expect(outcomes[0].Pods).To(HaveLen(2))
+			Expect(outcomes[0].Nodes).To(HaveLen(1))
+			Expect(outcomes[0].Nodes[0].Pods).To(HaveLen(2))
+			Expect(outcomes[0].Nodes[0].InstanceTypeOptions).ToNot(BeEmpty())
+			Expect(outcomes[0].Nodes[0].Err).ToNot(HaveOccurred())
+			Expect(outcomes[0].Launched()).To(ConsistOf(HaveField("Name", node.Name)))
+		})
+		It("should report the error of nodes that weren't launched", func() {
+			provisioner.Status = v1alpha5.ProvisionerStatus{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")}}
+			provisioner.Spec.Limits.Resources[v1.ResourceCPU] = resource.MustParse("20")
+			ExpectNotScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(outcomes).To(HaveLen(1))
+			Expect(outcomes[0].Nodes).To(HaveLen(1))
+			Expect(outcomes[0].Nodes[0].Node).To(BeNil())
+			Expect(outcomes[0].Nodes[0].Err).To(HaveOccurred())
+			Expect(outcomes[0].Launched()).To(BeEmpty())
+		})
+	})
+	Context("Checkpoint", func() {
+		inFlight := func() map[string]provisioning.Launch {
+			configMap, err := coreV1Client.ConfigMaps("default").Get(ctx, provisioning.CheckpointConfigMapName, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			result := map[string]provisioning.Launch{}
+			Expect(json.Unmarshal([]byte(configMap.Data["launches"]), &result)).To(Succeed())
+			return result
+		}
+		inFlightPods := func() []types.UID {
+			pods := []types.UID{}
+			for _, launch := range inFlight() {
+				pods = append(pods, launch.Pods...)
+			}
+			return pods
+		}
+		AfterEach(func() {
+			injectabletime.Now = time.Now
+		})
+		It("should restore in-flight launches after a restart", func() {
+			pod := test.UnschedulablePod()
+			pod.UID = types.UID(randomdata.Alphanumeric(16))
+			_, err := provisioning.NewCheckpoint(coreV1Client, "default").Begin(ctx, &provisioning.Launch{
+				Provisioner: provisioner.Name,
+				Pods:        []types.UID{pod.UID},
+				Nodes:       1,
+				Capacity:    v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inFlightPods()).To(ContainElement(pod.UID))
+			restarted := provisioning.NewCheckpoint(coreV1Client, "default")
+			Expect(restarted.InFlight(ctx, pod)).To(BeTrue())
+			Expect(restarted.PreviousCapacity(ctx, provisioner.Name)).To(Equal(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}))
+			Expect(restarted.PreviousCapacity(ctx, "other-provisioner")).To(BeEmpty())
+			// In-flight launches expire once their nodes would be expected to have started
+			injectabletime.Now = func() time.Time { return time.Now().Add(3 * time.Minute) }
+			Expect(restarted.InFlight(ctx, pod)).To(BeFalse())
+			Expect(restarted.PreviousCapacity(ctx, provisioner.Name)).To(BeEmpty())
+		})
+		It("should forget launches that completed", func() {
+			pod := test.UnschedulablePod()
+			pod.UID = types.UID(randomdata.Alphanumeric(16))
+			checkpoint := provisioning.NewCheckpoint(coreV1Client, "default")
+			id, err := checkpoint.Begin(ctx, &provisioning.Launch{Provisioner: provisioner.Name, Pods: []types.UID{pod.UID}, Nodes: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checkpoint.Complete(ctx, id)).To(Succeed())
+			Expect(inFlightPods()).ToNot(ContainElement(pod.UID))
+			Expect(provisioning.NewCheckpoint(coreV1Client, "default").InFlight(ctx, pod)).To(BeFalse())
+		})
+		It("should count the capacity that a previous controller was launching against the limits", func() {
+			checkpoint := provisioning.NewCheckpoint(coreV1Client, "default")
+			id, err := checkpoint.Begin(ctx, &provisioning.Launch{
+				Provisioner: provisioner.Name,
+				Nodes:       1,
+				Capacity:    v1.ResourceList{v1.ResourceCPU: resource.MustParse("9")},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			restarted := provisioning.NewController(ctx, env.Client, coreV1Client, cloudProvider, events.NewRecorder(recorder))
+			pod := ExpectProvisioned(ctx, env.Client, selection.NewController(env.Client, restarted, events.NewRecorder(test.NewEventRecorder())), restarted, provisioner,
+				test.UnschedulablePod(),
+			)[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(recorder.For(pod, events.ExceededLimits)).To(HaveLen(1))
+			ExpectProvisioningCleanedUp(ctx, env.Client, restarted)
+			Expect(checkpoint.Complete(ctx, id)).To(Succeed())
+		})
+		It("should forget launches once their pods are bound", func() {
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(inFlightPods()).ToNot(ContainElement(pod.UID))
+		})
+	})
+	Context("Leader Election", func() {
+		It("should stop provisioners when leadership is lost", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			Expect(provisioningController.List(ctx)).ToNot(BeEmpty())
+			leaderCtx, lose := context.WithCancel(ctx)
+			lose()
+			Expect(provisioningController.Start(leaderCtx)).To(Succeed())
+			Expect(provisioningController.List(ctx)).To(BeEmpty())
+		})
+	})
+	Context("Cost Anomalies", func() {
+		var analyzer *provisioning.CostAnalyzer
+		var start time.Time
+		BeforeEach(func() {
+			recorder.Reset()
+			start = time.Now()
+			injectabletime.Now = func() time.Time { return start }
+			analyzer = provisioning.NewCostAnalyzer(env.Client, events.NewRecorder(recorder), provisioning.CostAnalyzerOptions{
+				Threshold:   3,
+				Window:      10 * time.Minute,
+				Baseline:    time.Hour,
+				MinimumRate: 20,
+			})
+			ExpectApplied(ctx, env.Client, provisioner)
+		})
+		AfterEach(func() {
+			injectabletime.Now = time.Now
+		})
+		launch := func(after time.Duration, nodes int) {
+			injectabletime.Now = func() time.Time { return start.Add(after) }
+			outcome := provisioning.Outcome{Provisioner: provisioner.Name}
+			for i := 0; i < nodes; i++ {
+				outcome.Nodes = append(outcome.Nodes, &provisioning.NodeOutcome{
+					Node:         test.Node(),
+					InstanceType: fake.NewInstanceType(fake.InstanceTypeOptions{Name: "priced-instance-type", Price: ptr.Float64(1)}),
+				})
+			}
+			analyzer.Observe(ctx, outcome)
+		}
+		It("should report launch cost rates that exceed the threshold times the baseline", func() {
+			launch(5*time.Minute, 1)
+			launch(30*time.Minute, 1)
+			launch(70*time.Minute, 10)
+			Expect(recorder.For(provisioner, events.CostAnomaly)).To(HaveLen(1))
+			// Anomalies are reported at most once per window
+			launch(72*time.Minute, 10)
+			Expect(recorder.For(provisioner, events.CostAnomaly)).To(HaveLen(1))
+		})
+		It("should not report launch cost rates within the threshold", func() {
+			launch(5*time.Minute, 2)
+			launch(30*time.Minute, 2)
+			launch(70*time.Minute, 1)
+			Expect(recorder.For(provisioner, events.CostAnomaly)).To(BeEmpty())
+		})
+		It("should report launch cost rates that exceed the minimum rate if nothing was launched during the baseline", func() {
+			// 10 nodes at 1/h over 10 minutes is 60/h
+			launch(70*time.Minute, 10)
+			Expect(recorder.For(provisioner, events.CostAnomaly)).To(HaveLen(1))
+		})
+		It("should not report launch cost rates within the minimum rate if nothing was launched during the baseline", func() {
+			launch(70*time.Minute, 1)
+			Expect(recorder.For(provisioner, events.CostAnomaly)).To(BeEmpty())
+		})
+		It("should stop exporting rates once the provisioner's launches are older than the baseline", func() {
+			launch(70*time.Minute, 10)
+			Expect(ExpectGauge("karpenter_allocation_controller_launch_cost_rate", provisioner.Name)).ToNot(BeNil())
+			Expect(ExpectGauge("karpenter_allocation_controller_launch_cost_baseline_rate", provisioner.Name)).ToNot(BeNil())
+			launch(3*time.Hour, 0)
+			Expect(ExpectGauge("karpenter_allocation_controller_launch_cost_rate", provisioner.Name)).To(BeNil())
+			Expect(ExpectGauge("karpenter_allocation_controller_launch_cost_baseline_rate", provisioner.Name)).To(BeNil())
+		})
+		It("should not report anomalies before observing a baseline", func() {
+			launch(5*time.Minute, 100)
+			Expect(recorder.For(provisioner, events.CostAnomaly)).To(BeEmpty())
+		})
+		It("should not count nodes that weren't launched", func() {
+			launch(5*time.Minute, 1)
+			injectabletime.Now = func() time.Time { return start.Add(70 * time.Minute) }
+			analyzer.Observe(ctx, provisioning.Outcome{Provisioner: provisioner.Name, Nodes: []*provisioning.NodeOutcome{{Err: fmt.Errorf("failed")}}})
+			Expect(recorder.For(provisioner, events.CostAnomaly)).To(BeEmpty())
+		})
+	})
+	Context("Launch Rate", func() {
+		var fakeClock *clock.FakeClock
+		BeforeEach(func() {
+			fakeClock = clock.NewFakeClock(time.Now())
+			provisioning.LaunchClock = fakeClock
+		})
+		AfterEach(func() {
+			provisioning.LaunchClock = clock.RealClock{}
+		})
+		nodeCount := func() int {
+			nodes := &v1.NodeList{}
+			Expect(env.Client.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name})).To(Succeed())
+			return len(nodes.Items)
+		}
+		It("should launch nodes one at a time within the provisioner's launch rate", func() {
+			provisioner.Spec.MaxNodesPerMinute = ptr.Int32(1)
+			labels := map[string]string{"app": "rate-limited"}
+			options := test.PodOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+					TopologyKey:       v1.LabelHostname,
+					WhenUnsatisfiable: v1.DoNotSchedule,
+					LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+					MaxSkew:           1,
+				}},
+			}
+			provisioned := make(chan []*v1.Pod)
+			go func() {
+				defer GinkgoRecover()
+				provisioned <- ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options), test.UnschedulablePod(options), test.UnschedulablePod(options))
+			}()
+			// The first node is launched immediately, and each of the others a minute after the last
+			Eventually(nodeCount).Should(Equal(1))
+			for i := 2; i <= 3; i++ {
+				Eventually(fakeClock.HasWaiters).Should(BeTrue())
+				fakeClock.Step(59 * time.Second)
+				Consistently(nodeCount, 100*time.Millisecond).Should(Equal(i - 1))
+				fakeClock.Step(time.Second)
+				Eventually(nodeCount).Should(Equal(i))
+			}
+			nodes := map[string]bool{}
+			for _, pod := range <-provisioned {
+				nodes[ExpectScheduled(ctx, env.Client, pod).Name] = true
+			}
+			Expect(nodes).To(HaveLen(3))
+		})
+		It("should launch as many nodes at a time as the provisioner's launch rate allows", func() {
+			provisioner.Spec.MaxNodesPerMinute = ptr.Int32(2)
+			labels := map[string]string{"app": "rate-limited"}
+			options := test.PodOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+					TopologyKey:       v1.LabelHostname,
+					WhenUnsatisfiable: v1.DoNotSchedule,
+					LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+					MaxSkew:           1,
+				}},
+			}
+			createCalls := atomic.LoadInt64(&cloudProvider.CreateCalls)
+			provisioned := make(chan []*v1.Pod)
+			go func() {
+				defer GinkgoRecover()
+				provisioned <- ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(options), test.UnschedulablePod(options), test.UnschedulablePod(options))
+			}()
+			// The burst of two nodes is launched together, and the third once the rate allows
+			Eventually(nodeCount).Should(Equal(2))
+			Expect(atomic.LoadInt64(&cloudProvider.CreateCalls)).To(Equal(createCalls + 1))
+			Eventually(fakeClock.HasWaiters).Should(BeTrue())
+			fakeClock.Step(30 * time.Second)
+			Eventually(nodeCount).Should(Equal(3))
+			Expect(atomic.LoadInt64(&cloudProvider.CreateCalls)).To(Equal(createCalls + 2))
+			for _, pod := range <-provisioned {
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+		})
+		It("should not reset the provisioner's launch rate when its spec changes", func() {
+			provisioner.Spec.MaxNodesPerMinute = ptr.Int32(1)
+			ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			provisioner.Spec.Labels = map[string]string{"foo": "bar"}
+			provisioned := make(chan []*v1.Pod)
+			go func() {
+				defer GinkgoRecover()
+				provisioned <- ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())
+			}()
+			Eventually(fakeClock.HasWaiters).Should(BeTrue())
+			Expect(nodeCount()).To(Equal(1))
+			fakeClock.Step(time.Minute)
+			ExpectScheduled(ctx, env.Client, (<-provisioned)[0])
+			Expect(nodeCount()).To(Equal(2))
+		})
+	})
+	Context("Deletion Policy", func() {
+		It("should not add a finalizer to provisioners that orphan their nodes", func() {
+			ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.Finalizers).ToNot(ContainElement(v1alpha5.TerminationFinalizer))
+		})
+		It("should delete the nodes of provisioners with the Delete policy before removing them", func() {
+			cloudProvider.ManagedResources = true
+			policy := v1alpha5.DeletionPolicyDelete
+			provisioner.Spec.DeletionPolicy = &policy
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.Finalizers).To(ContainElement(v1alpha5.TerminationFinalizer))
+
+			Expect(env.Client.Delete(ctx, provisioner)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			Expect(node.DeletionTimestamp.IsZero()).To(BeFalse())
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+
+			// Simulate the termination controller removing the node
+			node.Finalizers = []string{}
+			Expect(env.Client.Update(ctx, node)).To(Succeed())
+			ExpectNotFound(ctx, env.Client, node)
+			Expect(cloudProvider.CleanedUp).ToNot(ContainElement(provisioner.Name))
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			ExpectNotFound(ctx, env.Client, provisioner)
+			Expect(cloudProvider.CleanedUp).To(ContainElement(provisioner.Name))
+		})
+		It("should keep the cloud provider's resources for orphaned nodes when provisioners are deleted", func() {
+			cloudProvider.ManagedResources = true
+			ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0])
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.Finalizers).To(ContainElement(v1alpha5.CleanupFinalizer))
+
+			Expect(env.Client.Delete(ctx, provisioner)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			ExpectNotFound(ctx, env.Client, provisioner)
+			Expect(cloudProvider.CleanedUp).ToNot(ContainElement(provisioner.Name))
+		})
+		It("should not add the cleanup finalizer to provisioners that the cloud provider manages no resources for", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.Finalizers).ToNot(ContainElement(v1alpha5.CleanupFinalizer))
+
+			Expect(env.Client.Delete(ctx, provisioner)).To(Succeed())
+			ExpectNotFound(ctx, env.Client, provisioner)
+			Expect(cloudProvider.CleanedUp).ToNot(ContainElement(provisioner.Name))
+		})
+		It("should remove the finalizer when the policy changes to Orphan", func() {
+			policy := v1alpha5.DeletionPolicyDelete
+			provisioner.Spec.DeletionPolicy = &policy
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.Finalizers).To(ContainElement(v1alpha5.TerminationFinalizer))
+
+			provisioner.Spec.DeletionPolicy = nil
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+			Expect(provisioner.Finalizers).ToNot(ContainElement(v1alpha5.TerminationFinalizer))
+		})
 	})
 })
+
+var _ = Describe("Batcher", func() {
+	var running context.Context
+	var stop context.CancelFunc
+	BeforeEach(func() {
+		running, stop = context.WithCancel(ctx)
+	})
+	AfterEach(func() {
+		stop()
+	})
+	add := func(batcher *provisioning.Batcher, item interface{}) chan (<-chan struct{}) {
+		gate := make(chan (<-chan struct{}), 1)
+		go func() { gate <- batcher.Add(item) }()
+		return gate
+	}
+
+	It("should close the batch after max items", func() {
+		batcher := provisioning.NewBatcher(running, provisioning.BatcherOptions{IdleDuration: time.Minute, MaxDuration: time.Minute, MaxItems: 2})
+		add(batcher, "a")
+		add(batcher, "b")
+		items, _, flush := batcher.Wait()
+		defer flush()
+		Expect(items).To(ConsistOf("a", "b"))
+	})
+	It("should close the batch after the idle duration", func() {
+		batcher := provisioning.NewBatcher(running, provisioning.BatcherOptions{IdleDuration: 10 * time.Millisecond, MaxDuration: time.Minute, MaxItems: 2})
+		add(batcher, "a")
+		items, window, flush := batcher.Wait()
+		defer flush()
+		Expect(items).To(ConsistOf("a"))
+		Expect(window).To(BeNumerically("<", time.Minute))
+	})
+	It("should close the batch after the max duration", func() {
+		batcher := provisioning.NewBatcher(running, provisioning.BatcherOptions{IdleDuration: time.Minute, MaxDuration: 10 * time.Millisecond, MaxItems: 2})
+		add(batcher, "a")
+		items, _, flush := batcher.Wait()
+		defer flush()
+		Expect(items).To(ConsistOf("a"))
+	})
+	It("should release the batch's gate only when flushed", func() {
+		batcher := provisioning.NewBatcher(running, provisioning.BatcherOptions{IdleDuration: 100 * time.Millisecond, MaxInFlight: 2})
+		first := add(batcher, "a")
+		_, _, flushFirst := batcher.Wait()
+		gate := <-first
+		second := add(batcher, "b")
+		_, _, flushSecond := batcher.Wait()
+		defer flushSecond()
+		Consistently(gate).ShouldNot(BeClosed())
+		flushFirst()
+		Eventually(gate).Should(BeClosed())
+		Consistently(<-second).ShouldNot(BeClosed())
+	})
+	It("should limit the number of batches in flight", func() {
+		batcher := provisioning.NewBatcher(running, provisioning.BatcherOptions{MaxItems: 1, MaxInFlight: 1})
+		add(batcher, "a")
+		_, _, flush := batcher.Wait()
+		add(batcher, "b")
+		next := make(chan []interface{}, 1)
+		go func() {
+			items, _, flush := batcher.Wait()
+			defer flush()
+			next <- items
+		}()
+		Consistently(next).ShouldNot(Receive())
+		flush()
+		Eventually(next).Should(Receive(ConsistOf("b")))
+	})
+	It("should return no items once stopped", func() {
+		batcher := provisioning.NewBatcher(running, provisioning.BatcherOptions{})
+		stop()
+		items, _, flush := batcher.Wait()
+		defer flush()
+		Expect(items).To(BeEmpty())
+	})
+})
+
+// ExpectSolveCount returns the number of times that pods have been solved by the scheduler
+func ExpectSolveCount() (count uint64) {
+	families, err := crmetrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != "karpenter_allocation_controller_scheduling_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			count += metric.GetHistogram().GetSampleCount()
+		}
+	}
+	return count
+}
+
+// ExpectGauge returns the value of the gauge for the provisioner, or nil if it isn't exported
+func ExpectGauge(name string, provisioner string) *float64 {
+	families, err := crmetrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "provisioner" && label.GetValue() == provisioner {
+					return ptr.Float64(metric.GetGauge().GetValue())
+				}
+			}
+		}
+	}
+	return nil
+}
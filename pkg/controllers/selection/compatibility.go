@@ -0,0 +1,214 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selection
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+var (
+	supportedTopologyKeys = sets.NewString(v1.LabelHostname, v1.LabelTopologyZone)
+
+	unsupportedFieldsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "allocation_controller",
+			Name:      "unsupported_pod_fields_total",
+			Help:      "Number of times a pending pod was found to use a pod spec field that isn't supported. Broken down by field and by whether the pod was rejected or the field was ignored.",
+		},
+		[]string{"field", "action"},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(unsupportedFieldsCounter)
+}
+
+// unsupportedField is a pod spec field that Karpenter does not simulate when
+// provisioning. Pods that use these fields are not provisioned, since the
+// nodes that Karpenter launches may not satisfy them.
+type unsupportedField struct {
+	// path of the field in the pod
+	path string
+	// description of how the field is used
+	description string
+	// preference is true if the field is a soft constraint, which is ignored
+	// rather than rejected if --ignore-unsupported-preferences is set
+	preference bool
+	// uses returns true if the pod uses the field in an unsupported way
+	uses func(*v1.Pod) bool
+	// ignore removes the unsupported uses of a preference from the pod
+	ignore func(*v1.Pod)
+}
+
+func (f unsupportedField) Error() string {
+	return fmt.Sprintf("%s is not supported, %s", f.description, f.path)
+}
+
+// unsupportedFields is the compatibility matrix of pod spec fields that
+// Karpenter does not simulate.
+var unsupportedFields = []unsupportedField{
+	{
+		path:        "spec.affinity.podAffinity.requiredDuringSchedulingIgnoredDuringExecution",
+		description: "pod affinity",
+		uses: func(p *v1.Pod) bool {
+			return p.Spec.Affinity != nil && p.Spec.Affinity.PodAffinity != nil && len(p.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 0
+		},
+	},
+	{
+		path:        "spec.affinity.podAffinity.preferredDuringSchedulingIgnoredDuringExecution",
+		description: "pod affinity preference",
+		preference:  true,
+		uses: func(p *v1.Pod) bool {
+			return p.Spec.Affinity != nil && p.Spec.Affinity.PodAffinity != nil && len(p.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 0
+		},
+		ignore: func(p *v1.Pod) { p.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution = nil },
+	},
+	{
+		path:        "spec.affinity.podAntiAffinity.requiredDuringSchedulingIgnoredDuringExecution",
+		description: "pod anti-affinity",
+		uses: func(p *v1.Pod) bool {
+			return p.Spec.Affinity != nil && p.Spec.Affinity.PodAntiAffinity != nil && len(p.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 0
+		},
+	},
+	{
+		path:        "spec.affinity.podAntiAffinity.preferredDuringSchedulingIgnoredDuringExecution",
+		description: "pod anti-affinity preference",
+		preference:  true,
+		uses: func(p *v1.Pod) bool {
+			return p.Spec.Affinity != nil && p.Spec.Affinity.PodAntiAffinity != nil && len(p.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 0
+		},
+		ignore: func(p *v1.Pod) { p.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = nil },
+	},
+	{
+		path:        "spec.affinity.nodeAffinity.requiredDuringSchedulingIgnoredDuringExecution.nodeSelectorTerms",
+		description: "node selector term with matchFields or an operator other than In, NotIn, Exists, or DoesNotExist",
+		uses: func(p *v1.Pod) bool {
+			if p.Spec.Affinity == nil || p.Spec.Affinity.NodeAffinity == nil || p.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+				return false
+			}
+			for _, term := range p.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				if !isSupportedNodeSelectorTerm(term) {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		path:        "spec.affinity.nodeAffinity.preferredDuringSchedulingIgnoredDuringExecution",
+		description: "preferred node selector term with matchFields or an operator other than In, NotIn, Exists, or DoesNotExist",
+		preference:  true,
+		uses: func(p *v1.Pod) bool {
+			if p.Spec.Affinity == nil || p.Spec.Affinity.NodeAffinity == nil {
+				return false
+			}
+			for _, term := range p.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+				if !isSupportedNodeSelectorTerm(term.Preference) {
+					return true
+				}
+			}
+			return false
+		},
+		ignore: func(p *v1.Pod) {
+			terms := []v1.PreferredSchedulingTerm{}
+			for _, term := range p.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+				if isSupportedNodeSelectorTerm(term.Preference) {
+					terms = append(terms, term)
+				}
+			}
+			p.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = terms
+		},
+	},
+	{
+		path:        "spec.topologySpreadConstraints.topologyKey",
+		description: fmt.Sprintf("topology spread constraint with a topology key not in %s", supportedTopologyKeys.List()),
+		uses: func(p *v1.Pod) bool {
+			for _, constraint := range p.Spec.TopologySpreadConstraints {
+				if constraint.WhenUnsatisfiable != v1.ScheduleAnyway && !supportedTopologyKeys.Has(constraint.TopologyKey) {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		path:        "spec.topologySpreadConstraints.topologyKey",
+		description: fmt.Sprintf("preferred topology spread constraint with a topology key not in %s", supportedTopologyKeys.List()),
+		preference:  true,
+		uses: func(p *v1.Pod) bool {
+			for _, constraint := range p.Spec.TopologySpreadConstraints {
+				if constraint.WhenUnsatisfiable == v1.ScheduleAnyway && !supportedTopologyKeys.Has(constraint.TopologyKey) {
+					return true
+				}
+			}
+			return false
+		},
+		ignore: func(p *v1.Pod) {
+			constraints := []v1.TopologySpreadConstraint{}
+			for _, constraint := range p.Spec.TopologySpreadConstraints {
+				if constraint.WhenUnsatisfiable != v1.ScheduleAnyway || supportedTopologyKeys.Has(constraint.TopologyKey) {
+					constraints = append(constraints, constraint)
+				}
+			}
+			p.Spec.TopologySpreadConstraints = constraints
+		},
+	},
+}
+
+// validate returns an error for each unsupported field that the pod uses.
+// Unsupported preferences are removed from the pod instead if
+// --ignore-unsupported-preferences is set.
+func validate(ctx context.Context, p *v1.Pod) (errs error) {
+	ignorePreferences := injection.GetOptions(ctx).IgnoreUnsupportedPreferences
+	for _, field := range unsupportedFields {
+		if !field.uses(p) {
+			continue
+		}
+		if field.preference && ignorePreferences {
+			logging.FromContext(ctx).Debugf("Ignoring unsupported %s, %s", field.description, field.path)
+			unsupportedFieldsCounter.WithLabelValues(field.path, "ignored").Inc()
+			field.ignore(p)
+			continue
+		}
+		unsupportedFieldsCounter.WithLabelValues(field.path, "rejected").Inc()
+		errs = multierr.Append(errs, field)
+	}
+	return errs
+}
+
+func isSupportedNodeSelectorTerm(term v1.NodeSelectorTerm) bool {
+	if len(term.MatchFields) != 0 {
+		return false
+	}
+	for _, requirement := range term.MatchExpressions {
+		if !v1alpha5.SupportedNodeSelectorOps.Has(string(requirement.Operator)) {
+			return false
+		}
+	}
+	return true
+}
@@ -24,16 +24,22 @@ import (
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/logging"
 	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/pod"
 )
 
@@ -45,15 +51,17 @@ type Controller struct {
 	provisioners   *provisioning.Controller
 	preferences    *Preferences
 	volumeTopology *VolumeTopology
+	recorder       events.Recorder
 }
 
 // NewController constructs a controller instance
-func NewController(kubeClient client.Client, provisioners *provisioning.Controller) *Controller {
+func NewController(kubeClient client.Client, provisioners *provisioning.Controller, recorder events.Recorder) *Controller {
 	return &Controller{
 		kubeClient:     kubeClient,
 		provisioners:   provisioners,
 		preferences:    NewPreferences(),
 		volumeTopology: NewVolumeTopology(kubeClient),
+		recorder:       recorder,
 	}
 }
 
@@ -71,8 +79,9 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	if !isProvisionable(pod) {
 		return reconcile.Result{}, nil
 	}
-	if err := validate(pod); err != nil {
+	if err := validate(ctx, pod); err != nil {
 		logging.FromContext(ctx).Errorf("Ignoring pod, %s", err)
+		c.recorder.PodUnsupported(pod, err)
 		return reconcile.Result{}, nil
 	}
 	// Select a provisioner, wait for it to bind the pod, and verify scheduling succeeded in the next loop
@@ -96,15 +105,18 @@ func (c *Controller) selectProvisioner(ctx context.Context, pod *v1.Pod) (errs e
 	if len(provisioners) == 0 {
 		return nil
 	}
-	for _, candidate := range c.provisioners.List(ctx) {
+	exclusions := []events.Exclusion{}
+	for _, candidate := range provisioners {
 		if err := candidate.Spec.DeepCopy().ValidatePod(pod); err != nil {
 			errs = multierr.Append(errs, fmt.Errorf("tried provisioner/%s: %w", candidate.Name, err))
+			exclusions = append(exclusions, events.Exclusion{Provisioner: candidate.Provisioner, Err: err})
 		} else {
 			provisioner = candidate
 			break
 		}
 	}
 	if provisioner == nil {
+		c.recorder.NoCompatibleProvisioners(pod, exclusions)
 		return fmt.Errorf("matched 0/%d provisioners, %w", len(multierr.Errors(errs)), errs)
 	}
 	select {
@@ -114,72 +126,69 @@ func (c *Controller) selectProvisioner(ctx context.Context, pod *v1.Pod) (errs e
 	return nil
 }
 
+// isProvisionable returns true if the pod is waiting for capacity. Pods that
+// are being deleted, e.g. the old replicas of a rolling restart, are skipped
+// since the kube-scheduler won't bind them, so capacity for them would be
+// launched in addition to their replacements'.
 func isProvisionable(p *v1.Pod) bool {
 	return !pod.IsScheduled(p) &&
+		!pod.IsTerminating(p) &&
 		!pod.IsPreempting(p) &&
 		pod.FailedToSchedule(p) &&
 		!pod.IsOwnedByDaemonSet(p) &&
 		!pod.IsOwnedByNode(p)
 }
 
-func validate(p *v1.Pod) error {
-	return multierr.Combine(
-		validateAffinity(p),
-		validateTopology(p),
-	)
-}
-
-func validateTopology(pod *v1.Pod) (errs error) {
-	for _, constraint := range pod.Spec.TopologySpreadConstraints {
-		if supported := sets.NewString(v1.LabelHostname, v1.LabelTopologyZone); !supported.Has(constraint.TopologyKey) {
-			errs = multierr.Append(errs, fmt.Errorf("unsupported topology key, %s not in %s", constraint.TopologyKey, supported))
+// PendingPods maps a provisioner to requests for the pods that are waiting to
+// be provisioned and are compatible with it. Pods are otherwise retried
+// periodically, so this allows a provisioner to be triggered to evaluate them
+// immediately, e.g. after its requirements are changed.
+func (c *Controller) PendingPods(ctx context.Context) handler.MapFunc {
+	return func(o client.Object) (requests []reconcile.Request) {
+		var provisioner *provisioning.Provisioner
+		for _, candidate := range c.provisioners.List(ctx) {
+			if candidate.Name == o.GetName() {
+				provisioner = candidate
+			}
 		}
-	}
-	return errs
-}
-
-func validateAffinity(p *v1.Pod) (errs error) {
-	if p.Spec.Affinity == nil {
-		return nil
-	}
-	if pod.HasPodAffinity(p) {
-		errs = multierr.Append(errs, fmt.Errorf("pod affinity is not supported"))
-	}
-	if pod.HasPodAntiAffinity(p) {
-		errs = multierr.Append(errs, fmt.Errorf("pod anti-affinity is not supported"))
-	}
-	if p.Spec.Affinity.NodeAffinity != nil {
-		for _, term := range p.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
-			errs = multierr.Append(errs, validateNodeSelectorTerm(term.Preference))
+		if provisioner == nil {
+			return nil
 		}
-		if p.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
-			for _, term := range p.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
-				errs = multierr.Append(errs, validateNodeSelectorTerm(term))
-			}
+		pods := &v1.PodList{}
+		if err := c.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": ""}); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to list pods when triggering provisioner %s, %s", o.GetName(), err)
+			return nil
 		}
-	}
-	return errs
-}
-
-func validateNodeSelectorTerm(term v1.NodeSelectorTerm) (errs error) {
-	if term.MatchFields != nil {
-		errs = multierr.Append(errs, fmt.Errorf("node selector term with matchFields is not supported"))
-	}
-	if term.MatchExpressions != nil {
-		for _, requirement := range term.MatchExpressions {
-			if !v1alpha5.SupportedNodeSelectorOps.Has(string(requirement.Operator)) {
-				errs = multierr.Append(errs, fmt.Errorf("node selector term has unsupported operator, %s", requirement.Operator))
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !isProvisionable(pod) || provisioner.Spec.DeepCopy().ValidatePod(pod) != nil {
+				continue
 			}
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
 		}
+		logging.FromContext(ctx).Infof("Triggered provisioner %s with %d pending pod(s)", o.GetName(), len(requests))
+		return requests
 	}
-	return errs
 }
 
-func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
 	return controllerruntime.
 		NewControllerManagedBy(m).
 		Named(controllerName).
 		For(&v1.Pod{}).
+		Watches(
+			// Reconcile pending pods immediately when a provisioner's trigger annotation changes.
+			&source.Kind{Type: &v1alpha5.Provisioner{}},
+			handler.EnqueueRequestsFromMapFunc(c.PendingPods(ctx)),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc:  func(event.CreateEvent) bool { return false },
+				DeleteFunc:  func(event.DeleteEvent) bool { return false },
+				GenericFunc: func(event.GenericEvent) bool { return false },
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					return e.ObjectOld.GetAnnotations()[v1alpha5.TriggerAnnotationKey] != e.ObjectNew.GetAnnotations()[v1alpha5.TriggerAnnotationKey]
+				},
+			}),
+		).
 		WithOptions(controller.Options{MaxConcurrentReconciles: 10_000}).
 		WithLogger(zapr.NewLogger(zap.NewNop())).
 		Complete(c)
@@ -16,6 +16,7 @@ package selection_test
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -25,12 +26,20 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
 
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	. "github.com/aws/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
@@ -43,6 +52,10 @@ var provisioner *v1alpha5.Provisioner
 var provisioners *provisioning.Controller
 var selectionController *selection.Controller
 var env *test.Environment
+var recorder *test.EventRecorder
+
+// cachedClient reads persistent volumes from a cache indexed like the manager's
+var cachedClient client.Client
 
 func TestAPIs(t *testing.T) {
 	ctx = TestContextWithLogger(t)
@@ -54,8 +67,24 @@ var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
 		cloudProvider := &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioners)
+		recorder = test.NewEventRecorder()
+		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, events.NewRecorder(recorder))
+		informers, err := cache.New(e.Config, cache.Options{Scheme: e.Client.Scheme()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(informers.IndexField(e.Ctx, &v1.PersistentVolume{}, "spec.claimRef", func(o client.Object) []string {
+			if claimRef := o.(*v1.PersistentVolume).Spec.ClaimRef; claimRef != nil {
+				return []string{claimRef.Namespace + "/" + claimRef.Name}
+			}
+			return nil
+		})).To(Succeed())
+		go func() { Expect(informers.Start(e.Ctx)).To(Succeed()) }()
+		cachedClient, err = client.NewDelegatingClient(client.NewDelegatingClientInput{
+			CacheReader:     informers,
+			Client:          e.Client,
+			UncachedObjects: []client.Object{&v1.Pod{}, &v1.PersistentVolumeClaim{}, &storagev1.StorageClass{}, &v1alpha5.Provisioner{}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		selectionController = selection.NewController(cachedClient, provisioners, events.NewRecorder(recorder))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -69,6 +98,7 @@ var _ = BeforeEach(func() {
 		ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
 		Spec:       v1alpha5.ProvisionerSpec{},
 	}
+	recorder.Reset()
 })
 
 var _ = AfterEach(func() {
@@ -114,6 +144,7 @@ var _ = Describe("Volume Topology Requirements", func() {
 		persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-3"}})
 		persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: persistentVolume.Name, StorageClassName: &storageClass.Name})
 		ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim, persistentVolume)
+		ExpectPersistentVolumeCached(persistentVolume)
 		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
 			PersistentVolumeClaims: []string{persistentVolumeClaim.Name},
 		}))[0]
@@ -124,6 +155,7 @@ var _ = Describe("Volume Topology Requirements", func() {
 		persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-3"}})
 		persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{VolumeName: persistentVolume.Name, StorageClassName: &storageClass.Name})
 		ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim, persistentVolume)
+		ExpectPersistentVolumeCached(persistentVolume)
 		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
 			PersistentVolumeClaims: []string{persistentVolumeClaim.Name},
 			NodeRequirements: []v1.NodeSelectorRequirement{{
@@ -132,6 +164,105 @@ var _ = Describe("Volume Topology Requirements", func() {
 		}))[0]
 		ExpectNotScheduled(ctx, env.Client, pod)
 	})
+	Context("StatefulSets", func() {
+		var statefulSetPod func() *v1.Pod
+		BeforeEach(func() {
+			statefulSetPod = func() *v1.Pod {
+				return test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{
+					Name:            "web-0",
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "web", UID: "test-uid"}},
+				}})
+			}
+		})
+		It("should schedule to the zone of a volume previously provisioned for the replica", func() {
+			persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				ObjectMeta:       metav1.ObjectMeta{Name: "data-web-0"},
+				StorageClassName: &storageClass.Name,
+			})
+			persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-3"}})
+			persistentVolume.Spec.ClaimRef = &v1.ObjectReference{Namespace: persistentVolumeClaim.Namespace, Name: persistentVolumeClaim.Name}
+			ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim, persistentVolume)
+			ExpectPersistentVolumeCached(persistentVolume)
+			pod := statefulSetPod()
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: persistentVolumeClaim.Name}}}}
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
+		})
+		It("should schedule to the zone of a volume reserved for the replica's claim", func() {
+			persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				ObjectMeta:       metav1.ObjectMeta{Name: "reserved-web-0"},
+				StorageClassName: &storageClass.Name,
+			})
+			ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim)
+			persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-3"}})
+			persistentVolume.Spec.ClaimRef = &v1.ObjectReference{Namespace: persistentVolumeClaim.Namespace, Name: persistentVolumeClaim.Name, UID: persistentVolumeClaim.UID}
+			ExpectCreated(ctx, env.Client, persistentVolume)
+			ExpectPersistentVolumeCached(persistentVolume)
+			pod := statefulSetPod()
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: persistentVolumeClaim.Name}}}}
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
+		})
+		It("should ignore volumes that are still reserved for a deleted claim", func() {
+			persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				ObjectMeta:       metav1.ObjectMeta{Name: "released-web-0"},
+				StorageClassName: &storageClass.Name,
+			})
+			persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-1"}})
+			persistentVolume.Spec.ClaimRef = &v1.ObjectReference{Namespace: persistentVolumeClaim.Namespace, Name: persistentVolumeClaim.Name, UID: "deleted-claim-uid"}
+			ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim, persistentVolume)
+			ExpectPersistentVolumeCached(persistentVolume)
+			pod := statefulSetPod()
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: persistentVolumeClaim.Name}}}}
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, SatisfyAny(Equal("test-zone-2"), Equal("test-zone-3"))))
+		})
+		It("should schedule to the zone of an available volume that was reserved for a deleted claim", func() {
+			persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				ObjectMeta:       metav1.ObjectMeta{Name: "available-web-0"},
+				StorageClassName: &storageClass.Name,
+			})
+			persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-1"}})
+			persistentVolume.Spec.ClaimRef = &v1.ObjectReference{Namespace: persistentVolumeClaim.Namespace, Name: persistentVolumeClaim.Name, UID: "deleted-claim-uid"}
+			ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim, persistentVolume)
+			persistentVolume.Status.Phase = v1.VolumeAvailable
+			ExpectStatusUpdated(ctx, env.Client, persistentVolume)
+			ExpectPersistentVolumeCached(persistentVolume)
+			pod := statefulSetPod()
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: persistentVolumeClaim.Name}}}}
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
+		})
+		It("should schedule to storage class zones if no volume was previously provisioned for the replica", func() {
+			persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				ObjectMeta:       metav1.ObjectMeta{Name: "data-web-0"},
+				StorageClassName: &storageClass.Name,
+			})
+			persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-1"}})
+			persistentVolume.Spec.ClaimRef = &v1.ObjectReference{Namespace: persistentVolumeClaim.Namespace, Name: "data-web-1"}
+			ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim, persistentVolume)
+			ExpectPersistentVolumeCached(persistentVolume)
+			pod := statefulSetPod()
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: persistentVolumeClaim.Name}}}}
+			node := ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, SatisfyAny(Equal("test-zone-2"), Equal("test-zone-3"))))
+		})
+		It("should not predict volume zones for pods that aren't owned by a StatefulSet", func() {
+			persistentVolumeClaim := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				ObjectMeta:       metav1.ObjectMeta{Name: "data-web-0"},
+				StorageClassName: &storageClass.Name,
+			})
+			persistentVolume := test.PersistentVolume(test.PersistentVolumeOptions{Zones: []string{"test-zone-1"}})
+			persistentVolume.Spec.ClaimRef = &v1.ObjectReference{Namespace: persistentVolumeClaim.Namespace, Name: persistentVolumeClaim.Name}
+			ExpectCreated(ctx, env.Client, storageClass, persistentVolumeClaim, persistentVolume)
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+				ObjectMeta:             metav1.ObjectMeta{Name: "web-0"},
+				PersistentVolumeClaims: []string{persistentVolumeClaim.Name},
+			}))[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, SatisfyAny(Equal("test-zone-2"), Equal("test-zone-3"))))
+		})
+	})
 })
 
 var _ = Describe("Preferential Fallback", func() {
@@ -287,6 +418,17 @@ var _ = Describe("Multiple Provisioners", func() {
 		node := ExpectScheduled(ctx, env.Client, pod)
 		Expect(node.Labels[v1alpha5.ProvisionerNameLabelKey]).To(Equal(provisioner2.Name))
 	})
+	It("should not schedule pods below a provisioner's minimum pod priority", func() {
+		high := test.PriorityClass(test.PriorityClassOptions{Value: 1000})
+		ExpectCreated(ctx, env.Client, high)
+		provisioner.Spec.MinPodPriority = ptr.Int32(1000)
+		pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+			test.UnschedulablePod(),
+			test.UnschedulablePod(test.PodOptions{PriorityClassName: high.Name}),
+		)
+		ExpectNotScheduled(ctx, env.Client, pods[0])
+		ExpectScheduled(ctx, env.Client, pods[1])
+	})
 	It("should prioritize provisioners alphabetically if multiple match", func() {
 		provisioner2 := provisioner.DeepCopy()
 		provisioner2.Name = "aaaaaaaaa"
@@ -306,6 +448,69 @@ var _ = Describe("Multiple Provisioners", func() {
 	})
 })
 
+var _ = Describe("Events", func() {
+	It("should explain why each provisioner was excluded if none match", func() {
+		provisioner.Spec.Taints = v1alpha5.Taints{{Key: "foo", Value: "bar", Effect: v1.TaintEffectNoSchedule}}
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "provisioner2"
+		provisioner2.Spec.Taints = nil
+		provisioner2.Spec.Requirements = v1alpha5.NewRequirements(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}})
+		ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner2)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+			test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"}}),
+		)[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		podEvents := recorder.For(pod, events.NoCompatibleProvisioners)
+		Expect(podEvents).To(HaveLen(1))
+		Expect(podEvents[0].Type).To(Equal(v1.EventTypeWarning))
+		Expect(podEvents[0].Message).To(ContainSubstring("Matched 0/2 provisioners"))
+		Expect(podEvents[0].Message).To(ContainSubstring(fmt.Sprintf("provisioner/%s: did not tolerate foo=bar:NoSchedule", provisioner.Name)))
+		Expect(podEvents[0].Message).To(ContainSubstring("provisioner/provisioner2: incompatible requirements"))
+		Expect(podEvents[0].Message).To(ContainSubstring(v1.LabelTopologyZone))
+		Expect(recorder.For(provisioner, events.ExcludedPod)).To(HaveLen(1))
+		Expect(recorder.For(provisioner2, events.ExcludedPod)).To(HaveLen(1))
+	})
+	It("should not explain the same exclusions again when the pod is retried", func() {
+		provisioner.Spec.Taints = v1alpha5.Taints{{Key: "foo", Value: "bar", Effect: v1.TaintEffectNoSchedule}}
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		for i := 0; i < 3; i++ {
+			_, err := selectionController.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			Expect(err).To(HaveOccurred())
+		}
+		Expect(recorder.For(pod, events.NoCompatibleProvisioners)).To(HaveLen(1))
+		Expect(recorder.For(provisioner, events.ExcludedPod)).To(HaveLen(1))
+	})
+	It("should not emit exclusions if a provisioner matches", func() {
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "aaaaaaaaa"
+		provisioner2.Spec.Taints = v1alpha5.Taints{{Key: "foo", Value: "bar", Effect: v1.TaintEffectNoSchedule}}
+		ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner2)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		Expect(recorder.For(pod, events.NoCompatibleProvisioners)).To(BeEmpty())
+		Expect(recorder.For(provisioner2, events.ExcludedPod)).To(BeEmpty())
+	})
+	It("should explain why a pod is unsupported", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			PodRequirements: []v1.PodAffinityTerm{{TopologyKey: "foo"}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		podEvents := recorder.For(pod, events.UnsupportedPod)
+		Expect(podEvents).To(HaveLen(1))
+		Expect(podEvents[0].Message).To(ContainSubstring("pod affinity is not supported"))
+	})
+	It("should explain why a pod didn't fit any instance type", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10000")}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		podEvents := recorder.For(pod, events.InsufficientCapacity)
+		Expect(podEvents).To(HaveLen(1))
+		Expect(podEvents[0].Message).To(ContainSubstring(fmt.Sprintf("provisioner/%s", provisioner.Name)))
+	})
+})
+
 var _ = Describe("Pod Affinity and AntiAffinity", func() {
 	It("should not schedule a pod with pod affinity", func() {
 		ExpectCreated(ctx, env.Client)
@@ -344,3 +549,83 @@ var _ = Describe("Pod Affinity and AntiAffinity", func() {
 		ExpectScheduled(ctx, env.Client, pod)
 	})
 })
+
+var _ = Describe("Unsupported Fields", func() {
+	It("should name the unsupported field", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			PodAntiPreferences: []v1.WeightedPodAffinityTerm{{Weight: 1, PodAffinityTerm: v1.PodAffinityTerm{TopologyKey: "foo"}}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		podEvents := recorder.For(pod, events.UnsupportedPod)
+		Expect(podEvents).To(HaveLen(1))
+		Expect(podEvents[0].Message).To(ContainSubstring("spec.affinity.podAntiAffinity.preferredDuringSchedulingIgnoredDuringExecution"))
+	})
+	It("should not schedule a pod with an unsupported node selector operator", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			NodeRequirements: []v1.NodeSelectorRequirement{{Key: "foo", Operator: v1.NodeSelectorOpGt, Values: []string{"1"}}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.For(pod, events.UnsupportedPod)).To(HaveLen(1))
+	})
+	Context("Ignoring Unsupported Preferences", func() {
+		var ignoreCtx context.Context
+		BeforeEach(func() {
+			ignoreCtx = injection.WithOptions(ctx, options.Options{IgnoreUnsupportedPreferences: true})
+		})
+		It("should schedule a pod with unsupported preferences", func() {
+			pod := ExpectProvisioned(ignoreCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+				PodPreferences:     []v1.WeightedPodAffinityTerm{{Weight: 1, PodAffinityTerm: v1.PodAffinityTerm{TopologyKey: "foo"}}},
+				PodAntiPreferences: []v1.WeightedPodAffinityTerm{{Weight: 1, PodAffinityTerm: v1.PodAffinityTerm{TopologyKey: "foo"}}},
+				TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+					MaxSkew:           1,
+					TopologyKey:       "foo",
+					WhenUnsatisfiable: v1.ScheduleAnyway,
+					LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}},
+				}},
+			}))[0]
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(recorder.For(pod, events.UnsupportedPod)).To(BeEmpty())
+		})
+		It("should not schedule a pod with unsupported requirements", func() {
+			pod := ExpectProvisioned(ignoreCtx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+				PodRequirements: []v1.PodAffinityTerm{{TopologyKey: "foo"}},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(recorder.For(pod, events.UnsupportedPod)).To(HaveLen(1))
+		})
+	})
+})
+
+var _ = Describe("Triggering", func() {
+	It("should map a provisioner to its pending pods", func() {
+		ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner)
+		pending := test.UnschedulablePod()
+		incompatible := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: "other-provisioner"}})
+		scheduled := test.Pod(test.PodOptions{NodeName: "node"})
+		terminating := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Finalizers: []string{"example.com/finalizer"}}})
+		ExpectCreatedWithStatus(ctx, env.Client, pending, incompatible, scheduled, terminating)
+		Expect(env.Client.Delete(ctx, terminating)).To(Succeed())
+
+		requests := selectionController.PendingPods(ctx)(provisioner)
+		Expect(requests).To(ConsistOf(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pending)}))
+
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(terminating), terminating)).To(Succeed())
+		terminating.SetFinalizers([]string{})
+		Expect(env.Client.Update(ctx, terminating)).To(Succeed())
+	})
+	It("should not map provisioners that haven't been applied", func() {
+		ExpectCreatedWithStatus(ctx, env.Client, test.UnschedulablePod())
+		Expect(selectionController.PendingPods(ctx)(provisioner)).To(BeEmpty())
+	})
+})
+
+// ExpectPersistentVolumeCached waits until the selection controller's cache has the volume as it was last written
+func ExpectPersistentVolumeCached(persistentVolume *v1.PersistentVolume) {
+	Eventually(func() string {
+		cached := &v1.PersistentVolume{}
+		if err := cachedClient.Get(ctx, client.ObjectKeyFromObject(persistentVolume), cached); err != nil {
+			return ""
+		}
+		return cached.ResourceVersion
+	}).Should(Equal(persistentVolume.ResourceVersion))
+}
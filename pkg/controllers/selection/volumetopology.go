@@ -17,12 +17,15 @@ package selection
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/utils/pod"
 )
 
 func NewVolumeTopology(kubeClient client.Client) *VolumeTopology {
@@ -79,6 +82,16 @@ func (v *VolumeTopology) getRequirements(ctx context.Context, pod *v1.Pod, volum
 		}
 		return requirements, nil
 	}
+	// Requirements of a volume previously provisioned for the same StatefulSet replica
+	if isStatefulSetClaim(pod, pvc) {
+		pv, err := v.getPreviousPersistentVolume(ctx, pvc)
+		if err != nil {
+			return nil, fmt.Errorf("getting previous persistent volume, %w", err)
+		}
+		if pv != nil {
+			return persistentVolumeRequirements(pv), nil
+		}
+	}
 	// Storage Class Requirements
 	if ptr.StringValue(pvc.Spec.StorageClassName) != "" {
 		requirements, err := v.getStorageClassRequirements(ctx, pvc)
@@ -110,16 +123,54 @@ func (v *VolumeTopology) getPersistentVolumeRequirements(ctx context.Context, po
 	if err := v.kubeClient.Get(ctx, types.NamespacedName{Name: pvc.Spec.VolumeName, Namespace: pod.Namespace}, pv); err != nil {
 		return nil, fmt.Errorf("getting persistent volume %q, %w", pvc.Spec.VolumeName, err)
 	}
+	return persistentVolumeRequirements(pv), nil
+}
+
+// getPreviousPersistentVolume returns the most recently created persistent
+// volume that was claimed by a claim of the same name, e.g. before a
+// StatefulSet was scaled down and its claim deleted, or nil if there is none.
+// StatefulSet claim names are predictable, so the replica's new claim will be
+// bound to a volume in the same zone as its previous volume if it is retained.
+// Volumes that are still reserved for a deleted claim can't be bound to the new
+// claim, so only available volumes, and volumes whose claim reference doesn't
+// name a different claim, are considered. Volumes are listed from the cache by
+// their claim reference.
+func (v *VolumeTopology) getPreviousPersistentVolume(ctx context.Context, pvc *v1.PersistentVolumeClaim) (*v1.PersistentVolume, error) {
+	pvs := &v1.PersistentVolumeList{}
+	if err := v.kubeClient.List(ctx, pvs, client.MatchingFields{"spec.claimRef": pvc.Namespace + "/" + pvc.Name}); err != nil {
+		return nil, fmt.Errorf("listing persistent volumes, %w", err)
+	}
+	var previous *v1.PersistentVolume
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Status.Phase != v1.VolumeAvailable && pv.Spec.ClaimRef.UID != "" && pv.Spec.ClaimRef.UID != pvc.UID {
+			continue
+		}
+		if previous == nil || previous.CreationTimestamp.Before(&pv.CreationTimestamp) {
+			previous = pv
+		}
+	}
+	return previous, nil
+}
+
+func persistentVolumeRequirements(pv *v1.PersistentVolume) []v1.NodeSelectorRequirement {
 	if pv.Spec.NodeAffinity == nil {
-		return nil, nil
+		return nil
 	}
 	if pv.Spec.NodeAffinity.Required == nil {
-		return nil, nil
+		return nil
 	}
 	var requirements []v1.NodeSelectorRequirement
 	if len(pv.Spec.NodeAffinity.Required.NodeSelectorTerms) > 0 {
 		// Terms are ORed, only use the first term
 		requirements = append(requirements, pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions...)
 	}
-	return requirements, nil
+	return requirements
+}
+
+// isStatefulSetClaim returns true if the claim was created from one of the
+// volume claim templates of the StatefulSet that owns the pod. These claims
+// are named <template>-<pod>, where the pod is named <statefulset>-<ordinal>.
+func isStatefulSetClaim(p *v1.Pod, pvc *v1.PersistentVolumeClaim) bool {
+	return pod.IsOwnedByStatefulSet(p) && strings.HasSuffix(pvc.Name, "-"+p.Name)
 }
@@ -0,0 +1,239 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package termination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+)
+
+const (
+	// ArchiveSinkLog writes terminated node records to the controller's log stream
+	ArchiveSinkLog = "log"
+	// ArchiveSinkConfigMap writes terminated node records to a ConfigMap in
+	// the controller's namespace, which keeps them until their TTL expires
+	ArchiveSinkConfigMap = "configmap"
+	// ArchiveConfigMapName is the ConfigMap that terminated node records are archived to
+	ArchiveConfigMapName = "karpenter-terminated-nodes"
+
+	// archiveMaxRecords and archiveMaxBytes bound the ConfigMap well within
+	// the API server's 1MiB object size limit
+	archiveMaxRecords = 500
+	archiveMaxBytes   = 768 * 1024
+)
+
+var archiveLabelKey = v1alpha5.Group + "/terminated-node-record"
+
+// NodeRecord is a compact summary of a terminated node, archived for
+// post-mortem analysis after the node object is gone
+type NodeRecord struct {
+	Name         string    `json:"name"`
+	UID          string    `json:"uid"`
+	ProviderID   string    `json:"providerID,omitempty"`
+	Provisioner  string    `json:"provisioner,omitempty"`
+	InstanceType string    `json:"instanceType,omitempty"`
+	Zone         string    `json:"zone,omitempty"`
+	CapacityType string    `json:"capacityType,omitempty"`
+	Created      time.Time `json:"created"`
+	Terminated   time.Time `json:"terminated"`
+	Lifetime     string    `json:"lifetime"`
+	// Reason is the reason the node was deprovisioned, or "deleted" if it was
+	// deleted by something other than Karpenter
+	Reason      string   `json:"reason"`
+	EvictedPods []string `json:"evictedPods,omitempty"`
+	// EstimatedCost is the instance type's hourly price times the node's
+	// lifetime, if the cloud provider reports prices
+	EstimatedCost *float64 `json:"estimatedCost,omitempty"`
+}
+
+// key identifies the record in the ConfigMap archive
+func (r *NodeRecord) key() string {
+	return r.Name + "." + r.UID
+}
+
+// Archive persists the records of terminated nodes
+type Archive interface {
+	Archive(context.Context, *NodeRecord) error
+}
+
+// NewNodeRecord summarizes the node, which is being terminated now
+func NewNodeRecord(node *v1.Node, evictedPods []string, instanceType cloudprovider.InstanceType) *NodeRecord {
+	now := injectabletime.Now()
+	record := &NodeRecord{
+		Name:         node.Name,
+		UID:          string(node.UID),
+		ProviderID:   node.Spec.ProviderID,
+		Provisioner:  node.Labels[v1alpha5.ProvisionerNameLabelKey],
+		InstanceType: node.Labels[v1.LabelInstanceTypeStable],
+		Zone:         node.Labels[v1.LabelTopologyZone],
+		CapacityType: node.Labels[v1alpha5.LabelCapacityType],
+		Created:      node.CreationTimestamp.Time,
+		Terminated:   now,
+		Lifetime:     now.Sub(node.CreationTimestamp.Time).Round(time.Second).String(),
+		Reason:       "deleted",
+		EvictedPods:  evictedPods,
+	}
+	if reason, ok := node.Annotations[v1alpha5.DeprovisioningReasonAnnotationKey]; ok {
+		record.Reason = reason
+	} else if reason, ok := node.Annotations[v1alpha5.DeprovisioningCandidateAnnotationKey]; ok {
+		record.Reason = reason
+	}
+	if priced, ok := instanceType.(cloudprovider.PricedInstanceType); ok {
+		if price, ok := priced.Price(); ok {
+			cost := price * now.Sub(node.CreationTimestamp.Time).Hours()
+			record.EstimatedCost = &cost
+		}
+	}
+	return record
+}
+
+// LogArchive writes records to the controller's log stream, for collection by
+// the cluster's log pipeline
+type LogArchive struct{}
+
+func (LogArchive) Archive(ctx context.Context, record *NodeRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshaling node record, %w", err)
+	}
+	logging.FromContext(ctx).Named("archive").With("record", string(data)).Infof("Archived terminated node")
+	return nil
+}
+
+// ConfigMapArchive writes records to a single ConfigMap in the namespace, keyed
+// by node, so that archiving the same node again replaces its record. Records
+// older than the TTL are dropped whenever a record is written, and the oldest
+// records are dropped to keep the ConfigMap within its bounds.
+type ConfigMapArchive struct {
+	coreV1Client corev1.CoreV1Interface
+	namespace    string
+	ttl          time.Duration
+
+	mu sync.Mutex
+}
+
+// NewConfigMapArchive returns an archive that's persisted to a ConfigMap in the namespace
+func NewConfigMapArchive(coreV1Client corev1.CoreV1Interface, namespace string, ttl time.Duration) *ConfigMapArchive {
+	return &ConfigMapArchive{coreV1Client: coreV1Client, namespace: namespace, ttl: ttl}
+}
+
+func (a *ConfigMapArchive) Archive(ctx context.Context, record *NodeRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshaling node record, %w", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	configMap, err := a.coreV1Client.ConfigMaps(a.namespace).Get(ctx, ArchiveConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("getting configmap %s, %w", ArchiveConfigMapName, err)
+		}
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ArchiveConfigMapName,
+				Namespace: a.namespace,
+				Labels:    map[string]string{archiveLabelKey: "true"},
+			},
+			Data: a.bound(ctx, map[string]string{record.key(): string(data)}),
+		}
+		if _, err := a.coreV1Client.ConfigMaps(a.namespace).Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("creating configmap %s, %w", ArchiveConfigMapName, err)
+		}
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[record.key()] = string(data)
+	configMap.Data = a.bound(ctx, configMap.Data)
+	if _, err := a.coreV1Client.ConfigMaps(a.namespace).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("updating configmap %s, %w", ArchiveConfigMapName, err)
+	}
+	return nil
+}
+
+// Records returns the unexpired records in the archive, oldest first
+func (a *ConfigMapArchive) Records(ctx context.Context) ([]*NodeRecord, error) {
+	configMap, err := a.coreV1Client.ConfigMaps(a.namespace).Get(ctx, ArchiveConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting configmap %s, %w", ArchiveConfigMapName, err)
+	}
+	records := []*NodeRecord{}
+	for _, record := range a.parse(ctx, configMap.Data) {
+		if !a.expired(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// bound drops expired and unparseable records, then the oldest records until
+// the archive is within archiveMaxRecords and archiveMaxBytes
+func (a *ConfigMapArchive) bound(ctx context.Context, data map[string]string) map[string]string {
+	bounded := map[string]string{}
+	size := 0
+	for _, record := range a.parse(ctx, data) {
+		if a.expired(record) {
+			continue
+		}
+		bounded[record.key()] = data[record.key()]
+		size += len(record.key()) + len(data[record.key()])
+	}
+	for _, record := range a.parse(ctx, bounded) {
+		if len(bounded) <= archiveMaxRecords && size <= archiveMaxBytes {
+			break
+		}
+		size -= len(record.key()) + len(bounded[record.key()])
+		delete(bounded, record.key())
+	}
+	return bounded
+}
+
+// parse returns the records in the data, oldest first
+func (a *ConfigMapArchive) parse(ctx context.Context, data map[string]string) []*NodeRecord {
+	records := []*NodeRecord{}
+	for key, value := range data {
+		record := &NodeRecord{}
+		if err := json.Unmarshal([]byte(value), record); err != nil || record.key() != key {
+			logging.FromContext(ctx).Errorf("Dropping invalid node record %s, %v", key, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Terminated.Before(records[j].Terminated) })
+	return records
+}
+
+func (a *ConfigMapArchive) expired(record *NodeRecord) bool {
+	return a.ttl > 0 && injectabletime.Now().Sub(record.Terminated) > a.ttl
+}
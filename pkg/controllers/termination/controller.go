@@ -17,6 +17,7 @@ package termination
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -26,6 +27,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/util/workqueue"
+	"knative.dev/pkg/system"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -34,6 +36,7 @@ import (
 
 	provisioning "github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
 )
@@ -47,7 +50,7 @@ type Controller struct {
 }
 
 // NewController constructs a controller instance
-func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider) *Controller {
+func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, recorder events.Recorder) *Controller {
 	return &Controller{
 		KubeClient: kubeClient,
 		Terminator: &Terminator{
@@ -55,10 +58,31 @@ func NewController(ctx context.Context, kubeClient client.Client, coreV1Client c
 			CoreV1Client:  coreV1Client,
 			CloudProvider: cloudProvider,
 			EvictionQueue: NewEvictionQueue(ctx, coreV1Client),
+			Recorder:      recorder,
+			Archive:       newArchive(ctx, coreV1Client),
 		},
 	}
 }
 
+// newArchive returns the archive selected by --terminated-node-archive, or nil
+// if terminated nodes aren't archived
+func newArchive(ctx context.Context, coreV1Client corev1.CoreV1Interface) Archive {
+	opts := injection.GetOptions(ctx)
+	switch opts.TerminatedNodeArchive {
+	case ArchiveSinkLog:
+		return LogArchive{}
+	case ArchiveSinkConfigMap:
+		// Records are written to the controller's namespace, if it's running in a cluster
+		if namespace := os.Getenv(system.NamespaceEnvKey); namespace != "" {
+			return NewConfigMapArchive(coreV1Client, namespace, opts.TerminatedNodeArchiveTTL)
+		}
+		logging.FromContext(ctx).Warnf("Archiving terminated nodes to the log stream, since the controller's namespace is unknown")
+		return LogArchive{}
+	default:
+		return nil
+	}
+}
+
 // Reconcile executes a termination control loop for the resource
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName).With("node", req.Name))
@@ -82,7 +106,7 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, fmt.Errorf("cordoning node %s, %w", node.Name, err)
 	}
 	// 4. Drain node
-	drained, err := c.Terminator.drain(ctx, node)
+	drained, err := c.Terminator.Drain(ctx, node)
 	if err != nil {
 		return reconcile.Result{}, fmt.Errorf("draining node %s, %w", node.Name, err)
 	}
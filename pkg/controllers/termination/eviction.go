@@ -16,6 +16,7 @@ package termination
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	set "github.com/deckarep/golang-set"
@@ -32,7 +33,9 @@ import (
 
 const (
 	evictionQueueBaseDelay = 100 * time.Millisecond
-	evictionQueueMaxDelay  = 10 * time.Second
+	// evictionQueueMaxDelay bounds the exponential backoff of evictions that
+	// keep failing, e.g. while they'd violate a pod disruption budget
+	evictionQueueMaxDelay = 2 * time.Minute
 )
 
 type EvictionQueue struct {
@@ -40,6 +43,12 @@ type EvictionQueue struct {
 	set.Set
 
 	coreV1Client corev1.CoreV1Interface
+
+	mu sync.RWMutex
+	// blocked are the reasons that pods' most recent evictions were rejected
+	blocked map[types.NamespacedName]error
+	// reported are the blocked pods whose reasons have been reported
+	reported map[types.NamespacedName]bool
 }
 
 func NewEvictionQueue(ctx context.Context, coreV1Client corev1.CoreV1Interface) *EvictionQueue {
@@ -48,6 +57,8 @@ func NewEvictionQueue(ctx context.Context, coreV1Client corev1.CoreV1Interface)
 		Set:                   set.NewSet(),
 
 		coreV1Client: coreV1Client,
+		blocked:      map[types.NamespacedName]error{},
+		reported:     map[types.NamespacedName]bool{},
 	}
 	go queue.Start(logging.WithLogger(ctx, logging.FromContext(ctx).Named("eviction")))
 	return queue
@@ -85,6 +96,14 @@ func (e *EvictionQueue) Start(ctx context.Context) {
 	logging.FromContext(ctx).Errorf("EvictionQueue is broken and has shutdown")
 }
 
+// Blocked returns the reason that the pod's most recent eviction was rejected,
+// or nil if it wasn't
+func (e *EvictionQueue) Blocked(pod *v1.Pod) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.blocked[client.ObjectKeyFromObject(pod)]
+}
+
 // evict returns true if successful eviction call, error is returned if not eviction-related error
 func (e *EvictionQueue) evict(ctx context.Context, nn types.NamespacedName) bool {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("pod", nn))
@@ -92,16 +111,53 @@ func (e *EvictionQueue) evict(ctx context.Context, nn types.NamespacedName) bool
 		ObjectMeta: metav1.ObjectMeta{Name: nn.Name, Namespace: nn.Namespace},
 	})
 	if errors.IsNotFound(err) { // 404
+		e.unblock(nn)
 		return true
 	}
 	if errors.IsTooManyRequests(err) { // 429
-		logging.FromContext(ctx).Debug(err)
+		// Only the first rejection is logged, since the eviction is retried until the budget allows it
+		if e.block(nn, err) {
+			logging.FromContext(ctx).Infof("Eviction blocked, retrying with backoff, %s", err)
+		}
 		return false
 	}
 	if err != nil {
 		logging.FromContext(ctx).Error(err)
 		return false
 	}
+	e.unblock(nn)
 	logging.FromContext(ctx).Debug("Evicted pod")
 	return true
 }
+
+// Unreported returns the reason that the pod's eviction was rejected the first
+// time it's called after the pod is blocked, or nil, so that each block is only
+// reported once however many times the pod's eviction is retried
+func (e *EvictionQueue) Unreported(pod *v1.Pod) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	nn := client.ObjectKeyFromObject(pod)
+	err, blocked := e.blocked[nn]
+	if !blocked || e.reported[nn] {
+		return nil
+	}
+	e.reported[nn] = true
+	return err
+}
+
+// block records the reason the pod's eviction was rejected, and returns true if
+// it wasn't already blocked
+func (e *EvictionQueue) block(nn types.NamespacedName, err error) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, blocked := e.blocked[nn]
+	e.blocked[nn] = err
+	return !blocked
+}
+
+func (e *EvictionQueue) unblock(nn types.NamespacedName) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.blocked, nn)
+	delete(e.reported, nn)
+}
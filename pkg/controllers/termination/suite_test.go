@@ -17,14 +17,17 @@ package termination_test
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/termination"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
@@ -35,6 +38,7 @@ import (
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	. "knative.dev/pkg/logging/testing"
@@ -44,7 +48,10 @@ import (
 var ctx context.Context
 var controller *termination.Controller
 var evictionQueue *termination.EvictionQueue
+var recorder *test.EventRecorder
 var env *test.Environment
+var cloudProvider *fake.CloudProvider
+var coreV1Client corev1.CoreV1Interface
 
 func TestAPIs(t *testing.T) {
 	ctx = TestContextWithLogger(t)
@@ -54,10 +61,11 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		cloudProvider := &fake.CloudProvider{}
+		cloudProvider = &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		coreV1Client := corev1.NewForConfigOrDie(e.Config)
+		coreV1Client = corev1.NewForConfigOrDie(e.Config)
 		evictionQueue = termination.NewEvictionQueue(ctx, coreV1Client)
+		recorder = test.NewEventRecorder()
 		controller = &termination.Controller{
 			KubeClient: e.Client,
 			Terminator: &termination.Terminator{
@@ -65,6 +73,7 @@ var _ = BeforeSuite(func() {
 				CoreV1Client:  coreV1Client,
 				CloudProvider: cloudProvider,
 				EvictionQueue: evictionQueue,
+				Recorder:      events.NewRecorder(recorder),
 			},
 		}
 	})
@@ -84,6 +93,7 @@ var _ = Describe("Termination", func() {
 
 	AfterEach(func() {
 		ExpectCleanedUp(ctx, env.Client)
+		recorder.Reset()
 		injectabletime.Now = time.Now
 	})
 
@@ -95,6 +105,24 @@ var _ = Describe("Termination", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
 			ExpectNotFound(ctx, env.Client, node)
 		})
+		It("should clean up the resources of a deleted provisioner after its last node", func() {
+			provisionerName := strings.ToLower(randomdata.SillyName())
+			node.Labels = map[string]string{v1alpha5.ProvisionerNameLabelKey: provisionerName}
+			other := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Labels:     map[string]string{v1alpha5.OrphanedProvisionerNameLabelKey: provisionerName},
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+			}})
+			ExpectCreated(ctx, env.Client, node, other)
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotFound(ctx, env.Client, node)
+			Expect(cloudProvider.CleanedUp).ToNot(ContainElement(provisionerName))
+
+			Expect(env.Client.Delete(ctx, other)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(other))
+			ExpectNotFound(ctx, env.Client, other)
+			Expect(cloudProvider.CleanedUp).To(ContainElement(provisionerName))
+		})
 		It("should not evict pods that tolerate unschedulable taint", func() {
 			podEvict := test.Pod(test.PodOptions{NodeName: node.Name})
 			podSkip := test.Pod(test.PodOptions{
@@ -138,6 +166,8 @@ var _ = Describe("Termination", func() {
 
 			// Expect node to exist and be draining
 			ExpectNodeDraining(env.Client, node.Name)
+			Expect(recorder.For(node, events.DrainBlocked)).To(HaveLen(1))
+			Expect(recorder.For(podNoEvict, events.DrainBlocked)).To(HaveLen(1))
 
 			// Delete do-not-evict pod
 			ExpectDeleted(ctx, env.Client, podNoEvict)
@@ -157,6 +187,28 @@ var _ = Describe("Termination", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
 			ExpectNotFound(ctx, env.Client, node)
 		})
+		It("should not be blocked by do-not-evict pods that have completed", func() {
+			podCompleted := test.Pod(test.PodOptions{
+				NodeName:   node.Name,
+				Phase:      v1.PodSucceeded,
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DoNotEvictPodAnnotationKey: "true"}},
+			})
+			ExpectCreated(ctx, env.Client, node)
+			ExpectCreatedWithStatus(ctx, env.Client, podCompleted)
+
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+
+			// Expect the completed pod to be evicted rather than blocking the drain, which deletes it immediately
+			Expect(recorder.For(node, events.DrainBlocked)).To(BeEmpty())
+			ExpectNotFound(ctx, env.Client, podCompleted)
+
+			// Reconcile to delete node
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotFound(ctx, env.Client, node)
+		})
 		It("should delete nodes that have do-not-evict on pods for which it does not apply", func() {
 			ExpectCreated(ctx, env.Client, node)
 			node = ExpectNodeExists(ctx, env.Client, node.Name)
@@ -228,6 +280,90 @@ var _ = Describe("Termination", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
 			ExpectNotFound(ctx, env.Client, node)
 		})
+		It("should emit events for evictions blocked by a PDB", func() {
+			minAvailable := intstr.FromInt(1)
+			labelSelector := map[string]string{randomdata.SillyName(): randomdata.SillyName()}
+			pdb := test.PodDisruptionBudget(test.PDBOptions{Labels: labelSelector, MinAvailable: &minAvailable})
+			podNoEvict := test.Pod(test.PodOptions{
+				NodeName:   node.Name,
+				ObjectMeta: metav1.ObjectMeta{Labels: labelSelector},
+				Phase:      v1.PodRunning,
+			})
+			ExpectCreated(ctx, env.Client, node)
+			ExpectCreatedWithStatus(ctx, env.Client, podNoEvict, pdb)
+
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			Eventually(func() error {
+				return evictionQueue.Blocked(podNoEvict)
+			}).Should(HaveOccurred())
+
+			// Reconcile to surface the blocked eviction
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			Expect(recorder.For(node, events.EvictionBlocked)).To(HaveLen(1))
+			Expect(recorder.For(podNoEvict, events.EvictionBlocked)).To(HaveLen(1))
+
+			// The block is only surfaced once, however many times the drain is requeued
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			Expect(recorder.For(node, events.EvictionBlocked)).To(HaveLen(1))
+			Expect(recorder.For(podNoEvict, events.EvictionBlocked)).To(HaveLen(1))
+			ExpectDeleted(ctx, env.Client, podNoEvict)
+		})
+		It("should force delete pods once the termination grace period elapses", func() {
+			provisioner := &v1alpha5.Provisioner{
+				ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
+				Spec:       v1alpha5.ProvisionerSpec{TerminationGracePeriod: &metav1.Duration{Duration: time.Minute}},
+			}
+			node = test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			}})
+			minAvailable := intstr.FromInt(1)
+			labelSelector := map[string]string{randomdata.SillyName(): randomdata.SillyName()}
+			pdb := test.PodDisruptionBudget(test.PDBOptions{Labels: labelSelector, MinAvailable: &minAvailable})
+			podNoEvict := test.Pod(test.PodOptions{
+				NodeName:   node.Name,
+				ObjectMeta: metav1.ObjectMeta{Labels: labelSelector},
+				Phase:      v1.PodRunning,
+			})
+			// The pod takes longer to shut down than the node's termination grace period
+			podNoEvict.Spec.TerminationGracePeriodSeconds = ptr.Int64(300)
+			ExpectCreated(ctx, env.Client, provisioner, node)
+			ExpectCreatedWithStatus(ctx, env.Client, podNoEvict, pdb)
+
+			// Before the grace period, the pod isn't evicted due to the PDB
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNodeDraining(env.Client, node.Name)
+			ExpectPodExists(ctx, env.Client, podNoEvict.Name, podNoEvict.Namespace)
+
+			// After the grace period, the pod is deleted with its own termination grace period
+			injectabletime.Now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			deleted := ExpectPodExists(ctx, env.Client, podNoEvict.Name, podNoEvict.Namespace)
+			Expect(deleted.DeletionTimestamp.IsZero()).To(BeFalse())
+			Expect(ptr.Int64Value(deleted.DeletionGracePeriodSeconds)).To(BeNumerically("==", 300))
+			Expect(recorder.For(node, events.ForceDeleted)).To(HaveLen(1))
+
+			// The node isn't deleted until the pod terminates, and the pod isn't deleted again
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNodeExists(ctx, env.Client, node.Name)
+			Expect(recorder.For(node, events.ForceDeleted)).To(HaveLen(1))
+
+			// Simulate the kubelet terminating the pod
+			ExpectDeleted(ctx, env.Client, podNoEvict)
+
+			// Reconcile to delete node
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotFound(ctx, env.Client, node)
+		})
 		It("should evict non-critical pods first", func() {
 			podEvict := test.Pod(test.PodOptions{NodeName: node.Name})
 			podNodeCritical := test.Pod(test.PodOptions{NodeName: node.Name, PriorityClassName: "system-node-critical"})
@@ -351,8 +487,176 @@ var _ = Describe("Termination", func() {
 			ExpectNotFound(ctx, env.Client, node)
 		})
 	})
+	Context("Archive", func() {
+		var archive *recordingArchive
+		BeforeEach(func() {
+			archive = &recordingArchive{}
+			controller.Terminator.Archive = archive
+		})
+		AfterEach(func() {
+			controller.Terminator.Archive = nil
+			cloudProvider.InstanceTypes = nil
+		})
+		It("should archive a record of terminated nodes", func() {
+			provisioner := &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())}}
+			cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+				fake.NewInstanceType(fake.InstanceTypeOptions{Name: "priced-instance-type", Price: ptr.Float64(0.5)}),
+			}
+			node = test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+					v1.LabelInstanceTypeStable:       "priced-instance-type",
+					v1.LabelTopologyZone:             "test-zone-1",
+					v1alpha5.LabelCapacityType:       "spot",
+				},
+				Annotations: map[string]string{v1alpha5.DeprovisioningReasonAnnotationKey: "expired"},
+			}})
+			pod := test.Pod(test.PodOptions{NodeName: node.Name})
+			ExpectCreated(ctx, env.Client, provisioner, node, pod)
+
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectEvicted(env.Client, pod)
+			ExpectDeleted(ctx, env.Client, pod)
+
+			// Terminate the node an hour after it was created
+			injectabletime.Now = func() time.Time { return node.CreationTimestamp.Add(time.Hour) }
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotFound(ctx, env.Client, node)
+
+			Expect(archive.records).To(HaveLen(1))
+			record := archive.records[0]
+			Expect(record.Name).To(Equal(node.Name))
+			Expect(record.Provisioner).To(Equal(provisioner.Name))
+			Expect(record.InstanceType).To(Equal("priced-instance-type"))
+			Expect(record.Zone).To(Equal("test-zone-1"))
+			Expect(record.CapacityType).To(Equal("spot"))
+			Expect(record.Lifetime).To(Equal("1h0m0s"))
+			Expect(record.Reason).To(Equal("expired"))
+			Expect(record.EvictedPods).To(ConsistOf(client.ObjectKeyFromObject(pod).String()))
+			Expect(record.EstimatedCost).ToNot(BeNil())
+			Expect(*record.EstimatedCost).To(BeNumerically("~", 0.5, 0.001))
+		})
+		It("should archive nodes deleted by something other than Karpenter", func() {
+			ExpectCreated(ctx, env.Client, node)
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotFound(ctx, env.Client, node)
+
+			Expect(archive.records).To(HaveLen(1))
+			Expect(archive.records[0].Reason).To(Equal("deleted"))
+			Expect(archive.records[0].EvictedPods).To(BeEmpty())
+			Expect(archive.records[0].EstimatedCost).To(BeNil())
+		})
+		It("should not archive nodes that aren't terminated", func() {
+			pod := test.Pod(test.PodOptions{NodeName: node.Name})
+			ExpectCreated(ctx, env.Client, node, pod)
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNodeDraining(env.Client, node.Name)
+			Expect(archive.records).To(BeEmpty())
+		})
+		It("should archive the record before removing the finalizer", func() {
+			controller.Terminator.Archive = failingArchive{}
+			ExpectCreated(ctx, env.Client, node)
+			Expect(env.Client.Delete(ctx, node)).To(Succeed())
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			ExpectReconcileFailed(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNodeExists(ctx, env.Client, node.Name)
+
+			controller.Terminator.Archive = archive
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+			ExpectNotFound(ctx, env.Client, node)
+			Expect(archive.records).To(HaveLen(1))
+		})
+		Context("ConfigMap", func() {
+			var configMapArchive *termination.ConfigMapArchive
+			BeforeEach(func() {
+				configMapArchive = termination.NewConfigMapArchive(coreV1Client, "default", time.Hour)
+			})
+			AfterEach(func() {
+				Expect(client.IgnoreNotFound(coreV1Client.ConfigMaps("default").Delete(ctx, termination.ArchiveConfigMapName, metav1.DeleteOptions{}))).To(Succeed())
+			})
+			It("should archive records to a single configmap", func() {
+				ExpectCreated(ctx, env.Client, node)
+				other := test.Node()
+				ExpectCreated(ctx, env.Client, other)
+				Expect(configMapArchive.Archive(ctx, termination.NewNodeRecord(node, nil, nil))).To(Succeed())
+				Expect(configMapArchive.Archive(ctx, termination.NewNodeRecord(other, nil, nil))).To(Succeed())
+				// Archiving a node again replaces its record
+				Expect(configMapArchive.Archive(ctx, termination.NewNodeRecord(node, nil, nil))).To(Succeed())
+
+				records, err := configMapArchive.Records(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(records).To(HaveLen(2))
+				configMaps, err := coreV1Client.ConfigMaps("default").List(ctx, metav1.ListOptions{LabelSelector: v1alpha5.Group + "/terminated-node-record"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(configMaps.Items).To(HaveLen(1))
+				Expect(configMaps.Items[0].Data).To(HaveLen(2))
+			})
+			It("should drop records from the configmap archive once they expire", func() {
+				ExpectCreated(ctx, env.Client, node)
+				Expect(configMapArchive.Archive(ctx, termination.NewNodeRecord(node, nil, nil))).To(Succeed())
+				records, err := configMapArchive.Records(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].Name).To(Equal(node.Name))
+
+				// Expired records are ignored, and dropped when the next record is archived
+				injectabletime.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+				records, err = configMapArchive.Records(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(records).To(BeEmpty())
+				other := test.Node()
+				ExpectCreated(ctx, env.Client, other)
+				Expect(configMapArchive.Archive(ctx, termination.NewNodeRecord(other, nil, nil))).To(Succeed())
+				configMap, err := coreV1Client.ConfigMaps("default").Get(ctx, termination.ArchiveConfigMapName, metav1.GetOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(configMap.Data).To(HaveLen(1))
+			})
+			It("should drop the oldest records to keep the configmap bounded", func() {
+				for i := 0; i < 501; i++ {
+					injectabletime.Now = func() time.Time { return time.Now().Add(time.Duration(i) * time.Second) }
+					n := test.Node()
+					n.UID = types.UID(fmt.Sprint(i))
+					Expect(configMapArchive.Archive(ctx, termination.NewNodeRecord(n, nil, nil))).To(Succeed())
+					if i == 0 {
+						node = n
+					}
+				}
+				records, err := configMapArchive.Records(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(records).To(HaveLen(500))
+				for _, record := range records {
+					Expect(record.Name).ToNot(Equal(node.Name))
+				}
+			})
+		})
+	})
 })
 
+// recordingArchive keeps the records it's given in memory
+type recordingArchive struct {
+	records []*termination.NodeRecord
+}
+
+func (a *recordingArchive) Archive(_ context.Context, record *termination.NodeRecord) error {
+	a.records = append(a.records, record)
+	return nil
+}
+
+// failingArchive fails to archive every record
+type failingArchive struct{}
+
+func (failingArchive) Archive(context.Context, *termination.NodeRecord) error {
+	return fmt.Errorf("failed to archive")
+}
+
 func ExpectNotEnqueuedForEviction(e *termination.EvictionQueue, pods ...*v1.Pod) {
 	for _, pod := range pods {
 		Expect(e.Contains(client.ObjectKeyFromObject(pod))).To(BeFalse())
@@ -17,15 +17,21 @@ package termination
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
 	"github.com/aws/karpenter/pkg/utils/pod"
@@ -37,6 +43,13 @@ type Terminator struct {
 	KubeClient    client.Client
 	CoreV1Client  corev1.CoreV1Interface
 	CloudProvider cloudprovider.CloudProvider
+	Recorder      events.Recorder
+	// Archive persists a record of each terminated node, if set
+	Archive Archive
+
+	mu sync.Mutex
+	// evicted are the pods evicted from each draining node, for its record
+	evicted map[types.UID]sets.String
 }
 
 // cordon cordons a node
@@ -55,42 +68,194 @@ func (t *Terminator) cordon(ctx context.Context, node *v1.Node) error {
 	return nil
 }
 
-// drain evicts pods from the node and returns true when all pods are evicted
+// Drain evicts pods from the node and returns true when all pods are evicted.
+// Evictions respect pod disruption budgets, and are retried until they succeed
+// or the node's termination grace period elapses, after which the remaining
+// pods are force deleted.
 // https://kubernetes.io/docs/concepts/architecture/nodes/#graceful-node-shutdown
-func (t *Terminator) drain(ctx context.Context, node *v1.Node) (bool, error) {
+func (t *Terminator) Drain(ctx context.Context, node *v1.Node) (bool, error) {
 	// Get evictable pods
 	pods, err := t.getPods(ctx, node)
 	if err != nil {
 		return false, fmt.Errorf("listing pods for node, %w", err)
 	}
-	// Skip node due to do-not-evict
-	for _, pod := range pods {
-		if val := pod.Annotations[v1alpha5.DoNotEvictPodAnnotationKey]; val == "true" {
-			logging.FromContext(ctx).Debugf("Unable to drain node, pod %s/%s has do-not-evict annotation", pod.Namespace, pod.Name)
+	// Force delete the remaining pods once the termination grace period of a deleted node elapses
+	if !node.DeletionTimestamp.IsZero() {
+		if gracePeriod := t.terminationGracePeriod(ctx, node); gracePeriod != nil && injectabletime.Now().After(node.DeletionTimestamp.Add(gracePeriod.Duration)) {
+			if err := t.forceDelete(ctx, node, pods, gracePeriod.Duration); err != nil {
+				return false, err
+			}
+			return len(pods) == 0, nil
+		}
+	}
+	// Skip node due to do-not-evict until the pod completes
+	for _, p := range pods {
+		if pod.HasDoNotEvict(p) && !pod.IsTerminal(p) {
+			logging.FromContext(ctx).Debugf("Unable to drain node, pod %s/%s has do-not-evict annotation", p.Namespace, p.Name)
+			t.Recorder.DrainBlocked(node, p)
 			return false, nil
 		}
 	}
 	// Enqueue for eviction
+	t.recordEvicted(node, pods)
 	t.evict(pods)
+	// Surface the evictions that are blocked, e.g. by pod disruption budgets, once per block
+	for _, p := range pods {
+		if err := t.EvictionQueue.Unreported(p); err != nil {
+			t.Recorder.EvictionBlocked(node, p, err)
+		}
+	}
 	return len(pods) == 0, nil
 }
 
-// terminate calls cloud provider delete then removes the finalizer to delete the node
+// terminationGracePeriod returns the termination grace period of the node's
+// provisioner, or nil if the node is drained until all of its pods are evicted
+func (t *Terminator) terminationGracePeriod(ctx context.Context, node *v1.Node) *metav1.Duration {
+	name, ok := node.Labels[v1alpha5.ProvisionerNameLabelKey]
+	if !ok {
+		return nil
+	}
+	provisioner := &v1alpha5.Provisioner{}
+	if err := t.KubeClient.Get(ctx, types.NamespacedName{Name: name}, provisioner); err != nil {
+		if !errors.IsNotFound(err) {
+			logging.FromContext(ctx).Errorf("Getting termination grace period, %s", err)
+		}
+		return nil
+	}
+	return provisioner.Spec.TerminationGracePeriod
+}
+
+// forceDelete deletes the pods without respecting pod disruption budgets. The
+// pods' own termination grace periods are respected, so that they can shut down
+// gracefully, and the node is drained once they have. Pods that are already
+// terminating aren't deleted again.
+func (t *Terminator) forceDelete(ctx context.Context, node *v1.Node, pods []*v1.Pod, gracePeriod time.Duration) error {
+	t.recordEvicted(node, pods)
+	for _, p := range pods {
+		if !p.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if err := t.KubeClient.Delete(ctx, p); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("force deleting pod %s/%s, %w", p.Namespace, p.Name, err)
+		}
+		logging.FromContext(ctx).Infof("Force deleted pod %s/%s after the termination grace period of %s", p.Namespace, p.Name, gracePeriod)
+		t.Recorder.ForceDeleted(node, p, gracePeriod)
+	}
+	return nil
+}
+
+// terminate calls cloud provider delete, archives a record of the node, then
+// removes the finalizer to delete the node
 func (t *Terminator) terminate(ctx context.Context, node *v1.Node) error {
 	// 1. Delete the instance associated with node
 	if err := t.CloudProvider.Delete(ctx, node); err != nil {
 		return fmt.Errorf("terminating cloudprovider instance, %w", err)
 	}
-	// 2. Remove finalizer from node in APIServer
+	// 2. Archive a record of the node while the finalizer still holds it, so
+	// that the record isn't lost if the controller restarts. Records are keyed
+	// by node, so archiving it again on a retry replaces the record.
+	if err := t.archive(ctx, node); err != nil {
+		return fmt.Errorf("archiving node record, %w", err)
+	}
+	// 3. Remove finalizer from node in APIServer
 	persisted := node.DeepCopy()
 	node.Finalizers = functional.StringSliceWithout(node.Finalizers, v1alpha5.TerminationFinalizer)
 	if err := t.KubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
 		if errors.IsNotFound(err) {
+			t.forgetEvicted(node)
 			return nil
 		}
 		return fmt.Errorf("removing finalizer from node, %w", err)
 	}
+	t.forgetEvicted(node)
 	logging.FromContext(ctx).Infof("Deleted node")
+	// 4. Clean up the resources of a deleted provisioner after its last node
+	if err := t.cleanup(ctx, node); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to clean up cloudprovider resources, %s", err)
+	}
+	return nil
+}
+
+// cleanup deletes the cloud provider's resources for the node's provisioner if
+// the provisioner was deleted while orphaning its nodes and the node was the
+// last of them
+func (t *Terminator) cleanup(ctx context.Context, node *v1.Node) error {
+	name, ok := node.Labels[v1alpha5.OrphanedProvisionerNameLabelKey]
+	if !ok {
+		if name, ok = node.Labels[v1alpha5.ProvisionerNameLabelKey]; !ok {
+			return nil
+		}
+	}
+	if err := t.KubeClient.Get(ctx, types.NamespacedName{Name: name}, &v1alpha5.Provisioner{}); !errors.IsNotFound(err) {
+		return err
+	}
+	for _, key := range []string{v1alpha5.ProvisionerNameLabelKey, v1alpha5.OrphanedProvisionerNameLabelKey} {
+		nodes := &v1.NodeList{}
+		if err := t.KubeClient.List(ctx, nodes, client.MatchingLabels{key: name}); err != nil {
+			return fmt.Errorf("listing nodes, %w", err)
+		}
+		for i := range nodes.Items {
+			if nodes.Items[i].Name != node.Name {
+				return nil
+			}
+		}
+	}
+	return t.CloudProvider.Cleanup(ctx, name)
+}
+
+// recordEvicted remembers the pods evicted from the node, if it's archived
+func (t *Terminator) recordEvicted(node *v1.Node, pods []*v1.Pod) {
+	if t.Archive == nil || len(pods) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.evicted == nil {
+		t.evicted = map[types.UID]sets.String{}
+	}
+	if _, ok := t.evicted[node.UID]; !ok {
+		t.evicted[node.UID] = sets.NewString()
+	}
+	for _, p := range pods {
+		t.evicted[node.UID].Insert(client.ObjectKeyFromObject(p).String())
+	}
+}
+
+// archive persists a record of the terminated node
+func (t *Terminator) archive(ctx context.Context, node *v1.Node) error {
+	if t.Archive == nil {
+		return nil
+	}
+	t.mu.Lock()
+	evicted := t.evicted[node.UID].List()
+	t.mu.Unlock()
+	return t.Archive.Archive(ctx, NewNodeRecord(node, evicted, t.instanceType(ctx, node)))
+}
+
+// forgetEvicted drops the pods evicted from the node once it's deleted
+func (t *Terminator) forgetEvicted(node *v1.Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.evicted, node.UID)
+}
+
+// instanceType returns the node's instance type, to estimate its cost, or nil
+// if it's unknown
+func (t *Terminator) instanceType(ctx context.Context, node *v1.Node) cloudprovider.InstanceType {
+	provisioner := &v1alpha5.Provisioner{}
+	if err := t.KubeClient.Get(ctx, types.NamespacedName{Name: node.Labels[v1alpha5.ProvisionerNameLabelKey]}, provisioner); err != nil {
+		return nil
+	}
+	instanceTypes, err := t.CloudProvider.GetInstanceTypes(ctx, provisioner.Spec.Provider)
+	if err != nil {
+		logging.FromContext(ctx).Debugf("Getting instance types to estimate node cost, %s", err)
+		return nil
+	}
+	for _, instanceType := range instanceTypes {
+		if instanceType.Name() == node.Labels[v1.LabelInstanceTypeStable] {
+			return instanceType
+		}
+	}
 	return nil
 }
 
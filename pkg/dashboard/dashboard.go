@@ -0,0 +1,162 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Dashboard serves a read-only view of Karpenter's provisioning state, for
+// operators that want to see what Karpenter is doing without a metrics stack.
+// The state is available as HTML at / and as JSON at /api/state. It isn't
+// authenticated, so it binds to localhost unless configured otherwise.
+type Dashboard struct {
+	address    string
+	port       int
+	kubeClient client.Client
+}
+
+// NewDashboard is a constructor
+func NewDashboard(address string, port int, kubeClient client.Client) *Dashboard {
+	return &Dashboard{address: address, port: port, kubeClient: kubeClient}
+}
+
+// Handler returns the dashboard's http handler
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/state", d.serveState)
+	mux.HandleFunc("/", d.serveIndex)
+	return mux
+}
+
+// Start serves the dashboard until the context is cancelled
+func (d *Dashboard) Start(ctx context.Context) error {
+	server := &http.Server{Addr: net.JoinHostPort(d.address, strconv.Itoa(d.port)), Handler: d.Handler()}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	logging.FromContext(ctx).Infof("Serving dashboard on %s", server.Addr)
+	select {
+	case err := <-errs:
+		return fmt.Errorf("serving dashboard, %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// NeedLeaderElection is false so that every replica serves the dashboard
+func (d *Dashboard) NeedLeaderElection() bool {
+	return false
+}
+
+func (d *Dashboard) serveState(w http.ResponseWriter, r *http.Request) {
+	state, err := Snapshot(r.Context(), d.kubeClient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		logging.FromContext(r.Context()).Errorf("Writing dashboard state, %s", err)
+	}
+}
+
+func (d *Dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	state, err := Snapshot(r.Context(), d.kubeClient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := index.Execute(w, state); err != nil {
+		logging.FromContext(r.Context()).Errorf("Rendering dashboard, %s", err)
+	}
+}
+
+var index = template.Must(template.New("index").Funcs(template.FuncMap{
+	"age":      func(now time.Time, t time.Time) string { return now.Sub(t).Round(time.Second).String() },
+	"quantity": func(quantity resource.Quantity) string { return quantity.String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Karpenter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+.Warning { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Karpenter</h1>
+<p>As of {{ .Time.Format "2006-01-02T15:04:05Z07:00" }}, refreshed every 10s. Also available as <a href="api/state">JSON</a>.</p>
+<h2>Provisioners</h2>
+<table>
+<tr><th>Name</th><th>Ready</th><th>Nodes</th><th>Resources</th><th>Limits</th></tr>
+{{- range .Provisioners }}
+<tr><td>{{ .Name }}</td><td>{{ .Ready }}</td><td>{{ .NodeCount }}</td><td>{{ range $name, $quantity := .Resources }}{{ $name }}={{ quantity $quantity }} {{ end }}</td><td>{{ range $name, $quantity := .Limits }}{{ $name }}={{ quantity $quantity }} {{ end }}</td></tr>
+{{- end }}
+</table>
+<h2>Pending Pods ({{ len .PendingPods }})</h2>
+<table>
+<tr><th>Pod</th><th>Age</th><th>Nominated Node</th><th>Provisioner</th><th>Message</th></tr>
+{{- $now := .Time }}
+{{- range .PendingPods }}
+<tr><td>{{ .Namespace }}/{{ .Name }}</td><td>{{ age $now .Created }}</td><td>{{ .NominatedNode }}</td><td>{{ .Provisioner }}</td><td>{{ .Message }}</td></tr>
+{{- end }}
+</table>
+<h2>In-flight Nodes ({{ len .InFlightNodes }})</h2>
+<table>
+<tr><th>Node</th><th>Age</th><th>Provisioner</th><th>Instance Type</th><th>Zone</th><th>Capacity Type</th></tr>
+{{- range .InFlightNodes }}
+<tr><td>{{ .Name }}</td><td>{{ age $now .Created }}</td><td>{{ .Provisioner }}</td><td>{{ .InstanceType }}</td><td>{{ .Zone }}</td><td>{{ .CapacityType }}</td></tr>
+{{- end }}
+</table>
+<h2>Disruptions ({{ len .Disruptions }})</h2>
+<table>
+<tr><th>Node</th><th>Age</th><th>Provisioner</th><th>Instance Type</th><th>Reason</th></tr>
+{{- range .Disruptions }}
+<tr><td>{{ .Name }}</td><td>{{ age $now .Created }}</td><td>{{ .Provisioner }}</td><td>{{ .InstanceType }}</td><td>{{ .Reason }}</td></tr>
+{{- end }}
+</table>
+<h2>Recent Decisions</h2>
+<table>
+<tr><th>Age</th><th>Reason</th><th>Object</th><th>Message</th></tr>
+{{- range .Decisions }}
+<tr class="{{ .Type }}"><td>{{ age $now .Time }}</td><td>{{ .Reason }}</td><td>{{ .Object }}</td><td>{{ .Message }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
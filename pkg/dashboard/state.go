@@ -0,0 +1,246 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/node"
+	"github.com/aws/karpenter/pkg/utils/pod"
+)
+
+const (
+	// EventSource is the component that Karpenter's events are recorded for
+	EventSource = "karpenter"
+	// maxDecisions bounds the number of recent decisions in a snapshot
+	maxDecisions = 50
+)
+
+// State is a read-only snapshot of Karpenter's provisioning state
+type State struct {
+	Time          time.Time          `json:"time"`
+	Provisioners  []ProvisionerState `json:"provisioners"`
+	PendingPods   []PodState         `json:"pendingPods"`
+	InFlightNodes []NodeState        `json:"inFlightNodes"`
+	Disruptions   []NodeState        `json:"disruptions"`
+	Decisions     []Decision         `json:"decisions"`
+}
+
+// ProvisionerState summarizes a provisioner and the capacity it has provisioned
+type ProvisionerState struct {
+	Name      string          `json:"name"`
+	Ready     bool            `json:"ready"`
+	NodeCount int32           `json:"nodeCount"`
+	Resources v1.ResourceList `json:"resources,omitempty"`
+	Limits    v1.ResourceList `json:"limits,omitempty"`
+}
+
+// PodState is a pod that is waiting for capacity, either unschedulable or
+// nominated to a node that isn't ready yet
+type PodState struct {
+	Namespace     string    `json:"namespace"`
+	Name          string    `json:"name"`
+	Created       time.Time `json:"created"`
+	NominatedNode string    `json:"nominatedNode,omitempty"`
+	Provisioner   string    `json:"provisioner,omitempty"`
+	Message       string    `json:"message,omitempty"`
+}
+
+// NodeState is a node launched by Karpenter that is starting up or being disrupted
+type NodeState struct {
+	Name         string    `json:"name"`
+	Created      time.Time `json:"created"`
+	Provisioner  string    `json:"provisioner"`
+	InstanceType string    `json:"instanceType,omitempty"`
+	Zone         string    `json:"zone,omitempty"`
+	CapacityType string    `json:"capacityType,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+// Decision is an event recorded by Karpenter
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Reason  string    `json:"reason"`
+	Object  string    `json:"object"`
+	Message string    `json:"message"`
+}
+
+// Snapshot reads the provisioning state from the cluster
+func Snapshot(ctx context.Context, kubeClient client.Client) (*State, error) {
+	state := &State{Time: injectabletime.Now()}
+	provisioners, err := getProvisioners(ctx, kubeClient)
+	if err != nil {
+		return nil, fmt.Errorf("listing provisioners, %w", err)
+	}
+	state.Provisioners = provisioners
+	if state.PendingPods, err = getPendingPods(ctx, kubeClient); err != nil {
+		return nil, fmt.Errorf("listing pods, %w", err)
+	}
+	if state.InFlightNodes, state.Disruptions, err = getNodes(ctx, kubeClient); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	if state.Decisions, err = getDecisions(ctx, kubeClient); err != nil {
+		return nil, fmt.Errorf("listing events, %w", err)
+	}
+	return state, nil
+}
+
+func getProvisioners(ctx context.Context, kubeClient client.Client) ([]ProvisionerState, error) {
+	provisionerList := &v1alpha5.ProvisionerList{}
+	if err := kubeClient.List(ctx, provisionerList); err != nil {
+		return nil, err
+	}
+	provisioners := []ProvisionerState{}
+	for i := range provisionerList.Items {
+		provisioner := &provisionerList.Items[i]
+		state := ProvisionerState{
+			Name:      provisioner.Name,
+			Ready:     provisioner.StatusConditions().GetCondition(apis.ConditionReady).IsTrue(),
+			NodeCount: provisioner.Status.NodeCount,
+			Resources: provisioner.Status.Resources,
+		}
+		if provisioner.Spec.Limits != nil {
+			state.Limits = provisioner.Spec.Limits.Resources
+		}
+		provisioners = append(provisioners, state)
+	}
+	sort.Slice(provisioners, func(i, j int) bool { return provisioners[i].Name < provisioners[j].Name })
+	return provisioners, nil
+}
+
+func getPendingPods(ctx context.Context, kubeClient client.Client) ([]PodState, error) {
+	podList := &v1.PodList{}
+	if err := kubeClient.List(ctx, podList); err != nil {
+		return nil, err
+	}
+	pods := []PodState{}
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		if p.Status.Phase == v1.PodRunning || pod.IsTerminal(p) || pod.IsTerminating(p) {
+			continue
+		}
+		_, nominated := p.Annotations[v1alpha5.NominatedNodeAnnotationKey]
+		if !nominated && !pod.FailedToSchedule(p) {
+			continue
+		}
+		state := PodState{
+			Namespace:     p.Namespace,
+			Name:          p.Name,
+			Created:       p.CreationTimestamp.Time,
+			NominatedNode: p.Annotations[v1alpha5.NominatedNodeAnnotationKey],
+			Provisioner:   p.Annotations[v1alpha5.NominatedProvisionerAnnotationKey],
+		}
+		for _, condition := range p.Status.Conditions {
+			if condition.Type == v1.PodScheduled && condition.Status != v1.ConditionTrue {
+				state.Message = condition.Message
+			}
+		}
+		pods = append(pods, state)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Created.Before(pods[j].Created) })
+	return pods, nil
+}
+
+// getNodes returns Karpenter's nodes that aren't ready yet, and those that are
+// being disrupted, i.e. terminating, cordoned, or selected for deprovisioning
+func getNodes(ctx context.Context, kubeClient client.Client) (inFlight []NodeState, disruptions []NodeState, err error) {
+	nodeList := &v1.NodeList{}
+	if err := kubeClient.List(ctx, nodeList, client.HasLabels{v1alpha5.ProvisionerNameLabelKey}); err != nil {
+		return nil, nil, err
+	}
+	inFlight, disruptions = []NodeState{}, []NodeState{}
+	for i := range nodeList.Items {
+		n := &nodeList.Items[i]
+		state := NodeState{
+			Name:         n.Name,
+			Created:      n.CreationTimestamp.Time,
+			Provisioner:  n.Labels[v1alpha5.ProvisionerNameLabelKey],
+			InstanceType: n.Labels[v1.LabelInstanceTypeStable],
+			Zone:         n.Labels[v1.LabelTopologyZone],
+			CapacityType: n.Labels[v1alpha5.LabelCapacityType],
+		}
+		if reason := disruptionReason(n); reason != "" {
+			state.Reason = reason
+			disruptions = append(disruptions, state)
+			continue
+		}
+		if !node.IsReady(n) {
+			inFlight = append(inFlight, state)
+		}
+	}
+	sort.Slice(inFlight, func(i, j int) bool { return inFlight[i].Created.Before(inFlight[j].Created) })
+	sort.Slice(disruptions, func(i, j int) bool { return disruptions[i].Created.Before(disruptions[j].Created) })
+	return inFlight, disruptions, nil
+}
+
+func disruptionReason(n *v1.Node) string {
+	if !n.DeletionTimestamp.IsZero() {
+		return "terminating"
+	}
+	if reason, ok := n.Annotations[v1alpha5.DeprovisioningReasonAnnotationKey]; ok {
+		return reason
+	}
+	if reason, ok := n.Annotations[v1alpha5.DeprovisioningCandidateAnnotationKey]; ok {
+		return reason
+	}
+	if n.Spec.Unschedulable {
+		return "cordoned"
+	}
+	if _, ok := n.Annotations[v1alpha5.EmptinessTimestampAnnotationKey]; ok {
+		return "empty"
+	}
+	return ""
+}
+
+// getDecisions returns the most recent events recorded by Karpenter, newest
+// first. Events are read from the manager's cache, which indexes their source.
+func getDecisions(ctx context.Context, kubeClient client.Client) ([]Decision, error) {
+	eventList := &v1.EventList{}
+	if err := kubeClient.List(ctx, eventList, client.MatchingFields{"source": EventSource}); err != nil {
+		return nil, err
+	}
+	decisions := []Decision{}
+	for _, event := range eventList.Items {
+		decision := Decision{
+			Time:    event.LastTimestamp.Time,
+			Type:    event.Type,
+			Reason:  event.Reason,
+			Object:  fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+			Message: event.Message,
+		}
+		if event.InvolvedObject.Namespace != "" {
+			decision.Object = fmt.Sprintf("%s/%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Namespace, event.InvolvedObject.Name)
+		}
+		if decision.Time.IsZero() {
+			decision.Time = event.CreationTimestamp.Time
+		}
+		decisions = append(decisions, decision)
+	}
+	sort.SliceStable(decisions, func(i, j int) bool { return decisions[i].Time.After(decisions[j].Time) })
+	if len(decisions) > maxDecisions {
+		decisions = decisions[:maxDecisions]
+	}
+	return decisions, nil
+}
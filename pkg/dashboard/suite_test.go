@@ -0,0 +1,166 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/dashboard"
+	"github.com/aws/karpenter/pkg/test"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dashboard")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx)
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Dashboard", func() {
+	var provisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
+			Spec:       v1alpha5.ProvisionerSpec{},
+		}
+	})
+	AfterEach(func() {
+		Expect(env.Client.DeleteAllOf(ctx, &v1.Event{}, client.InNamespace("default"))).To(Succeed())
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	node := func(options test.NodeOptions) *v1.Node {
+		options.Labels = map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name, v1.LabelInstanceTypeStable: "default-instance-type"}
+		return test.Node(options)
+	}
+
+	Context("Snapshot", func() {
+		It("should summarize provisioners", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			state, err := dashboard.Snapshot(ctx, env.Client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state.Provisioners).To(HaveLen(1))
+			Expect(state.Provisioners[0].Name).To(Equal(provisioner.Name))
+		})
+		It("should include unschedulable and nominated pods, but not running pods", func() {
+			unschedulable := test.UnschedulablePod()
+			nominated := test.Pod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				v1alpha5.NominatedNodeAnnotationKey:        "nominated-node",
+				v1alpha5.NominatedProvisionerAnnotationKey: provisioner.Name,
+			}}})
+			running := test.Pod(test.PodOptions{Phase: v1.PodRunning})
+			ExpectCreatedWithStatus(ctx, env.Client, unschedulable, nominated, running)
+			state, err := dashboard.Snapshot(ctx, env.Client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state.PendingPods).To(ConsistOf(
+				HaveField("Name", unschedulable.Name),
+				And(HaveField("Name", nominated.Name), HaveField("NominatedNode", "nominated-node"), HaveField("Provisioner", provisioner.Name)),
+			))
+		})
+		It("should include nodes that aren't ready and nodes that are being disrupted", func() {
+			launching := node(test.NodeOptions{ReadyStatus: v1.ConditionUnknown})
+			cordoned := node(test.NodeOptions{Unschedulable: true})
+			candidate := node(test.NodeOptions{})
+			candidate.Annotations = map[string]string{v1alpha5.DeprovisioningCandidateAnnotationKey: "expired"}
+			ready := node(test.NodeOptions{})
+			unmanaged := test.Node(test.NodeOptions{ReadyStatus: v1.ConditionUnknown})
+			ExpectCreatedWithStatus(ctx, env.Client, launching, cordoned, candidate, ready, unmanaged)
+			state, err := dashboard.Snapshot(ctx, env.Client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state.InFlightNodes).To(ConsistOf(
+				And(HaveField("Name", launching.Name), HaveField("Provisioner", provisioner.Name), HaveField("InstanceType", "default-instance-type")),
+			))
+			Expect(state.Disruptions).To(ConsistOf(
+				And(HaveField("Name", cordoned.Name), HaveField("Reason", "cordoned")),
+				And(HaveField("Name", candidate.Name), HaveField("Reason", "expired")),
+			))
+		})
+		It("should include Karpenter's events, newest first", func() {
+			event := func(reason string, age time.Duration, component string) *v1.Event {
+				return &v1.Event{
+					ObjectMeta:     metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName()), Namespace: "default"},
+					InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "test-pod"},
+					Reason:         reason,
+					Type:           v1.EventTypeWarning,
+					Source:         v1.EventSource{Component: component},
+					LastTimestamp:  metav1.NewTime(time.Now().Add(-age)),
+				}
+			}
+			ExpectCreated(ctx, env.Client,
+				event("NoCompatibleProvisioners", time.Minute, dashboard.EventSource),
+				event("InsufficientCapacity", time.Second, dashboard.EventSource),
+				event("FailedScheduling", 0, "default-scheduler"),
+			)
+			state, err := dashboard.Snapshot(ctx, env.Client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state.Decisions).To(HaveLen(2))
+			Expect(state.Decisions[0].Reason).To(Equal("InsufficientCapacity"))
+			Expect(state.Decisions[0].Object).To(Equal("Pod/default/test-pod"))
+			Expect(state.Decisions[1].Reason).To(Equal("NoCompatibleProvisioners"))
+		})
+	})
+	Context("Handler", func() {
+		It("should serve the state as JSON", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			recorder := httptest.NewRecorder()
+			dashboard.NewDashboard("127.0.0.1", 0, env.Client).Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/state", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			state := &dashboard.State{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), state)).To(Succeed())
+			Expect(state.Provisioners).To(ConsistOf(HaveField("Name", provisioner.Name)))
+		})
+		It("should serve the state as HTML", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Name: "pending-pod"}}))
+			recorder := httptest.NewRecorder()
+			dashboard.NewDashboard("127.0.0.1", 0, env.Client).Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(ContainSubstring(provisioner.Name))
+			Expect(recorder.Body.String()).To(ContainSubstring("pending-pod"))
+		})
+		It("should not serve unknown paths", func() {
+			recorder := httptest.NewRecorder()
+			dashboard.NewDashboard("127.0.0.1", 0, env.Client).Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
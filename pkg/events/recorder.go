@@ -0,0 +1,194 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+const (
+	// Reasons for events emitted to pods
+	UnsupportedPod           = "UnsupportedPod"
+	NoCompatibleProvisioners = "NoCompatibleProvisioners"
+	IncompatiblePod          = "IncompatiblePod"
+	InsufficientCapacity     = "InsufficientCapacity"
+	ExceededLimits           = "ExceededLimits"
+	Nominated                = "Nominated"
+	// Reasons for events emitted to provisioners
+	ExcludedPod  = "ExcludedPod"
+	LaunchFailed = "LaunchFailed"
+	CostAnomaly  = "CostAnomaly"
+	// Reasons for events emitted to nodes
+	DeprovisioningBlocked = "DeprovisioningBlocked"
+	DrainBlocked          = "DrainBlocked"
+	EvictionBlocked       = "EvictionBlocked"
+	ForceDeleted          = "ForceDeleted"
+
+	// maxMessageLength bounds event messages, which may otherwise grow with
+	// the number of provisioners and instance types that were evaluated
+	maxMessageLength = 1024
+	// dedupeTimeout is how long an event isn't emitted again with the same
+	// message to the same object, since pods that can't be provisioned are
+	// reconciled repeatedly
+	dedupeTimeout = 5 * time.Minute
+)
+
+// Exclusion is the reason a provisioner was unable to provision a pod
+type Exclusion struct {
+	Provisioner *v1alpha5.Provisioner
+	Err         error
+}
+
+// Recorder emits events for the decisions Karpenter makes when provisioning
+// pods, so that users can diagnose why a pod was not provisioned without
+// reading the controller's logs.
+type Recorder interface {
+	// PodUnsupported is emitted to a pod that uses scheduling features that Karpenter doesn't support
+	PodUnsupported(pod *v1.Pod, err error)
+	// NoCompatibleProvisioners is emitted to a pod, and to each provisioner that was
+	// evaluated, when none of the provisioners are compatible with the pod. Events
+	// with the same message are emitted at most once per object every five minutes.
+	NoCompatibleProvisioners(pod *v1.Pod, exclusions []Exclusion)
+	// PodIncompatible is emitted to a pod that was batched by a provisioner but
+	// is incompatible with the provisioner's constraints, e.g. after topology is injected
+	PodIncompatible(pod *v1.Pod, provisioner *v1alpha5.Provisioner, err error)
+	// PodDidNotFit is emitted to a pod whose requests don't fit any of the instance types allowed by the provisioner.
+	// If instanceTypes is empty, no instance type had capacity for the provisioner's daemons and overhead.
+	PodDidNotFit(pod *v1.Pod, provisioner string, instanceTypes []string)
+	// PodExceededLimits is emitted to a pod that wasn't provisioned because the nodes
+	// for it, and for pods of equal or higher priority, would exceed the provisioner's limits
+	PodExceededLimits(pod *v1.Pod, provisioner string, err error)
+	// PodNominated is emitted to a pod that a node was launched for, and is pending until the node is ready
+	PodNominated(pod *v1.Pod, node string, provisioner string, expectedReady time.Time)
+	// LaunchFailed is emitted to a provisioner that was unable to launch a node
+	LaunchFailed(provisioner *v1alpha5.Provisioner, err error)
+	// CostAnomaly is emitted to a provisioner whose rate of launching nodes, weighted
+	// by their cost, is anomalously high compared to its baseline
+	CostAnomaly(provisioner *v1alpha5.Provisioner, nodes int, window time.Duration, rate float64, baseline float64)
+	// DeprovisioningBlocked is emitted to a node that would have been deprovisioned for the
+	// given reason, but has the do-not-consolidate annotation or a pod with the do-not-evict annotation
+	DeprovisioningBlocked(node *v1.Node, reason string, blocker string)
+	// DrainBlocked is emitted to a terminating node, and to the pod that blocks it, while
+	// the pod has the do-not-evict annotation
+	DrainBlocked(node *v1.Node, pod *v1.Pod)
+	// EvictionBlocked is emitted to a terminating node, and to the pod that blocks it, while
+	// the pod's eviction is rejected, e.g. because it would violate a pod disruption budget
+	EvictionBlocked(node *v1.Node, pod *v1.Pod, err error)
+	// ForceDeleted is emitted to a terminating node, and to the pod that was force deleted
+	// from it, once the node has been draining for longer than its termination grace period
+	ForceDeleted(node *v1.Node, pod *v1.Pod, gracePeriod time.Duration)
+}
+
+type recorder struct {
+	record.EventRecorder
+	// emitted holds the events that were recently emitted by object UID, reason, and message
+	emitted *cache.Cache
+}
+
+// NewRecorder returns a Recorder that emits events using the given EventRecorder
+func NewRecorder(r record.EventRecorder) Recorder {
+	return &recorder{EventRecorder: r, emitted: cache.New(dedupeTimeout, dedupeTimeout)}
+}
+
+// eventOnce emits the event unless it was emitted to the object with the same message within the dedupe timeout
+func (r *recorder) eventOnce(object runtime.Object, uid types.UID, eventtype, reason, message string) {
+	if err := r.emitted.Add(fmt.Sprintf("%s/%s/%s", uid, reason, message), nil, cache.DefaultExpiration); err != nil {
+		return
+	}
+	r.Event(object, eventtype, reason, message)
+}
+
+func (r *recorder) PodUnsupported(pod *v1.Pod, err error) {
+	r.Event(pod, v1.EventTypeWarning, UnsupportedPod, truncate(fmt.Sprintf("Ignoring pod, %s", err)))
+}
+
+func (r *recorder) NoCompatibleProvisioners(pod *v1.Pod, exclusions []Exclusion) {
+	reasons := []string{}
+	for _, exclusion := range exclusions {
+		reasons = append(reasons, fmt.Sprintf("provisioner/%s: %s", exclusion.Provisioner.Name, exclusion.Err))
+		r.eventOnce(exclusion.Provisioner, exclusion.Provisioner.UID, v1.EventTypeWarning, ExcludedPod, truncate(fmt.Sprintf("Excluded pod %s/%s, %s", pod.Namespace, pod.Name, exclusion.Err)))
+	}
+	r.eventOnce(pod, pod.UID, v1.EventTypeWarning, NoCompatibleProvisioners, truncate(fmt.Sprintf("Matched 0/%d provisioners, %s", len(exclusions), strings.Join(reasons, "; "))))
+}
+
+func (r *recorder) PodIncompatible(pod *v1.Pod, provisioner *v1alpha5.Provisioner, err error) {
+	r.Event(pod, v1.EventTypeWarning, IncompatiblePod, truncate(fmt.Sprintf("Incompatible with provisioner/%s, %s", provisioner.Name, err)))
+}
+
+func (r *recorder) PodDidNotFit(pod *v1.Pod, provisioner string, instanceTypes []string) {
+	if len(instanceTypes) == 0 {
+		r.Event(pod, v1.EventTypeWarning, InsufficientCapacity, fmt.Sprintf("None of the instance types allowed by provisioner/%s have enough resources for the pod and daemons", provisioner))
+		return
+	}
+	r.Event(pod, v1.EventTypeWarning, InsufficientCapacity, truncate(fmt.Sprintf("Requests did not fit any of the %d instance type(s) allowed by provisioner/%s, %s", len(instanceTypes), provisioner, strings.Join(instanceTypes, ", "))))
+}
+
+func (r *recorder) PodExceededLimits(pod *v1.Pod, provisioner string, err error) {
+	r.Event(pod, v1.EventTypeWarning, ExceededLimits, truncate(fmt.Sprintf("Not provisioned by provisioner/%s, %s", provisioner, err)))
+}
+
+func (r *recorder) PodNominated(pod *v1.Pod, node string, provisioner string, expectedReady time.Time) {
+	r.Event(pod, v1.EventTypeNormal, Nominated, fmt.Sprintf("Nominated to node %s launched by provisioner/%s, expected to be ready at %s", node, provisioner, expectedReady.Format(time.RFC3339)))
+}
+
+func (r *recorder) LaunchFailed(provisioner *v1alpha5.Provisioner, err error) {
+	r.Event(provisioner, v1.EventTypeWarning, LaunchFailed, truncate(fmt.Sprintf("Could not launch node, %s", err)))
+}
+
+func (r *recorder) CostAnomaly(provisioner *v1alpha5.Provisioner, nodes int, window time.Duration, rate float64, baseline float64) {
+	r.Event(provisioner, v1.EventTypeWarning, CostAnomaly, fmt.Sprintf("Launched %d node(s) in the last %s at a cost rate of %.2f/h, compared to a baseline of %.2f/h", nodes, window, rate, baseline))
+}
+
+func (r *recorder) DeprovisioningBlocked(node *v1.Node, reason string, blocker string) {
+	r.Event(node, v1.EventTypeNormal, DeprovisioningBlocked, fmt.Sprintf("Not deprovisioning %s node, %s", reason, blocker))
+}
+
+func (r *recorder) DrainBlocked(node *v1.Node, pod *v1.Pod) {
+	r.Event(node, v1.EventTypeNormal, DrainBlocked, fmt.Sprintf("Waiting to drain node until pod %s/%s with the %s annotation completes", pod.Namespace, pod.Name, v1alpha5.DoNotEvictPodAnnotationKey))
+	r.Event(pod, v1.EventTypeNormal, DrainBlocked, fmt.Sprintf("Blocking drain of node %s until the pod completes, since it has the %s annotation", node.Name, v1alpha5.DoNotEvictPodAnnotationKey))
+}
+
+func (r *recorder) EvictionBlocked(node *v1.Node, pod *v1.Pod, err error) {
+	r.Event(node, v1.EventTypeWarning, EvictionBlocked, truncate(fmt.Sprintf("Waiting to drain node until pod %s/%s can be evicted, %s", pod.Namespace, pod.Name, err)))
+	r.Event(pod, v1.EventTypeWarning, EvictionBlocked, truncate(fmt.Sprintf("Blocking drain of node %s, %s", node.Name, err)))
+}
+
+func (r *recorder) ForceDeleted(node *v1.Node, pod *v1.Pod, gracePeriod time.Duration) {
+	r.Event(node, v1.EventTypeWarning, ForceDeleted, fmt.Sprintf("Force deleted pod %s/%s, since the node was draining for longer than its termination grace period of %s", pod.Namespace, pod.Name, gracePeriod))
+	r.Event(pod, v1.EventTypeWarning, ForceDeleted, fmt.Sprintf("Force deleted from node %s, since the node was draining for longer than its termination grace period of %s", node.Name, gracePeriod))
+}
+
+func truncate(message string) string {
+	if len(message) <= maxMessageLength {
+		return message
+	}
+	// Cut on a rune boundary, so that the message remains valid UTF-8
+	end := maxMessageLength - 3
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+	return message[:end] + "..."
+}
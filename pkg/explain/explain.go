@@ -0,0 +1,169 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/events"
+)
+
+// Explainer reports why a pod is or isn't provisioned, by running the same
+// selection, scheduling, and bin packing logic as the controller in process,
+// without launching any nodes.
+type Explainer struct {
+	kubeClient     client.Client
+	cloudProvider  cloudprovider.CloudProvider
+	volumeTopology *selection.VolumeTopology
+	scheduler      *scheduling.Scheduler
+	packer         *binpacking.Packer
+}
+
+// NewExplainer is a constructor
+func NewExplainer(ctx context.Context, kubeClient client.Client, cloudProvider cloudprovider.CloudProvider) *Explainer {
+	// Events aren't emitted, since nothing is provisioned
+	recorder := events.NewRecorder(&record.FakeRecorder{})
+	return &Explainer{
+		kubeClient:     kubeClient,
+		cloudProvider:  cloudProvider,
+		volumeTopology: selection.NewVolumeTopology(kubeClient),
+		scheduler:      scheduling.NewScheduler(kubeClient, recorder),
+		packer:         binpacking.NewPacker(ctx, kubeClient, cloudProvider, recorder),
+	}
+}
+
+// Report explains whether each provisioner provisions a pod
+type Report struct {
+	Pod          types.NamespacedName
+	Provisioners []ProvisionerReport
+}
+
+// ProvisionerReport explains whether a provisioner provisions a pod
+type ProvisionerReport struct {
+	Name string
+	// Err is the reason that the provisioner doesn't provision the pod, if any
+	Err error
+	// InstanceTypes are the reasons that each of the provisioner's instance
+	// types can't run the pod, keyed by name, or nil if it can
+	InstanceTypes map[string]error
+}
+
+// Explain reports whether each provisioner provisions the pod. Provisioners
+// are evaluated independently, rather than in the order that the controller
+// tries them.
+func (e *Explainer) Explain(ctx context.Context, pod *v1.Pod) (*Report, error) {
+	pod = pod.DeepCopy()
+	if err := e.volumeTopology.Inject(ctx, pod); err != nil {
+		return nil, fmt.Errorf("getting volume topology requirements, %w", err)
+	}
+	provisionerList := &v1alpha5.ProvisionerList{}
+	if err := e.kubeClient.List(ctx, provisionerList); err != nil {
+		return nil, fmt.Errorf("listing provisioners, %w", err)
+	}
+	report := &Report{Pod: client.ObjectKeyFromObject(pod)}
+	for i := range provisionerList.Items {
+		report.Provisioners = append(report.Provisioners, e.explain(ctx, &provisionerList.Items[i], pod))
+	}
+	return report, nil
+}
+
+func (e *Explainer) explain(ctx context.Context, provisioner *v1alpha5.Provisioner, pod *v1.Pod) ProvisionerReport {
+	report := ProvisionerReport{Name: provisioner.Name}
+	instanceTypes, err := provisioning.Resolve(ctx, e.cloudProvider, provisioner)
+	if err != nil {
+		report.Err = fmt.Errorf("starting provisioner, %w", err)
+		return report
+	}
+	if err := provisioner.Spec.DeepCopy().ValidatePod(pod); err != nil {
+		report.Err = err
+		return report
+	}
+	schedules, err := e.scheduler.Solve(ctx, provisioner, []*v1.Pod{pod.DeepCopy()})
+	if err != nil {
+		report.Err = fmt.Errorf("scheduling, %w", err)
+		return report
+	}
+	if len(schedules) == 0 {
+		report.Err = fmt.Errorf("incompatible after topology spread was applied")
+		return report
+	}
+	if report.InstanceTypes, err = e.packer.Explain(ctx, schedules[0], instanceTypes); err != nil {
+		report.Err = fmt.Errorf("packing, %w", err)
+		return report
+	}
+	if len(report.Compatible()) == 0 {
+		report.Err = fmt.Errorf("no instance type can run the pod")
+	}
+	return report
+}
+
+// Compatible returns the names of the instance types that can run the pod
+func (r ProvisionerReport) Compatible() []string {
+	names := []string{}
+	for name, err := range r.InstanceTypes {
+		if err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Print writes the report in a human readable format. If verbose, the reason
+// that each incompatible instance type can't run the pod is included.
+func (r *Report) Print(w io.Writer, verbose bool) {
+	fmt.Fprintf(w, "pod/%s\n", r.Pod.Name)
+	if len(r.Provisioners) == 0 {
+		fmt.Fprintf(w, "  no provisioners found\n")
+	}
+	for _, provisioner := range r.Provisioners {
+		if provisioner.Err == nil {
+			fmt.Fprintf(w, "  provisioner/%s: provisions the pod\n", provisioner.Name)
+		} else {
+			fmt.Fprintf(w, "  provisioner/%s: doesn't provision the pod, %s\n", provisioner.Name, provisioner.Err)
+		}
+		if compatible := provisioner.Compatible(); len(compatible) > 0 {
+			fmt.Fprintf(w, "    compatible instance types: %s\n", strings.Join(compatible, ", "))
+		}
+		if !verbose {
+			continue
+		}
+		names := []string{}
+		for name, err := range provisioner.InstanceTypes {
+			if err != nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "    %s: %s\n", name, provisioner.InstanceTypes[name])
+		}
+	}
+}
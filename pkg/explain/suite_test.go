@@ -0,0 +1,109 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explain_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/cloudprovider/registry"
+	"github.com/aws/karpenter/pkg/explain"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var explainer *explain.Explainer
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Explain")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		ctx = injection.WithOptions(ctx, options.Options{})
+		cloudProvider := &fake.CloudProvider{}
+		registry.RegisterOrDie(ctx, cloudProvider)
+		explainer = explain.NewExplainer(ctx, e.Client, cloudProvider)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Explain", func() {
+	var provisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
+			Spec:       v1alpha5.ProvisionerSpec{},
+		}
+	})
+	AfterEach(func() {
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	It("should report the compatible instance types", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		report, err := explainer.Explain(ctx, test.UnschedulablePod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Provisioners).To(HaveLen(1))
+		Expect(report.Provisioners[0].Err).ToNot(HaveOccurred())
+		Expect(report.Provisioners[0].Compatible()).To(ContainElements("default-instance-type", "small-instance-type"))
+		Expect(report.Provisioners[0].InstanceTypes["nvidia-gpu-instance-type"]).To(MatchError(ContainSubstring("is not required")))
+	})
+	It("should report why a provisioner's taints aren't tolerated", func() {
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		report, err := explainer.Explain(ctx, test.UnschedulablePod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Provisioners).To(HaveLen(1))
+		Expect(report.Provisioners[0].Err).To(MatchError(ContainSubstring("test-key")))
+		Expect(report.Provisioners[0].InstanceTypes).To(BeEmpty())
+	})
+	It("should report when the pod doesn't fit any instance type", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10000")},
+		}})
+		report, err := explainer.Explain(ctx, pod)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Provisioners[0].Err).To(MatchError("no instance type can run the pod"))
+		Expect(report.Provisioners[0].InstanceTypes["default-instance-type"]).To(MatchError("not enough resources for 1/1 pods"))
+
+		out := &bytes.Buffer{}
+		report.Print(out, true)
+		Expect(out.String()).To(ContainSubstring("default-instance-type: not enough resources for 1/1 pods"))
+	})
+})
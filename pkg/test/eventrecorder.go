@@ -0,0 +1,76 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Event is an event recorded by the EventRecorder
+type Event struct {
+	InvolvedObject client.Object
+	Type           string
+	Reason         string
+	Message        string
+}
+
+// EventRecorder is a record.EventRecorder that keeps the events it records in
+// memory. Unlike record.FakeRecorder, it never blocks.
+type EventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewEventRecorder() *EventRecorder {
+	return &EventRecorder{}
+}
+
+func (e *EventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, Event{InvolvedObject: object.(client.Object), Type: eventtype, Reason: reason, Message: message})
+}
+
+func (e *EventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	e.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (e *EventRecorder) AnnotatedEventf(object runtime.Object, _ map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	e.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// For returns the events recorded for the object with the given reason
+func (e *EventRecorder) For(object client.Object, reason string) []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	events := []Event{}
+	for _, event := range e.events {
+		if event.Reason == reason && event.InvolvedObject.GetNamespace() == object.GetNamespace() && event.InvolvedObject.GetName() == object.GetName() {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Reset must be called between tests otherwise tests will pollute each other
+func (e *EventRecorder) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = nil
+}
@@ -73,6 +73,15 @@ func ExpectScheduled(ctx context.Context, c client.Client, pod *v1.Pod) *v1.Node
 	return ExpectNodeExists(ctx, c, p.Spec.NodeName)
 }
 
+// ExpectNominated expects the pod to be nominated to a node without being bound
+// to it, as are pods that request extended resources
+func ExpectNominated(ctx context.Context, c client.Client, pod *v1.Pod) *v1.Node {
+	p := ExpectPodExists(ctx, c, pod.Name, pod.Namespace)
+	Expect(p.Spec.NodeName).To(BeEmpty(), fmt.Sprintf("expected %s/%s to not be scheduled", pod.Namespace, pod.Name))
+	Expect(p.Annotations).To(HaveKey(v1alpha5.NominatedNodeAnnotationKey), fmt.Sprintf("expected %s/%s to be nominated", pod.Namespace, pod.Name))
+	return ExpectNodeExists(ctx, c, p.Annotations[v1alpha5.NominatedNodeAnnotationKey])
+}
+
 func ExpectNotScheduled(ctx context.Context, c client.Client, pod *v1.Pod) {
 	p := ExpectPodExists(ctx, c, pod.Name, pod.Namespace)
 	Eventually(p.Spec.NodeName).Should(BeEmpty(), fmt.Sprintf("expected %s/%s to not be scheduled", pod.Namespace, pod.Name))
@@ -136,6 +145,12 @@ func ExpectCleanedUp(ctx context.Context, c client.Client) {
 		nodes.Items[i].SetFinalizers([]string{})
 		Expect(c.Update(ctx, &nodes.Items[i])).To(Succeed())
 	}
+	provisioners := &v1alpha5.ProvisionerList{}
+	Expect(c.List(ctx, provisioners)).To(Succeed())
+	for i := range provisioners.Items {
+		provisioners.Items[i].SetFinalizers([]string{})
+		Expect(c.Update(ctx, &provisioners.Items[i])).To(Succeed())
+	}
 	for _, object := range []client.Object{
 		&v1.Pod{},
 		&v1.Node{},
@@ -144,6 +159,7 @@ func ExpectCleanedUp(ctx context.Context, c client.Client) {
 		&v1.PersistentVolumeClaim{},
 		&v1.PersistentVolume{},
 		&storagev1.StorageClass{},
+		&storagev1.CSINode{},
 		&v1alpha5.Provisioner{},
 	} {
 		for _, namespace := range namespaces.Items {
@@ -202,6 +218,11 @@ func ExpectReconcileSucceeded(ctx context.Context, reconciler reconcile.Reconcil
 	return result
 }
 
+func ExpectReconcileFailed(ctx context.Context, reconciler reconcile.Reconciler, key client.ObjectKey) {
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+	Expect(err).To(HaveOccurred())
+}
+
 func ExpectMetric(prefix string) *prometheus.MetricFamily {
 	metrics, err := metrics.Registry.Gather()
 	Expect(err).To(BeNil())
@@ -30,6 +30,7 @@ type NodeOptions struct {
 	Unschedulable bool
 	Taints        []v1.Taint
 	Allocatable   v1.ResourceList
+	Capacity      v1.ResourceList
 }
 
 func Node(overrides ...NodeOptions) *v1.Node {
@@ -50,6 +51,7 @@ func Node(overrides ...NodeOptions) *v1.Node {
 		},
 		Status: v1.NodeStatus{
 			Allocatable: options.Allocatable,
+			Capacity:    options.Capacity,
 			Conditions:  []v1.NodeCondition{{Type: v1.NodeReady, Status: options.ReadyStatus, Reason: options.ReadyReason}},
 		},
 	}
@@ -77,11 +77,12 @@ func PersistentVolumeClaim(overrides ...PersistentVolumeClaimOptions) *v1.Persis
 
 type StorageClassOptions struct {
 	metav1.ObjectMeta
-	Zones []string
+	Zones       []string
+	Provisioner string
 }
 
 func StorageClass(overrides ...StorageClassOptions) *storagev1.StorageClass {
-	options := StorageClassOptions{}
+	options := StorageClassOptions{Provisioner: "test-provisioner"}
 	for _, opts := range overrides {
 		if err := mergo.Merge(&options, opts, mergo.WithOverride); err != nil {
 			panic(fmt.Sprintf("Failed to merge options: %s", err))
@@ -95,7 +96,7 @@ func StorageClass(overrides ...StorageClassOptions) *storagev1.StorageClass {
 
 	return &storagev1.StorageClass{
 		ObjectMeta:        ObjectMeta(options.ObjectMeta),
-		Provisioner:       "test-provisioner",
+		Provisioner:       options.Provisioner,
 		AllowedTopologies: allowedTopologies,
 	}
 }
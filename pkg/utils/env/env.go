@@ -17,6 +17,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 // WithDefaultInt returns the int value of the supplied environment variable or, if not present,
@@ -43,6 +44,20 @@ func WithDefaultString(key string, def string) string {
 	return val
 }
 
+// WithDefaultFloat64 returns the float64 value of the supplied environment variable or, if not present,
+// the supplied default value. If the float64 conversion fails, returns the default
+func WithDefaultFloat64(key string, def float64) float64 {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
 // WithDefaultBool returns the boolean value of the supplied environment variable or, if not present,
 // the supplied default value.
 func WithDefaultBool(key string, def bool) bool {
@@ -56,3 +71,17 @@ func WithDefaultBool(key string, def bool) bool {
 	}
 	return parsedVal
 }
+
+// WithDefaultDuration returns the duration value of the supplied environment variable or, if not present,
+// the supplied default value. If the duration parsing fails, returns the default
+func WithDefaultDuration(key string, def time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	parsedVal, err := time.ParseDuration(val)
+	if err != nil {
+		return def
+	}
+	return parsedVal
+}
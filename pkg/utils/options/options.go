@@ -18,9 +18,13 @@ import (
 	"flag"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"go.uber.org/multierr"
 
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/utils/env"
 )
 
@@ -33,17 +37,51 @@ const (
 
 func MustParse() Options {
 	opts := Options{}
+	flag.StringVar(&opts.CloudProvider, "cloud-provider", env.WithDefaultString("CLOUD_PROVIDER", ""), "The name of the registered cloud provider to use. If not set, the cloud provider compiled into the binary is used")
+	flag.StringVar(&opts.ExternalCloudProviderAddress, "external-cloud-provider-address", env.WithDefaultString("EXTERNAL_CLOUD_PROVIDER_ADDRESS", ""), "The gRPC address of the out-of-tree cloud provider when using the external cloud provider, e.g. unix:///var/run/karpenter/cloudprovider.sock")
 	flag.StringVar(&opts.ClusterName, "cluster-name", env.WithDefaultString("CLUSTER_NAME", ""), "The kubernetes cluster name for resource discovery")
-	flag.StringVar(&opts.ClusterEndpoint, "cluster-endpoint", env.WithDefaultString("CLUSTER_ENDPOINT", ""), "The external kubernetes cluster endpoint for new nodes to connect with")
+	flag.StringVar(&opts.ClusterEndpoint, "cluster-endpoint", env.WithDefaultString("CLUSTER_ENDPOINT", ""), "The external kubernetes cluster endpoint for new nodes to connect with. If not set, it is discovered from the cloud provider")
 	flag.StringVar(&opts.KarpenterService, "karpenter-service", env.WithDefaultString("KARPENTER_SERVICE", ""), "The Karpenter Service name for the dynamic webhook certificate")
 	flag.IntVar(&opts.MetricsPort, "metrics-port", env.WithDefaultInt("METRICS_PORT", 8080), "The port the metric endpoint binds to for operating metrics about the controller itself")
+	flag.StringVar(&opts.DashboardAddress, "dashboard-address", env.WithDefaultString("DASHBOARD_ADDRESS", "127.0.0.1"), "The address the read-only provisioning dashboard binds to. The dashboard isn't authenticated, so it's only reachable by port forwarding unless bound to other addresses, e.g. 0.0.0.0")
+	flag.IntVar(&opts.DashboardPort, "dashboard-port", env.WithDefaultInt("DASHBOARD_PORT", 0), "The port the read-only provisioning dashboard binds to. If 0, the dashboard is disabled")
 	flag.IntVar(&opts.HealthProbePort, "health-probe-port", env.WithDefaultInt("HEALTH_PROBE_PORT", 8081), "The port the health probe endpoint binds to for reporting controller health")
 	flag.IntVar(&opts.WebhookPort, "port", 8443, "The port the webhook endpoint binds to for validation and mutation of resources")
+	flag.BoolVar(&opts.LeaderElection, "leader-elect", env.WithDefaultBool("LEADER_ELECT", true), "Indicates whether replicas should elect a leader that provisions and terminates nodes. Disable only when running a single replica")
+	flag.DurationVar(&opts.LeaderElectionLeaseDuration, "leader-election-lease-duration", env.WithDefaultDuration("LEADER_ELECTION_LEASE_DURATION", 15*time.Second), "The duration that replicas wait before acquiring the leadership of a leader that stopped renewing it, which bounds the time to fail over")
+	flag.DurationVar(&opts.LeaderElectionRenewDeadline, "leader-election-renew-deadline", env.WithDefaultDuration("LEADER_ELECTION_RENEW_DEADLINE", 10*time.Second), "The duration that the leader retries renewing its leadership before giving it up")
+	flag.DurationVar(&opts.LeaderElectionRetryPeriod, "leader-election-retry-period", env.WithDefaultDuration("LEADER_ELECTION_RETRY_PERIOD", 2*time.Second), "The duration that replicas wait between attempts to acquire or renew leadership")
 	flag.IntVar(&opts.KubeClientQPS, "kube-client-qps", env.WithDefaultInt("KUBE_CLIENT_QPS", 200), "The smoothed rate of qps to kube-apiserver")
 	flag.IntVar(&opts.KubeClientBurst, "kube-client-burst", env.WithDefaultInt("KUBE_CLIENT_BURST", 300), "The maximum allowed burst of queries to the kube-apiserver")
 	flag.StringVar(&opts.AWSNodeNameConvention, "aws-node-name-convention", env.WithDefaultString("AWS_NODE_NAME_CONVENTION", string(IPName)), "The node naming convention used by the AWS cloud provider. DEPRECATION WARNING: this field may be deprecated at any time")
 	flag.BoolVar(&opts.AWSENILimitedPodDensity, "aws-eni-limited-pod-density", env.WithDefaultBool("AWS_ENI_LIMITED_POD_DENSITY", true), "Indicates whether new nodes should use ENI-based pod density")
 	flag.StringVar(&opts.AWSDefaultInstanceProfile, "aws-default-instance-profile", env.WithDefaultString("AWS_DEFAULT_INSTANCE_PROFILE", ""), "The default instance profile to use when provisioning nodes in AWS")
+	flag.BoolVar(&opts.AWSSpotPlacementScores, "aws-spot-placement-scores", env.WithDefaultBool("AWS_SPOT_PLACEMENT_SCORES", false), "Indicates whether EC2 Spot placement scores should be used to prefer zones with deeper spot capacity pools. Scores are refreshed in the background and only break ties between the zones of each instance type in CreateFleet's priorities")
+	flag.DurationVar(&opts.AWSInstanceTypesCacheTTL, "aws-instance-types-cache-ttl", env.WithDefaultDuration("AWS_INSTANCE_TYPES_CACHE_TTL", 5*time.Minute), "The duration that the AWS cloud provider caches instance types and their zone offerings")
+	flag.DurationVar(&opts.AWSAMICacheTTL, "aws-ami-cache-ttl", env.WithDefaultDuration("AWS_AMI_CACHE_TTL", time.Minute), "The duration that the AWS cloud provider caches the AMIs resolved from SSM parameters")
+	flag.DurationVar(&opts.AWSSubnetCacheTTL, "aws-subnet-cache-ttl", env.WithDefaultDuration("AWS_SUBNET_CACHE_TTL", time.Minute), "The duration that the AWS cloud provider caches the subnets matched by each subnet selector")
+	flag.DurationVar(&opts.AWSSecurityGroupCacheTTL, "aws-security-group-cache-ttl", env.WithDefaultDuration("AWS_SECURITY_GROUP_CACHE_TTL", time.Minute), "The duration that the AWS cloud provider caches the security groups matched by each security group selector")
+	flag.BoolVar(&opts.AWSCacheWarmup, "aws-cache-warmup", env.WithDefaultBool("AWS_CACHE_WARMUP", true), "Indicates whether the AWS cloud provider should fill its caches for each provisioner when it's started, so that the first provisioning round after a restart isn't delayed by AWS API calls")
+	flag.StringVar(&opts.AzureSubscriptionID, "azure-subscription-id", env.WithDefaultString("AZURE_SUBSCRIPTION_ID", ""), "The subscription that the Azure cloud provider launches virtual machines in. If not set, it is discovered from the Instance Metadata Service")
+	flag.StringVar(&opts.AzureLocation, "azure-location", env.WithDefaultString("AZURE_LOCATION", ""), "The location that the Azure cloud provider launches virtual machines in. If not set, it is discovered from the Instance Metadata Service")
+	flag.StringVar(&opts.AzureResourceGroup, "azure-resource-group", env.WithDefaultString("AZURE_RESOURCE_GROUP", ""), "The default resource group that the Azure cloud provider launches virtual machines in. If not set, it is discovered from the Instance Metadata Service")
+	flag.StringVar(&opts.AzureClientID, "azure-client-id", env.WithDefaultString("AZURE_CLIENT_ID", ""), "The client ID of the user assigned managed identity used by the Azure cloud provider, if the host has several")
+	flag.DurationVar(&opts.BatchIdleDuration, "batch-idle-duration", env.WithDefaultDuration("BATCH_IDLE_DURATION", time.Second), "The amount of time to wait for additional pods after the most recently received pod before provisioning capacity")
+	flag.DurationVar(&opts.BatchMaxDuration, "batch-max-duration", env.WithDefaultDuration("BATCH_MAX_DURATION", 10*time.Second), "The maximum amount of time to wait for pods before provisioning capacity, measured from the first pod in a batch")
+	flag.IntVar(&opts.BatchMaxItems, "batch-max-items", env.WithDefaultInt("BATCH_MAX_ITEMS", 2_000), "The maximum number of pods in a single provisioning batch")
+	flag.IntVar(&opts.BatchMaxInFlight, "batch-max-in-flight", env.WithDefaultInt("BATCH_MAX_IN_FLIGHT", 1), "The maximum number of batches each provisioner may collect or solve concurrently; launches are serialized")
+	flag.DurationVar(&opts.NodeStartupDuration, "node-startup-duration", env.WithDefaultDuration("NODE_STARTUP_DURATION", 2*time.Minute), "The expected amount of time from launching a node until it's ready, used to estimate when pods nominated to the node will run")
+	flag.StringVar(&opts.DeprovisioningMode, "deprovisioning-mode", env.WithDefaultString("DEPROVISIONING_MODE", string(v1alpha5.DeprovisioningModeDelete)), "The action taken on nodes selected for deprovisioning, either Delete or Cordon. Cordon only cordons and annotates nodes, leaving draining and termination to the cluster operator")
+	flag.BoolVar(&opts.WorkloadStickiness, "workload-stickiness", env.WithDefaultBool("WORKLOAD_STICKINESS", false), "Indicates whether replicas of the same workload should prefer the zone and instance type chosen for previous replicas")
+	flag.BoolVar(&opts.IgnoreUnsupportedPreferences, "ignore-unsupported-preferences", env.WithDefaultBool("IGNORE_UNSUPPORTED_PREFERENCES", false), "Indicates whether pods with scheduling preferences that aren't supported, e.g. preferred pod affinity, should be provisioned while ignoring those preferences, rather than not provisioned")
+	flag.IntVar(&opts.MaxNodesPerMinute, "max-nodes-per-minute", env.WithDefaultInt("MAX_NODES_PER_MINUTE", 0), "The maximum number of nodes launched per minute across all provisioners, in bursts of up to a minute's worth of nodes. Nodes that exceed the rate are queued. If 0, the rate is unlimited")
+	flag.Float64Var(&opts.CostAnomalyThreshold, "cost-anomaly-threshold", env.WithDefaultFloat64("COST_ANOMALY_THRESHOLD", 0), "The ratio of a provisioner's launch cost rate over the cost anomaly window to its rate over the baseline that emits a CostAnomaly event, e.g. 5. If 0, cost anomalies aren't detected")
+	flag.DurationVar(&opts.CostAnomalyWindow, "cost-anomaly-window", env.WithDefaultDuration("COST_ANOMALY_WINDOW", 10*time.Minute), "The recent period over which each provisioner's launch cost rate is measured to detect cost anomalies")
+	flag.DurationVar(&opts.CostAnomalyBaseline, "cost-anomaly-baseline", env.WithDefaultDuration("COST_ANOMALY_BASELINE", 24*time.Hour), "The trailing period, before the cost anomaly window, that each provisioner's launch cost rate is compared to")
+	flag.Float64Var(&opts.CostAnomalyMinimumRate, "cost-anomaly-minimum-rate", env.WithDefaultFloat64("COST_ANOMALY_MINIMUM_RATE", 10), "The launch cost rate, per hour, that's anomalous for provisioners that launched nothing during the cost anomaly baseline")
+	flag.StringVar(&opts.InstanceTypeOrdering, "instance-type-ordering", env.WithDefaultString("INSTANCE_TYPE_ORDERING", "size"), "Comma separated comparators that order the instance type options of each node, from most to least preferred, e.g. price,size. Supports size, price, generation, and interruption-rate, if the cloud provider reports them")
+	flag.StringVar(&opts.TerminatedNodeArchive, "terminated-node-archive", env.WithDefaultString("TERMINATED_NODE_ARCHIVE", ""), "The sink that a record of each terminated node is archived to for post-mortem analysis, either log or configmap. If not set, terminated nodes aren't archived")
+	flag.DurationVar(&opts.TerminatedNodeArchiveTTL, "terminated-node-archive-ttl", env.WithDefaultDuration("TERMINATED_NODE_ARCHIVE_TTL", 7*24*time.Hour), "The duration that terminated node records are kept in the configmap archive. If 0, records are kept until they're deleted")
 	flag.Parse()
 	if err := opts.Validate(); err != nil {
 		panic(err)
@@ -53,17 +91,51 @@ func MustParse() Options {
 
 // Options for running this binary
 type Options struct {
-	ClusterName               string
-	ClusterEndpoint           string
-	KarpenterService          string
-	MetricsPort               int
-	HealthProbePort           int
-	WebhookPort               int
-	KubeClientQPS             int
-	KubeClientBurst           int
-	AWSNodeNameConvention     string
-	AWSENILimitedPodDensity   bool
-	AWSDefaultInstanceProfile string
+	CloudProvider                string
+	ExternalCloudProviderAddress string
+	ClusterName                  string
+	ClusterEndpoint              string
+	KarpenterService             string
+	MetricsPort                  int
+	HealthProbePort              int
+	DashboardAddress             string
+	DashboardPort                int
+	WebhookPort                  int
+	LeaderElection               bool
+	LeaderElectionLeaseDuration  time.Duration
+	LeaderElectionRenewDeadline  time.Duration
+	LeaderElectionRetryPeriod    time.Duration
+	KubeClientQPS                int
+	KubeClientBurst              int
+	AWSNodeNameConvention        string
+	AWSENILimitedPodDensity      bool
+	AWSDefaultInstanceProfile    string
+	AWSSpotPlacementScores       bool
+	AWSInstanceTypesCacheTTL     time.Duration
+	AWSAMICacheTTL               time.Duration
+	AWSSubnetCacheTTL            time.Duration
+	AWSSecurityGroupCacheTTL     time.Duration
+	AWSCacheWarmup               bool
+	AzureSubscriptionID          string
+	AzureLocation                string
+	AzureResourceGroup           string
+	AzureClientID                string
+	BatchIdleDuration            time.Duration
+	BatchMaxDuration             time.Duration
+	BatchMaxItems                int
+	BatchMaxInFlight             int
+	WorkloadStickiness           bool
+	DeprovisioningMode           string
+	NodeStartupDuration          time.Duration
+	IgnoreUnsupportedPreferences bool
+	MaxNodesPerMinute            int
+	InstanceTypeOrdering         string
+	CostAnomalyThreshold         float64
+	CostAnomalyWindow            time.Duration
+	CostAnomalyBaseline          time.Duration
+	CostAnomalyMinimumRate       float64
+	TerminatedNodeArchive        string
+	TerminatedNodeArchiveTTL     time.Duration
 }
 
 func (o Options) Validate() (err error) {
@@ -71,14 +143,64 @@ func (o Options) Validate() (err error) {
 	if o.ClusterName == "" {
 		err = multierr.Append(err, fmt.Errorf("CLUSTER_NAME is required"))
 	}
+	if o.CloudProvider == "external" && o.ExternalCloudProviderAddress == "" {
+		err = multierr.Append(err, fmt.Errorf("external-cloud-provider-address is required when using the external cloud provider"))
+	}
 	awsNodeNameConvention := AWSNodeNameConvention(o.AWSNodeNameConvention)
 	if awsNodeNameConvention != IPName && awsNodeNameConvention != ResourceName {
 		err = multierr.Append(err, fmt.Errorf("aws-node-name-convention may only be either ip-name or resource-name"))
 	}
+	if o.AWSInstanceTypesCacheTTL < 0 || o.AWSAMICacheTTL < 0 || o.AWSSubnetCacheTTL < 0 || o.AWSSecurityGroupCacheTTL < 0 {
+		err = multierr.Append(err, fmt.Errorf("aws-instance-types-cache-ttl, aws-ami-cache-ttl, aws-subnet-cache-ttl, and aws-security-group-cache-ttl cannot be negative"))
+	}
+	if o.BatchIdleDuration < 0 || o.BatchMaxDuration < 0 {
+		err = multierr.Append(err, fmt.Errorf("batch-idle-duration and batch-max-duration cannot be negative"))
+	}
+	if o.BatchMaxItems < 0 || o.BatchMaxInFlight < 0 {
+		err = multierr.Append(err, fmt.Errorf("batch-max-items and batch-max-in-flight cannot be negative"))
+	}
+	if o.DashboardPort < 0 {
+		err = multierr.Append(err, fmt.Errorf("dashboard-port cannot be negative"))
+	}
+	if o.MaxNodesPerMinute < 0 {
+		err = multierr.Append(err, fmt.Errorf("max-nodes-per-minute cannot be negative"))
+	}
+	if o.LeaderElection && !(o.LeaderElectionRetryPeriod > 0 && o.LeaderElectionRetryPeriod < o.LeaderElectionRenewDeadline && o.LeaderElectionRenewDeadline < o.LeaderElectionLeaseDuration) {
+		err = multierr.Append(err, fmt.Errorf("leader-election-retry-period must be positive and less than leader-election-renew-deadline, which must be less than leader-election-lease-duration"))
+	}
+	if o.CostAnomalyThreshold < 0 || o.CostAnomalyMinimumRate < 0 {
+		err = multierr.Append(err, fmt.Errorf("cost-anomaly-threshold and cost-anomaly-minimum-rate cannot be negative"))
+	}
+	if o.CostAnomalyThreshold > 0 && (o.CostAnomalyWindow <= 0 || o.CostAnomalyBaseline <= 0) {
+		err = multierr.Append(err, fmt.Errorf("cost-anomaly-window and cost-anomaly-baseline must be positive to detect cost anomalies"))
+	}
+	if o.NodeStartupDuration < 0 {
+		err = multierr.Append(err, fmt.Errorf("node-startup-duration cannot be negative"))
+	}
+	if o.DeprovisioningMode != "" && !v1alpha5.SupportedDeprovisioningModes.Has(o.DeprovisioningMode) {
+		err = multierr.Append(err, fmt.Errorf("deprovisioning-mode may only be either Delete or Cordon"))
+	}
+	if o.InstanceTypeOrdering != "" {
+		if _, e := cloudprovider.NewComparatorChain(strings.Split(o.InstanceTypeOrdering, ",")...); e != nil {
+			err = multierr.Append(err, fmt.Errorf("instance-type-ordering is invalid, %w", e))
+		}
+	}
+	if o.TerminatedNodeArchive != "" && o.TerminatedNodeArchive != "log" && o.TerminatedNodeArchive != "configmap" {
+		err = multierr.Append(err, fmt.Errorf("terminated-node-archive may only be either log or configmap"))
+	}
+	if o.TerminatedNodeArchiveTTL < 0 {
+		err = multierr.Append(err, fmt.Errorf("terminated-node-archive-ttl cannot be negative"))
+	}
+	if o.BatchIdleDuration > o.BatchMaxDuration {
+		err = multierr.Append(err, fmt.Errorf("batch-idle-duration must not exceed batch-max-duration"))
+	}
 	return err
 }
 
 func (o Options) validateEndpoint() error {
+	if o.ClusterEndpoint == "" {
+		return nil
+	}
 	endpoint, err := url.Parse(o.ClusterEndpoint)
 	// url.Parse() will accept a lot of input without error; make
 	// sure it's a real URL
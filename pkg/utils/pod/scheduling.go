@@ -17,6 +17,9 @@ package pod
 import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
 )
 
 func FailedToSchedule(pod *v1.Pod) bool {
@@ -44,12 +47,31 @@ func IsTerminating(pod *v1.Pod) bool {
 	return pod.DeletionTimestamp != nil
 }
 
+// IsPastTerminationGracePeriod returns true if the pod is terminating and its
+// grace period has elapsed, after which its resources are considered released
+// even if the kubelet hasn't confirmed its deletion
+func IsPastTerminationGracePeriod(pod *v1.Pod) bool {
+	return IsTerminating(pod) && !injectabletime.Now().Before(pod.DeletionTimestamp.Time)
+}
+
+// HasDoNotEvict returns true if the pod opted out of eviction with the do-not-evict annotation
+func HasDoNotEvict(pod *v1.Pod) bool {
+	return pod.Annotations[v1alpha5.DoNotEvictPodAnnotationKey] == "true"
+}
+
 func IsOwnedByDaemonSet(pod *v1.Pod) bool {
 	return IsOwnedBy(pod, []schema.GroupVersionKind{
 		{Group: "apps", Version: "v1", Kind: "DaemonSet"},
 	})
 }
 
+// IsOwnedByStatefulSet returns true if the pod is a replica of a StatefulSet
+func IsOwnedByStatefulSet(pod *v1.Pod) bool {
+	return IsOwnedBy(pod, []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	})
+}
+
 // IsOwnedByNode returns true if the pod is a static pod owned by a specific node
 func IsOwnedByNode(pod *v1.Pod) bool {
 	return IsOwnedBy(pod, []schema.GroupVersionKind{
@@ -15,6 +15,8 @@ limitations under the License.
 package resources
 
 import (
+	"strings"
+
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
@@ -24,6 +26,7 @@ const (
 	AMDGPU    = "amd.com/gpu"
 	AWSNeuron = "aws.amazon.com/neuron"
 	AWSPodENI = "vpc.amazonaws.com/pod-eni"
+	AWSEFA    = "vpc.amazonaws.com/efa"
 )
 
 // RequestsForPods returns the total resources of a variadic list of podspecs.
@@ -61,6 +64,16 @@ func GPULimitsFor(pod *v1.Pod) v1.ResourceList {
 	return resources
 }
 
+// IsExtended returns true if the resource is an extended resource, such as one
+// advertised by a device plugin, rather than a resource native to Kubernetes
+// https://kubernetes.io/docs/concepts/configuration/manage-resources-containers/#extended-resources
+func IsExtended(resourceName v1.ResourceName) bool {
+	name := string(resourceName)
+	return strings.Contains(name, "/") &&
+		!strings.HasPrefix(name, v1.ResourceDefaultNamespacePrefix) &&
+		!strings.HasPrefix(name, v1.DefaultResourceRequestsPrefix)
+}
+
 // Merge the resources from the variadic into a single v1.ResourceList
 func Merge(resources ...v1.ResourceList) v1.ResourceList {
 	result := v1.ResourceList{}
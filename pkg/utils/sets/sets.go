@@ -47,8 +47,12 @@ func NewComplementSet(values ...string) Set {
 // DeepCopy creates a deep copy of the set object
 // It is required by the Kubernetes CRDs code generation
 func (s Set) DeepCopy() Set {
+	values := make(sets.String, len(s.values))
+	for value := range s.values {
+		values.Insert(value)
+	}
 	return Set{
-		values:     sets.NewString(s.values.UnsortedList()...),
+		values:     values,
 		complement: s.complement,
 	}
 }